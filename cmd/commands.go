@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/urfave/cli"
+	"io"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"tcpip/constants"
 	"tcpip/data"
 	"tcpip/layers"
@@ -30,7 +34,12 @@ func ShowNodeArpTable(c *cli.Context) {
 		fmt.Println("Node not found")
 		return
 	}
-	node.Properties.ArpTable.Print()
+	vrf, _, err := parseVrfOption(node, c.Args()[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	node.VrfArpTable(vrf).Print()
 }
 
 func ShowNodeMacTable(c *cli.Context) {
@@ -58,7 +67,129 @@ func ShowNodeRoutingTable(c *cli.Context) {
 		fmt.Println("Node not found")
 		return
 	}
-	node.Properties.RoutingTable.Print()
+	vrf, _, err := parseVrfOption(node, c.Args()[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	node.VrfRoutingTable(vrf).Print()
+}
+
+func ShowNodeRib(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	vrf, _, err := parseVrfOption(node, c.Args()[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	node.VrfRib(vrf).Print()
+}
+
+func ShowNodeNeighbors(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	node.Properties.LldpTable.Print()
+}
+
+func ShowNodeRip(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Rip == nil {
+		fmt.Println("RIP is not enabled on node", nodeName)
+		return
+	}
+	node.Properties.Rip.Print()
+}
+
+func getOspfNode(c *cli.Context) *data.Node {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return nil
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return nil
+	}
+	if node.Properties.Ospf == nil {
+		fmt.Println("OSPF is not enabled on node", nodeName)
+		return nil
+	}
+	return node
+}
+
+func ShowNodeOspfNeighbors(c *cli.Context) {
+	if node := getOspfNode(c); node != nil {
+		node.Properties.Ospf.PrintNeighbors()
+	}
+}
+
+func ShowNodeOspfDatabase(c *cli.Context) {
+	if node := getOspfNode(c); node != nil {
+		node.Properties.Ospf.PrintDatabase()
+	}
+}
+
+func ShowNodeOspfRoutes(c *cli.Context) {
+	if node := getOspfNode(c); node != nil {
+		node.Properties.Ospf.PrintRoutes()
+	}
+}
+
+func getBgpNode(c *cli.Context) *data.Node {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return nil
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return nil
+	}
+	if node.Properties.Bgp == nil {
+		fmt.Println("BGP is not configured on node", nodeName)
+		return nil
+	}
+	return node
+}
+
+func ShowNodeBgpSummary(c *cli.Context) {
+	if node := getBgpNode(c); node != nil {
+		node.Properties.Bgp.PrintSummary()
+	}
+}
+
+func ShowNodeBgpRoutes(c *cli.Context) {
+	if node := getBgpNode(c); node != nil {
+		node.Properties.Bgp.PrintRoutes()
+	}
 }
 
 func ShowNode(c *cli.Context) {
@@ -108,15 +239,20 @@ func RunResolveARPCommand(c *cli.Context) {
 		fmt.Println("Invalid IP address")
 		return
 	}
+	if !ip.IsIPv4() {
+		layers.SendNeighborSolicitation(node, nil, ip)
+		return
+	}
 	layers.SendARPBroadcastRequest(node, nil, ip)
 }
 
 func RunPingCommand(c *cli.Context) {
-	_nodeName := c.Args().Get(0)
-	_gatewayIP := c.Args().Get(1)
+	vrfName, args, err := cutVrfOption(c.Args(), "--vrf")
+	_nodeName := cli.Args(args).Get(0)
+	_gatewayIP := cli.Args(args).Get(1)
 
-	if _nodeName == "" || _gatewayIP == "" {
-		fmt.Println("Invalid command structure. Use 'run node ping <nodeName> <gatewayIP>'")
+	if err != nil || _nodeName == "" || _gatewayIP == "" {
+		fmt.Println("Invalid command structure. Use 'run node ping <nodeName> <gatewayIP>[%<interfaceName>] [--vrf <vrfName>]'")
 		return
 	}
 
@@ -125,6 +261,8 @@ func RunPingCommand(c *cli.Context) {
 		fmt.Println("Node not found")
 		return
 	}
+	// IPv6 link-local destinations name the outgoing interface as their zone
+	_gatewayIP, _zone, hasZone := strings.Cut(_gatewayIP, "%")
 	emptyIPAddress := data.IPAddress{}
 	ip := data.StringToIPAddress(_gatewayIP)
 	if bytes.Equal(ip[:], emptyIPAddress[:]) {
@@ -132,7 +270,75 @@ func RunPingCommand(c *cli.Context) {
 		return
 	}
 
-	layers.PacketDemoteToLayer3(node, []byte{}, constants.IcmpProto, ip)
+	if !ip.IsIPv4() {
+		var oif *data.Interface
+		if hasZone {
+			if oif = node.GetNodeIntfByName(_zone); oif == nil {
+				fmt.Println("Interface not found")
+				return
+			}
+		}
+		if err := layers.PingIPv6(node, oif, ip, vrfName); err != nil {
+			fmt.Println("Error:", err)
+		}
+		return
+	}
+	if err := layers.Ping(node, ip, vrfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func RunTracerouteCommand(c *cli.Context) {
+	vrfName, args, err := cutVrfOption(c.Args(), "--vrf")
+	_nodeName := cli.Args(args).Get(0)
+	_destinationIP := cli.Args(args).Get(1)
+
+	if err != nil || _nodeName == "" || _destinationIP == "" || len(args) > 2 {
+		fmt.Println("Invalid command structure. Use 'run node traceroute <nodeName> <destinationIP> [--vrf <vrfName>]'")
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if net.ParseIP(_destinationIP) == nil {
+		fmt.Println("Invalid IP address")
+		return
+	}
+	if err := layers.Traceroute(node, data.StringToIPAddress(_destinationIP), vrfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// cutVrfOption removes "<option> <vrfName>" from args and returns the VRF name, empty when args do
+// not name a VRF.
+func cutVrfOption(args []string, option string) (string, []string, error) {
+	for i, arg := range args {
+		if arg != option {
+			continue
+		}
+		if i+1 >= len(args) {
+			return "", nil, errors.New("missing VRF name")
+		}
+		rest := append(append([]string{}, args[:i]...), args[i+2:]...)
+		return args[i+1], rest, nil
+	}
+	return "", args, nil
+}
+
+// parseVrfOption removes "vrf <vrfName>" from args and returns the VRF of the node it names, nil
+// for the default VRF.
+func parseVrfOption(node *data.Node, args []string) (*data.Vrf, []string, error) {
+	vrfName, rest, err := cutVrfOption(args, "vrf")
+	if err != nil || vrfName == "" {
+		return nil, rest, err
+	}
+	vrf := node.LookupVrf(vrfName)
+	if vrf == nil {
+		return nil, nil, data.ErrVrfNotFound
+	}
+	return vrf, rest, nil
 }
 
 func RunPingTunnelCommand(c *cli.Context) {
@@ -168,53 +374,2081 @@ func RunPingTunnelCommand(c *cli.Context) {
 	copy(ipHeader.DestinationIP[:], ip[:])
 	copy(ipHeader.SourceIP[:], node.Properties.LB[:])
 	ipHeader.IHL = uint8(unsafe.Sizeof(data.IPHeader{}) / 4)
+	icmpHeader := data.IcmpHeader{
+		Type: constants.IcmpTypeEchoRequest,
+	}
+	message := icmpHeader.SerializeIcmpMessage(nil)
+	ipHeader.Length = uint16(unsafe.Sizeof(data.IPHeader{})) + uint16(len(message))
+
+	layers.PacketDemoteToLayer3(node, append(ipHeader.SerializeIPHeader(), message...), constants.IpInIpProto, tunnelIP)
+}
+
+type configNextHop struct {
+	gatewayIP data.IPAddress
+	intf      *data.Interface
+	weight    uint
+}
+
+func (nextHop configNextHop) interfaceName() *data.InterfaceName {
+	if nextHop.intf == nil {
+		return nil
+	}
+	return &nextHop.intf.Name
+}
+
+// parseRoutePrefix reads either "<ipAddress>/<mask>" or "<ipAddress> <mask>" and returns the number of arguments consumed.
+func parseRoutePrefix(args []string) (data.IPAddress, rune, int, error) {
+	if len(args) == 0 {
+		return data.IPAddress{}, 0, 0, errors.New("missing destination prefix")
+	}
+
+	consumed := 1
+	_ipAddress, _mask, found := strings.Cut(args[0], "/")
+	if !found {
+		if len(args) < 2 {
+			return data.IPAddress{}, 0, 0, errors.New("missing mask")
+		}
+		_mask = args[1]
+		consumed = 2
+	}
+
+	ip := data.StringToIPAddress(_ipAddress)
+	if net.ParseIP(_ipAddress) == nil {
+		return data.IPAddress{}, 0, 0, errors.New("invalid IP address")
+	}
+	mask, err := strconv.Atoi(_mask)
+	if err != nil {
+		return data.IPAddress{}, 0, 0, data.ErrInvalidRouteMask
+	}
+	if err := data.ValidateRoutePrefix(ip, rune(mask)); err != nil {
+		return data.IPAddress{}, 0, 0, err
+	}
+	return ip, rune(mask), consumed, nil
+}
+
+// parseNextHops reads "null0"/"blackhole" or a list of "<gatewayIP> [<interfaceName>] [weight <weight>]".
+// A gateway without interface is bound to the connected subnet it belongs to, or resolved recursively
+// through the routing table when it is not on a connected subnet.
+func parseNextHops(node *data.Node, vrf *data.Vrf, args []string) ([]configNextHop, bool, error) {
+	if len(args) == 1 && (args[0] == "null0" || args[0] == "blackhole") {
+		return nil, true, nil
+	}
+	if len(args) == 0 {
+		return nil, false, errors.New("missing next hop")
+	}
+
+	var nextHops []configNextHop
+	for i := 0; i < len(args); {
+		if net.ParseIP(args[i]) == nil {
+			return nil, false, fmt.Errorf("invalid gateway IP address %s", args[i])
+		}
+		nextHop := configNextHop{gatewayIP: data.StringToIPAddress(args[i]), weight: 1}
+		i++
+
+		if layers.IsVrfLocalDelivery(node, vrf, nextHop.gatewayIP) {
+			return nil, false, fmt.Errorf("gateway %s is an address of the node", nextHop.gatewayIP.String())
+		}
+
+		if i < len(args) && args[i] != "weight" && net.ParseIP(args[i]) == nil {
+			nextHop.intf = node.GetNodeIntfOrTunnelByName(args[i])
+			if nextHop.intf == nil {
+				return nil, false, fmt.Errorf("invalid interface name %s", args[i])
+			}
+			if nextHop.gatewayIP.IsIPv4() && !nextHop.intf.Properties.IsIpConfigured {
+				return nil, false, fmt.Errorf("interface %s is not in L3 mode", args[i])
+			}
+			if !nextHop.gatewayIP.IsIPv4() && !nextHop.intf.Properties.IsIpv6Enabled {
+				return nil, false, fmt.Errorf("IPv6 is not enabled on interface %s", args[i])
+			}
+			if nextHop.intf.Properties.Vrf != vrf {
+				return nil, false, fmt.Errorf("interface %s is not in VRF %s", args[i], vrf.String())
+			}
+			// a link-local gateway is on the link of any IPv6 interface
+			if !nextHop.gatewayIP.IsLinkLocalUnicast() && node.GetVrfMatchingSubnetInterface(nextHop.gatewayIP, vrf) != nextHop.intf {
+				return nil, false, fmt.Errorf("gateway %s is not on the subnet of interface %s", nextHop.gatewayIP.String(), args[i])
+			}
+			i++
+		} else if nextHop.gatewayIP.IsLinkLocalUnicast() && !nextHop.gatewayIP.IsIPv4() {
+			return nil, false, fmt.Errorf("link-local gateway %s requires an interface", nextHop.gatewayIP.String())
+		} else {
+			nextHop.intf = node.GetVrfMatchingSubnetInterface(nextHop.gatewayIP, vrf)
+		}
 
-	layers.PacketDemoteToLayer3(node, ipHeader.SerializeIPHeader(), constants.IpInIpProto, tunnelIP)
+		if i < len(args) && args[i] == "weight" {
+			if i+1 >= len(args) {
+				return nil, false, errors.New("missing weight")
+			}
+			weight, err := strconv.Atoi(args[i+1])
+			if err != nil || weight <= 0 {
+				return nil, false, errors.New("invalid weight")
+			}
+			nextHop.weight = uint(weight)
+			i += 2
+		}
+		nextHops = append(nextHops, nextHop)
+	}
+	return nextHops, false, nil
 }
 
 func ConfigNodeRoute(c *cli.Context) {
-	_nodeName := c.Args().Get(0)
-	_ipAddress := c.Args().Get(1)
-	_mask := c.Args().Get(2)
-	_gatewayIP := c.Args().Get(3)
-	_interfaceName := c.Args().Get(4)
+	rib, ip, mask, nextHops, isBlackhole, ok := parseConfigNodeRoute(c, "config node route")
+	if !ok {
+		return
+	}
+
+	if isBlackhole {
+		if err := rib.AddBlackholeRoute(ip, mask, constants.RouteSourceStatic); err != nil {
+			fmt.Println("Error:", err)
+		}
+		return
+	}
+	for _, nextHop := range nextHops {
+		err := rib.AddRoute(ip, mask, constants.RouteSourceStatic, 0, &nextHop.gatewayIP, nextHop.interfaceName(), nextHop.weight)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+}
+
+func ConfigNodeRouteReplace(c *cli.Context) {
+	rib, ip, mask, nextHops, isBlackhole, ok := parseConfigNodeRoute(c, "config node route replace")
+	if !ok {
+		return
+	}
 
-	if _nodeName == "" || _ipAddress == "" || _mask == "" || _gatewayIP == "" || _interfaceName == "" {
-		fmt.Println("Invalid command structure. Use 'config node route <nodeName> <ipAddress> <mask> <gatewayIP> <interfaceName>'")
+	if isBlackhole {
+		if err := rib.ReplaceBlackholeRoute(ip, mask, constants.RouteSourceStatic); err != nil {
+			fmt.Println("Error:", err)
+		}
 		return
 	}
+	var layer3NextHops []data.Layer3NextHop
+	for _, nextHop := range nextHops {
+		layer3NextHop := data.Layer3NextHop{GatewayIP: nextHop.gatewayIP, Weight: nextHop.weight}
+		if nextHop.intf != nil {
+			layer3NextHop.InterfaceName = nextHop.intf.Name
+		}
+		layer3NextHops = append(layer3NextHops, layer3NextHop)
+	}
+	if err := rib.ReplaceRoute(ip, mask, constants.RouteSourceStatic, 0, layer3NextHops); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// parseConfigNodeRoute returns the RIB of the VRF the route is configured in with the route.
+func parseConfigNodeRoute(c *cli.Context, command string) (*data.Layer3Rib, data.IPAddress, rune, []configNextHop, bool, bool) {
+	usage := "Invalid command structure. Use '" + command + " <nodeName> [vrf <vrfName>] <ipAddress> <mask> <gatewayIP> [<interfaceName>] [weight <weight>] [<gatewayIP> [<interfaceName>] [weight <weight>]]...' " +
+		"or '" + command + " <nodeName> [vrf <vrfName>] <ipAddress>/<mask> null0'"
+
+	_nodeName := c.Args().Get(0)
+	if _nodeName == "" || c.NArg() < 3 {
+		fmt.Println(usage)
+		return nil, data.IPAddress{}, 0, nil, false, false
+	}
 	node := (*Topology).GetNodeByName(_nodeName)
 	if node == nil {
 		fmt.Println("Node not found")
+		return nil, data.IPAddress{}, 0, nil, false, false
+	}
+
+	vrf, args, err := parseVrfOption(node, c.Args()[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return nil, data.IPAddress{}, 0, nil, false, false
+	}
+	ip, mask, consumed, err := parseRoutePrefix(args)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return nil, data.IPAddress{}, 0, nil, false, false
+	}
+	nextHops, isBlackhole, err := parseNextHops(node, vrf, args[consumed:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println(usage)
+		return nil, data.IPAddress{}, 0, nil, false, false
+	}
+	return node.VrfRib(vrf), ip, mask, nextHops, isBlackhole, true
+}
+
+func ConfigNodeRouteDelete(c *cli.Context) {
+	_nodeName := c.Args().Get(0)
+	if _nodeName == "" || c.NArg() < 2 {
+		fmt.Println("Invalid command structure. Use 'config node route delete <nodeName> [vrf <vrfName>] <ipAddress>/<mask> [<gatewayIP> [<interfaceName>]]'")
 		return
 	}
-	emptyIPAddress := data.IPAddress{}
-	ip := data.StringToIPAddress(_ipAddress)
-	if bytes.Equal(ip[:], emptyIPAddress[:]) {
-		fmt.Println("Invalid IP address")
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
 		return
 	}
-	mask, err := strconv.Atoi(_mask)
 
-	if err != nil || mask < 0 || mask > 128 {
-		fmt.Println("Invalid mask")
+	vrf, args, err := parseVrfOption(node, c.Args()[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
 		return
 	}
-	gatewayIP := data.StringToIPAddress(_gatewayIP)
-	if bytes.Equal(gatewayIP[:], emptyIPAddress[:]) {
-		fmt.Println("Invalid IP address")
+	ip, mask, consumed, err := parseRoutePrefix(args)
+	if err != nil {
+		fmt.Println("Error:", err)
 		return
 	}
 
-	for _, intf := range node.Interfaces {
-		if intf == nil {
-			continue
+	rib := node.VrfRib(vrf)
+	if len(args) == consumed {
+		if err := rib.DeleteRoute(ip, mask, constants.RouteSourceStatic); err != nil {
+			fmt.Println("Error:", err)
 		}
-		if intf.Name.String() == _interfaceName {
-			node.Properties.RoutingTable.AddRoute(ip, rune(mask), &gatewayIP, &intf.Name)
-			return
+		return
+	}
+
+	nextHops, isBlackhole, err := parseNextHops(node, vrf, args[consumed:])
+	if err != nil || isBlackhole || len(nextHops) != 1 {
+		fmt.Println("Error: expected a single next hop <gatewayIP> [<interfaceName>]")
+		return
+	}
+	var interfaceName data.InterfaceName
+	if nextHops[0].intf != nil {
+		interfaceName = nextHops[0].intf.Name
+	}
+	if err := rib.DeleteNextHop(ip, mask, constants.RouteSourceStatic, nextHops[0].gatewayIP, interfaceName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func parseConfigNodeInterface(c *cli.Context, command string) (*data.Node, string, bool) {
+	_nodeName := c.Args().Get(0)
+	intfName := c.Args().Get(1)
+	if _nodeName == "" || intfName == "" {
+		fmt.Println("Invalid command structure. Use '" + command + " <nodeName> <interfaceName>'")
+		return nil, "", false
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return nil, "", false
+	}
+	return node, intfName, true
+}
+
+func ConfigNodeRipEnable(c *cli.Context) {
+	node, intfName, ok := parseConfigNodeInterface(c, "config node rip enable")
+	if !ok {
+		return
+	}
+	if err := layers.EnableRIP(node, intfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeRipDisable(c *cli.Context) {
+	node, intfName, ok := parseConfigNodeInterface(c, "config node rip disable")
+	if !ok {
+		return
+	}
+	if err := layers.DisableRIP(node, intfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeOspfEnable(c *cli.Context) {
+	node, intfName, ok := parseConfigNodeInterface(c, "config node ospf enable")
+	if !ok {
+		return
+	}
+	if err := layers.EnableOSPF(node, intfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeOspfDisable(c *cli.Context) {
+	node, intfName, ok := parseConfigNodeInterface(c, "config node ospf disable")
+	if !ok {
+		return
+	}
+	if err := layers.DisableOSPF(node, intfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func configNodeInterfaceLink(c *cli.Context, command string, isDown bool) {
+	node, intfName, ok := parseConfigNodeInterface(c, command)
+	if !ok {
+		return
+	}
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		fmt.Println("Interface not found")
+		return
+	}
+	intf.Link.IsDown = isDown
+}
+
+func ConfigNodeInterfaceDown(c *cli.Context) {
+	configNodeInterfaceLink(c, "config node interface down", true)
+}
+
+func ConfigNodeInterfaceUp(c *cli.Context) {
+	configNodeInterfaceLink(c, "config node interface up", false)
+}
+
+// ConfigNodeInterfaceIPv6 enables IPv6 on an interface with its link-local address, and sets its
+// global address when one is given.
+func ConfigNodeInterfaceIPv6(c *cli.Context) {
+	node, intfName, ok := parseConfigNodeInterface(c, "config node interface ipv6")
+	if !ok {
+		return
+	}
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		fmt.Println("Interface not found")
+		return
+	}
+	if c.NArg() < 3 {
+		node.EnableIntfIPv6(intfName)
+		return
+	}
+
+	ip, mask, _, err := parseRoutePrefix(c.Args()[2:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if ip.IsIPv4() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || mask == 0 {
+		fmt.Println("Error: expected a global IPv6 address and prefix length")
+		return
+	}
+	if !intf.Properties.IPv6.IsUnspecified() {
+		if err := node.Properties.Rib.DeleteRoute(intf.Properties.IPv6, intf.Properties.IPv6Mask, constants.RouteSourceConnected); err != nil {
+			fmt.Println("Error:", err)
 		}
 	}
+	node.SetIntfIPv6Address(intfName, ip, mask)
+}
+
+// ConfigNodeInterfaceRa makes a router advertise the global prefix of an interface and any further
+// prefixes given, hosts of the link autoconfigure their addresses from them.
+func ConfigNodeInterfaceRa(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node interface ra <nodeName> <interfaceName> [lifetime <seconds>] [prefix <prefix>/<length>]...'"
 
-	fmt.Println("Invalid interface name")
+	node, intfName, ok := parseConfigNodeInterface(c, "config node interface ra")
+	if !ok {
+		return
+	}
+
+	routerLifetime := constants.RaDefaultRouterLifetimeSeconds
+	var prefixes []data.RaPrefix
+	args := c.Args()[2:]
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "lifetime" && i+1 < len(args):
+			lifetime, err := strconv.ParseUint(args[i+1], 10, 16)
+			if err != nil {
+				fmt.Println("Error: invalid router lifetime")
+				return
+			}
+			routerLifetime = uint16(lifetime)
+			i++
+		case args[i] == "prefix" && i+1 < len(args):
+			ip, mask, consumed, err := parseRoutePrefix(args[i+1:])
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			if ip.IsIPv4() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+				fmt.Println("Error: expected a global IPv6 prefix")
+				return
+			}
+			prefixes = append(prefixes, data.RaPrefix{Prefix: ip, Mask: mask})
+			i += consumed
+		default:
+			fmt.Println(usage)
+			return
+		}
+	}
+
+	if err := layers.EnableRouterAdvertisement(node, intfName, routerLifetime, prefixes); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeInterfaceAutoconfig(c *cli.Context) {
+	node, intfName, ok := parseConfigNodeInterface(c, "config node interface autoconfig")
+	if !ok {
+		return
+	}
+	if err := layers.EnableSLAAC(node, intfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeSlaac(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Slaac == nil {
+		fmt.Println("SLAAC is not configured on node", nodeName)
+		return
+	}
+	node.Properties.Slaac.Print()
+}
+
+func ConfigTopologyComputeRoutes(c *cli.Context) {
+	count := Topology.ComputeRoutes()
+	fmt.Println("Installed", count, "routes")
+}
+
+func parseASNumber(_as string) (uint16, error) {
+	as, err := strconv.ParseUint(_as, 10, 16)
+	if err != nil || as == 0 {
+		return 0, errors.New("invalid AS number")
+	}
+	return uint16(as), nil
+}
+
+func ConfigNodeBgpAS(c *cli.Context) {
+	_nodeName := c.Args().Get(0)
+	if _nodeName == "" || c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'config node bgp as <nodeName> <asNumber>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	as, err := parseASNumber(c.Args().Get(1))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := layers.ConfigureBGP(node, as); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeBgpNeighbor(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node bgp neighbor <nodeName> <peerIP> remote-as <asNumber> [next-hop-self] [local-pref <localPref>]'"
+
+	_nodeName := c.Args().Get(0)
+	if _nodeName == "" || c.NArg() < 4 || c.Args().Get(2) != "remote-as" {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if net.ParseIP(c.Args().Get(1)) == nil {
+		fmt.Println("Error: invalid peer IP address")
+		return
+	}
+	remoteAS, err := parseASNumber(c.Args().Get(3))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	nextHopSelf := false
+	var localPref uint32
+	args := c.Args()[4:]
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "next-hop-self":
+			nextHopSelf = true
+		case args[i] == "local-pref" && i+1 < len(args):
+			_localPref, err := strconv.ParseUint(args[i+1], 10, 32)
+			if err != nil {
+				fmt.Println("Error: invalid local preference")
+				return
+			}
+			localPref = uint32(_localPref)
+			i++
+		default:
+			fmt.Println(usage)
+			return
+		}
+	}
+
+	if err := layers.AddBGPPeer(node, data.StringToIPAddress(c.Args().Get(1)), remoteAS, nextHopSelf, localPref); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeBgpNetwork(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node bgp network <nodeName> <ipAddress>/<mask> [med <med>]'"
+
+	_nodeName := c.Args().Get(0)
+	if _nodeName == "" || c.NArg() < 2 {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+
+	args := c.Args()[1:]
+	ip, mask, consumed, err := parseRoutePrefix(args)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	var med uint64
+	hasMed := false
+	switch {
+	case len(args) == consumed:
+	case len(args) == consumed+2 && args[consumed] == "med":
+		if med, err = strconv.ParseUint(args[consumed+1], 10, 32); err != nil {
+			fmt.Println("Error: invalid MED")
+			return
+		}
+		hasMed = true
+	default:
+		fmt.Println(usage)
+		return
+	}
+
+	if err := layers.AddBGPNetwork(node, ip, mask, uint32(med), hasMed); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeBgpFilter(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node bgp filter <nodeName> <peerIP> in|out permit|deny <ipAddress>/<mask> [le <maxMask>]'"
+
+	_nodeName := c.Args().Get(0)
+	if _nodeName == "" || c.NArg() < 5 {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if net.ParseIP(c.Args().Get(1)) == nil {
+		fmt.Println("Error: invalid peer IP address")
+		return
+	}
+
+	direction, action := c.Args().Get(2), c.Args().Get(3)
+	if (direction != "in" && direction != "out") || (action != "permit" && action != "deny") {
+		fmt.Println(usage)
+		return
+	}
+
+	args := c.Args()[4:]
+	ip, mask, consumed, err := parseRoutePrefix(args)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	entry := data.BgpPrefixFilterEntry{IP: ip, Mask: mask, MaxMask: mask, Permit: action == "permit"}
+	switch {
+	case len(args) == consumed:
+	case len(args) == consumed+2 && args[consumed] == "le":
+		maxMask, err := strconv.Atoi(args[consumed+1])
+		if err != nil || rune(maxMask) < mask || maxMask > 32 {
+			fmt.Println("Error: invalid maximum mask")
+			return
+		}
+		entry.MaxMask = rune(maxMask)
+	default:
+		fmt.Println(usage)
+		return
+	}
+
+	if err := layers.AddBGPPrefixFilter(node, data.StringToIPAddress(c.Args().Get(1)), direction == "in", entry); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeVrrp(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Vrrp == nil {
+		fmt.Println("VRRP is not configured on node", nodeName)
+		return
+	}
+	node.Properties.Vrrp.Print()
+}
+
+func parseVRID(_vrid string) (uint8, error) {
+	vrid, err := strconv.ParseUint(_vrid, 10, 8)
+	if err != nil || vrid == 0 {
+		return 0, errors.New("invalid virtual router ID")
+	}
+	return uint8(vrid), nil
+}
+
+func ConfigNodeVrrp(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node vrrp <nodeName> <interfaceName> <vrid> <virtualIP> [priority <priority>] [no-preempt]'"
+
+	if c.NArg() < 4 {
+		fmt.Println(usage)
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node vrrp")
+	if !ok {
+		return
+	}
+	vrid, err := parseVRID(c.Args().Get(2))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if net.ParseIP(c.Args().Get(3)) == nil {
+		fmt.Println("Error: invalid virtual IP address")
+		return
+	}
+
+	priority := constants.VrrpDefaultPriority
+	preempt := true
+	args := c.Args()[4:]
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "no-preempt":
+			preempt = false
+		case args[i] == "priority" && i+1 < len(args):
+			_priority, err := strconv.ParseUint(args[i+1], 10, 8)
+			if err != nil {
+				fmt.Println("Error: invalid priority")
+				return
+			}
+			priority = uint8(_priority)
+			i++
+		default:
+			fmt.Println(usage)
+			return
+		}
+	}
+
+	if err := layers.ConfigureVRRP(node, intfName, vrid, data.StringToIPAddress(c.Args().Get(3)), priority, preempt); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeVrrpDelete(c *cli.Context) {
+	if c.NArg() != 3 {
+		fmt.Println("Invalid command structure. Use 'config node vrrp delete <nodeName> <interfaceName> <vrid>'")
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node vrrp delete")
+	if !ok {
+		return
+	}
+	vrid, err := parseVRID(c.Args().Get(2))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := layers.DeleteVRRP(node, intfName, vrid); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeDhcpLeases(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Dhcp == nil {
+		fmt.Println("DHCP is not configured on node", nodeName)
+		return
+	}
+	node.Properties.Dhcp.PrintLeases()
+}
+
+func ConfigNodeDhcpPool(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node dhcp pool <nodeName> <poolName> <ipAddress>/<mask> <rangeStart> <rangeEnd> [gateway <gatewayIP>] [lease <seconds>]'"
+
+	_nodeName, poolName := c.Args().Get(0), c.Args().Get(1)
+	if _nodeName == "" || poolName == "" || c.NArg() < 5 {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+
+	args := c.Args()[2:]
+	ip, mask, consumed, err := parseRoutePrefix(args)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	args = args[consumed:]
+	if len(args) < 2 || net.ParseIP(args[0]) == nil || net.ParseIP(args[1]) == nil {
+		fmt.Println(usage)
+		return
+	}
+	start, end := data.StringToIPAddress(args[0]), data.StringToIPAddress(args[1])
+
+	var gateway data.IPAddress
+	var leaseSeconds uint64
+	args = args[2:]
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "gateway" && i+1 < len(args) && net.ParseIP(args[i+1]) != nil:
+			gateway = data.StringToIPAddress(args[i+1])
+			i++
+		case args[i] == "lease" && i+1 < len(args):
+			if leaseSeconds, err = strconv.ParseUint(args[i+1], 10, 32); err != nil || leaseSeconds == 0 {
+				fmt.Println("Error: invalid lease time")
+				return
+			}
+			i++
+		default:
+			fmt.Println(usage)
+			return
+		}
+	}
+
+	if err := layers.AddDHCPPool(node, poolName, ip, mask, start, end, gateway, uint32(leaseSeconds)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeDhcpBinding(c *cli.Context) {
+	_nodeName := c.Args().Get(0)
+	if _nodeName == "" || c.NArg() != 4 {
+		fmt.Println("Invalid command structure. Use 'config node dhcp binding <nodeName> <poolName> <macAddress> <ipAddress>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if _, err := net.ParseMAC(c.Args().Get(2)); err != nil {
+		fmt.Println("Error: invalid MAC address")
+		return
+	}
+	if net.ParseIP(c.Args().Get(3)) == nil {
+		fmt.Println("Error: invalid IP address")
+		return
+	}
+	if err := layers.AddDHCPBinding(node, c.Args().Get(1), data.StringToMACAddress(c.Args().Get(2)), data.StringToIPAddress(c.Args().Get(3))); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeDhcpClient(c *cli.Context) {
+	node, intfName, ok := parseConfigNodeInterface(c, "config node dhcp client")
+	if !ok {
+		return
+	}
+	if err := layers.EnableDHCPClient(node, intfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeDhcpRelay(c *cli.Context) {
+	if c.NArg() != 3 {
+		fmt.Println("Invalid command structure. Use 'config node dhcp relay <nodeName> <interfaceName> <serverIP>'")
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node dhcp relay")
+	if !ok {
+		return
+	}
+	if net.ParseIP(c.Args().Get(2)) == nil {
+		fmt.Println("Error: invalid server IP address")
+		return
+	}
+	if err := layers.AddDHCPRelay(node, intfName, data.StringToIPAddress(c.Args().Get(2))); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeUdp(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	node.Properties.UDPPorts.Print()
+}
+
+func parsePort(_port string) (uint16, error) {
+	port, err := strconv.ParseUint(_port, 10, 16)
+	if err != nil || port == 0 {
+		return 0, errors.New("invalid port")
+	}
+	return uint16(port), nil
+}
+
+func RunNodeUdpListen(c *cli.Context) {
+	if c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'run node udp listen <nodeName> <port>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	port, err := parsePort(c.Args().Get(1))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	socket, err := node.BindUDP(data.IPAddress{}, port)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	go func() {
+		for {
+			datagram, err := socket.Recv(0)
+			if err != nil {
+				return
+			}
+			fmt.Printf("UDP: node %s port %d received %q from %s:%d\n", node.NodeName, port, datagram.Data,
+				datagram.SourceIP.String(), datagram.SourcePort)
+		}
+	}()
+}
+
+func RunNodeUdpClose(c *cli.Context) {
+	if c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'run node udp close <nodeName> <port>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	port, err := parsePort(c.Args().Get(1))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	socket := node.Properties.UDPPorts.LookupSocket(port)
+	if socket == nil {
+		fmt.Println("Error: port is not open")
+		return
+	}
+	socket.Close()
+}
+
+func RunNodeUdpSend(c *cli.Context) {
+	if c.NArg() < 4 {
+		fmt.Println("Invalid command structure. Use 'run node udp send <nodeName> <destinationIP> <port> <message>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if net.ParseIP(c.Args().Get(1)) == nil {
+		fmt.Println("Error: invalid destination IP address")
+		return
+	}
+	port, err := parsePort(c.Args().Get(2))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	socket, err := node.BindUDP(data.IPAddress{}, 0)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer socket.Close()
+
+	message := strings.Join(c.Args()[3:], " ")
+	if err := socket.SendTo(data.StringToIPAddress(c.Args().Get(1)), port, []byte(message)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeTcpConnections(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Tcp == nil {
+		fmt.Println("TCP is not in use on node", nodeName)
+		return
+	}
+	node.Properties.Tcp.Print()
+}
+
+func RunNodeTcpListen(c *cli.Context) {
+	if c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'run node tcp listen <nodeName> <port>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	port, err := parsePort(c.Args().Get(1))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	listener, err := layers.ListenTCP(node, data.IPAddress{}, port)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				n, err := io.Copy(conn, conn)
+				if err != nil {
+					fmt.Println("TCP: connection from", conn.RemoteAddr(), "to node", node.NodeName, "failed:", err)
+					return
+				}
+				fmt.Println("TCP: node", node.NodeName, "echoed", n, "bytes to", conn.RemoteAddr())
+			}()
+		}
+	}()
+}
+
+func RunNodeTcpConnect(c *cli.Context) {
+	if c.NArg() < 4 {
+		fmt.Println("Invalid command structure. Use 'run node tcp connect <nodeName> <destinationIP> <port> <message>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if net.ParseIP(c.Args().Get(1)) == nil {
+		fmt.Println("Error: invalid destination IP address")
+		return
+	}
+	port, err := parsePort(c.Args().Get(2))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	remoteIP := data.StringToIPAddress(c.Args().Get(1))
+	message := strings.Join(c.Args()[3:], " ")
+
+	// the handshake and the reply take round trips, the prompt stays usable meanwhile
+	go func() {
+		conn, err := layers.DialTCP(node, remoteIP, port)
+		if err != nil {
+			fmt.Println("TCP: connect from node", node.NodeName, "failed:", err)
+			return
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(message)); err != nil {
+			fmt.Println("TCP: send from node", node.NodeName, "failed:", err)
+			return
+		}
+		conn.CloseWrite()
+		reply, err := io.ReadAll(conn)
+		if err != nil {
+			fmt.Println("TCP: receive on node", node.NodeName, "failed:", err)
+			return
+		}
+		fmt.Printf("TCP: node %s received %q from %s\n", node.NodeName, reply, conn.RemoteAddr())
+	}()
+}
+
+func ShowNodeNatTranslations(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Nat == nil {
+		fmt.Println("NAT is not configured on node", nodeName)
+		return
+	}
+	node.Properties.Nat.PrintTranslations()
+}
+
+func ClearNodeNat(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	fmt.Println("Cleared", layers.ClearNATTranslations(node), "translations")
+}
+
+func configNodeNatInterface(c *cli.Context, command string, role uint8) {
+	node, intfName, ok := parseConfigNodeInterface(c, command)
+	if !ok {
+		return
+	}
+	if err := layers.SetNATInterface(node, intfName, role); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeNatInside(c *cli.Context) {
+	configNodeNatInterface(c, "config node nat inside", constants.NatRoleInside)
+}
+
+func ConfigNodeNatOutside(c *cli.Context) {
+	configNodeNatInterface(c, "config node nat outside", constants.NatRoleOutside)
+}
+
+// parseNodeAddressPair reads "<nodeName> <ipAddress> <ipAddress>".
+func parseNodeAddressPair(c *cli.Context, command string) (*data.Node, data.IPAddress, data.IPAddress, bool) {
+	if c.NArg() != 3 {
+		fmt.Println("Invalid command structure. Use '" + command + "'")
+		return nil, data.IPAddress{}, data.IPAddress{}, false
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return nil, data.IPAddress{}, data.IPAddress{}, false
+	}
+	if net.ParseIP(c.Args().Get(1)) == nil || net.ParseIP(c.Args().Get(2)) == nil {
+		fmt.Println("Error: invalid IP address")
+		return nil, data.IPAddress{}, data.IPAddress{}, false
+	}
+	return node, data.StringToIPAddress(c.Args().Get(1)), data.StringToIPAddress(c.Args().Get(2)), true
+}
+
+func ConfigNodeNatStatic(c *cli.Context) {
+	node, insideLocalIP, insideGlobalIP, ok := parseNodeAddressPair(c, "config node nat static <nodeName> <insideLocalIP> <insideGlobalIP>")
+	if !ok {
+		return
+	}
+	if err := layers.AddNATStatic(node, insideLocalIP, insideGlobalIP); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeNatPool(c *cli.Context) {
+	node, start, end, ok := parseNodeAddressPair(c, "config node nat pool <nodeName> <startIP> <endIP>")
+	if !ok {
+		return
+	}
+	if err := layers.SetNATPool(node, start, end); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeNatOverload(c *cli.Context) {
+	node, intfName, ok := parseConfigNodeInterface(c, "config node nat overload")
+	if !ok {
+		return
+	}
+	if err := layers.SetNATOverload(node, intfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeAcl(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Acl == nil {
+		fmt.Println("No access lists configured on node", nodeName)
+		return
+	}
+	node.Properties.Acl.Print()
+}
+
+// parseAclAddress reads "any", "host <ipAddress>" or "<ipAddress>/<mask>" and returns the number of arguments consumed.
+func parseAclAddress(args []string) (data.IPAddress, rune, int, error) {
+	if len(args) == 0 {
+		return data.IPAddress{}, 0, 0, errors.New("missing address")
+	}
+	switch args[0] {
+	case "any":
+		return data.StringToIPAddress("0.0.0.0"), 0, 1, nil
+	case "host":
+		if len(args) < 2 || net.ParseIP(args[1]) == nil {
+			return data.IPAddress{}, 0, 0, errors.New("invalid host address")
+		}
+		return data.StringToIPAddress(args[1]), 32, 2, nil
+	}
+	ip, mask, _, err := parseRoutePrefix(args[:1])
+	return ip, mask, 1, err
+}
+
+// parseAclPorts reads an optional "eq <port>", "gt <port>", "lt <port>" or "range <low> <high>" and
+// returns the number of arguments consumed, zero when there is no port match.
+func parseAclPorts(args []string) (uint16, uint16, int, error) {
+	if len(args) == 0 {
+		return 0, 0, 0, nil
+	}
+	switch args[0] {
+	case "eq", "gt", "lt":
+		if len(args) < 2 {
+			return 0, 0, 0, errors.New("missing port")
+		}
+		port, err := parsePort(args[1])
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		switch {
+		case args[0] == "eq":
+			return port, port, 2, nil
+		case args[0] == "gt" && port < 0xFFFF:
+			return port + 1, 0xFFFF, 2, nil
+		case args[0] == "lt" && port > 0:
+			return 0, port - 1, 2, nil
+		}
+		return 0, 0, 0, errors.New("port range is empty")
+	case "range":
+		if len(args) < 3 {
+			return 0, 0, 0, errors.New("missing port range")
+		}
+		low, err := parsePort(args[1])
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		high, err := parsePort(args[2])
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if low > high {
+			return 0, 0, 0, errors.New("port range is empty")
+		}
+		return low, high, 3, nil
+	}
+	return 0, 0, 0, nil
+}
+
+var aclIcmpTypes = map[string]uint8{
+	"echo-reply":    constants.IcmpTypeEchoReply,
+	"unreachable":   constants.IcmpTypeDestinationUnreachable,
+	"echo":          constants.IcmpTypeEchoRequest,
+	"time-exceeded": constants.IcmpTypeTimeExceeded,
+}
+
+var aclProtocols = map[string]uint8{
+	"ip":   0,
+	"icmp": constants.IcmpProto,
+	"tcp":  constants.TcpProto,
+	"udp":  constants.UdpProto,
+}
+
+// parseAclRule reads "[<sequence>] permit|deny <protocol> <source> [<ports>] <destination> [<ports>] [<icmpType>] [log]".
+func parseAclRule(args []string) (data.AclRule, error) {
+	var rule data.AclRule
+	if len(args) > 0 {
+		if sequence, err := strconv.Atoi(args[0]); err == nil {
+			if sequence <= 0 {
+				return rule, errors.New("invalid sequence number")
+			}
+			rule.Sequence = sequence
+			args = args[1:]
+		}
+	}
+	if len(args) < 4 || (args[0] != "permit" && args[0] != "deny") {
+		return rule, errors.New("expected permit or deny, a protocol, a source and a destination")
+	}
+	rule.IsPermit = args[0] == "permit"
+
+	args, err := parseAclMatch(&rule, args[1:])
+	if err != nil {
+		return rule, err
+	}
+	if len(args) > 0 && args[0] == "log" {
+		rule.IsLog = true
+		args = args[1:]
+	}
+	if len(args) != 0 {
+		return rule, fmt.Errorf("unexpected argument %s", args[0])
+	}
+	return rule, nil
+}
+
+// parseAclMatch parses the protocol, source, destination, ports and ICMP type a rule matches and
+// returns the arguments following them.
+func parseAclMatch(rule *data.AclRule, args []string) ([]string, error) {
+	if len(args) < 3 {
+		return nil, errors.New("expected a protocol, a source and a destination")
+	}
+	protocol, ok := aclProtocols[args[0]]
+	if !ok {
+		number, err := strconv.ParseUint(args[0], 10, 8)
+		if err != nil {
+			return nil, errors.New("invalid protocol")
+		}
+		protocol = uint8(number)
+	}
+	rule.Protocol = protocol
+	hasPorts := protocol == constants.TcpProto || protocol == constants.UdpProto
+	args = args[1:]
+
+	var consumed int
+	var err error
+	if rule.SourceIP, rule.SourceMask, consumed, err = parseAclAddress(args); err != nil {
+		return nil, err
+	}
+	args = args[consumed:]
+	if hasPorts {
+		if rule.SourcePortLow, rule.SourcePortHigh, consumed, err = parseAclPorts(args); err != nil {
+			return nil, err
+		}
+		rule.HasSourcePorts = consumed != 0
+		args = args[consumed:]
+	}
+	if rule.DestinationIP, rule.DestinationMask, consumed, err = parseAclAddress(args); err != nil {
+		return nil, err
+	}
+	args = args[consumed:]
+	if hasPorts {
+		if rule.DestinationPortLow, rule.DestinationPortHigh, consumed, err = parseAclPorts(args); err != nil {
+			return nil, err
+		}
+		rule.HasDestinationPorts = consumed != 0
+		args = args[consumed:]
+	}
+	if protocol == constants.IcmpProto && len(args) > 0 && args[0] != "log" {
+		icmpType, ok := aclIcmpTypes[args[0]]
+		if !ok {
+			number, err := strconv.ParseUint(args[0], 10, 8)
+			if err != nil {
+				return nil, errors.New("invalid ICMP type")
+			}
+			icmpType = uint8(number)
+		}
+		rule.HasIcmpType = true
+		rule.IcmpType = icmpType
+		args = args[1:]
+	}
+	return args, nil
+}
+
+// ConfigNodeAcl adds a rule to a numbered or named access list.
+func ConfigNodeAcl(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node acl <nodeName> <aclName> [<sequence>] permit|deny <protocol> <source> [<ports>] <destination> [<ports>] [<icmpType>] [log]'"
+
+	_nodeName, aclName := c.Args().Get(0), c.Args().Get(1)
+	if _nodeName == "" || aclName == "" {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	rule, err := parseAclRule(c.Args()[2:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println(usage)
+		return
+	}
+	if err := layers.AddACLRule(node, aclName, rule); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeAclDelete(c *cli.Context) {
+	_nodeName, aclName := c.Args().Get(0), c.Args().Get(1)
+	if _nodeName == "" || aclName == "" || c.NArg() > 3 {
+		fmt.Println("Invalid command structure. Use 'config node acl delete <nodeName> <aclName> [<sequence>]'")
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if c.NArg() == 2 {
+		if err := layers.DeleteACL(node, aclName); err != nil {
+			fmt.Println("Error:", err)
+		}
+		return
+	}
+	sequence, err := strconv.Atoi(c.Args().Get(2))
+	if err != nil {
+		fmt.Println("Error: invalid sequence number")
+		return
+	}
+	if err := layers.DeleteACLRule(node, aclName, sequence); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func parseAclDirection(direction string) (uint8, bool) {
+	switch direction {
+	case "in":
+		return constants.AclDirectionIn, true
+	case "out":
+		return constants.AclDirectionOut, true
+	}
+	return 0, false
+}
+
+func ConfigNodeAclApply(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node acl apply <nodeName> <interfaceName> <aclName> in|out'"
+	if c.NArg() != 4 {
+		fmt.Println(usage)
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node acl apply")
+	if !ok {
+		return
+	}
+	direction, ok := parseAclDirection(c.Args().Get(3))
+	if !ok {
+		fmt.Println(usage)
+		return
+	}
+	if err := layers.ApplyACL(node, intfName, c.Args().Get(2), direction); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeAclRemove(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node acl remove <nodeName> <interfaceName> in|out'"
+	if c.NArg() != 3 {
+		fmt.Println(usage)
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node acl remove")
+	if !ok {
+		return
+	}
+	direction, ok := parseAclDirection(c.Args().Get(2))
+	if !ok {
+		fmt.Println(usage)
+		return
+	}
+	if err := layers.RemoveACL(node, intfName, direction); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeRouteMap(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.RouteMaps == nil {
+		fmt.Println("No route maps configured on node", nodeName)
+		return
+	}
+	node.Properties.RouteMaps.Print()
+}
+
+// parseRouteMapEntry parses "[<sequence>] <match> set [nexthop <ipAddress>] [interface <interfaceName>]",
+// the match takes the protocol, addresses and ports of an access list rule.
+func parseRouteMapEntry(args []string) (data.RouteMapEntry, error) {
+	var entry data.RouteMapEntry
+	if len(args) > 0 {
+		if sequence, err := strconv.Atoi(args[0]); err == nil {
+			if sequence <= 0 {
+				return entry, errors.New("invalid sequence number")
+			}
+			entry.Sequence = sequence
+			args = args[1:]
+		}
+	}
+	args, err := parseAclMatch(&entry.Match, args)
+	if err != nil {
+		return entry, err
+	}
+	if len(args) < 3 || args[0] != "set" {
+		return entry, errors.New("expected set nexthop or set interface")
+	}
+	for args = args[1:]; len(args) > 0; args = args[2:] {
+		if len(args) < 2 {
+			return entry, fmt.Errorf("missing value of %s", args[0])
+		}
+		switch args[0] {
+		case "nexthop":
+			if net.ParseIP(args[1]) == nil {
+				return entry, errors.New("invalid next hop")
+			}
+			entry.HasNextHop = true
+			entry.NextHop = data.StringToIPAddress(args[1])
+		case "interface":
+			entry.Interface = data.StringToInterfaceName(args[1])
+		default:
+			return entry, fmt.Errorf("unexpected argument %s", args[0])
+		}
+	}
+	return entry, nil
+}
+
+// ConfigNodeRouteMap adds an entry to a route map.
+func ConfigNodeRouteMap(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node route-map <nodeName> <mapName> [<sequence>] <protocol> <source> [<ports>] <destination> [<ports>] set [nexthop <ipAddress>] [interface <interfaceName>]'"
+
+	_nodeName, mapName := c.Args().Get(0), c.Args().Get(1)
+	if _nodeName == "" || mapName == "" {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	entry, err := parseRouteMapEntry(c.Args()[2:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		fmt.Println(usage)
+		return
+	}
+	if err := layers.AddRouteMapEntry(node, mapName, entry); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeRouteMapDelete(c *cli.Context) {
+	_nodeName, mapName := c.Args().Get(0), c.Args().Get(1)
+	if _nodeName == "" || mapName == "" || c.NArg() > 3 {
+		fmt.Println("Invalid command structure. Use 'config node route-map delete <nodeName> <mapName> [<sequence>]'")
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if c.NArg() == 2 {
+		if err := layers.DeleteRouteMap(node, mapName); err != nil {
+			fmt.Println("Error:", err)
+		}
+		return
+	}
+	sequence, err := strconv.Atoi(c.Args().Get(2))
+	if err != nil {
+		fmt.Println("Error: invalid sequence number")
+		return
+	}
+	if err := layers.DeleteRouteMapEntry(node, mapName, sequence); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeRouteMapApply(c *cli.Context) {
+	if c.NArg() != 3 {
+		fmt.Println("Invalid command structure. Use 'config node route-map apply <nodeName> <interfaceName> <mapName>'")
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node route-map apply")
+	if !ok {
+		return
+	}
+	if err := layers.ApplyRouteMap(node, intfName, c.Args().Get(2)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeRouteMapRemove(c *cli.Context) {
+	if c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'config node route-map remove <nodeName> <interfaceName>'")
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node route-map remove")
+	if !ok {
+		return
+	}
+	if err := layers.RemoveRouteMap(node, intfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeConntrack(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Firewall == nil {
+		fmt.Println("Firewall is not configured on node", nodeName)
+		return
+	}
+	node.Properties.Firewall.PrintConnections()
+}
+
+func ShowNodeFirewall(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Firewall == nil {
+		fmt.Println("Firewall is not configured on node", nodeName)
+		return
+	}
+	node.Properties.Firewall.Print()
+}
+
+func ClearNodeConntrack(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	fmt.Println("Cleared", layers.ClearConntrack(node), "connections")
+}
+
+func ConfigNodeFirewallZone(c *cli.Context) {
+	if c.NArg() != 3 {
+		fmt.Println("Invalid command structure. Use 'config node firewall zone <nodeName> <interfaceName> <zoneName>|none'")
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node firewall zone")
+	if !ok {
+		return
+	}
+	if err := layers.SetFirewallZone(node, intfName, c.Args().Get(2)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeFirewallPolicy(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node firewall policy <nodeName> <fromZone> <toZone> permit|deny'"
+	if c.NArg() != 4 {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	var isPermit bool
+	switch c.Args().Get(3) {
+	case "permit":
+		isPermit = true
+	case "deny":
+		isPermit = false
+	default:
+		fmt.Println(usage)
+		return
+	}
+	if err := layers.SetFirewallPolicy(node, c.Args().Get(1), c.Args().Get(2), isPermit); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeTunnel(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Tunnels == nil {
+		fmt.Println("No tunnels configured on node", nodeName)
+		return
+	}
+	node.Properties.Tunnels.Print()
+}
+
+func ConfigNodeTunnel(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node tunnel <nodeName> <tunnelName> gre|ipip source <ipAddress> destination <ipAddress> [key <key>]'"
+	args := c.Args()
+	if (len(args) != 7 && len(args) != 9) || args[3] != "source" || args[5] != "destination" {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(args[0])
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	var mode uint8
+	switch args[2] {
+	case "gre":
+		mode = data.TunnelModeGRE
+	case "ipip":
+		mode = data.TunnelModeIPIP
+	default:
+		fmt.Println(usage)
+		return
+	}
+	if net.ParseIP(args[4]) == nil || net.ParseIP(args[6]) == nil {
+		fmt.Println("Invalid IP address")
+		return
+	}
+	var hasKey bool
+	var key uint64
+	if len(args) == 9 {
+		if args[7] != "key" {
+			fmt.Println(usage)
+			return
+		}
+		var err error
+		if key, err = strconv.ParseUint(args[8], 10, 32); err != nil {
+			fmt.Println("Error: invalid tunnel key")
+			return
+		}
+		hasKey = true
+	}
+	if err := layers.AddTunnel(node, args[1], mode, data.StringToIPAddress(args[4]), data.StringToIPAddress(args[6]), hasKey, uint32(key)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeTunnelIp(c *cli.Context) {
+	if c.NArg() < 3 {
+		fmt.Println("Invalid command structure. Use 'config node tunnel ip <nodeName> <tunnelName> <ipAddress>/<mask>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	ip, mask, _, err := parseRoutePrefix(c.Args()[2:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := layers.SetTunnelAddress(node, c.Args().Get(1), ip, mask); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeTunnelDelete(c *cli.Context) {
+	if c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'config node tunnel delete <nodeName> <tunnelName>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if err := layers.DeleteTunnel(node, c.Args().Get(1)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeVxlan(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Vxlan == nil {
+		fmt.Println("VXLAN is not configured on node", nodeName)
+		return
+	}
+	node.Properties.Vxlan.Print(node)
+}
+
+func ConfigNodeVxlanSource(c *cli.Context) {
+	if c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'config node vxlan source <nodeName> loopback|<interfaceName>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if err := layers.SetVXLANSource(node, c.Args().Get(1)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeVxlanVni(c *cli.Context) {
+	args := c.Args()
+	if len(args) != 4 || args[2] != "vlan" {
+		fmt.Println("Invalid command structure. Use 'config node vxlan vni <nodeName> <vni> vlan <vlanId>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(args[0])
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	vni, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		fmt.Println("Error: invalid VNI")
+		return
+	}
+	vlan, err := strconv.ParseUint(args[3], 10, 32)
+	if err != nil {
+		fmt.Println("Error: invalid VLAN ID")
+		return
+	}
+	if err := layers.SetVXLANVni(node, uint32(vni), uint(vlan)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeVxlanFlood(c *cli.Context) {
+	args := c.Args()
+	if len(args) < 3 {
+		fmt.Println("Invalid command structure. Use 'config node vxlan flood <nodeName> <vni> <ipAddress>...'")
+		return
+	}
+	node := (*Topology).GetNodeByName(args[0])
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	vni, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		fmt.Println("Error: invalid VNI")
+		return
+	}
+	var peers []data.IPAddress
+	for _, arg := range args[2:] {
+		if net.ParseIP(arg) == nil {
+			fmt.Println("Invalid IP address")
+			return
+		}
+		peers = append(peers, data.StringToIPAddress(arg))
+	}
+	if err := layers.SetVXLANFloodList(node, uint32(vni), peers); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ShowNodeMpls(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Mpls == nil {
+		fmt.Println("MPLS is not configured on node", nodeName)
+		return
+	}
+	node.Properties.Mpls.Print()
+}
+
+// parseMplsLabels parses labels up to the "nexthop" keyword and returns them with the next hop
+// following it, if any.
+func parseMplsLabels(args []string) ([]uint32, *data.IPAddress, error) {
+	var labels []uint32
+	for i, arg := range args {
+		if arg == "nexthop" {
+			if i != len(args)-2 || net.ParseIP(args[i+1]) == nil {
+				return nil, nil, errors.New("invalid next hop")
+			}
+			nextHop := data.StringToIPAddress(args[i+1])
+			return labels, &nextHop, nil
+		}
+		switch arg {
+		case "implicit-null":
+			labels = append(labels, constants.MplsLabelImplicitNull)
+		case "explicit-null":
+			labels = append(labels, constants.MplsLabelExplicitNull)
+		default:
+			label, err := strconv.ParseUint(arg, 10, 32)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid label %s", arg)
+			}
+			labels = append(labels, uint32(label))
+		}
+	}
+	return labels, nil, nil
+}
+
+func ConfigNodeMplsLfib(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node mpls lfib <nodeName> <inLabel> swap <label>|implicit-null nexthop <ipAddress>', " +
+		"'config node mpls lfib <nodeName> <inLabel> push <label>... nexthop <ipAddress>' or 'config node mpls lfib <nodeName> <inLabel> pop [nexthop <ipAddress>]'"
+	args := c.Args()
+	if len(args) < 3 {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(args[0])
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	inLabel, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		fmt.Println("Error: invalid incoming label")
+		return
+	}
+	var operation uint8
+	switch args[2] {
+	case "swap":
+		operation = data.MplsOpSwap
+	case "push":
+		operation = data.MplsOpPush
+	case "pop":
+		operation = data.MplsOpPop
+	default:
+		fmt.Println(usage)
+		return
+	}
+	labels, nextHop, err := parseMplsLabels(args[3:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := layers.SetLfibEntry(node, uint32(inLabel), operation, labels, nextHop); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeMplsLfibDelete(c *cli.Context) {
+	if c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'config node mpls lfib delete <nodeName> <inLabel>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	inLabel, err := strconv.ParseUint(c.Args().Get(1), 10, 32)
+	if err != nil {
+		fmt.Println("Error: invalid incoming label")
+		return
+	}
+	if err := layers.DeleteLfibEntry(node, uint32(inLabel)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeMplsLsp(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node mpls lsp <nodeName> <lspName> push <label>... nexthop <ipAddress>'"
+	args := c.Args()
+	if len(args) < 4 || args[2] != "push" {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(args[0])
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	labels, nextHop, err := parseMplsLabels(args[3:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if nextHop == nil {
+		fmt.Println(usage)
+		return
+	}
+	if err := layers.SetLsp(node, args[1], labels, *nextHop); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeMplsLspDelete(c *cli.Context) {
+	if c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'config node mpls lsp delete <nodeName> <lspName>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if err := layers.DeleteLsp(node, c.Args().Get(1)); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeMplsFtn(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node mpls ftn <nodeName> <ipAddress>/<mask> <lspName>|none'"
+	args := c.Args()
+	if len(args) < 3 {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(args[0])
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	ip, mask, consumed, err := parseRoutePrefix(args[1:])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(args) != consumed+2 {
+		fmt.Println(usage)
+		return
+	}
+	if err := layers.SetFtn(node, ip, mask, args[consumed+1]); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeMplsTtlPropagate(c *cli.Context) {
+	usage := "Invalid command structure. Use 'config node mpls ttl-propagate <nodeName> enable|disable'"
+	if c.NArg() != 2 {
+		fmt.Println(usage)
+		return
+	}
+	node := (*Topology).GetNodeByName(c.Args().Get(0))
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	switch c.Args().Get(1) {
+	case "enable":
+		layers.SetMplsTtlPropagation(node, true)
+	case "disable":
+		layers.SetMplsTtlPropagation(node, false)
+	default:
+		fmt.Println(usage)
+	}
+}
+
+func ShowNodeVrf(c *cli.Context) {
+	nodeName := c.Args().First()
+	if nodeName == "" {
+		fmt.Println("Please provide a node name")
+		return
+	}
+	node := (*Topology).GetNodeByName(nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if node.Properties.Vrfs == nil {
+		fmt.Println("No VRFs configured on node", nodeName)
+		return
+	}
+	node.Properties.Vrfs.Print(node)
+}
+
+func ConfigNodeVrf(c *cli.Context) {
+	_nodeName, vrfName := c.Args().Get(0), c.Args().Get(1)
+	if _nodeName == "" || vrfName == "" || c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'config node vrf <nodeName> <vrfName>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if err := layers.AddVrf(node, vrfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeVrfDelete(c *cli.Context) {
+	_nodeName, vrfName := c.Args().Get(0), c.Args().Get(1)
+	if _nodeName == "" || vrfName == "" || c.NArg() != 2 {
+		fmt.Println("Invalid command structure. Use 'config node vrf delete <nodeName> <vrfName>'")
+		return
+	}
+	node := (*Topology).GetNodeByName(_nodeName)
+	if node == nil {
+		fmt.Println("Node not found")
+		return
+	}
+	if err := layers.DeleteVrf(node, vrfName); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+func ConfigNodeVrfInterface(c *cli.Context) {
+	if c.NArg() != 3 {
+		fmt.Println("Invalid command structure. Use 'config node vrf interface <nodeName> <interfaceName> <vrfName>|none'")
+		return
+	}
+	node, intfName, ok := parseConfigNodeInterface(c, "config node vrf interface")
+	if !ok {
+		return
+	}
+	if err := layers.SetIntfVrf(node, intfName, c.Args().Get(2)); err != nil {
+		fmt.Println("Error:", err)
+	}
 }
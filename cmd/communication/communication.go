@@ -6,6 +6,7 @@ import (
 	"sync"
 	"tcpip/cmd/communication/receive"
 	"tcpip/data"
+	"tcpip/layers"
 )
 
 var UDPPortNumber uint = 4000
@@ -48,6 +49,8 @@ func StartPacketReceiverThread(topology *data.Graph) {
 		initUDPSocket(dllNode.DllToNode())
 	}
 
+	go layers.StartLLDPAgent(topology)
+
 	var wg sync.WaitGroup
 
 	for dllNode := topology.Nodes.Next; dllNode != nil; dllNode = dllNode.Next {
@@ -10,6 +10,9 @@ import (
 // PacketSend sends a packet out through the specified interface to the connected neighbor node.
 func PacketSend(packet data.Packet, intf *data.Interface) int {
 	var intfName data.InterfaceName
+	if !intf.IsUp() {
+		return -1
+	}
 	neighbourNode := intf.GetNeighbourNode()
 
 	if intf.Link.Interface1 == *intf {
@@ -52,9 +52,149 @@ func Init() {
 							},
 							{
 								Name:   "routing-table",
-								Usage:  "Show routing table of the node",
+								Usage:  "Show routing table of the node or of one of its VRFs",
 								Action: ShowNodeRoutingTable,
 							},
+							{
+								Name:   "rib",
+								Usage:  "Show all candidate routes of the node",
+								Action: ShowNodeRib,
+							},
+							{
+								Name:   "neighbors",
+								Usage:  "Show LLDP neighbors of the node",
+								Action: ShowNodeNeighbors,
+							},
+							{
+								Name:   "rip",
+								Usage:  "Show RIP interfaces and routes of the node",
+								Action: ShowNodeRip,
+							},
+							{
+								Name:  "ospf",
+								Usage: "Show OSPF state of the node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "neighbors",
+										Usage:  "Show OSPF neighbors of the node",
+										Action: ShowNodeOspfNeighbors,
+									},
+									{
+										Name:   "database",
+										Usage:  "Show the link state database of the node",
+										Action: ShowNodeOspfDatabase,
+									},
+									{
+										Name:   "routes",
+										Usage:  "Show the routes computed by SPF",
+										Action: ShowNodeOspfRoutes,
+									},
+								},
+							},
+							{
+								Name:   "vrrp",
+								Usage:  "Show the virtual routers of the node",
+								Action: ShowNodeVrrp,
+							},
+							{
+								Name:   "udp",
+								Usage:  "Show the UDP ports and counters of the node",
+								Action: ShowNodeUdp,
+							},
+							{
+								Name:  "tcp",
+								Usage: "Show TCP state of the node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "connections",
+										Usage:  "Show the TCP listeners and connections of the node",
+										Action: ShowNodeTcpConnections,
+									},
+								},
+							},
+							{
+								Name:   "acl",
+								Usage:  "Show the access lists of the node with their hit counters",
+								Action: ShowNodeAcl,
+							},
+							{
+								Name:   "route-map",
+								Usage:  "Show the route maps of the node with their hit counters",
+								Action: ShowNodeRouteMap,
+							},
+							{
+								Name:   "tunnel",
+								Usage:  "Show the tunnel interfaces of the node with their counters",
+								Action: ShowNodeTunnel,
+							},
+							{
+								Name:   "vxlan",
+								Usage:  "Show the VTEP source and the VNIs of the node",
+								Action: ShowNodeVxlan,
+							},
+							{
+								Name:   "mpls",
+								Usage:  "Show the LFIB, the LSPs and the prefixes mapped to them",
+								Action: ShowNodeMpls,
+							},
+							{
+								Name:   "vrf",
+								Usage:  "Show the VRFs of the node with their interfaces",
+								Action: ShowNodeVrf,
+							},
+							{
+								Name:   "firewall",
+								Usage:  "Show the firewall zones and policies of the node",
+								Action: ShowNodeFirewall,
+							},
+							{
+								Name:   "conntrack",
+								Usage:  "Show the connections tracked by the firewall of the node",
+								Action: ShowNodeConntrack,
+							},
+							{
+								Name:  "nat",
+								Usage: "Show NAT state of the node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "translations",
+										Usage:  "Show the NAT translation table of the node",
+										Action: ShowNodeNatTranslations,
+									},
+								},
+							},
+							{
+								Name:   "slaac",
+								Usage:  "Show the router advertisements and autoconfigured addresses of the node",
+								Action: ShowNodeSlaac,
+							},
+							{
+								Name:  "dhcp",
+								Usage: "Show DHCP state of the node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "leases",
+										Usage:  "Show the DHCP leases of the node",
+										Action: ShowNodeDhcpLeases,
+									},
+								},
+							},
+							{
+								Name:  "bgp",
+								Usage: "Show BGP state of the node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "summary",
+										Usage:  "Show the BGP peers of the node",
+										Action: ShowNodeBgpSummary,
+									},
+									{
+										Name:   "routes",
+										Usage:  "Show the BGP paths of the node",
+										Action: ShowNodeBgpRoutes,
+									},
+								},
+							},
 						},
 					},
 				},
@@ -84,6 +224,48 @@ func Init() {
 									},
 								},
 							},
+							{
+								Name:   "traceroute",
+								Usage:  "Print the routers on the path to an address",
+								Action: RunTracerouteCommand,
+							},
+							{
+								Name:  "udp",
+								Usage: "Send and receive UDP datagrams on a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "listen",
+										Usage:  "Bind a UDP port and print the datagrams it receives",
+										Action: RunNodeUdpListen,
+									},
+									{
+										Name:   "close",
+										Usage:  "Close a UDP port opened with listen",
+										Action: RunNodeUdpClose,
+									},
+									{
+										Name:   "send",
+										Usage:  "Send a UDP datagram from an ephemeral port",
+										Action: RunNodeUdpSend,
+									},
+								},
+							},
+							{
+								Name:  "tcp",
+								Usage: "Open TCP connections on a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "listen",
+										Usage:  "Accept connections on a TCP port and echo what they send",
+										Action: RunNodeTcpListen,
+									},
+									{
+										Name:   "connect",
+										Usage:  "Connect to a TCP port, send a message and print the reply",
+										Action: RunNodeTcpConnect,
+									},
+								},
+							},
 						},
 					},
 				},
@@ -92,6 +274,17 @@ func Init() {
 				Name:  "config",
 				Usage: "Configure the network",
 				Subcommands: []cli.Command{
+					{
+						Name:  "topology",
+						Usage: "Configure the whole topology",
+						Subcommands: []cli.Command{
+							{
+								Name:   "compute-routes",
+								Usage:  "Install the shortest paths between all L3 nodes as static routes",
+								Action: ConfigTopologyComputeRoutes,
+							},
+						},
+					},
 					{
 						Name:  "node",
 						Usage: "Configure a node",
@@ -100,6 +293,353 @@ func Init() {
 								Name:   "route",
 								Usage:  "Add a route to a node",
 								Action: ConfigNodeRoute,
+								Subcommands: []cli.Command{
+									{
+										Name:   "replace",
+										Usage:  "Replace the next hops of a static route",
+										Action: ConfigNodeRouteReplace,
+									},
+									{
+										Name:   "delete",
+										Usage:  "Delete a static route or one of its next hops",
+										Action: ConfigNodeRouteDelete,
+									},
+								},
+							},
+							{
+								Name:  "rip",
+								Usage: "Configure RIPv2 on a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "enable",
+										Usage:  "Enable RIP on an interface",
+										Action: ConfigNodeRipEnable,
+									},
+									{
+										Name:   "disable",
+										Usage:  "Disable RIP on an interface",
+										Action: ConfigNodeRipDisable,
+									},
+								},
+							},
+							{
+								Name:  "ospf",
+								Usage: "Configure OSPF on a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "enable",
+										Usage:  "Enable OSPF on an interface",
+										Action: ConfigNodeOspfEnable,
+									},
+									{
+										Name:   "disable",
+										Usage:  "Disable OSPF on an interface",
+										Action: ConfigNodeOspfDisable,
+									},
+								},
+							},
+							{
+								Name:  "bgp",
+								Usage: "Configure BGP on a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "as",
+										Usage:  "Start BGP in an AS",
+										Action: ConfigNodeBgpAS,
+									},
+									{
+										Name:   "neighbor",
+										Usage:  "Add a BGP peer",
+										Action: ConfigNodeBgpNeighbor,
+									},
+									{
+										Name:   "network",
+										Usage:  "Originate a prefix into BGP",
+										Action: ConfigNodeBgpNetwork,
+									},
+									{
+										Name:   "filter",
+										Usage:  "Add a prefix filter entry for a BGP peer",
+										Action: ConfigNodeBgpFilter,
+									},
+								},
+							},
+							{
+								Name:   "vrrp",
+								Usage:  "Configure a VRRP virtual router on an interface",
+								Action: ConfigNodeVrrp,
+								Subcommands: []cli.Command{
+									{
+										Name:   "delete",
+										Usage:  "Remove a VRRP virtual router from an interface",
+										Action: ConfigNodeVrrpDelete,
+									},
+								},
+							},
+							{
+								Name:  "dhcp",
+								Usage: "Configure DHCP on a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "pool",
+										Usage:  "Serve a subnet from a DHCP address pool",
+										Action: ConfigNodeDhcpPool,
+									},
+									{
+										Name:   "binding",
+										Usage:  "Reserve an address of a pool for a MAC address",
+										Action: ConfigNodeDhcpBinding,
+									},
+									{
+										Name:   "client",
+										Usage:  "Lease the address of an interface from a DHCP server",
+										Action: ConfigNodeDhcpClient,
+									},
+									{
+										Name:   "relay",
+										Usage:  "Relay the DHCP broadcasts of an interface to a DHCP server",
+										Action: ConfigNodeDhcpRelay,
+									},
+								},
+							},
+							{
+								Name:   "acl",
+								Usage:  "Add a rule to an access list",
+								Action: ConfigNodeAcl,
+								Subcommands: []cli.Command{
+									{
+										Name:   "delete",
+										Usage:  "Remove a rule or a whole access list",
+										Action: ConfigNodeAclDelete,
+									},
+									{
+										Name:   "apply",
+										Usage:  "Filter the packets of an interface in or out with an access list",
+										Action: ConfigNodeAclApply,
+									},
+									{
+										Name:   "remove",
+										Usage:  "Stop filtering the packets of an interface in or out",
+										Action: ConfigNodeAclRemove,
+									},
+								},
+							},
+							{
+								Name:   "route-map",
+								Usage:  "Add an entry to a policy routing route map",
+								Action: ConfigNodeRouteMap,
+								Subcommands: []cli.Command{
+									{
+										Name:   "delete",
+										Usage:  "Remove an entry or a whole route map",
+										Action: ConfigNodeRouteMapDelete,
+									},
+									{
+										Name:   "apply",
+										Usage:  "Route the packets received on an interface with a route map",
+										Action: ConfigNodeRouteMapApply,
+									},
+									{
+										Name:   "remove",
+										Usage:  "Route the packets received on an interface by destination again",
+										Action: ConfigNodeRouteMapRemove,
+									},
+								},
+							},
+							{
+								Name:   "tunnel",
+								Usage:  "Create a GRE or IP-in-IP tunnel interface",
+								Action: ConfigNodeTunnel,
+								Subcommands: []cli.Command{
+									{
+										Name:   "ip",
+										Usage:  "Set the IP address of a tunnel interface",
+										Action: ConfigNodeTunnelIp,
+									},
+									{
+										Name:   "delete",
+										Usage:  "Remove a tunnel interface",
+										Action: ConfigNodeTunnelDelete,
+									},
+								},
+							},
+							{
+								Name:  "vxlan",
+								Usage: "Configure the VXLAN tunnel endpoint of a switch",
+								Subcommands: []cli.Command{
+									{
+										Name:   "source",
+										Usage:  "Set the loopback or interface VXLAN packets are sent from",
+										Action: ConfigNodeVxlanSource,
+									},
+									{
+										Name:   "vni",
+										Usage:  "Map a VNI to a VLAN of the switch",
+										Action: ConfigNodeVxlanVni,
+									},
+									{
+										Name:   "flood",
+										Usage:  "Set the remote VTEPs broadcast and unknown unicast frames of a VNI are replicated to",
+										Action: ConfigNodeVxlanFlood,
+									},
+								},
+							},
+							{
+								Name:  "mpls",
+								Usage: "Configure static MPLS label switching",
+								Subcommands: []cli.Command{
+									{
+										Name:   "lfib",
+										Usage:  "Swap, push or pop the label packets arrive with",
+										Action: ConfigNodeMplsLfib,
+										Subcommands: []cli.Command{
+											{
+												Name:   "delete",
+												Usage:  "Remove the LFIB entry of an incoming label",
+												Action: ConfigNodeMplsLfibDelete,
+											},
+										},
+									},
+									{
+										Name:   "lsp",
+										Usage:  "Create a static LSP at its ingress",
+										Action: ConfigNodeMplsLsp,
+										Subcommands: []cli.Command{
+											{
+												Name:   "delete",
+												Usage:  "Remove a static LSP",
+												Action: ConfigNodeMplsLspDelete,
+											},
+										},
+									},
+									{
+										Name:   "ftn",
+										Usage:  "Send the packets of a route into an LSP",
+										Action: ConfigNodeMplsFtn,
+									},
+									{
+										Name:   "ttl-propagate",
+										Usage:  "Copy the IP TTL to the labels and back",
+										Action: ConfigNodeMplsTtlPropagate,
+									},
+								},
+							},
+							{
+								Name:   "vrf",
+								Usage:  "Create a VRF with its own routing and ARP tables",
+								Action: ConfigNodeVrf,
+								Subcommands: []cli.Command{
+									{
+										Name:   "delete",
+										Usage:  "Remove a VRF without interfaces",
+										Action: ConfigNodeVrfDelete,
+									},
+									{
+										Name:   "interface",
+										Usage:  "Assign an interface to a VRF",
+										Action: ConfigNodeVrfInterface,
+									},
+								},
+							},
+							{
+								Name:  "firewall",
+								Usage: "Configure the zone firewall of a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "zone",
+										Usage:  "Put an interface in a firewall zone",
+										Action: ConfigNodeFirewallZone,
+									},
+									{
+										Name:   "policy",
+										Usage:  "Permit or deny new connections from one zone to another",
+										Action: ConfigNodeFirewallPolicy,
+									},
+								},
+							},
+							{
+								Name:  "nat",
+								Usage: "Configure NAT on a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "inside",
+										Usage:  "Translate the source of packets from an interface to the outside",
+										Action: ConfigNodeNatInside,
+									},
+									{
+										Name:   "outside",
+										Usage:  "Translate the destination of packets from an interface back to the inside",
+										Action: ConfigNodeNatOutside,
+									},
+									{
+										Name:   "static",
+										Usage:  "Map an inside address to a global address permanently",
+										Action: ConfigNodeNatStatic,
+									},
+									{
+										Name:   "pool",
+										Usage:  "Hand out global addresses of a range to inside hosts",
+										Action: ConfigNodeNatPool,
+									},
+									{
+										Name:   "overload",
+										Usage:  "Share the address of an interface or the loopback address with port translation",
+										Action: ConfigNodeNatOverload,
+									},
+								},
+							},
+							{
+								Name:  "interface",
+								Usage: "Configure an interface of a node",
+								Subcommands: []cli.Command{
+									{
+										Name:   "down",
+										Usage:  "Bring the link of an interface down",
+										Action: ConfigNodeInterfaceDown,
+									},
+									{
+										Name:   "up",
+										Usage:  "Bring the link of an interface up",
+										Action: ConfigNodeInterfaceUp,
+									},
+									{
+										Name:   "ipv6",
+										Usage:  "Enable IPv6 on an interface and set its global address",
+										Action: ConfigNodeInterfaceIPv6,
+									},
+									{
+										Name:   "ra",
+										Usage:  "Send IPv6 router advertisements on an interface",
+										Action: ConfigNodeInterfaceRa,
+									},
+									{
+										Name:   "autoconfig",
+										Usage:  "Autoconfigure the IPv6 address and default routers of an interface",
+										Action: ConfigNodeInterfaceAutoconfig,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:  "clear",
+				Usage: "Clear state",
+				Subcommands: []cli.Command{
+					{
+						Name:  "node",
+						Usage: "Clear node state",
+						Subcommands: []cli.Command{
+							{
+								Name:   "nat",
+								Usage:  "Remove the dynamic NAT translations of the node",
+								Action: ClearNodeNat,
+							},
+							{
+								Name:   "conntrack",
+								Usage:  "Remove the connections tracked by the firewall of the node",
+								Action: ClearNodeConntrack,
 							},
 						},
 					},
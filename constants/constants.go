@@ -1,21 +1,262 @@
 package constants
 
 const (
-	MaxIntfPerNode      int  = 10
-	MaxPacketBufferSize int  = 1540 // 1536
-	MaxPacketSize       int  = 1524 //1520
-	MaxPayloadSize      int  = 1500
-	MaxAuxiliarySize    int  = 16
-	MaxVlanMembership   uint = 10
+	MaxIntfPerNode         int  = 10
+	MaxPacketBufferSize    int  = 1542 // auxiliary data and packet
+	MaxPacketSize          int  = 1526 // 14 + 4 + 4 + 1500 + 4: header, S-tag, C-tag, payload and FCS
+	MaxPayloadSize         int  = 1500
+	MaxAuxiliarySize       int  = 16
+	MaxVlanMembership      uint = 10
+	MaxNextHops            int  = 8
+	MaxRecursiveRouteDepth int  = 8
 )
 
 const (
 	Vlan8021qProto  uint16 = 0x8100
+	Vlan8021adProto uint16 = 0x88a8
 	EthernetIpProto uint16 = 0x0800
 	IcmpProto       uint8  = 0x01
 	IpInIpProto     uint8  = 0x04
+	TcpProto        uint8  = 0x06
+	UdpProto        uint8  = 0x11
 )
 
+const (
+	IcmpHeaderSize                 int    = 8
+	IcmpTypeEchoReply              uint8  = 0
+	IcmpTypeDestinationUnreachable uint8  = 3
+	IcmpTypeEchoRequest            uint8  = 8
+	IcmpTypeTimeExceeded           uint8  = 11
+	IcmpCodePortUnreachable        uint8  = 3
+	IcmpCodeTtlExceeded            uint8  = 0
+	TracerouteMaxHops              int    = 16
+	TracerouteProbeTimeoutMs       int    = 500
+	UdpEphemeralPortStart          uint16 = 49152
+	UdpSocketQueueSize             int    = 64
+)
+
+const (
+	TcpHeaderSize         int    = 20
+	TcpFlagFin            uint8  = 0x01
+	TcpFlagSyn            uint8  = 0x02
+	TcpFlagRst            uint8  = 0x04
+	TcpFlagPsh            uint8  = 0x08
+	TcpFlagAck            uint8  = 0x10
+	TcpMss                uint32 = 1024
+	TcpInitialWindow      uint32 = 3 * TcpMss
+	TcpSendBufferSize     int    = 65536
+	TcpReceiveBufferSize  int    = 16384
+	TcpListenBacklog      int    = 16
+	TcpInitialRtoMs       int    = 1000
+	TcpMinRtoMs           int    = 200
+	TcpMaxRtoMs           int    = 60000
+	TcpMaxRetransmits     int    = 8
+	TcpMaxSynRetransmits  int    = 4
+	TcpDupAckThreshold    int    = 3
+	TcpMslSeconds         int    = 5
+	TcpEphemeralPortStart uint16 = 49152
+)
+
+const (
+	NatRoleInside               uint8  = 1
+	NatRoleOutside              uint8  = 2
+	NatPortStart                uint16 = 1024
+	NatAddressTimeoutSeconds    int    = 86400
+	NatIcmpTimeoutSeconds       int    = 60
+	NatUdpTimeoutSeconds        int    = 300
+	NatTcpTimeoutSeconds        int    = 86400
+	NatTcpClosingTimeoutSeconds int    = 10
+)
+
+const (
+	AclDirectionIn  uint8 = 1
+	AclDirectionOut uint8 = 2
+	AclSequenceStep int   = 10
+)
+
+const (
+	ConntrackIcmpTimeoutSeconds      int = 30
+	ConntrackUdpTimeoutSeconds       int = 30
+	ConntrackUdpStreamTimeoutSeconds int = 180
+	ConntrackTcpSynTimeoutSeconds    int = 120
+	ConntrackTcpTimeoutSeconds       int = 432000
+	ConntrackTcpCloseTimeoutSeconds  int = 10
+)
+
+const (
+	GreProto      uint8  = 47
+	GreHeaderSize int    = 4
+	GreKeySize    int    = 4
+	GreFlagKey    uint16 = 0x2000
+)
+
+const (
+	VxlanPort       uint16 = 4789
+	VxlanHeaderSize int    = 8
+	VxlanFlagVni    uint8  = 0x08
+	VxlanMaxVni     uint32 = 0xFFFFFF
+)
+
+const (
+	EthernetMplsProto      uint16 = 0x8847
+	MplsLabelSize          int    = 4
+	MplsMaxLabelStackDepth int    = 8
+	MplsLabelExplicitNull  uint32 = 0
+	MplsLabelImplicitNull  uint32 = 3
+	MplsMinStaticLabel     uint32 = 16
+	MplsMaxLabel           uint32 = 0xFFFFF
+	MplsMaxTTL             uint8  = 255
+)
+
+const (
+	EthernetIpv6Proto                uint16 = 0x86DD
+	Icmpv6Proto                      uint8  = 58
+	Ipv6HeaderSize                   int    = 40
+	Ipv6DefaultHopLimit              uint8  = 64
+	Ipv6LinkLocalPrefixLength        rune   = 64
+	Icmpv6TypeDestinationUnreachable uint8  = 1
+	Icmpv6TypeTimeExceeded           uint8  = 3
+	Icmpv6TypeEchoRequest            uint8  = 128
+	Icmpv6TypeEchoReply              uint8  = 129
+	Icmpv6TypeRouterSolicitation     uint8  = 133
+	Icmpv6TypeRouterAdvertisement    uint8  = 134
+	Icmpv6TypeNeighborSolicitation   uint8  = 135
+	Icmpv6TypeNeighborAdvertisement  uint8  = 136
+	NdHopLimit                       uint8  = 255
+	NdOptionSourceLinkLayerAddress   uint8  = 1
+	NdOptionTargetLinkLayerAddress   uint8  = 2
+	NdOptionPrefixInformation        uint8  = 3
+	NdFlagRouter                     uint32 = 0x80000000
+	NdFlagSolicited                  uint32 = 0x40000000
+	NdFlagOverride                   uint32 = 0x20000000
+	NdPrefixFlagOnLink               uint8  = 0x80
+	NdPrefixFlagAutonomous           uint8  = 0x40
+)
+
+const (
+	RaIntervalSeconds                 int    = 10
+	RaDefaultRouterLifetimeSeconds    uint16 = 30
+	RaDefaultValidLifetimeSeconds     uint32 = 2592000
+	RaDefaultPreferredLifetimeSeconds uint32 = 604800
+	RsMaxSolicitations                int    = 3
+	RsIntervalSeconds                 int    = 4
+	SlaacPrefixLength                 rune   = 64
+)
+
+var Ipv6AllNodesIP = [16]byte{0xFF, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+var Ipv6AllRoutersIP = [16]byte{0xFF, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+
+const (
+	LldpProto                 uint16 = 0x88cc
+	LldpTxIntervalSeconds     int    = 30
+	LldpTxHoldMultiplier      int    = 4
+	LldpCapabilityBridge      uint16 = 0x0004
+	LldpCapabilityRouter      uint16 = 0x0010
+	LldpTlvEnd                uint8  = 0
+	LldpTlvChassisID          uint8  = 1
+	LldpTlvPortID             uint8  = 2
+	LldpTlvTTL                uint8  = 3
+	LldpTlvSystemName         uint8  = 5
+	LldpTlvSystemCapabilities uint8  = 7
+	LldpTlvManagementAddress  uint8  = 8
+)
+
+var LldpMulticastMacAddress = [6]byte{0x01, 0x80, 0xC2, 0x00, 0x00, 0x0E}
+
+const (
+	RipPort                     uint16 = 520
+	RipVersion                  uint8  = 2
+	RipCommandRequest           uint8  = 1
+	RipCommandResponse          uint8  = 2
+	RipAddressFamilyIP          uint16 = 2
+	RipInfinity                 uint32 = 16
+	RipMaxEntriesPerMessage     int    = 25
+	RipUpdateIntervalSeconds    int    = 30
+	RipTimeoutSeconds           int    = 180
+	RipGarbageCollectionSeconds int    = 120
+)
+
+var RipMulticastIP = [16]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 224, 0, 0, 9}
+
+const (
+	OspfProto                 uint8  = 89
+	OspfVersion               uint8  = 2
+	OspfPacketHello           uint8  = 1
+	OspfPacketLinkStateUpdate uint8  = 4
+	OspfLinkPointToPoint      uint8  = 1
+	OspfLinkStub              uint8  = 3
+	OspfInitialSequence       uint32 = 0x80000001
+	OspfHelloIntervalSeconds  int    = 10
+	OspfDeadIntervalSeconds   int    = 40
+	OspfLsRefreshSeconds      int    = 1800
+	OspfMaxAgeSeconds         int    = 3600
+)
+
+var OspfAllSPFRoutersIP = [16]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 224, 0, 0, 5}
+
+const (
+	BgpPort                uint16 = 179
+	BgpVersion             uint8  = 4
+	BgpMessageOpen         uint8  = 1
+	BgpMessageUpdate       uint8  = 2
+	BgpMessageNotification uint8  = 3
+	BgpMessageKeepalive    uint8  = 4
+	BgpAttributeOrigin     uint8  = 1
+	BgpAttributeAsPath     uint8  = 2
+	BgpAttributeNextHop    uint8  = 3
+	BgpAttributeMed        uint8  = 4
+	BgpAttributeLocalPref  uint8  = 5
+	BgpOriginIGP           uint8  = 0
+	BgpAsSequence          uint8  = 2
+	BgpDefaultLocalPref    uint32 = 100
+	BgpHoldTimeSeconds     int    = 90
+	BgpKeepaliveSeconds    int    = 30
+	BgpConnectRetrySeconds int    = 5
+	BgpRefreshSeconds      int    = 30
+)
+
+const (
+	VrrpProto                uint8 = 112
+	VrrpVersion              uint8 = 3
+	VrrpTypeAdvertisement    uint8 = 1
+	VrrpDefaultPriority      uint8 = 100
+	VrrpOwnerPriority        uint8 = 255
+	VrrpAdvertisementSeconds int   = 1
+)
+
+const (
+	DhcpServerPort          uint16 = 67
+	DhcpClientPort          uint16 = 68
+	DhcpOpRequest           uint8  = 1
+	DhcpOpReply             uint8  = 2
+	DhcpDiscover            uint8  = 1
+	DhcpOffer               uint8  = 2
+	DhcpRequest             uint8  = 3
+	DhcpDecline             uint8  = 4
+	DhcpAck                 uint8  = 5
+	DhcpNak                 uint8  = 6
+	DhcpRelease             uint8  = 7
+	DhcpOptionPad           uint8  = 0
+	DhcpOptionSubnetMask    uint8  = 1
+	DhcpOptionRouter        uint8  = 3
+	DhcpOptionRequestedIP   uint8  = 50
+	DhcpOptionLeaseTime     uint8  = 51
+	DhcpOptionMessageType   uint8  = 53
+	DhcpOptionServerID      uint8  = 54
+	DhcpOptionEnd           uint8  = 255
+	DhcpMagicCookie         uint32 = 0x63825363
+	DhcpFlagBroadcast       uint16 = 0x8000
+	DhcpDefaultLeaseSeconds uint32 = 3600
+	DhcpOfferHoldSeconds    int    = 30
+	DhcpRetransmitSeconds   int    = 4
+	DhcpMaxHops             uint8  = 16
+)
+
+var VrrpMulticastIP = [16]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 224, 0, 0, 18}
+
+var LimitedBroadcastIP = [16]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 255, 255, 255, 255}
+
 const (
 	ArpBroadcastRequest uint16 = 1
 	ArpReply            uint16 = 2
@@ -26,8 +267,36 @@ var BroadcastMacAddress = [6]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
 
 var DefaultRoutingIp = [16]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 
+const (
+	RouteSourceConnected uint8 = iota
+	RouteSourceLoopback
+	RouteSourceStatic
+	RouteSourceEBGP
+	RouteSourceOSPF
+	RouteSourceRIP
+	RouteSourceIBGP
+	RouteSourceDHCP
+	RouteSourceRA
+	RouteSourceSPF
+)
+
+const (
+	DistanceConnected uint8 = 0
+	DistanceLoopback  uint8 = 0
+	DistanceStatic    uint8 = 1
+	DistanceEBGP      uint8 = 20
+	DistanceOSPF      uint8 = 110
+	DistanceRIP       uint8 = 120
+	DistanceIBGP      uint8 = 200
+	DistanceDHCP      uint8 = 254
+	DistanceRA        uint8 = 254
+	DistanceSPF       uint8 = 130
+)
+
 const (
 	ACCESS = iota
 	TRUNK
+	PROVIDER_EDGE
+	PROVIDER_CORE
 	L2ModeUnknown
 )
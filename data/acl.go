@@ -0,0 +1,247 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tcpip/constants"
+	"unsafe"
+)
+
+// AclRule matches IPv4 packets on their addresses, protocol, ports and ICMP type. Protocol zero
+// matches every protocol, a prefix with mask zero matches every address.
+type AclRule struct {
+	Sequence            int
+	IsPermit            bool
+	IsLog               bool
+	Protocol            uint8
+	SourceIP            IPAddress
+	SourceMask          rune
+	DestinationIP       IPAddress
+	DestinationMask     rune
+	HasSourcePorts      bool
+	SourcePortLow       uint16
+	SourcePortHigh      uint16
+	HasDestinationPorts bool
+	DestinationPortLow  uint16
+	DestinationPortHigh uint16
+	HasIcmpType         bool
+	IcmpType            uint8
+	Hits                uint64
+}
+
+// AccessList is evaluated rule by rule in sequence order, the first matching rule decides and
+// packets no rule matches are denied.
+type AccessList struct {
+	Name             string
+	Rules            []*AclRule
+	ImplicitDenyHits uint64
+	ListGlue         Dll
+}
+
+// AclPacket holds the fields of a packet access lists match on.
+type AclPacket struct {
+	Protocol        uint8
+	SourceIP        IPAddress
+	DestinationIP   IPAddress
+	HasPorts        bool
+	SourcePort      uint16
+	DestinationPort uint16
+	HasIcmpType     bool
+	IcmpType        uint8
+}
+
+type AclInstance struct {
+	Lists    Dll
+	Inbound  map[*Interface]*AccessList
+	Outbound map[*Interface]*AccessList
+	Mutex    sync.Mutex
+}
+
+var ErrAclNotFound = errors.New("access list not found")
+
+func (dll *Dll) DllToAccessList() *AccessList {
+	return (*AccessList)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(AccessList{}.ListGlue)))
+}
+
+func (acl *AclInstance) LookupList(name string) *AccessList {
+	for dllList := acl.Lists.Next; dllList != nil; dllList = dllList.Next {
+		list := dllList.DllToAccessList()
+		if list.Name == name {
+			return list
+		}
+	}
+	return nil
+}
+
+func (acl *AclInstance) AddList(list *AccessList) {
+	(&list.ListGlue).Init()
+	(&acl.Lists).AddNode(&list.ListGlue)
+}
+
+// AddRule inserts the rule in sequence order, a rule without sequence number is appended after the
+// last rule. It fails if the sequence number is taken.
+func (list *AccessList) AddRule(rule *AclRule) error {
+	if rule.Sequence == 0 {
+		rule.Sequence = constants.AclSequenceStep
+		if len(list.Rules) != 0 {
+			rule.Sequence = list.Rules[len(list.Rules)-1].Sequence + constants.AclSequenceStep
+		}
+	}
+	if list.LookupRule(rule.Sequence) != nil {
+		return errors.New("sequence number already in use")
+	}
+	list.Rules = append(list.Rules, rule)
+	sort.Slice(list.Rules, func(i, j int) bool {
+		return list.Rules[i].Sequence < list.Rules[j].Sequence
+	})
+	return nil
+}
+
+func (list *AccessList) LookupRule(sequence int) *AclRule {
+	for _, rule := range list.Rules {
+		if rule.Sequence == sequence {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (list *AccessList) DeleteRule(sequence int) bool {
+	for i, rule := range list.Rules {
+		if rule.Sequence == sequence {
+			list.Rules = append(list.Rules[:i], list.Rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate returns the first rule matching the packet and counts the hit, or nil when the implicit
+// deny applies.
+func (list *AccessList) Evaluate(packet AclPacket) *AclRule {
+	for _, rule := range list.Rules {
+		if rule.Matches(packet) {
+			rule.Hits++
+			return rule
+		}
+	}
+	list.ImplicitDenyHits++
+	return nil
+}
+
+func portsMatch(hasPorts bool, low uint16, high uint16, port uint16) bool {
+	return !hasPorts || (port >= low && port <= high)
+}
+
+func (rule *AclRule) Matches(packet AclPacket) bool {
+	if rule.Protocol != 0 && rule.Protocol != packet.Protocol {
+		return false
+	}
+	if ApplyMask(packet.SourceIP, rule.SourceMask) != rule.SourceIP || ApplyMask(packet.DestinationIP, rule.DestinationMask) != rule.DestinationIP {
+		return false
+	}
+	if (rule.HasSourcePorts || rule.HasDestinationPorts) && !packet.HasPorts {
+		return false
+	}
+	if !portsMatch(rule.HasSourcePorts, rule.SourcePortLow, rule.SourcePortHigh, packet.SourcePort) ||
+		!portsMatch(rule.HasDestinationPorts, rule.DestinationPortLow, rule.DestinationPortHigh, packet.DestinationPort) {
+		return false
+	}
+	return !rule.HasIcmpType || (packet.HasIcmpType && rule.IcmpType == packet.IcmpType)
+}
+
+func aclPrefixString(IP IPAddress, mask rune) string {
+	if mask == 0 {
+		return "any"
+	}
+	if mask == 32 {
+		return "host " + IP.String()
+	}
+	return fmt.Sprintf("%s/%d", IP.String(), mask)
+}
+
+func aclPortsString(low uint16, high uint16) string {
+	if low == high {
+		return fmt.Sprintf(" eq %d", low)
+	}
+	return fmt.Sprintf(" range %d %d", low, high)
+}
+
+func aclProtocolString(protocol uint8) string {
+	if protocol == 0 {
+		return "ip"
+	}
+	if name := natProtocolString(protocol); name != "any" {
+		return name
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+func (packet AclPacket) String() string {
+	source, destination := packet.SourceIP.String(), packet.DestinationIP.String()
+	if packet.HasPorts {
+		source = fmt.Sprintf("%s:%d", source, packet.SourcePort)
+		destination = fmt.Sprintf("%s:%d", destination, packet.DestinationPort)
+	}
+	return fmt.Sprintf("%s %s -> %s", aclProtocolString(packet.Protocol), source, destination)
+}
+
+func (rule *AclRule) String() string {
+	action := "deny"
+	if rule.IsPermit {
+		action = "permit"
+	}
+	match := fmt.Sprintf("%d %s %s", rule.Sequence, action, rule.MatchString())
+	if rule.IsLog {
+		match += " log"
+	}
+	return match
+}
+
+// MatchString returns the protocol, addresses, ports and ICMP type the rule matches.
+func (rule *AclRule) MatchString() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%s %s", aclProtocolString(rule.Protocol), aclPrefixString(rule.SourceIP, rule.SourceMask))
+	if rule.HasSourcePorts {
+		builder.WriteString(aclPortsString(rule.SourcePortLow, rule.SourcePortHigh))
+	}
+	builder.WriteString(" " + aclPrefixString(rule.DestinationIP, rule.DestinationMask))
+	if rule.HasDestinationPorts {
+		builder.WriteString(aclPortsString(rule.DestinationPortLow, rule.DestinationPortHigh))
+	}
+	if rule.HasIcmpType {
+		fmt.Fprintf(&builder, " %d", rule.IcmpType)
+	}
+	return builder.String()
+}
+
+func (acl *AclInstance) Print() {
+	acl.Mutex.Lock()
+	defer acl.Mutex.Unlock()
+
+	for dllList := acl.Lists.Next; dllList != nil; dllList = dllList.Next {
+		list := dllList.DllToAccessList()
+		fmt.Printf("Access List: %s\n", list.Name)
+		for _, rule := range list.Rules {
+			fmt.Printf("    %s (%d matches)\n", rule.String(), rule.Hits)
+		}
+		fmt.Printf("    implicit deny (%d matches)\n", list.ImplicitDenyHits)
+	}
+	for _, direction := range []struct {
+		name     string
+		bindings map[*Interface]*AccessList
+	}{{"in", acl.Inbound}, {"out", acl.Outbound}} {
+		var interfaces []string
+		for intf, list := range direction.bindings {
+			interfaces = append(interfaces, fmt.Sprintf("Interface: %s, Direction: %s, Access List: %s", intf.Name.String(), direction.name, list.Name))
+		}
+		sort.Strings(interfaces)
+		for _, line := range interfaces {
+			fmt.Println(line)
+		}
+	}
+}
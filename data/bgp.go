@@ -0,0 +1,458 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const (
+	bgpHeaderSize = 19
+	bgpOpenSize   = 10
+)
+
+const (
+	BgpStateIdle uint8 = iota
+	BgpStateOpenSent
+	BgpStateOpenConfirm
+	BgpStateEstablished
+)
+
+type BgpOpen struct {
+	AS       uint16
+	HoldTime uint16
+	RouterID IPAddress
+}
+
+type BgpPrefix struct {
+	IP   IPAddress
+	Mask rune
+}
+
+type BgpPathAttributes struct {
+	AsPath       []uint16
+	NextHop      IPAddress
+	Med          uint32
+	HasMed       bool
+	LocalPref    uint32
+	HasLocalPref bool
+}
+
+type BgpUpdate struct {
+	Withdrawn  []BgpPrefix
+	Attributes BgpPathAttributes
+	Nlri       []BgpPrefix
+}
+
+type BgpMessage struct {
+	Type   uint8
+	Open   *BgpOpen
+	Update *BgpUpdate
+}
+
+type BgpPrefixFilterEntry struct {
+	IP      IPAddress
+	Mask    rune
+	MaxMask rune
+	Permit  bool
+}
+
+// BgpPrefixFilter is an ordered prefix list, the first matching entry decides and prefixes
+// matching no entry are denied. An empty filter permits everything.
+type BgpPrefixFilter struct {
+	Entries []BgpPrefixFilterEntry
+}
+
+type BgpPeer struct {
+	IP               IPAddress
+	LocalIP          IPAddress
+	RemoteAS         uint16
+	RouterID         IPAddress
+	State            uint8
+	NextHopSelf      bool
+	LocalPref        uint32
+	ImportFilter     BgpPrefixFilter
+	ExportFilter     BgpPrefixFilter
+	HoldExpiresAt    time.Time
+	NextKeepalive    time.Time
+	NextConnectRetry time.Time
+	NextRefresh      time.Time
+	EstablishedAt    time.Time
+	LastEndOfRib     time.Time
+	PeerGlue         Dll
+}
+
+// BgpPath is a path to a prefix, learned from Peer or originated locally when Peer is nil.
+// RefreshedAt is the last time the peer advertised it.
+type BgpPath struct {
+	Prefix      IPAddress
+	Mask        rune
+	Peer        *BgpPeer
+	Attributes  BgpPathAttributes
+	IsBest      bool
+	RefreshedAt time.Time
+	PathGlue    Dll
+}
+
+type BgpInstance struct {
+	AS       uint16
+	RouterID IPAddress
+	Peers    Dll
+	Paths    Dll
+	Mutex    sync.Mutex
+}
+
+func (dll *Dll) DllToBgpPeer() *BgpPeer {
+	return (*BgpPeer)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(BgpPeer{}.PeerGlue)))
+}
+
+func (dll *Dll) DllToBgpPath() *BgpPath {
+	return (*BgpPath)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(BgpPath{}.PathGlue)))
+}
+
+func serializeBgpPrefix(data []byte, prefix BgpPrefix) []byte {
+	data = append(data, byte(prefix.Mask))
+	return append(data, net.IP(prefix.IP[:]).To4()[:(prefix.Mask+7)/8]...)
+}
+
+func deserializeBgpPrefixes(data []byte) ([]BgpPrefix, error) {
+	var prefixes []BgpPrefix
+	for len(data) > 0 {
+		mask := rune(data[0])
+		size := int(mask+7) / 8
+		if mask > 32 || len(data) < 1+size {
+			return nil, errors.New("invalid BGP prefix")
+		}
+		var IPv4 [4]byte
+		copy(IPv4[:], data[1:1+size])
+		prefixes = append(prefixes, BgpPrefix{IP: ApplyMask(getIPv4(IPv4[:]), mask), Mask: mask})
+		data = data[1+size:]
+	}
+	return prefixes, nil
+}
+
+func appendBgpAttribute(data []byte, flags uint8, attributeType uint8, value []byte) []byte {
+	data = append(data, flags, attributeType, byte(len(value)))
+	return append(data, value...)
+}
+
+func (attributes BgpPathAttributes) serialize() []byte {
+	var data []byte
+	data = appendBgpAttribute(data, 0x40, constants.BgpAttributeOrigin, []byte{constants.BgpOriginIGP})
+
+	var asPath []byte
+	if len(attributes.AsPath) > 0 {
+		asPath = append(asPath, constants.BgpAsSequence, byte(len(attributes.AsPath)))
+		for _, AS := range attributes.AsPath {
+			asPath = binary.BigEndian.AppendUint16(asPath, AS)
+		}
+	}
+	data = appendBgpAttribute(data, 0x40, constants.BgpAttributeAsPath, asPath)
+	data = appendBgpAttribute(data, 0x40, constants.BgpAttributeNextHop, net.IP(attributes.NextHop[:]).To4())
+	if attributes.HasMed {
+		data = appendBgpAttribute(data, 0x80, constants.BgpAttributeMed, binary.BigEndian.AppendUint32(nil, attributes.Med))
+	}
+	if attributes.HasLocalPref {
+		data = appendBgpAttribute(data, 0x40, constants.BgpAttributeLocalPref, binary.BigEndian.AppendUint32(nil, attributes.LocalPref))
+	}
+	return data
+}
+
+func deserializeBgpPathAttributes(data []byte) (BgpPathAttributes, error) {
+	var attributes BgpPathAttributes
+	for len(data) > 0 {
+		if len(data) < 3 || len(data) < 3+int(data[2]) {
+			return attributes, errors.New("truncated BGP path attribute")
+		}
+		attributeType := data[1]
+		value := data[3 : 3+int(data[2])]
+		data = data[3+int(data[2]):]
+
+		switch attributeType {
+		case constants.BgpAttributeAsPath:
+			for len(value) >= 2 {
+				count := int(value[1])
+				if len(value) < 2+2*count {
+					return attributes, errors.New("truncated BGP AS_PATH")
+				}
+				for i := 0; i < count; i++ {
+					attributes.AsPath = append(attributes.AsPath, binary.BigEndian.Uint16(value[2+2*i:]))
+				}
+				value = value[2+2*count:]
+			}
+		case constants.BgpAttributeNextHop:
+			if len(value) != 4 {
+				return attributes, errors.New("invalid BGP NEXT_HOP")
+			}
+			attributes.NextHop = getIPv4(value)
+		case constants.BgpAttributeMed:
+			if len(value) != 4 {
+				return attributes, errors.New("invalid BGP MULTI_EXIT_DISC")
+			}
+			attributes.Med = binary.BigEndian.Uint32(value)
+			attributes.HasMed = true
+		case constants.BgpAttributeLocalPref:
+			if len(value) != 4 {
+				return attributes, errors.New("invalid BGP LOCAL_PREF")
+			}
+			attributes.LocalPref = binary.BigEndian.Uint32(value)
+			attributes.HasLocalPref = true
+		}
+	}
+	return attributes, nil
+}
+
+func (message BgpMessage) SerializeBgpMessage() []byte {
+	data := make([]byte, bgpHeaderSize)
+	copy(data[:16], bytes.Repeat([]byte{0xFF}, 16))
+	data[18] = message.Type
+
+	switch message.Type {
+	case constants.BgpMessageOpen:
+		data = append(data, constants.BgpVersion)
+		data = binary.BigEndian.AppendUint16(data, message.Open.AS)
+		data = binary.BigEndian.AppendUint16(data, message.Open.HoldTime)
+		data = append(data, net.IP(message.Open.RouterID[:]).To4()...)
+		data = append(data, 0)
+	case constants.BgpMessageUpdate:
+		var withdrawn []byte
+		for _, prefix := range message.Update.Withdrawn {
+			withdrawn = serializeBgpPrefix(withdrawn, prefix)
+		}
+		data = binary.BigEndian.AppendUint16(data, uint16(len(withdrawn)))
+		data = append(data, withdrawn...)
+
+		var attributes []byte
+		if len(message.Update.Nlri) > 0 {
+			attributes = message.Update.Attributes.serialize()
+		}
+		data = binary.BigEndian.AppendUint16(data, uint16(len(attributes)))
+		data = append(data, attributes...)
+		for _, prefix := range message.Update.Nlri {
+			data = serializeBgpPrefix(data, prefix)
+		}
+	}
+
+	binary.BigEndian.PutUint16(data[16:18], uint16(len(data)))
+	return data
+}
+
+func DeserializeBgpMessage(data []byte) (*BgpMessage, error) {
+	if len(data) < bgpHeaderSize || !bytes.Equal(data[:16], bytes.Repeat([]byte{0xFF}, 16)) {
+		return nil, errors.New("invalid BGP message header")
+	}
+	length := int(binary.BigEndian.Uint16(data[16:18]))
+	if length < bgpHeaderSize || length > len(data) {
+		return nil, errors.New("invalid BGP message length")
+	}
+	message := &BgpMessage{Type: data[18]}
+	body := data[bgpHeaderSize:length]
+
+	switch message.Type {
+	case constants.BgpMessageOpen:
+		if len(body) < bgpOpenSize {
+			return nil, errors.New("truncated BGP OPEN")
+		}
+		if body[0] != constants.BgpVersion {
+			return nil, errors.New("unsupported BGP version")
+		}
+		message.Open = &BgpOpen{
+			AS:       binary.BigEndian.Uint16(body[1:3]),
+			HoldTime: binary.BigEndian.Uint16(body[3:5]),
+			RouterID: getIPv4(body[5:9]),
+		}
+	case constants.BgpMessageUpdate:
+		if len(body) < 4 {
+			return nil, errors.New("truncated BGP UPDATE")
+		}
+		withdrawnLength := int(binary.BigEndian.Uint16(body[0:2]))
+		if len(body) < 4+withdrawnLength {
+			return nil, errors.New("truncated BGP UPDATE")
+		}
+		attributesLength := int(binary.BigEndian.Uint16(body[2+withdrawnLength:]))
+		if len(body) < 4+withdrawnLength+attributesLength {
+			return nil, errors.New("truncated BGP UPDATE")
+		}
+
+		update := &BgpUpdate{}
+		var err error
+		if update.Withdrawn, err = deserializeBgpPrefixes(body[2 : 2+withdrawnLength]); err != nil {
+			return nil, err
+		}
+		attributes := body[4+withdrawnLength : 4+withdrawnLength+attributesLength]
+		if update.Attributes, err = deserializeBgpPathAttributes(attributes); err != nil {
+			return nil, err
+		}
+		if update.Nlri, err = deserializeBgpPrefixes(body[4+withdrawnLength+attributesLength:]); err != nil {
+			return nil, err
+		}
+		message.Update = update
+	case constants.BgpMessageNotification, constants.BgpMessageKeepalive:
+	default:
+		return nil, errors.New("unsupported BGP message type")
+	}
+	return message, nil
+}
+
+func (filter *BgpPrefixFilter) Permits(IP IPAddress, mask rune) bool {
+	if len(filter.Entries) == 0 {
+		return true
+	}
+	for _, entry := range filter.Entries {
+		if mask < entry.Mask || mask > entry.MaxMask {
+			continue
+		}
+		if ApplyMask(IP, entry.Mask) == entry.IP {
+			return entry.Permit
+		}
+	}
+	return false
+}
+
+func (peer *BgpPeer) IsIBGP(bgp *BgpInstance) bool {
+	return peer.RemoteAS == bgp.AS
+}
+
+func (bgp *BgpInstance) LookupPeer(IP IPAddress) *BgpPeer {
+	for dllPeer := bgp.Peers.Next; dllPeer != nil; dllPeer = dllPeer.Next {
+		peer := dllPeer.DllToBgpPeer()
+		if peer.IP == IP {
+			return peer
+		}
+	}
+	return nil
+}
+
+func (bgp *BgpInstance) LookupPath(IP IPAddress, mask rune, peer *BgpPeer) *BgpPath {
+	for dllPath := bgp.Paths.Next; dllPath != nil; dllPath = dllPath.Next {
+		path := dllPath.DllToBgpPath()
+		if path.Peer == peer && path.Mask == mask && path.Prefix == IP {
+			return path
+		}
+	}
+	return nil
+}
+
+func (bgp *BgpInstance) AddPath(path *BgpPath) {
+	(&path.PathGlue).Init()
+	(&bgp.Paths).AddNode(&path.PathGlue)
+}
+
+func (path *BgpPath) localPref() uint32 {
+	if path.Attributes.HasLocalPref {
+		return path.Attributes.LocalPref
+	}
+	return constants.BgpDefaultLocalPref
+}
+
+// isBetter runs the best path comparison: local origin, highest LOCAL_PREF, shortest AS_PATH,
+// lowest MED, eBGP over iBGP and finally the lowest router ID of the peer.
+func (path *BgpPath) isBetter(other *BgpPath, bgp *BgpInstance) bool {
+	if (path.Peer == nil) != (other.Peer == nil) {
+		return path.Peer == nil
+	}
+	if path.localPref() != other.localPref() {
+		return path.localPref() > other.localPref()
+	}
+	if len(path.Attributes.AsPath) != len(other.Attributes.AsPath) {
+		return len(path.Attributes.AsPath) < len(other.Attributes.AsPath)
+	}
+	if path.Attributes.Med != other.Attributes.Med {
+		return path.Attributes.Med < other.Attributes.Med
+	}
+	if path.Peer == nil {
+		return false
+	}
+	if path.Peer.IsIBGP(bgp) != other.Peer.IsIBGP(bgp) {
+		return !path.Peer.IsIBGP(bgp)
+	}
+	return bytes.Compare(path.Peer.RouterID[:], other.Peer.RouterID[:]) < 0
+}
+
+// SelectBestPath marks the best of the paths to the prefix whose next hop is reachable and returns it.
+func (bgp *BgpInstance) SelectBestPath(IP IPAddress, mask rune, isReachable func(IPAddress) bool) *BgpPath {
+	var bestPath *BgpPath
+	for dllPath := bgp.Paths.Next; dllPath != nil; dllPath = dllPath.Next {
+		path := dllPath.DllToBgpPath()
+		if path.Mask != mask || path.Prefix != IP {
+			continue
+		}
+		path.IsBest = false
+		if path.Peer != nil && !isReachable(path.Attributes.NextHop) {
+			continue
+		}
+		if bestPath == nil || path.isBetter(bestPath, bgp) {
+			bestPath = path
+		}
+	}
+	if bestPath != nil {
+		bestPath.IsBest = true
+	}
+	return bestPath
+}
+
+func bgpStateString(state uint8) string {
+	switch state {
+	case BgpStateOpenSent:
+		return "OpenSent"
+	case BgpStateOpenConfirm:
+		return "OpenConfirm"
+	case BgpStateEstablished:
+		return "Established"
+	default:
+		return "Idle"
+	}
+}
+
+func (bgp *BgpInstance) PrintSummary() {
+	bgp.Mutex.Lock()
+	defer bgp.Mutex.Unlock()
+
+	now := time.Now()
+	fmt.Printf("BGP router identifier %s, local AS number %d\n", bgp.RouterID.String(), bgp.AS)
+	for dllPeer := bgp.Peers.Next; dllPeer != nil; dllPeer = dllPeer.Next {
+		peer := dllPeer.DllToBgpPeer()
+		received := 0
+		for dllPath := bgp.Paths.Next; dllPath != nil; dllPath = dllPath.Next {
+			if dllPath.DllToBgpPath().Peer == peer {
+				received++
+			}
+		}
+		upTime := "never"
+		if peer.State == BgpStateEstablished {
+			upTime = now.Sub(peer.EstablishedAt).Truncate(time.Second).String()
+		}
+		fmt.Printf("Neighbor: %s, AS: %d, State: %s, Up/Down: %s, Prefixes Received: %d\n",
+			peer.IP.String(), peer.RemoteAS, bgpStateString(peer.State), upTime, received)
+	}
+}
+
+func (bgp *BgpInstance) PrintRoutes() {
+	bgp.Mutex.Lock()
+	defer bgp.Mutex.Unlock()
+
+	for dllPath := bgp.Paths.Next; dllPath != nil; dllPath = dllPath.Next {
+		path := dllPath.DllToBgpPath()
+		marker := "* "
+		if path.IsBest {
+			marker = "*>"
+		}
+		asPath := make([]string, 0, len(path.Attributes.AsPath))
+		for _, AS := range path.Attributes.AsPath {
+			asPath = append(asPath, fmt.Sprint(AS))
+		}
+		nextHop := path.Attributes.NextHop.String()
+		if path.Peer == nil {
+			nextHop = "local"
+		}
+		fmt.Printf("%s %s/%d, Next Hop: %s, MED: %d, LocPrf: %d, AS Path: %s\n",
+			marker, path.Prefix.String(), path.Mask, nextHop, path.Attributes.Med, path.localPref(), strings.Join(asPath, " "))
+	}
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+// states of a packet as seen by connection tracking, a connection is NEW until a reply went through
+const (
+	ConntrackStateNew uint8 = iota
+	ConntrackStateEstablished
+	ConntrackStateRelated
+	ConntrackStateInvalid
+)
+
+// states of a tracked TCP connection
+const (
+	TcpConntrackSynSent uint8 = iota
+	TcpConntrackSynReceived
+	TcpConntrackEstablished
+	TcpConntrackFinWait
+	TcpConntrackClose
+)
+
+// ConntrackTuple identifies the packets of one direction of a flow, ICMP queries use their
+// identifier as both ports so that requests and replies reverse into each other.
+type ConntrackTuple struct {
+	Protocol        uint8
+	SourceIP        IPAddress
+	DestinationIP   IPAddress
+	SourcePort      uint16
+	DestinationPort uint16
+}
+
+// ConntrackEntry is a connection opened by the original tuple, packets of the reversed tuple are
+// its replies.
+type ConntrackEntry struct {
+	Original       ConntrackTuple
+	State          uint8
+	TcpState       uint8
+	Packets        uint64
+	ReplyPackets   uint64
+	ExpiresAt      time.Time
+	ConnectionGlue Dll
+}
+
+// FirewallZonePair is the direction of a zone policy, connections are opened from zone From to zone To.
+type FirewallZonePair struct {
+	From string
+	To   string
+}
+
+// FirewallInstance assigns interfaces to zones and tracks the connections routed between them, new
+// connections need a policy permitting their zone pair while replies and related ICMP errors of
+// tracked connections always pass.
+type FirewallInstance struct {
+	Zones       map[*Interface]string
+	Policies    map[FirewallZonePair]bool
+	Connections Dll
+	Mutex       sync.Mutex
+}
+
+func (dll *Dll) DllToConntrackEntry() *ConntrackEntry {
+	return (*ConntrackEntry)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(ConntrackEntry{}.ConnectionGlue)))
+}
+
+func (tuple ConntrackTuple) Reverse() ConntrackTuple {
+	return ConntrackTuple{
+		Protocol:        tuple.Protocol,
+		SourceIP:        tuple.DestinationIP,
+		DestinationIP:   tuple.SourceIP,
+		SourcePort:      tuple.DestinationPort,
+		DestinationPort: tuple.SourcePort,
+	}
+}
+
+func (tuple ConntrackTuple) String() string {
+	return fmt.Sprintf("%s %s:%d -> %s:%d", natProtocolString(tuple.Protocol), tuple.SourceIP.String(), tuple.SourcePort,
+		tuple.DestinationIP.String(), tuple.DestinationPort)
+}
+
+func (firewall *FirewallInstance) AddConnection(entry *ConntrackEntry) {
+	(&entry.ConnectionGlue).Init()
+	(&firewall.Connections).AddNode(&entry.ConnectionGlue)
+}
+
+// LookupConnection returns the connection the tuple belongs to and whether the tuple is its reply direction.
+func (firewall *FirewallInstance) LookupConnection(tuple ConntrackTuple) (*ConntrackEntry, bool) {
+	reverse := tuple.Reverse()
+	for dllEntry := firewall.Connections.Next; dllEntry != nil; dllEntry = dllEntry.Next {
+		entry := dllEntry.DllToConntrackEntry()
+		if entry.Original == tuple {
+			return entry, false
+		}
+		if entry.Original == reverse {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// ClearConnections removes every tracked connection and returns how many there were.
+func (firewall *FirewallInstance) ClearConnections() int {
+	count := 0
+	var next *Dll
+	for dllEntry := firewall.Connections.Next; dllEntry != nil; dllEntry = next {
+		next = dllEntry.Next
+		dllEntry.RemoveNode()
+		count++
+	}
+	return count
+}
+
+func ConntrackStateString(state uint8) string {
+	switch state {
+	case ConntrackStateNew:
+		return "NEW"
+	case ConntrackStateEstablished:
+		return "ESTABLISHED"
+	case ConntrackStateRelated:
+		return "RELATED"
+	default:
+		return "INVALID"
+	}
+}
+
+func tcpConntrackStateString(state uint8) string {
+	switch state {
+	case TcpConntrackSynSent:
+		return "SYN_SENT"
+	case TcpConntrackSynReceived:
+		return "SYN_RECV"
+	case TcpConntrackEstablished:
+		return "ESTABLISHED"
+	case TcpConntrackFinWait:
+		return "FIN_WAIT"
+	default:
+		return "CLOSE"
+	}
+}
+
+func (firewall *FirewallInstance) PrintConnections() {
+	firewall.Mutex.Lock()
+	defer firewall.Mutex.Unlock()
+
+	now := time.Now()
+	for dllEntry := firewall.Connections.Next; dllEntry != nil; dllEntry = dllEntry.Next {
+		entry := dllEntry.DllToConntrackEntry()
+		state := ConntrackStateString(entry.State)
+		if entry.Original.Protocol == constants.TcpProto {
+			state += "/" + tcpConntrackStateString(entry.TcpState)
+		}
+		fmt.Printf("Original: %s, Reply: %s, State: %s, Packets: %d/%d, Expires in: %ds\n",
+			entry.Original.String(), entry.Original.Reverse().String(), state, entry.Packets, entry.ReplyPackets,
+			int(entry.ExpiresAt.Sub(now).Seconds()))
+	}
+}
+
+func (firewall *FirewallInstance) Print() {
+	firewall.Mutex.Lock()
+	defer firewall.Mutex.Unlock()
+
+	var zones []string
+	for intf, zone := range firewall.Zones {
+		zones = append(zones, fmt.Sprintf("Interface: %s, Zone: %s", intf.Name.String(), zone))
+	}
+	sort.Strings(zones)
+	var policies []string
+	for pair := range firewall.Policies {
+		policies = append(policies, fmt.Sprintf("Policy: %s -> %s, permit", pair.From, pair.To))
+	}
+	sort.Strings(policies)
+	for _, line := range append(zones, policies...) {
+		fmt.Println(line)
+	}
+}
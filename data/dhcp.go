@@ -0,0 +1,418 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const (
+	dhcpFixedSize    = 236
+	dhcpOptionOffset = dhcpFixedSize + 4
+)
+
+const (
+	DhcpStateInit uint8 = iota
+	DhcpStateSelecting
+	DhcpStateRequesting
+	DhcpStateBound
+	DhcpStateRenewing
+	DhcpStateRebinding
+)
+
+// DhcpMessage carries the BOOTP fields and the options used by the simulator, unset options are zero.
+type DhcpMessage struct {
+	Op           uint8
+	Hops         uint8
+	XID          uint32
+	Flags        uint16
+	ClientIP     IPAddress
+	YourIP       IPAddress
+	GatewayIP    IPAddress
+	ClientMAC    MacAddress
+	MessageType  uint8
+	SubnetMask   rune
+	Router       IPAddress
+	LeaseSeconds uint32
+	ServerID     IPAddress
+	RequestedIP  IPAddress
+}
+
+type DhcpPool struct {
+	Name         string
+	Network      IPAddress
+	Mask         rune
+	RangeStart   IPAddress
+	RangeEnd     IPAddress
+	Gateway      IPAddress
+	LeaseSeconds uint32
+	Bindings     map[MacAddress]IPAddress
+	PoolGlue     Dll
+}
+
+type DhcpLease struct {
+	Pool      *DhcpPool
+	MAC       MacAddress
+	IP        IPAddress
+	IsBound   bool
+	ExpiresAt time.Time
+	LeaseGlue Dll
+}
+
+type DhcpClient struct {
+	Interface      *Interface
+	State          uint8
+	XID            uint32
+	ServerID       IPAddress
+	IP             IPAddress
+	Mask           rune
+	Router         IPAddress
+	LeaseSeconds   uint32
+	LeasedAt       time.Time
+	NextRetransmit time.Time
+	ClientGlue     Dll
+}
+
+// DhcpRelay forwards the DHCP broadcasts received on the interface to the server.
+type DhcpRelay struct {
+	Interface *Interface
+	ServerIP  IPAddress
+	RelayGlue Dll
+}
+
+type DhcpInstance struct {
+	Pools   Dll
+	Leases  Dll
+	Clients Dll
+	Relays  Dll
+	Mutex   sync.Mutex
+}
+
+func (dll *Dll) DllToDhcpPool() *DhcpPool {
+	return (*DhcpPool)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(DhcpPool{}.PoolGlue)))
+}
+
+func (dll *Dll) DllToDhcpLease() *DhcpLease {
+	return (*DhcpLease)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(DhcpLease{}.LeaseGlue)))
+}
+
+func (dll *Dll) DllToDhcpClient() *DhcpClient {
+	return (*DhcpClient)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(DhcpClient{}.ClientGlue)))
+}
+
+func (dll *Dll) DllToDhcpRelay() *DhcpRelay {
+	return (*DhcpRelay)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(DhcpRelay{}.RelayGlue)))
+}
+
+func (message DhcpMessage) SerializeDhcpMessage() []byte {
+	data := make([]byte, dhcpOptionOffset, dhcpOptionOffset+64)
+	data[0] = message.Op
+	data[1] = 1
+	data[2] = 6
+	data[3] = message.Hops
+	binary.BigEndian.PutUint32(data[4:8], message.XID)
+	binary.BigEndian.PutUint16(data[10:12], message.Flags)
+	putIPv4(data[12:16], message.ClientIP)
+	putIPv4(data[16:20], message.YourIP)
+	putIPv4(data[24:28], message.GatewayIP)
+	copy(data[28:34], message.ClientMAC[:])
+	binary.BigEndian.PutUint32(data[dhcpFixedSize:dhcpOptionOffset], constants.DhcpMagicCookie)
+
+	data = append(data, constants.DhcpOptionMessageType, 1, message.MessageType)
+	if message.SubnetMask != 0 {
+		data = append(data, constants.DhcpOptionSubnetMask, 4)
+		data = append(data, net.CIDRMask(int(message.SubnetMask), 32)...)
+	}
+	for _, option := range []struct {
+		code uint8
+		IP   IPAddress
+	}{
+		{constants.DhcpOptionRouter, message.Router},
+		{constants.DhcpOptionServerID, message.ServerID},
+		{constants.DhcpOptionRequestedIP, message.RequestedIP},
+	} {
+		if !option.IP.IsUnspecified() {
+			data = append(data, option.code, 4)
+			data = append(data, net.IP(option.IP[:]).To4()...)
+		}
+	}
+	if message.LeaseSeconds != 0 {
+		data = append(data, constants.DhcpOptionLeaseTime, 4)
+		data = binary.BigEndian.AppendUint32(data, message.LeaseSeconds)
+	}
+	return append(data, constants.DhcpOptionEnd)
+}
+
+func DeserializeDhcpMessage(data []byte) (*DhcpMessage, error) {
+	if len(data) < dhcpOptionOffset || binary.BigEndian.Uint32(data[dhcpFixedSize:dhcpOptionOffset]) != constants.DhcpMagicCookie {
+		return nil, errors.New("invalid DHCP message")
+	}
+
+	message := &DhcpMessage{
+		Op:        data[0],
+		Hops:      data[3],
+		XID:       binary.BigEndian.Uint32(data[4:8]),
+		Flags:     binary.BigEndian.Uint16(data[10:12]),
+		ClientIP:  getIPv4(data[12:16]),
+		YourIP:    getIPv4(data[16:20]),
+		GatewayIP: getIPv4(data[24:28]),
+	}
+	copy(message.ClientMAC[:], data[28:34])
+
+	for offset := dhcpOptionOffset; offset < len(data); {
+		code := data[offset]
+		if code == constants.DhcpOptionEnd {
+			break
+		}
+		if code == constants.DhcpOptionPad {
+			offset++
+			continue
+		}
+		if offset+2 > len(data) || offset+2+int(data[offset+1]) > len(data) {
+			return nil, errors.New("truncated DHCP option")
+		}
+		value := data[offset+2 : offset+2+int(data[offset+1])]
+		offset += 2 + len(value)
+
+		if code == constants.DhcpOptionMessageType && len(value) == 1 {
+			message.MessageType = value[0]
+			continue
+		}
+		if len(value) != 4 {
+			continue
+		}
+		switch code {
+		case constants.DhcpOptionSubnetMask:
+			ones, _ := net.IPMask(value).Size()
+			message.SubnetMask = rune(ones)
+		case constants.DhcpOptionRouter:
+			message.Router = getIPv4(value)
+		case constants.DhcpOptionServerID:
+			message.ServerID = getIPv4(value)
+		case constants.DhcpOptionRequestedIP:
+			message.RequestedIP = getIPv4(value)
+		case constants.DhcpOptionLeaseTime:
+			message.LeaseSeconds = binary.BigEndian.Uint32(value)
+		}
+	}
+	if message.MessageType == 0 {
+		return nil, errors.New("DHCP message type missing")
+	}
+	return message, nil
+}
+
+func ipv4ToUint32(IP IPAddress) uint32 {
+	return binary.BigEndian.Uint32(IP[12:16])
+}
+
+func uint32ToIPv4(value uint32) IPAddress {
+	IP := IPAddress{10: 0xFF, 11: 0xFF}
+	binary.BigEndian.PutUint32(IP[12:16], value)
+	return IP
+}
+
+// Contains reports whether IP is part of the subnet of the pool.
+func (pool *DhcpPool) Contains(IP IPAddress) bool {
+	return ApplyMask(IP, pool.Mask) == pool.Network
+}
+
+func (dhcp *DhcpInstance) LookupPool(name string) *DhcpPool {
+	for dllPool := dhcp.Pools.Next; dllPool != nil; dllPool = dllPool.Next {
+		pool := dllPool.DllToDhcpPool()
+		if pool.Name == name {
+			return pool
+		}
+	}
+	return nil
+}
+
+// LookupPoolBySubnet returns the pool serving the subnet of IP.
+func (dhcp *DhcpInstance) LookupPoolBySubnet(IP IPAddress) *DhcpPool {
+	for dllPool := dhcp.Pools.Next; dllPool != nil; dllPool = dllPool.Next {
+		pool := dllPool.DllToDhcpPool()
+		if pool.Contains(IP) {
+			return pool
+		}
+	}
+	return nil
+}
+
+func (dhcp *DhcpInstance) AddPool(pool *DhcpPool) {
+	pool.Network = ApplyMask(pool.Network, pool.Mask)
+	pool.Bindings = map[MacAddress]IPAddress{}
+	(&pool.PoolGlue).Init()
+	(&dhcp.Pools).AddNode(&pool.PoolGlue)
+}
+
+func (dhcp *DhcpInstance) LookupLease(pool *DhcpPool, MAC MacAddress) *DhcpLease {
+	for dllLease := dhcp.Leases.Next; dllLease != nil; dllLease = dllLease.Next {
+		lease := dllLease.DllToDhcpLease()
+		if lease.Pool == pool && lease.MAC == MAC {
+			return lease
+		}
+	}
+	return nil
+}
+
+func (dhcp *DhcpInstance) lookupLeaseByIP(IP IPAddress) *DhcpLease {
+	for dllLease := dhcp.Leases.Next; dllLease != nil; dllLease = dllLease.Next {
+		lease := dllLease.DllToDhcpLease()
+		if lease.IP == IP {
+			return lease
+		}
+	}
+	return nil
+}
+
+func (pool *DhcpPool) isStaticIP(IP IPAddress) bool {
+	for _, bindingIP := range pool.Bindings {
+		if bindingIP == IP {
+			return true
+		}
+	}
+	return false
+}
+
+// AllocateLease returns the lease of the client in the pool, a new lease gets the static binding of
+// the client or the lowest free address of the range. It returns nil when the pool is exhausted.
+func (dhcp *DhcpInstance) AllocateLease(pool *DhcpPool, MAC MacAddress) *DhcpLease {
+	if lease := dhcp.LookupLease(pool, MAC); lease != nil {
+		return lease
+	}
+
+	IP, isStatic := pool.Bindings[MAC]
+	if !isStatic {
+		found := false
+		for value := ipv4ToUint32(pool.RangeStart); value <= ipv4ToUint32(pool.RangeEnd); value++ {
+			IP = uint32ToIPv4(value)
+			if IP != pool.Gateway && !pool.isStaticIP(IP) && dhcp.lookupLeaseByIP(IP) == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+
+	lease := &DhcpLease{
+		Pool: pool,
+		MAC:  MAC,
+		IP:   IP,
+	}
+	(&lease.LeaseGlue).Init()
+	(&dhcp.Leases).AddNode(&lease.LeaseGlue)
+	return lease
+}
+
+func (dhcp *DhcpInstance) LookupClient(intf *Interface) *DhcpClient {
+	for dllClient := dhcp.Clients.Next; dllClient != nil; dllClient = dllClient.Next {
+		client := dllClient.DllToDhcpClient()
+		if client.Interface == intf {
+			return client
+		}
+	}
+	return nil
+}
+
+func (dhcp *DhcpInstance) AddClient(client *DhcpClient) {
+	(&client.ClientGlue).Init()
+	(&dhcp.Clients).AddNode(&client.ClientGlue)
+}
+
+func (dhcp *DhcpInstance) LookupRelay(intf *Interface, serverIP IPAddress) *DhcpRelay {
+	for dllRelay := dhcp.Relays.Next; dllRelay != nil; dllRelay = dllRelay.Next {
+		relay := dllRelay.DllToDhcpRelay()
+		if relay.Interface == intf && (serverIP.IsUnspecified() || relay.ServerIP == serverIP) {
+			return relay
+		}
+	}
+	return nil
+}
+
+// LookupRelayByAddress returns a relay of the interface with the relay agent address IP.
+func (dhcp *DhcpInstance) LookupRelayByAddress(IP IPAddress) *DhcpRelay {
+	for dllRelay := dhcp.Relays.Next; dllRelay != nil; dllRelay = dllRelay.Next {
+		relay := dllRelay.DllToDhcpRelay()
+		if relay.Interface.Properties.IP == IP {
+			return relay
+		}
+	}
+	return nil
+}
+
+func (dhcp *DhcpInstance) AddRelay(relay *DhcpRelay) {
+	(&relay.RelayGlue).Init()
+	(&dhcp.Relays).AddNode(&relay.RelayGlue)
+}
+
+// RenewAt and RebindAt are the T1 and T2 times of the lease, at half and seven eighths of the lease time.
+func (client *DhcpClient) RenewAt() time.Time {
+	return client.LeasedAt.Add(time.Duration(client.LeaseSeconds) * time.Second / 2)
+}
+
+func (client *DhcpClient) RebindAt() time.Time {
+	return client.LeasedAt.Add(time.Duration(client.LeaseSeconds) * time.Second * 7 / 8)
+}
+
+func (client *DhcpClient) ExpiresAt() time.Time {
+	return client.LeasedAt.Add(time.Duration(client.LeaseSeconds) * time.Second)
+}
+
+func dhcpStateString(state uint8) string {
+	switch state {
+	case DhcpStateSelecting:
+		return "Selecting"
+	case DhcpStateRequesting:
+		return "Requesting"
+	case DhcpStateBound:
+		return "Bound"
+	case DhcpStateRenewing:
+		return "Renewing"
+	case DhcpStateRebinding:
+		return "Rebinding"
+	default:
+		return "Init"
+	}
+}
+
+func (dhcp *DhcpInstance) PrintLeases() {
+	dhcp.Mutex.Lock()
+	defer dhcp.Mutex.Unlock()
+
+	now := time.Now()
+	for dllLease := dhcp.Leases.Next; dllLease != nil; dllLease = dllLease.Next {
+		lease := dllLease.DllToDhcpLease()
+		state := "offered"
+		if lease.IsBound {
+			state = "bound"
+		}
+		if _, isStatic := lease.Pool.Bindings[lease.MAC]; isStatic {
+			state += ", static"
+		}
+		fmt.Printf("Pool: %s, IP: %s, MAC: %s, State: %s, Expires in: %ds\n",
+			lease.Pool.Name, lease.IP.String(), lease.MAC.String(), state, int(lease.ExpiresAt.Sub(now).Seconds()))
+	}
+
+	for dllClient := dhcp.Clients.Next; dllClient != nil; dllClient = dllClient.Next {
+		client := dllClient.DllToDhcpClient()
+		if client.State < DhcpStateBound {
+			fmt.Printf("Interface Name: %s, State: %s\n", client.Interface.Name.String(), dhcpStateString(client.State))
+			continue
+		}
+		fmt.Printf("Interface Name: %s, State: %s, IP: %s/%d, Router: %s, Server: %s, Renew in: %ds, Expires in: %ds\n",
+			client.Interface.Name.String(), dhcpStateString(client.State), client.IP.String(), client.Mask,
+			client.Router.String(), client.ServerID.String(), int(client.RenewAt().Sub(now).Seconds()), int(client.ExpiresAt().Sub(now).Seconds()))
+	}
+
+	for dllRelay := dhcp.Relays.Next; dllRelay != nil; dllRelay = dllRelay.Next {
+		relay := dllRelay.DllToDhcpRelay()
+		fmt.Printf("Relay Interface Name: %s, Agent IP: %s, Server: %s\n",
+			relay.Interface.Name.String(), relay.Interface.Properties.IP.String(), relay.ServerIP.String())
+	}
+}
@@ -35,6 +35,7 @@ type Link struct {
 	Interface1 Interface
 	Interface2 Interface
 	Cost       uint
+	IsDown     bool
 }
 
 func (dll *Dll) DllToNode() *Node {
@@ -83,6 +84,18 @@ func (intf *Interface) GetNeighbourNode() *Node {
 	return link.Interface1.Node
 }
 
+// LinkCost is the routing metric of the link attached to intf, links without a cost count as one hop.
+func (intf *Interface) LinkCost() uint32 {
+	if intf.Link == nil || intf.Link.Cost == 0 {
+		return 1
+	}
+	return uint32(intf.Link.Cost)
+}
+
+func (intf *Interface) IsUp() bool {
+	return intf.Link != nil && !intf.Link.IsDown
+}
+
 func (node *Node) GetNodeIntfByName(intfName string) *Interface {
 	var intf *Interface
 
@@ -151,22 +164,48 @@ func (intf *Interface) InterfaceAssignMACAddress() {
 }
 
 func (node *Node) GetMatchingSubnetInterface(IP IPAddress) *Interface {
+	return node.GetVrfMatchingSubnetInterface(IP, nil)
+}
+
+// GetVrfMatchingSubnetInterface returns the interface of vrf on the subnet of IP, the nil VRF also
+// matches the tunnels of the node.
+func (node *Node) GetVrfMatchingSubnetInterface(IP IPAddress, vrf *Vrf) *Interface {
 	for _, intf := range node.Interfaces {
-		if intf == nil || !intf.Properties.IsIpConfigured {
+		if intf == nil {
+			continue
+		}
+		if intf.Properties.Vrf != vrf {
+			continue
+		}
+		intfIP, mask := intf.Properties.IP, intf.Properties.Mask
+		if !IP.IsIPv4() {
+			// link-local addresses are on every IPv6 link, only global addresses select an interface
+			if !intf.Properties.IsIpv6Enabled || intf.Properties.IPv6.IsUnspecified() {
+				continue
+			}
+			intfIP, mask = intf.Properties.IPv6, intf.Properties.IPv6Mask
+		} else if !intf.Properties.IsIpConfigured {
 			continue
 		}
 
-		subnet1 := applyMask(IP, intf.Properties.Mask)
-		subnet2 := applyMask(intf.Properties.IP, intf.Properties.Mask)
+		subnet1 := ApplyMask(IP, mask)
+		subnet2 := ApplyMask(intfIP, mask)
 
 		if bytes.Equal(subnet1[:], subnet2[:]) {
 			return intf
 		}
 	}
+	if IP.IsIPv4() && vrf == nil && node.Properties.Tunnels != nil {
+		return node.Properties.Tunnels.matchingSubnetInterface(IP)
+	}
 	return nil
 }
 
-func applyMask(ip IPAddress, mask rune) IPAddress {
+// ApplyMask returns the subnet of ip, the mask counts the bits of the IPv4 or IPv6 address.
+func ApplyMask(ip IPAddress, mask rune) IPAddress {
+	if !ip.IsIPv4() {
+		return IPAddress(net.IP(ip[:]).Mask(net.CIDRMask(int(mask), 128)))
+	}
 	return IPAddress(net.IP(ip[:]).Mask(net.CIDRMask(int(mask), 32)).To16())
 }
 
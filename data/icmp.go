@@ -0,0 +1,38 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"tcpip/constants"
+)
+
+type IcmpHeader struct {
+	Type     uint8
+	Code     uint8
+	Checksum uint16
+	Rest     uint32
+}
+
+// SerializeIcmpMessage encodes the header followed by body, the checksum covers the whole message.
+func (header IcmpHeader) SerializeIcmpMessage(body []byte) []byte {
+	data := make([]byte, constants.IcmpHeaderSize+len(body))
+	data[0] = header.Type
+	data[1] = header.Code
+	binary.BigEndian.PutUint32(data[4:8], header.Rest)
+	copy(data[constants.IcmpHeaderSize:], body)
+	binary.BigEndian.PutUint16(data[2:4], InternetChecksum(data))
+	return data
+}
+
+// DeserializeIcmpHeader decodes the header of an ICMP message, the body starts at IcmpHeaderSize.
+func DeserializeIcmpHeader(data []byte) (IcmpHeader, error) {
+	if len(data) < constants.IcmpHeaderSize {
+		return IcmpHeader{}, errors.New("invalid ICMP message length")
+	}
+	return IcmpHeader{
+		Type:     data[0],
+		Code:     data[1],
+		Checksum: binary.BigEndian.Uint16(data[2:4]),
+		Rest:     binary.BigEndian.Uint32(data[4:8]),
+	}, nil
+}
@@ -0,0 +1,169 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"tcpip/constants"
+)
+
+type IPv6Header struct {
+	Version       uint8
+	TrafficClass  uint8
+	FlowLabel     uint32
+	PayloadLength uint16
+	NextHeader    uint8
+	HopLimit      uint8
+	SourceIP      IPAddress
+	DestinationIP IPAddress
+}
+
+// NdMessage is the body of a neighbor solicitation or advertisement, the link-layer address option
+// carries the source address of a solicitation and the target address of an advertisement.
+type NdMessage struct {
+	Target              IPAddress
+	LinkLayerAddress    MacAddress
+	HasLinkLayerAddress bool
+}
+
+func (header IPv6Header) SerializeIPv6Header() []byte {
+	data := make([]byte, constants.Ipv6HeaderSize)
+	binary.BigEndian.PutUint32(data[0:4], uint32(6)<<28|uint32(header.TrafficClass)<<20|header.FlowLabel&0xFFFFF)
+	binary.BigEndian.PutUint16(data[4:6], header.PayloadLength)
+	data[6] = header.NextHeader
+	data[7] = header.HopLimit
+	copy(data[8:24], header.SourceIP[:])
+	copy(data[24:40], header.DestinationIP[:])
+	return data
+}
+
+func DeserializeIPv6Header(data []byte) (IPv6Header, error) {
+	if len(data) < constants.Ipv6HeaderSize {
+		return IPv6Header{}, errors.New("invalid IPv6 packet length")
+	}
+	versionClassFlow := binary.BigEndian.Uint32(data[0:4])
+	header := IPv6Header{
+		Version:       uint8(versionClassFlow >> 28),
+		TrafficClass:  uint8(versionClassFlow >> 20),
+		FlowLabel:     versionClassFlow & 0xFFFFF,
+		PayloadLength: binary.BigEndian.Uint16(data[4:6]),
+		NextHeader:    data[6],
+		HopLimit:      data[7],
+	}
+	copy(header.SourceIP[:], data[8:24])
+	copy(header.DestinationIP[:], data[24:40])
+
+	if header.Version != 6 {
+		return IPv6Header{}, errors.New("invalid IPv6 version")
+	}
+	if int(header.PayloadLength) > len(data)-constants.Ipv6HeaderSize {
+		return IPv6Header{}, errors.New("invalid IPv6 payload length")
+	}
+	return header, nil
+}
+
+// PseudoHeaderIPv6 builds the IPv6 pseudo header covered by the checksum of ICMPv6 and transport protocols.
+func PseudoHeaderIPv6(sourceIP IPAddress, destinationIP IPAddress, nextHeader uint8, length int) []byte {
+	data := make([]byte, 40)
+	copy(data[0:16], sourceIP[:])
+	copy(data[16:32], destinationIP[:])
+	binary.BigEndian.PutUint32(data[32:36], uint32(length))
+	data[39] = nextHeader
+	return data
+}
+
+// SerializeIcmpv6Message encodes the header followed by body, unlike ICMP for IPv4 the checksum also
+// covers the pseudo header of the packet.
+func (header IcmpHeader) SerializeIcmpv6Message(sourceIP IPAddress, destinationIP IPAddress, body []byte) []byte {
+	data := make([]byte, constants.IcmpHeaderSize+len(body))
+	data[0] = header.Type
+	data[1] = header.Code
+	binary.BigEndian.PutUint32(data[4:8], header.Rest)
+	copy(data[constants.IcmpHeaderSize:], body)
+	checksum := InternetChecksum(PseudoHeaderIPv6(sourceIP, destinationIP, constants.Icmpv6Proto, len(data)), data)
+	binary.BigEndian.PutUint16(data[2:4], checksum)
+	return data
+}
+
+func VerifyIcmpv6Checksum(sourceIP IPAddress, destinationIP IPAddress, data []byte) bool {
+	return InternetChecksum(PseudoHeaderIPv6(sourceIP, destinationIP, constants.Icmpv6Proto, len(data)), data) == 0
+}
+
+// SerializeNdMessage encodes the target address followed by the link-layer address option of optionType.
+func (message NdMessage) SerializeNdMessage(optionType uint8) []byte {
+	data := make([]byte, 16, 24)
+	copy(data[0:16], message.Target[:])
+	if message.HasLinkLayerAddress {
+		data = append(data, linkLayerAddressOption(optionType, message.LinkLayerAddress)...)
+	}
+	return data
+}
+
+// DeserializeNdMessage decodes the body following the ICMPv6 header, unknown options are skipped.
+func DeserializeNdMessage(data []byte) (NdMessage, error) {
+	var message NdMessage
+	if len(data) < 16 {
+		return NdMessage{}, errors.New("invalid neighbor discovery message length")
+	}
+	copy(message.Target[:], data[0:16])
+
+	err := forEachNdOption(data[16:], func(optionType uint8, option []byte) {
+		if optionType == constants.NdOptionSourceLinkLayerAddress || optionType == constants.NdOptionTargetLinkLayerAddress {
+			copy(message.LinkLayerAddress[:], option[2:8])
+			message.HasLinkLayerAddress = true
+		}
+	})
+	if err != nil {
+		return NdMessage{}, err
+	}
+	return message, nil
+}
+
+func linkLayerAddressOption(optionType uint8, MAC MacAddress) []byte {
+	// option lengths count units of eight bytes
+	return append([]byte{optionType, 1}, MAC[:]...)
+}
+
+// forEachNdOption calls handle with every option including its type and length bytes, link-layer
+// address options are at least eight bytes long.
+func forEachNdOption(options []byte, handle func(optionType uint8, option []byte)) error {
+	for len(options) >= 2 {
+		length := int(options[1]) * 8
+		if length == 0 || length > len(options) {
+			return errors.New("invalid neighbor discovery option length")
+		}
+		handle(options[0], options[:length])
+		options = options[length:]
+	}
+	return nil
+}
+
+// IsIPv4 reports IPv4 addresses, they are stored IPv4-mapped.
+func (ip IPAddress) IsIPv4() bool {
+	return net.IP(ip[:]).To4() != nil
+}
+
+func (ip IPAddress) IsLinkLocalUnicast() bool {
+	return net.IP(ip[:]).IsLinkLocalUnicast()
+}
+
+func (ip IPAddress) IsMulticast() bool {
+	return net.IP(ip[:]).IsMulticast()
+}
+
+// LinkLocalAddressFromMAC builds the fe80::/64 address with the modified EUI-64 interface identifier of MAC.
+func LinkLocalAddressFromMAC(MAC MacAddress) IPAddress {
+	IP := IPAddress{0xFE, 0x80}
+	copy(IP[8:], EUI64InterfaceID(MAC))
+	return IP
+}
+
+// EUI64InterfaceID inserts ff:fe in the middle of MAC and flips its universal/local bit.
+func EUI64InterfaceID(MAC MacAddress) []byte {
+	return []byte{MAC[0] ^ 0x02, MAC[1], MAC[2], 0xFF, 0xFE, MAC[3], MAC[4], MAC[5]}
+}
+
+// SolicitedNodeAddress is the multicast group ff02::1:ffXX:XXXX neighbor solicitations for IP are sent to.
+func SolicitedNodeAddress(IP IPAddress) IPAddress {
+	return IPAddress{0xFF, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xFF, IP[13], IP[14], IP[15]}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sync"
 	"tcpip/constants"
 	"unsafe"
 )
@@ -25,6 +26,16 @@ type VLANEthernetHeader struct {
 	FCS            uint32
 }
 
+type QinQEthernetHeader struct {
+	DestinationMAC MacAddress
+	SourceMAC      MacAddress
+	STag           VLANTag
+	CTag           VLANTag
+	Type           uint16
+	Payload        Payload
+	FCS            uint32
+}
+
 type VLANTag struct {
 	TPID uint16
 	TCI  uint16
@@ -42,8 +53,11 @@ type ArpHeader struct {
 	DestinationIP         IPAddress
 }
 
+// ArpTable is shared by the receive goroutine and the timers sending packets, ArpTableLookup and
+// AddArpTableEntry expect the caller to hold Mutex.
 type ArpTable struct {
 	ArpEntries Dll
+	Mutex      sync.Mutex
 }
 
 type ArpEntry struct {
@@ -67,9 +81,13 @@ type MacTable struct {
 	MacEntries Dll
 }
 
+// MacEntry maps a MAC address to the local interface it was learned on, or to the remote VTEP
+// behind which it was learned for frames received over VXLAN.
 type MacEntry struct {
 	MAC           MacAddress
 	InterfaceName InterfaceName
+	IsRemote      bool
+	VtepIP        IPAddress
 	MacGlue       Dll
 }
 
@@ -103,6 +121,17 @@ func SetVLAN(vlanID uint) VLANTag {
 	return vlanHeader
 }
 
+func SetSVLAN(vlanID uint) VLANTag {
+	if vlanID > 4095 {
+		panic("Invalid S-VLAN ID. It must be in the range 0-4095.")
+	}
+	// 802.1ad service tag, the TCI carries the S-VLAN ID in its lowest 12 bits
+	return VLANTag{
+		TPID: constants.Vlan8021adProto,
+		TCI:  uint16(vlanID & 0xFFF),
+	}
+}
+
 func (hdr *VLANTag) GetVlanID() uint16 {
 	// Extract VLAN ID (bits 0-11 of TCI)
 	return hdr.TCI & 0xFFF
@@ -188,6 +217,34 @@ func (data Packet) DeserializeVLANEthernetHeader() *VLANEthernetHeader {
 	return &header
 }
 
+func (header QinQEthernetHeader) SerializeQinQEthernetHeader() Packet {
+	data := Packet{}
+	copy(data[0:6], header.DestinationMAC[:])
+	copy(data[6:12], header.SourceMAC[:])
+	binary.BigEndian.PutUint16(data[12:14], header.STag.TPID)
+	binary.BigEndian.PutUint16(data[14:16], header.STag.TCI)
+	binary.BigEndian.PutUint16(data[16:18], header.CTag.TPID)
+	binary.BigEndian.PutUint16(data[18:20], header.CTag.TCI)
+	binary.BigEndian.PutUint16(data[20:22], header.Type)
+	copy(data[22:], header.Payload[:])
+	binary.BigEndian.PutUint32(data[len(data)-4:], header.FCS)
+	return data
+}
+
+func (data Packet) DeserializeQinQEthernetHeader() *QinQEthernetHeader {
+	var header QinQEthernetHeader
+	copy(header.DestinationMAC[:], data[0:6])
+	copy(header.SourceMAC[:], data[6:12])
+	header.STag.TPID = binary.BigEndian.Uint16(data[12:14])
+	header.STag.TCI = binary.BigEndian.Uint16(data[14:16])
+	header.CTag.TPID = binary.BigEndian.Uint16(data[16:18])
+	header.CTag.TCI = binary.BigEndian.Uint16(data[18:20])
+	header.Type = binary.BigEndian.Uint16(data[20:22])
+	copy(header.Payload[:], data[22:len(data)-4])
+	header.FCS = binary.BigEndian.Uint32(data[len(data)-4:])
+	return &header
+}
+
 func ArpTableLookup(arpTable *ArpTable, IP IPAddress) *ArpEntry {
 	for dllArpEntry := arpTable.ArpEntries.Next; dllArpEntry != nil; dllArpEntry = dllArpEntry.Next {
 		arpEntry := dllArpEntry.DllToArpEntry()
@@ -251,6 +308,21 @@ func (arpEntry *ArpEntry) DeleteArpEntry() {
 	}
 }
 
+// DeleteInterfaceEntries removes the entries resolved on the interface name.
+func (arpTable *ArpTable) DeleteInterfaceEntries(name InterfaceName) {
+	arpTable.Mutex.Lock()
+	defer arpTable.Mutex.Unlock()
+
+	var next *Dll
+	for dllArpEntry := arpTable.ArpEntries.Next; dllArpEntry != nil; dllArpEntry = next {
+		next = dllArpEntry.Next
+		arpEntry := dllArpEntry.DllToArpEntry()
+		if arpEntry.InterfaceName == name {
+			arpEntry.DeleteArpEntry()
+		}
+	}
+}
+
 func IsArpEntriesEqual(arpEntry1 *ArpEntry, arpEntry2 *ArpEntry) bool {
 	if arpEntry1 == nil || arpEntry2 == nil {
 		return false
@@ -271,6 +343,9 @@ func (arpEntry *ArpEntry) AddPendingArpTableEntry(callback ArpCallback, packet P
 }
 
 func (arpTable *ArpTable) Print() {
+	arpTable.Mutex.Lock()
+	defer arpTable.Mutex.Unlock()
+
 	for dllArpEntry := arpTable.ArpEntries.Next; dllArpEntry != nil; dllArpEntry = dllArpEntry.Next {
 		arpEntry := dllArpEntry.DllToArpEntry()
 
@@ -282,6 +357,10 @@ func (macTable *MacTable) Print() {
 	for dllMacEntry := macTable.MacEntries.Next; dllMacEntry != nil; dllMacEntry = dllMacEntry.Next {
 		macEntry := dllMacEntry.DllToMacEntry()
 
+		if macEntry.IsRemote {
+			fmt.Printf("Mac: %s, VTEP: %s\n", macEntry.MAC.String(), macEntry.VtepIP.String())
+			continue
+		}
 		fmt.Printf("Mac: %s, Interface: %v\n", macEntry.MAC.String(), macEntry.InterfaceName.String())
 	}
 }
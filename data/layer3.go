@@ -3,7 +3,10 @@ package data
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"net"
+	"sync"
 	"tcpip/constants"
 	"unsafe"
 )
@@ -24,19 +27,39 @@ type IPHeader struct {
 	Options       []byte
 }
 
+type Layer3NextHop struct {
+	GatewayIP     IPAddress
+	InterfaceName InterfaceName
+	Weight        uint
+}
+
 type Layer3Route struct {
 	DestinationIP IPAddress
 	Mask          rune
 	IsDirect      bool
-	GatewayIP     IPAddress
-	InterfaceName InterfaceName
+	IsBlackhole   bool
+	Source        uint8
+	Distance      uint8
+	Metric        uint32
+	NextHops      [constants.MaxNextHops]*Layer3NextHop
 	RouteGlue     Dll
 }
 
+// Layer3RouteTable is the FIB of a node, routes are kept in a path compressed trie keyed by prefix.
 type Layer3RouteTable struct {
-	Routes Dll
+	Routes prefixTrie
+	Mutex  sync.RWMutex
 }
 
+var (
+	ErrRouteExists      = errors.New("route already exists")
+	ErrRouteNotFound    = errors.New("route not found")
+	ErrNextHopNotFound  = errors.New("next hop not found")
+	ErrMaxNextHops      = errors.New("maximum number of next hops reached")
+	ErrBlackholeRoute   = errors.New("route is a blackhole route, use replace to change it")
+	ErrInvalidRouteMask = errors.New("invalid mask")
+)
+
 func (dll *Dll) DllToRoute() *Layer3Route {
 	return (*Layer3Route)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(Layer3Route{}.RouteGlue)))
 }
@@ -58,100 +81,144 @@ func (header *IPHeader) Init() {
 }
 
 func (routingTable *Layer3RouteTable) LookupRoutingTableLPM(IP IPAddress) *Layer3Route {
-	var route, lpmL3Route, defaultRoute *Layer3Route
-	var subnet IPAddress
-	var longestMask rune
-
-	for dllRoute := routingTable.Routes.Next; dllRoute != nil; dllRoute = dllRoute.Next {
-		route = dllRoute.DllToRoute()
-		subnet = applyMask(IP, route.Mask)
-
-		if bytes.Equal(constants.DefaultRoutingIp[:], route.DestinationIP[:]) && route.Mask == 0 {
-			defaultRoute = route
-		} else if bytes.Equal(subnet[:], route.DestinationIP[:]) {
-			if route.Mask > longestMask {
-				longestMask = route.Mask
-				lpmL3Route = route
-			}
-		}
-	}
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
 
-	if lpmL3Route != nil {
-		return lpmL3Route
-	}
-	return defaultRoute
+	// IPv4 destinations never fall back to a prefix shorter than the IPv4-mapped range
+	return routingTable.Routes.LookupLPM(IP, trieKeyLength(IP, 0))
 }
 
 func (routingTable *Layer3RouteTable) LookupRoutingTable(IP IPAddress, mask rune) *Layer3Route {
-	for dllRoute := routingTable.Routes.Next; dllRoute != nil; dllRoute = dllRoute.Next {
-		route := dllRoute.DllToRoute()
-		if route.Mask == mask && bytes.Equal(IP[:], route.DestinationIP[:]) {
-			return route
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
+
+	return routingTable.Routes.Lookup(IP, trieKeyLength(IP, mask))
+}
+
+func (route *Layer3Route) LookupNextHop(gatewayIP IPAddress, interfaceName InterfaceName) *Layer3NextHop {
+	for _, nextHop := range route.NextHops {
+		if nextHop == nil {
+			break
+		}
+		if bytes.Equal(nextHop.GatewayIP[:], gatewayIP[:]) && bytes.Equal(nextHop.InterfaceName[:], interfaceName[:]) {
+			return nextHop
 		}
 	}
 	return nil
 }
 
-func isRouteSame(route1 *Layer3Route, route2 *Layer3Route) bool {
-	return route1.Mask == route2.Mask &&
-		bytes.Equal(route1.DestinationIP[:], route2.DestinationIP[:]) &&
-		bytes.Equal(route1.GatewayIP[:], route2.GatewayIP[:]) &&
-		route1.IsDirect == route2.IsDirect &&
-		bytes.Equal(route1.InterfaceName[:], route2.InterfaceName[:])
+func (route *Layer3Route) NextHopCount() int {
+	for i, nextHop := range route.NextHops {
+		if nextHop == nil {
+			return i
+		}
+	}
+	return len(route.NextHops)
 }
 
-func (routingTable *Layer3RouteTable) DeleteRoute(IP IPAddress, mask rune) {
-	route := routingTable.LookupRoutingTable(applyMask(IP, mask), mask)
-	if route == nil {
-		return
+// AddNextHop appends an equal cost next hop to the route, it returns false if the next hop
+// is already present or the route has no free next hop slot.
+func (route *Layer3Route) AddNextHop(gatewayIP IPAddress, interfaceName InterfaceName, weight uint) bool {
+	if route.LookupNextHop(gatewayIP, interfaceName) != nil {
+		return false
+	}
+	count := route.NextHopCount()
+	if count == len(route.NextHops) {
+		return false
+	}
+	if weight == 0 {
+		weight = 1
+	}
+	route.NextHops[count] = &Layer3NextHop{
+		GatewayIP:     gatewayIP,
+		InterfaceName: interfaceName,
+		Weight:        weight,
 	}
-	(&route.RouteGlue).RemoveNode()
+	return true
 }
 
-func (routingTable *Layer3RouteTable) AddRoute(IP IPAddress, mask rune, gatewayIP *IPAddress, interfaceName *InterfaceName) {
-	route := routingTable.LookupRoutingTableLPM(IP)
-
-	if route != nil {
-		fmt.Println("Error : Route already exists")
-		return
+// SelectNextHop picks one of the next hops of the route in proportion to their weights,
+// packets of the same flow carry the same hash and therefore always take the same path.
+func (route *Layer3Route) SelectNextHop(flowHash uint32) *Layer3NextHop {
+	var totalWeight uint
+	for _, nextHop := range route.NextHops {
+		if nextHop == nil {
+			break
+		}
+		totalWeight += nextHop.Weight
 	}
-	route = &Layer3Route{
-		DestinationIP: IPAddress{},
-		Mask:          mask,
-		IsDirect:      gatewayIP == nil && interfaceName == nil,
-		GatewayIP:     IPAddress{},
-		InterfaceName: InterfaceName{},
-		RouteGlue:     Dll{},
+	if totalWeight == 0 {
+		return nil
 	}
 
-	subnet := applyMask(IP, mask)
-	copy(route.DestinationIP[:], subnet[:])
+	bucket := uint(flowHash) % totalWeight
+	for _, nextHop := range route.NextHops {
+		if bucket < nextHop.Weight {
+			return nextHop
+		}
+		bucket -= nextHop.Weight
+	}
+	return nil
+}
 
-	if gatewayIP != nil && interfaceName != nil {
-		copy(route.GatewayIP[:], gatewayIP[:])
-		copy(route.InterfaceName[:], interfaceName[:])
+func (routingTable *Layer3RouteTable) DeleteRoute(IP IPAddress, mask rune) error {
+	routingTable.Mutex.Lock()
+	defer routingTable.Mutex.Unlock()
+
+	if routingTable.Routes.Delete(IP, trieKeyLength(IP, mask)) == nil {
+		return ErrRouteNotFound
 	}
+	return nil
+}
 
-	oldRoute := routingTable.LookupRoutingTable(route.DestinationIP, route.Mask)
-	if oldRoute != nil && isRouteSame(oldRoute, route) {
-		fmt.Println("Error : Route already exists")
-		return
+// ValidateRoutePrefix checks the mask against the address family of IP.
+func ValidateRoutePrefix(IP IPAddress, mask rune) error {
+	maxMask := rune(128)
+	if net.IP(IP[:]).To4() != nil {
+		maxMask = 32
 	}
-	if oldRoute != nil {
-		routingTable.DeleteRoute(oldRoute.DestinationIP, oldRoute.Mask)
+	if mask < 0 || mask > maxMask {
+		return ErrInvalidRouteMask
 	}
-	(&route.RouteGlue).Init()
-	(&routingTable.Routes).AddNode(&route.RouteGlue)
+	return nil
 }
 
-func (routingTable *Layer3RouteTable) AddDirectRoute(IP IPAddress, mask rune) {
-	routingTable.AddRoute(IP, mask, nil, nil)
+// InstallRoute places route in the table, replacing the route to the same prefix if there is one.
+func (routingTable *Layer3RouteTable) InstallRoute(route *Layer3Route) {
+	routingTable.Mutex.Lock()
+	defer routingTable.Mutex.Unlock()
+
+	routingTable.Routes.Insert(route.DestinationIP, trieKeyLength(route.DestinationIP, route.Mask), route)
 }
 
 func (routingTable *Layer3RouteTable) Print() {
-	for dllNode := routingTable.Routes.Next; dllNode != nil; dllNode = dllNode.Next {
-		route := dllNode.DllToRoute()
-		fmt.Println("Destination IP: ", route.DestinationIP.String(), ", Mask: ", route.Mask, ", IsDirect: ", route.IsDirect, ", Gateway IP: ", route.GatewayIP.String(), ", Interface Name: ", route.InterfaceName.String())
+	routingTable.Mutex.RLock()
+	defer routingTable.Mutex.RUnlock()
+
+	routingTable.Routes.Walk(func(route *Layer3Route) {
+		route.Print()
+	})
+}
+
+func (route *Layer3Route) Print() {
+	source := RouteSourceString(route.Source)
+	if route.IsBlackhole {
+		fmt.Println("Destination IP: ", route.DestinationIP.String(), ", Mask: ", route.Mask, ", IsDirect: ", route.IsDirect, ", Source: ", source, ", Distance/Metric: ", fmt.Sprintf("%d/%d", route.Distance, route.Metric), ", Blackhole")
+		return
+	}
+	if route.IsDirect {
+		fmt.Println("Destination IP: ", route.DestinationIP.String(), ", Mask: ", route.Mask, ", IsDirect: ", route.IsDirect, ", Source: ", source, ", Distance/Metric: ", fmt.Sprintf("%d/%d", route.Distance, route.Metric))
+		return
+	}
+	for _, nextHop := range route.NextHops {
+		if nextHop == nil {
+			break
+		}
+		interfaceName := nextHop.InterfaceName.String()
+		if interfaceName == "" {
+			interfaceName = "recursive"
+		}
+		fmt.Println("Destination IP: ", route.DestinationIP.String(), ", Mask: ", route.Mask, ", IsDirect: ", route.IsDirect, ", Source: ", source, ", Distance/Metric: ", fmt.Sprintf("%d/%d", route.Distance, route.Metric), ", Gateway IP: ", nextHop.GatewayIP.String(), ", Interface Name: ", interfaceName, ", Weight: ", nextHop.Weight)
 	}
 }
 
@@ -189,3 +256,43 @@ func DeserializeIPHeader(data []byte) IPHeader {
 	header.Options = append([]byte(nil), data[44:]...)
 	return header
 }
+
+// InternetChecksum returns the ones complement of the ones complement sum of the 16 bit words of all chunks.
+func InternetChecksum(chunks ...[]byte) uint16 {
+	var sum uint32
+	var odd []byte
+	for _, chunk := range chunks {
+		if len(odd) == 1 && len(chunk) > 0 {
+			sum += uint32(odd[0])<<8 | uint32(chunk[0])
+			chunk = chunk[1:]
+			odd = nil
+		}
+		for ; len(chunk) >= 2; chunk = chunk[2:] {
+			sum += uint32(binary.BigEndian.Uint16(chunk))
+		}
+		if len(chunk) == 1 {
+			odd = chunk
+		}
+	}
+	if len(odd) == 1 {
+		sum += uint32(odd[0]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// PseudoHeader builds the pseudo header covered by the checksum of transport protocols, the IPv6
+// pseudo header of RFC 8200 for IPv6 addresses.
+func PseudoHeader(sourceIP IPAddress, destinationIP IPAddress, protocol uint8, length int) []byte {
+	if !sourceIP.IsIPv4() {
+		return PseudoHeaderIPv6(sourceIP, destinationIP, protocol, length)
+	}
+	data := make([]byte, 12)
+	copy(data[0:4], net.IP(sourceIP[:]).To4())
+	copy(data[4:8], net.IP(destinationIP[:]).To4())
+	data[9] = protocol
+	binary.BigEndian.PutUint16(data[10:12], uint16(length))
+	return data
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const (
+	lldpChassisIDSubtypeLocal   uint8 = 7
+	lldpPortIDSubtypeIntfName   uint8 = 5
+	lldpManagementAddressIPv4   uint8 = 1
+	lldpManagementIntfIfIndex   uint8 = 2
+	lldpManagementAddressTLVLen       = 12
+)
+
+type LldpDU struct {
+	ChassisID           string
+	PortID              string
+	TTL                 uint16
+	SystemName          string
+	SystemCapabilities  uint16
+	EnabledCapabilities uint16
+	ManagementIP        IPAddress
+}
+
+type LldpTable struct {
+	Neighbors Dll
+	Mutex     sync.Mutex
+}
+
+type LldpNeighbor struct {
+	InterfaceName       InterfaceName
+	ChassisID           string
+	PortID              string
+	SystemName          string
+	SystemCapabilities  uint16
+	EnabledCapabilities uint16
+	ManagementIP        IPAddress
+	TTL                 uint16
+	ExpiresAt           time.Time
+	NeighborGlue        Dll
+}
+
+func (dll *Dll) DllToLldpNeighbor() *LldpNeighbor {
+	return (*LldpNeighbor)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(LldpNeighbor{}.NeighborGlue)))
+}
+
+func putLldpTLVHeader(data []byte, tlvType uint8, length int) {
+	binary.BigEndian.PutUint16(data[0:2], uint16(tlvType)<<9|uint16(length&0x1FF))
+}
+
+func (du LldpDU) SerializeLldpDU() []byte {
+	data := make([]byte, 0, 128)
+	tlv := make([]byte, 2)
+
+	putLldpTLVHeader(tlv, constants.LldpTlvChassisID, 1+len(du.ChassisID))
+	data = append(data, tlv...)
+	data = append(data, lldpChassisIDSubtypeLocal)
+	data = append(data, du.ChassisID...)
+
+	putLldpTLVHeader(tlv, constants.LldpTlvPortID, 1+len(du.PortID))
+	data = append(data, tlv...)
+	data = append(data, lldpPortIDSubtypeIntfName)
+	data = append(data, du.PortID...)
+
+	putLldpTLVHeader(tlv, constants.LldpTlvTTL, 2)
+	data = append(data, tlv...)
+	data = binary.BigEndian.AppendUint16(data, du.TTL)
+
+	putLldpTLVHeader(tlv, constants.LldpTlvSystemName, len(du.SystemName))
+	data = append(data, tlv...)
+	data = append(data, du.SystemName...)
+
+	putLldpTLVHeader(tlv, constants.LldpTlvSystemCapabilities, 4)
+	data = append(data, tlv...)
+	data = binary.BigEndian.AppendUint16(data, du.SystemCapabilities)
+	data = binary.BigEndian.AppendUint16(data, du.EnabledCapabilities)
+
+	if ipv4 := net.IP(du.ManagementIP[:]).To4(); ipv4 != nil && !ipv4.IsUnspecified() {
+		putLldpTLVHeader(tlv, constants.LldpTlvManagementAddress, lldpManagementAddressTLVLen)
+		data = append(data, tlv...)
+		data = append(data, 1+net.IPv4len, lldpManagementAddressIPv4)
+		data = append(data, ipv4...)
+		data = append(data, lldpManagementIntfIfIndex, 0, 0, 0, 0, 0)
+	}
+
+	putLldpTLVHeader(tlv, constants.LldpTlvEnd, 0)
+	data = append(data, tlv...)
+	return data
+}
+
+func DeserializeLldpDU(data []byte) (*LldpDU, error) {
+	du := &LldpDU{}
+	var seen [constants.LldpTlvTTL + 1]bool
+
+	for offset := 0; offset+2 <= len(data); {
+		header := binary.BigEndian.Uint16(data[offset : offset+2])
+		tlvType := uint8(header >> 9)
+		length := int(header & 0x1FF)
+		offset += 2
+
+		if offset+length > len(data) {
+			return nil, errors.New("truncated LLDP TLV")
+		}
+		value := data[offset : offset+length]
+		offset += length
+
+		switch tlvType {
+		case constants.LldpTlvEnd:
+			if !seen[constants.LldpTlvChassisID] || !seen[constants.LldpTlvPortID] || !seen[constants.LldpTlvTTL] {
+				return nil, errors.New("missing mandatory LLDP TLV")
+			}
+			return du, nil
+		case constants.LldpTlvChassisID:
+			if length < 2 {
+				return nil, errors.New("invalid chassis ID TLV")
+			}
+			du.ChassisID = string(value[1:])
+		case constants.LldpTlvPortID:
+			if length < 2 {
+				return nil, errors.New("invalid port ID TLV")
+			}
+			du.PortID = string(value[1:])
+		case constants.LldpTlvTTL:
+			if length != 2 {
+				return nil, errors.New("invalid TTL TLV")
+			}
+			du.TTL = binary.BigEndian.Uint16(value)
+		case constants.LldpTlvSystemName:
+			du.SystemName = string(value)
+		case constants.LldpTlvSystemCapabilities:
+			if length != 4 {
+				return nil, errors.New("invalid system capabilities TLV")
+			}
+			du.SystemCapabilities = binary.BigEndian.Uint16(value[0:2])
+			du.EnabledCapabilities = binary.BigEndian.Uint16(value[2:4])
+		case constants.LldpTlvManagementAddress:
+			if length >= 6 && value[0] == 1+net.IPv4len && value[1] == lldpManagementAddressIPv4 {
+				copy(du.ManagementIP[:], net.IP(value[2:6]).To16())
+			}
+		}
+		if int(tlvType) < len(seen) {
+			seen[tlvType] = true
+		}
+	}
+	return nil, errors.New("LLDPDU without end TLV")
+}
+
+func (lldpTable *LldpTable) lookup(intfName InterfaceName, chassisID, portID string) *LldpNeighbor {
+	for dllNeighbor := lldpTable.Neighbors.Next; dllNeighbor != nil; dllNeighbor = dllNeighbor.Next {
+		neighbor := dllNeighbor.DllToLldpNeighbor()
+		if bytes.Equal(neighbor.InterfaceName[:], intfName[:]) && neighbor.ChassisID == chassisID && neighbor.PortID == portID {
+			return neighbor
+		}
+	}
+	return nil
+}
+
+// UpdateNeighbor records the LLDPDU received on intfName, a TTL of zero removes the neighbor.
+func (lldpTable *LldpTable) UpdateNeighbor(intfName InterfaceName, du *LldpDU) {
+	lldpTable.Mutex.Lock()
+	defer lldpTable.Mutex.Unlock()
+
+	neighbor := lldpTable.lookup(intfName, du.ChassisID, du.PortID)
+	if du.TTL == 0 {
+		if neighbor != nil {
+			(&neighbor.NeighborGlue).RemoveNode()
+		}
+		return
+	}
+
+	if neighbor == nil {
+		neighbor = &LldpNeighbor{
+			InterfaceName: intfName,
+			ChassisID:     du.ChassisID,
+			PortID:        du.PortID,
+		}
+		(&neighbor.NeighborGlue).Init()
+		(&lldpTable.Neighbors).AddNode(&neighbor.NeighborGlue)
+	}
+	neighbor.SystemName = du.SystemName
+	neighbor.SystemCapabilities = du.SystemCapabilities
+	neighbor.EnabledCapabilities = du.EnabledCapabilities
+	neighbor.ManagementIP = du.ManagementIP
+	neighbor.TTL = du.TTL
+	neighbor.ExpiresAt = time.Now().Add(time.Duration(du.TTL) * time.Second)
+}
+
+// AgeOut removes the neighbors whose TTL has expired.
+func (lldpTable *LldpTable) AgeOut(now time.Time) {
+	lldpTable.Mutex.Lock()
+	defer lldpTable.Mutex.Unlock()
+
+	var next *Dll
+	for dllNeighbor := lldpTable.Neighbors.Next; dllNeighbor != nil; dllNeighbor = next {
+		next = dllNeighbor.Next
+		neighbor := dllNeighbor.DllToLldpNeighbor()
+		if now.After(neighbor.ExpiresAt) {
+			(&neighbor.NeighborGlue).RemoveNode()
+		}
+	}
+}
+
+func lldpCapabilitiesString(capabilities uint16) string {
+	var str string
+	if capabilities&constants.LldpCapabilityBridge != 0 {
+		str += "B"
+	}
+	if capabilities&constants.LldpCapabilityRouter != 0 {
+		str += "R"
+	}
+	if str == "" {
+		return "-"
+	}
+	return str
+}
+
+func (lldpTable *LldpTable) Print() {
+	lldpTable.Mutex.Lock()
+	defer lldpTable.Mutex.Unlock()
+
+	now := time.Now()
+	for dllNeighbor := lldpTable.Neighbors.Next; dllNeighbor != nil; dllNeighbor = dllNeighbor.Next {
+		neighbor := dllNeighbor.DllToLldpNeighbor()
+		if now.After(neighbor.ExpiresAt) {
+			continue
+		}
+		managementIP := "nil"
+		if !net.IP(neighbor.ManagementIP[:]).IsUnspecified() {
+			managementIP = neighbor.ManagementIP.String()
+		}
+		fmt.Printf("Local Intf: %v, Chassis ID: %v, Port ID: %v, Capabilities: %v, Management IP: %v, Hold time: %v\n",
+			neighbor.InterfaceName.String(), neighbor.ChassisID, neighbor.PortID,
+			lldpCapabilitiesString(neighbor.EnabledCapabilities), managementIP, int(neighbor.ExpiresAt.Sub(now).Seconds()))
+	}
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"tcpip/constants"
+	"unsafe"
+)
+
+const (
+	MplsOpPush uint8 = iota
+	MplsOpSwap
+	MplsOpPop
+)
+
+// MplsLabel is a label stack entry (RFC 3032).
+type MplsLabel struct {
+	Label         uint32
+	TC            uint8
+	BottomOfStack bool
+	TTL           uint8
+}
+
+// LfibEntry tells an LSR what to do with packets arriving with InLabel on top of their stack: swap
+// it for OutLabels[0], push OutLabels above it or pop it. Packets are sent to NextHop, a packet
+// popped without next hop is processed by the node itself.
+type LfibEntry struct {
+	InLabel    uint32
+	Operation  uint8
+	OutLabels  []uint32
+	HasNextHop bool
+	NextHop    IPAddress
+	Packets    uint64
+	LfibGlue   Dll
+}
+
+// MplsLsp is the head end of a static LSP, packets entering it get Labels pushed, the first one
+// on top, and are sent to NextHop.
+type MplsLsp struct {
+	Name    string
+	Labels  []uint32
+	NextHop IPAddress
+	Packets uint64
+	LspGlue Dll
+}
+
+// MplsFtn maps the route of a prefix to the LSP the packets it forwards enter.
+type MplsFtn struct {
+	DestinationIP IPAddress
+	Mask          rune
+	LspName       string
+	FtnGlue       Dll
+}
+
+// MplsInstance is the label switching state of a node. With IsTtlPropagate the IP TTL is copied to
+// the labels at the ingress and back at the egress, otherwise the LSP counts as a single hop.
+type MplsInstance struct {
+	Lfib           Dll
+	Lsps           Dll
+	Ftns           Dll
+	IsTtlPropagate bool
+	Mutex          sync.Mutex
+}
+
+func (dll *Dll) DllToLfibEntry() *LfibEntry {
+	return (*LfibEntry)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(LfibEntry{}.LfibGlue)))
+}
+
+func (dll *Dll) DllToMplsLsp() *MplsLsp {
+	return (*MplsLsp)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(MplsLsp{}.LspGlue)))
+}
+
+func (dll *Dll) DllToMplsFtn() *MplsFtn {
+	return (*MplsFtn)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(MplsFtn{}.FtnGlue)))
+}
+
+// SerializeMplsLabelStack encodes the stack top first, the last entry gets the bottom of stack bit.
+func SerializeMplsLabelStack(stack []MplsLabel) []byte {
+	data := make([]byte, len(stack)*constants.MplsLabelSize)
+	for i, label := range stack {
+		entry := label.Label<<12 | uint32(label.TC&0x07)<<9 | uint32(label.TTL)
+		if i == len(stack)-1 {
+			entry |= 1 << 8
+		}
+		binary.BigEndian.PutUint32(data[i*constants.MplsLabelSize:], entry)
+	}
+	return data
+}
+
+// DeserializeMplsLabelStack decodes the label stack leading data up to its bottom entry.
+func DeserializeMplsLabelStack(data []byte) ([]MplsLabel, error) {
+	var stack []MplsLabel
+	for offset := 0; offset+constants.MplsLabelSize <= len(data); offset += constants.MplsLabelSize {
+		if len(stack) == constants.MplsMaxLabelStackDepth {
+			return nil, errors.New("label stack too deep")
+		}
+		entry := binary.BigEndian.Uint32(data[offset:])
+		label := MplsLabel{
+			Label:         entry >> 12,
+			TC:            uint8(entry>>9) & 0x07,
+			BottomOfStack: entry&(1<<8) != 0,
+			TTL:           uint8(entry),
+		}
+		stack = append(stack, label)
+		if label.BottomOfStack {
+			return stack, nil
+		}
+	}
+	return nil, errors.New("label stack has no bottom")
+}
+
+func (mpls *MplsInstance) AddLfibEntry(entry *LfibEntry) {
+	(&entry.LfibGlue).Init()
+	(&mpls.Lfib).AddNode(&entry.LfibGlue)
+}
+
+func (mpls *MplsInstance) LookupLfibEntry(label uint32) *LfibEntry {
+	for dllEntry := mpls.Lfib.Next; dllEntry != nil; dllEntry = dllEntry.Next {
+		entry := dllEntry.DllToLfibEntry()
+		if entry.InLabel == label {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (mpls *MplsInstance) AddLsp(lsp *MplsLsp) {
+	(&lsp.LspGlue).Init()
+	(&mpls.Lsps).AddNode(&lsp.LspGlue)
+}
+
+func (mpls *MplsInstance) LookupLsp(name string) *MplsLsp {
+	for dllLsp := mpls.Lsps.Next; dllLsp != nil; dllLsp = dllLsp.Next {
+		lsp := dllLsp.DllToMplsLsp()
+		if lsp.Name == name {
+			return lsp
+		}
+	}
+	return nil
+}
+
+func (mpls *MplsInstance) AddFtn(ftn *MplsFtn) {
+	(&ftn.FtnGlue).Init()
+	(&mpls.Ftns).AddNode(&ftn.FtnGlue)
+}
+
+func (mpls *MplsInstance) LookupFtn(IP IPAddress, mask rune) *MplsFtn {
+	for dllFtn := mpls.Ftns.Next; dllFtn != nil; dllFtn = dllFtn.Next {
+		ftn := dllFtn.DllToMplsFtn()
+		if ftn.DestinationIP == IP && ftn.Mask == mask {
+			return ftn
+		}
+	}
+	return nil
+}
+
+func MplsLabelString(label uint32) string {
+	switch label {
+	case constants.MplsLabelExplicitNull:
+		return "explicit-null"
+	case constants.MplsLabelImplicitNull:
+		return "implicit-null"
+	default:
+		return fmt.Sprint(label)
+	}
+}
+
+func mplsOpString(operation uint8) string {
+	switch operation {
+	case MplsOpPush:
+		return "push"
+	case MplsOpSwap:
+		return "swap"
+	default:
+		return "pop"
+	}
+}
+
+func mplsLabelsString(labels []uint32) string {
+	var out []string
+	for _, label := range labels {
+		out = append(out, MplsLabelString(label))
+	}
+	return strings.Join(out, " ")
+}
+
+func (mpls *MplsInstance) Print() {
+	mpls.Mutex.Lock()
+	defer mpls.Mutex.Unlock()
+
+	if mpls.IsTtlPropagate {
+		fmt.Println("TTL propagation: enabled")
+	} else {
+		fmt.Println("TTL propagation: disabled")
+	}
+	for dllEntry := mpls.Lfib.Next; dllEntry != nil; dllEntry = dllEntry.Next {
+		entry := dllEntry.DllToLfibEntry()
+		nextHop := "local"
+		if entry.HasNextHop {
+			nextHop = entry.NextHop.String()
+		}
+		fmt.Printf("In label: %d, Operation: %s, Out labels: [%s], Next hop: %s, Packets: %d\n", entry.InLabel,
+			mplsOpString(entry.Operation), mplsLabelsString(entry.OutLabels), nextHop, entry.Packets)
+	}
+	for dllLsp := mpls.Lsps.Next; dllLsp != nil; dllLsp = dllLsp.Next {
+		lsp := dllLsp.DllToMplsLsp()
+		fmt.Printf("LSP: %s, Labels: [%s], Next hop: %s, Packets: %d\n", lsp.Name, mplsLabelsString(lsp.Labels), lsp.NextHop.String(), lsp.Packets)
+	}
+	for dllFtn := mpls.Ftns.Next; dllFtn != nil; dllFtn = dllFtn.Next {
+		ftn := dllFtn.DllToMplsFtn()
+		fmt.Printf("FTN: %s/%d -> LSP %s\n", ftn.DestinationIP.String(), ftn.Mask, ftn.LspName)
+	}
+}
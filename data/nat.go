@@ -0,0 +1,197 @@
+package data
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const (
+	NatTypeStatic uint8 = iota
+	NatTypeDynamic
+	NatTypePAT
+)
+
+// NatTranslation maps an inside local address to the inside global address it is seen as from the
+// outside. Static and dynamic translations map whole addresses and have Protocol zero, PAT
+// translations map the port or ICMP identifier of a single protocol as well.
+type NatTranslation struct {
+	Type             uint8
+	Protocol         uint8
+	InsideLocalIP    IPAddress
+	InsideLocalPort  uint16
+	InsideGlobalIP   IPAddress
+	InsideGlobalPort uint16
+	IsClosing        bool
+	ExpiresAt        time.Time
+	TranslationGlue  Dll
+}
+
+// NatInstance holds the interface roles, the address pool and the translation table of a node, PAT
+// overloads the address of OverloadInterface or the loopback address of the node.
+type NatInstance struct {
+	Roles              map[*Interface]uint8
+	Translations       Dll
+	PoolStart          IPAddress
+	PoolEnd            IPAddress
+	IsPoolConfigured   bool
+	OverloadInterface  *Interface
+	IsOverloadLoopback bool
+	NextPort           uint16
+	Mutex              sync.Mutex
+}
+
+func (dll *Dll) DllToNatTranslation() *NatTranslation {
+	return (*NatTranslation)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(NatTranslation{}.TranslationGlue)))
+}
+
+// AdjustChecksum updates checksum for the bytes of old replaced by new without summing the whole
+// message again (RFC 1624), both slices have the same even length.
+func AdjustChecksum(checksum uint16, old []byte, new []byte) uint16 {
+	sum := uint32(^checksum)
+	for i := 0; i+1 < len(old); i += 2 {
+		sum += uint32(^binary.BigEndian.Uint16(old[i:]))
+		sum += uint32(binary.BigEndian.Uint16(new[i:]))
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func (nat *NatInstance) AddTranslation(translation *NatTranslation) {
+	(&translation.TranslationGlue).Init()
+	(&nat.Translations).AddNode(&translation.TranslationGlue)
+}
+
+// LookupByInsideLocal returns the address translation of IP, or the PAT translation of the
+// protocol and port when there is none.
+func (nat *NatInstance) LookupByInsideLocal(protocol uint8, IP IPAddress, port uint16) *NatTranslation {
+	var patTranslation *NatTranslation
+	for dllTranslation := nat.Translations.Next; dllTranslation != nil; dllTranslation = dllTranslation.Next {
+		translation := dllTranslation.DllToNatTranslation()
+		if translation.InsideLocalIP != IP {
+			continue
+		}
+		if translation.Type != NatTypePAT {
+			return translation
+		}
+		if translation.Protocol == protocol && translation.InsideLocalPort == port {
+			patTranslation = translation
+		}
+	}
+	return patTranslation
+}
+
+// LookupByInsideGlobal returns the PAT translation of the protocol and port of IP, or the address
+// translation of IP when there is none.
+func (nat *NatInstance) LookupByInsideGlobal(protocol uint8, IP IPAddress, port uint16) *NatTranslation {
+	var addressTranslation *NatTranslation
+	for dllTranslation := nat.Translations.Next; dllTranslation != nil; dllTranslation = dllTranslation.Next {
+		translation := dllTranslation.DllToNatTranslation()
+		if translation.InsideGlobalIP != IP {
+			continue
+		}
+		if translation.Type != NatTypePAT {
+			addressTranslation = translation
+			continue
+		}
+		if translation.Protocol == protocol && translation.InsideGlobalPort == port {
+			return translation
+		}
+	}
+	return addressTranslation
+}
+
+// AllocatePoolAddress returns the lowest pool address no translation uses, or false when the pool is exhausted.
+func (nat *NatInstance) AllocatePoolAddress() (IPAddress, bool) {
+	if !nat.IsPoolConfigured {
+		return IPAddress{}, false
+	}
+	for value := ipv4ToUint32(nat.PoolStart); value <= ipv4ToUint32(nat.PoolEnd); value++ {
+		IP := uint32ToIPv4(value)
+		if nat.LookupByInsideGlobal(0, IP, 0) == nil {
+			return IP, true
+		}
+	}
+	return IPAddress{}, false
+}
+
+// AllocatePort returns a port of IP that no PAT translation of the protocol uses, or false when all are taken.
+func (nat *NatInstance) AllocatePort(protocol uint8, IP IPAddress) (uint16, bool) {
+	count := 0xFFFF - int(constants.NatPortStart) + 1
+	for i := 0; i < count; i++ {
+		if nat.NextPort < constants.NatPortStart {
+			nat.NextPort = constants.NatPortStart
+		}
+		port := nat.NextPort
+		nat.NextPort++
+		translation := nat.LookupByInsideGlobal(protocol, IP, port)
+		if translation == nil || translation.Type != NatTypePAT {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// ClearTranslations removes the dynamic and PAT translations and returns how many were removed.
+func (nat *NatInstance) ClearTranslations() int {
+	count := 0
+	var next *Dll
+	for dllTranslation := nat.Translations.Next; dllTranslation != nil; dllTranslation = next {
+		next = dllTranslation.Next
+		if dllTranslation.DllToNatTranslation().Type != NatTypeStatic {
+			dllTranslation.RemoveNode()
+			count++
+		}
+	}
+	return count
+}
+
+func natTypeString(natType uint8) string {
+	switch natType {
+	case NatTypeStatic:
+		return "static"
+	case NatTypeDynamic:
+		return "dynamic"
+	default:
+		return "pat"
+	}
+}
+
+func natProtocolString(protocol uint8) string {
+	switch protocol {
+	case constants.IcmpProto:
+		return "icmp"
+	case constants.UdpProto:
+		return "udp"
+	case constants.TcpProto:
+		return "tcp"
+	default:
+		return "any"
+	}
+}
+
+func (nat *NatInstance) PrintTranslations() {
+	nat.Mutex.Lock()
+	defer nat.Mutex.Unlock()
+
+	now := time.Now()
+	for dllTranslation := nat.Translations.Next; dllTranslation != nil; dllTranslation = dllTranslation.Next {
+		translation := dllTranslation.DllToNatTranslation()
+		insideLocal, insideGlobal := translation.InsideLocalIP.String(), translation.InsideGlobalIP.String()
+		if translation.Type == NatTypePAT {
+			insideLocal = fmt.Sprintf("%s:%d", insideLocal, translation.InsideLocalPort)
+			insideGlobal = fmt.Sprintf("%s:%d", insideGlobal, translation.InsideGlobalPort)
+		}
+		expires := "never"
+		if translation.Type != NatTypeStatic {
+			expires = fmt.Sprintf("%ds", int(translation.ExpiresAt.Sub(now).Seconds()))
+		}
+		fmt.Printf("Protocol: %s, Inside Local: %s, Inside Global: %s, Type: %s, Expires in: %s\n",
+			natProtocolString(translation.Protocol), insideLocal, insideGlobal, natTypeString(translation.Type), expires)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"sync"
 	"tcpip/constants"
 	"tcpip/util"
 )
@@ -25,6 +26,25 @@ type NodeNetworkProperties struct {
 	ArpTable       *ArpTable
 	MacTable       *MacTable
 	RoutingTable   *Layer3RouteTable
+	Rib            *Layer3Rib
+	LldpTable      *LldpTable
+	UDPPorts       *UDPPortTable
+	Tcp            *TcpInstance
+	Rip            *RipInstance
+	Ospf           *OspfInstance
+	Bgp            *BgpInstance
+	Vrrp           *VrrpInstance
+	Dhcp           *DhcpInstance
+	Slaac          *SlaacInstance
+	Nat            *NatInstance
+	Acl            *AclInstance
+	Firewall       *FirewallInstance
+	Tunnels        *TunnelTable
+	Vxlan          *VxlanInstance
+	Mpls           *MplsInstance
+	RouteMaps      *RouteMapInstance
+	Vrfs           *VrfTable
+	Loopback       *LoopbackQueue
 	IsLbConfigured bool
 	LB             IPAddress
 }
@@ -36,7 +56,24 @@ type IntfNetworkProperties struct {
 	Vlans            [constants.MaxVlanMembership]uint
 	IP               IPAddress
 	Mask             rune
+	IsIpv6Enabled    bool
+	LinkLocalIP      IPAddress
+	IPv6             IPAddress
+	IPv6Mask         rune
 	IntfL2Mode       int
+	Vrf              *Vrf
+}
+
+// LoopbackQueue holds the packets a node sends to its own addresses until they are delivered.
+type LoopbackQueue struct {
+	Packets      []LoopbackPacket
+	IsDelivering bool
+	Mutex        sync.Mutex
+}
+
+type LoopbackPacket struct {
+	Payload        Payload
+	ProtocolNumber uint16
 }
 
 func (properties *NodeNetworkProperties) InitNodeNetworkProperty() {
@@ -48,12 +85,20 @@ func (properties *NodeNetworkProperties) InitNodeNetworkProperty() {
 	properties.MacTable = &MacTable{
 		MacEntries: Dll{},
 	}
-	properties.RoutingTable = &Layer3RouteTable{
-		Routes: Dll{},
+	properties.RoutingTable = &Layer3RouteTable{}
+	properties.Rib = &Layer3Rib{
+		Candidates: Dll{},
+		Fib:        properties.RoutingTable,
 	}
+	properties.LldpTable = &LldpTable{
+		Neighbors: Dll{},
+	}
+	properties.UDPPorts = &UDPPortTable{}
+	properties.Loopback = &LoopbackQueue{}
 	(&properties.ArpTable.ArpEntries).Init()
 	(&properties.MacTable.MacEntries).Init()
-	(&properties.RoutingTable.Routes).Init()
+	(&properties.Rib.Candidates).Init()
+	(&properties.LldpTable.Neighbors).Init()
 	copy(properties.LB[:], bytes.Repeat([]byte{0}, len(properties.LB)))
 }
 
@@ -62,6 +107,10 @@ func (properties *IntfNetworkProperties) InitIntfProperty() {
 	properties.IsIpConfigured = false
 	copy(properties.IP[:], bytes.Repeat([]byte{0}, len(properties.IP)))
 	properties.Mask = 0
+	properties.IsIpv6Enabled = false
+	properties.LinkLocalIP = IPAddress{}
+	properties.IPv6 = IPAddress{}
+	properties.IPv6Mask = 0
 }
 
 func (node *Node) SetDeviceType(deviceType uint) bool {
@@ -78,7 +127,7 @@ func (node *Node) SetLbAddress(IP IPAddress) bool {
 	node.Properties.IsLbConfigured = true
 	copy(node.Properties.LB[:], IP[:])
 
-	node.Properties.RoutingTable.AddDirectRoute(IP, 32)
+	node.Properties.Rib.AddRoute(IP, 32, constants.RouteSourceLoopback, 0, nil, nil, 0)
 
 	return true
 }
@@ -94,10 +143,46 @@ func (node *Node) SetIntfIPAddress(intfName string, IP IPAddress, mask rune) boo
 
 	intf.Properties.Mask = mask
 	intf.Properties.IsIpConfigured = true
-	node.Properties.RoutingTable.AddDirectRoute(IP, mask)
+	node.VrfRib(intf.Properties.Vrf).AddRoute(IP, mask, constants.RouteSourceConnected, 0, nil, nil, 0)
+	return true
+}
+
+// EnableIntfIPv6 starts IPv6 on the interface with the link-local address derived from its MAC.
+func (node *Node) EnableIntfIPv6(intfName string) bool {
+	intf := node.GetNodeIntfByName(intfName)
+
+	if intf == nil {
+		panic("Interface not found")
+	}
+
+	intf.Properties.LinkLocalIP = LinkLocalAddressFromMAC(intf.Properties.MAC)
+	intf.Properties.IsIpv6Enabled = true
+	return true
+}
+
+// SetIntfIPv6Address sets the global IPv6 address of the interface next to its IPv4 address.
+func (node *Node) SetIntfIPv6Address(intfName string, IP IPAddress, mask rune) bool {
+	intf := node.GetNodeIntfByName(intfName)
+
+	if intf == nil {
+		panic("Interface not found")
+	}
+	if !intf.Properties.IsIpv6Enabled {
+		node.EnableIntfIPv6(intfName)
+	}
+
+	copy(intf.Properties.IPv6[:], IP[:])
+
+	intf.Properties.IPv6Mask = mask
+	node.VrfRib(intf.Properties.Vrf).AddRoute(IP, mask, constants.RouteSourceConnected, 0, nil, nil, 0)
 	return true
 }
 
+// IsL3Mode reports interfaces routing IPv4, IPv6 or both.
+func (intf *Interface) IsL3Mode() bool {
+	return intf.Properties.IsIpConfigured || intf.Properties.IsIpv6Enabled
+}
+
 func (graph *Graph) Print() {
 	fmt.Printf("Topology name: %v\n\n", graph.TopologyName)
 
@@ -141,6 +226,15 @@ func (intf *Interface) Print() {
 	} else {
 		fmt.Printf("IP: nil")
 	}
+	if intf.Properties.IsIpv6Enabled {
+		if !intf.Properties.IPv6.IsUnspecified() {
+			fmt.Printf(", IPv6: %s/%d", intf.Properties.IPv6.String(), intf.Properties.IPv6Mask)
+		}
+		fmt.Printf(", Link-Local: %s", intf.Properties.LinkLocalIP.String())
+	}
+	if intf.Properties.Vrf != nil {
+		fmt.Printf(", VRF: %s", intf.Properties.Vrf.Name)
+	}
 	fmt.Printf(", MAC: %s, ", intf.Properties.MAC.String())
 	fmt.Printf("Neighbour node: %v, Cost: %v, Mode: %v\n", neighbourNode.NodeName, link.Cost, intf.Properties.IntfL2Mode)
 }
@@ -165,12 +259,17 @@ func (ip IPAddress) String() string {
 	return net.IP(ip[:]).String()
 }
 
+// IsUnspecified reports the 0.0.0.0 address and the zero value of an unset address.
+func (ip IPAddress) IsUnspecified() bool {
+	return net.IP(ip[:]).IsUnspecified()
+}
+
 func (mac MacAddress) String() string {
 	return net.HardwareAddr(mac[:]).String()
 }
 
 func (intf *Interface) IsTrunkInterfaceVLANEnabled(vlanID uint) bool {
-	if intf.Properties.IntfL2Mode != constants.TRUNK {
+	if intf.Properties.IntfL2Mode != constants.TRUNK && intf.Properties.IntfL2Mode != constants.PROVIDER_CORE {
 		panic("Invalid L2 mode for trunk interface")
 	}
 
@@ -0,0 +1,364 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const (
+	ospfHeaderSize    = 12
+	ospfHelloSize     = 8
+	ospfLsaHeaderSize = 12
+	ospfLinkSize      = 12
+)
+
+const (
+	OspfNeighborInit uint8 = iota
+	OspfNeighborFull
+)
+
+type OspfLink struct {
+	Type     uint8
+	Cost     uint16
+	LinkID   IPAddress
+	LinkData IPAddress
+	Mask     rune
+}
+
+// OspfLsa is the router LSA of AdvertisingRouter, it lists the point to point adjacencies and
+// the stub networks of the router.
+type OspfLsa struct {
+	AdvertisingRouter IPAddress
+	Sequence          uint32
+	Age               uint16
+	Links             []OspfLink
+	InstalledAt       time.Time
+	LsaGlue           Dll
+}
+
+type OspfHello struct {
+	NetworkMask   rune
+	HelloInterval uint16
+	DeadInterval  uint16
+	Neighbors     []IPAddress
+}
+
+type OspfPacket struct {
+	Type     uint8
+	RouterID IPAddress
+	Hello    *OspfHello
+	Lsas     []*OspfLsa
+}
+
+type OspfNeighbor struct {
+	RouterID      IPAddress
+	IP            IPAddress
+	InterfaceName InterfaceName
+	State         uint8
+	DeadAt        time.Time
+	NeighborGlue  Dll
+}
+
+type OspfRoute struct {
+	DestinationIP IPAddress
+	Mask          rune
+	Cost          uint32
+	NextHops      []Layer3NextHop
+	RouteGlue     Dll
+}
+
+type OspfInstance struct {
+	RouterID   IPAddress
+	Interfaces [constants.MaxIntfPerNode]*Interface
+	Neighbors  Dll
+	Lsdb       Dll
+	Routes     Dll
+	Sequence   uint32
+	NextHello  time.Time
+	RefreshAt  time.Time
+	SpfPending bool
+	Mutex      sync.Mutex
+}
+
+func (dll *Dll) DllToOspfLsa() *OspfLsa {
+	return (*OspfLsa)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(OspfLsa{}.LsaGlue)))
+}
+
+func (dll *Dll) DllToOspfNeighbor() *OspfNeighbor {
+	return (*OspfNeighbor)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(OspfNeighbor{}.NeighborGlue)))
+}
+
+func (dll *Dll) DllToOspfRoute() *OspfRoute {
+	return (*OspfRoute)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(OspfRoute{}.RouteGlue)))
+}
+
+func putIPv4(data []byte, IP IPAddress) {
+	copy(data[:4], net.IP(IP[:]).To4())
+}
+
+func getIPv4(data []byte) IPAddress {
+	var IP IPAddress
+	copy(IP[:], net.IP(data[:4]).To16())
+	return IP
+}
+
+// CurrentAge is the age the LSA has reached since it was installed, it never exceeds MaxAge.
+func (lsa *OspfLsa) CurrentAge(now time.Time) uint16 {
+	age := int(lsa.Age) + int(now.Sub(lsa.InstalledAt).Seconds())
+	if age > constants.OspfMaxAgeSeconds {
+		age = constants.OspfMaxAgeSeconds
+	}
+	return uint16(age)
+}
+
+func (lsa *OspfLsa) size() int {
+	return ospfLsaHeaderSize + len(lsa.Links)*ospfLinkSize
+}
+
+func (lsa *OspfLsa) serialize(data []byte, now time.Time) {
+	binary.BigEndian.PutUint16(data[0:2], lsa.CurrentAge(now))
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(lsa.Links)))
+	putIPv4(data[4:8], lsa.AdvertisingRouter)
+	binary.BigEndian.PutUint32(data[8:12], lsa.Sequence)
+
+	for i, link := range lsa.Links {
+		offset := ospfLsaHeaderSize + i*ospfLinkSize
+		data[offset] = link.Type
+		binary.BigEndian.PutUint16(data[offset+2:offset+4], link.Cost)
+		putIPv4(data[offset+4:offset+8], link.LinkID)
+		if link.Type == constants.OspfLinkStub {
+			copy(data[offset+8:offset+12], net.CIDRMask(int(link.Mask), 32))
+		} else {
+			putIPv4(data[offset+8:offset+12], link.LinkData)
+		}
+	}
+}
+
+func deserializeOspfLsa(data []byte) (*OspfLsa, int, error) {
+	if len(data) < ospfLsaHeaderSize {
+		return nil, 0, errors.New("truncated OSPF LSA")
+	}
+	lsa := &OspfLsa{
+		Age:               binary.BigEndian.Uint16(data[0:2]),
+		AdvertisingRouter: getIPv4(data[4:8]),
+		Sequence:          binary.BigEndian.Uint32(data[8:12]),
+	}
+	linkCount := int(binary.BigEndian.Uint16(data[2:4]))
+	size := ospfLsaHeaderSize + linkCount*ospfLinkSize
+	if len(data) < size {
+		return nil, 0, errors.New("truncated OSPF LSA")
+	}
+
+	for i := 0; i < linkCount; i++ {
+		offset := ospfLsaHeaderSize + i*ospfLinkSize
+		link := OspfLink{
+			Type:   data[offset],
+			Cost:   binary.BigEndian.Uint16(data[offset+2 : offset+4]),
+			LinkID: getIPv4(data[offset+4 : offset+8]),
+		}
+		if link.Type == constants.OspfLinkStub {
+			ones, bits := net.IPMask(data[offset+8 : offset+12]).Size()
+			if bits == 0 {
+				return nil, 0, errors.New("invalid OSPF stub network mask")
+			}
+			link.Mask = rune(ones)
+		} else {
+			link.LinkData = getIPv4(data[offset+8 : offset+12])
+		}
+		lsa.Links = append(lsa.Links, link)
+	}
+	return lsa, size, nil
+}
+
+func (packet OspfPacket) SerializeOspfPacket() []byte {
+	now := time.Now()
+	length := ospfHeaderSize
+	switch packet.Type {
+	case constants.OspfPacketHello:
+		length += ospfHelloSize + 4*len(packet.Hello.Neighbors)
+	case constants.OspfPacketLinkStateUpdate:
+		length += 4
+		for _, lsa := range packet.Lsas {
+			length += lsa.size()
+		}
+	}
+
+	data := make([]byte, length)
+	data[0] = constants.OspfVersion
+	data[1] = packet.Type
+	binary.BigEndian.PutUint16(data[2:4], uint16(length))
+	putIPv4(data[4:8], packet.RouterID)
+
+	switch packet.Type {
+	case constants.OspfPacketHello:
+		body := data[ospfHeaderSize:]
+		copy(body[0:4], net.CIDRMask(int(packet.Hello.NetworkMask), 32))
+		binary.BigEndian.PutUint16(body[4:6], packet.Hello.HelloInterval)
+		binary.BigEndian.PutUint16(body[6:8], packet.Hello.DeadInterval)
+		for i, neighbor := range packet.Hello.Neighbors {
+			putIPv4(body[ospfHelloSize+i*4:], neighbor)
+		}
+	case constants.OspfPacketLinkStateUpdate:
+		binary.BigEndian.PutUint32(data[ospfHeaderSize:ospfHeaderSize+4], uint32(len(packet.Lsas)))
+		offset := ospfHeaderSize + 4
+		for _, lsa := range packet.Lsas {
+			lsa.serialize(data[offset:], now)
+			offset += lsa.size()
+		}
+	}
+	return data
+}
+
+func DeserializeOspfPacket(data []byte) (*OspfPacket, error) {
+	if len(data) < ospfHeaderSize {
+		return nil, errors.New("truncated OSPF packet")
+	}
+	if data[0] != constants.OspfVersion {
+		return nil, errors.New("unsupported OSPF version")
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < ospfHeaderSize || length > len(data) {
+		return nil, errors.New("invalid OSPF packet length")
+	}
+	data = data[:length]
+
+	packet := &OspfPacket{
+		Type:     data[1],
+		RouterID: getIPv4(data[4:8]),
+	}
+	body := data[ospfHeaderSize:]
+
+	switch packet.Type {
+	case constants.OspfPacketHello:
+		if len(body) < ospfHelloSize || (len(body)-ospfHelloSize)%4 != 0 {
+			return nil, errors.New("invalid OSPF hello length")
+		}
+		ones, _ := net.IPMask(body[0:4]).Size()
+		packet.Hello = &OspfHello{
+			NetworkMask:   rune(ones),
+			HelloInterval: binary.BigEndian.Uint16(body[4:6]),
+			DeadInterval:  binary.BigEndian.Uint16(body[6:8]),
+		}
+		for offset := ospfHelloSize; offset < len(body); offset += 4 {
+			packet.Hello.Neighbors = append(packet.Hello.Neighbors, getIPv4(body[offset:]))
+		}
+	case constants.OspfPacketLinkStateUpdate:
+		if len(body) < 4 {
+			return nil, errors.New("truncated OSPF link state update")
+		}
+		count := int(binary.BigEndian.Uint32(body[0:4]))
+		offset := 4
+		for i := 0; i < count; i++ {
+			lsa, size, err := deserializeOspfLsa(body[offset:])
+			if err != nil {
+				return nil, err
+			}
+			packet.Lsas = append(packet.Lsas, lsa)
+			offset += size
+		}
+	default:
+		return nil, errors.New("unsupported OSPF packet type")
+	}
+	return packet, nil
+}
+
+func (ospf *OspfInstance) IsInterfaceEnabled(intf *Interface) bool {
+	for _, ospfIntf := range ospf.Interfaces {
+		if ospfIntf == intf {
+			return true
+		}
+	}
+	return false
+}
+
+func (ospf *OspfInstance) LookupNeighbor(routerID IPAddress, interfaceName InterfaceName) *OspfNeighbor {
+	for dllNeighbor := ospf.Neighbors.Next; dllNeighbor != nil; dllNeighbor = dllNeighbor.Next {
+		neighbor := dllNeighbor.DllToOspfNeighbor()
+		if neighbor.RouterID == routerID && neighbor.InterfaceName == interfaceName {
+			return neighbor
+		}
+	}
+	return nil
+}
+
+func (ospf *OspfInstance) LookupLsa(advertisingRouter IPAddress) *OspfLsa {
+	for dllLsa := ospf.Lsdb.Next; dllLsa != nil; dllLsa = dllLsa.Next {
+		lsa := dllLsa.DllToOspfLsa()
+		if lsa.AdvertisingRouter == advertisingRouter {
+			return lsa
+		}
+	}
+	return nil
+}
+
+// InstallLsa replaces the LSA of the same advertising router in the LSDB.
+func (ospf *OspfInstance) InstallLsa(lsa *OspfLsa) {
+	if oldLsa := ospf.LookupLsa(lsa.AdvertisingRouter); oldLsa != nil {
+		(&oldLsa.LsaGlue).RemoveNode()
+	}
+	(&lsa.LsaGlue).Init()
+	(&ospf.Lsdb).AddNode(&lsa.LsaGlue)
+}
+
+func ospfNeighborStateString(state uint8) string {
+	switch state {
+	case OspfNeighborInit:
+		return "Init"
+	case OspfNeighborFull:
+		return "Full"
+	default:
+		return "Down"
+	}
+}
+
+func (ospf *OspfInstance) PrintNeighbors() {
+	ospf.Mutex.Lock()
+	defer ospf.Mutex.Unlock()
+
+	now := time.Now()
+	for dllNeighbor := ospf.Neighbors.Next; dllNeighbor != nil; dllNeighbor = dllNeighbor.Next {
+		neighbor := dllNeighbor.DllToOspfNeighbor()
+		fmt.Printf("Neighbor ID: %s, State: %s, Dead Time: %ds, Address: %s, Interface Name: %s\n",
+			neighbor.RouterID.String(), ospfNeighborStateString(neighbor.State), int(neighbor.DeadAt.Sub(now).Seconds()),
+			neighbor.IP.String(), neighbor.InterfaceName.String())
+	}
+}
+
+func (ospf *OspfInstance) PrintDatabase() {
+	ospf.Mutex.Lock()
+	defer ospf.Mutex.Unlock()
+
+	now := time.Now()
+	fmt.Println("Router ID:", ospf.RouterID.String())
+	for dllLsa := ospf.Lsdb.Next; dllLsa != nil; dllLsa = dllLsa.Next {
+		lsa := dllLsa.DllToOspfLsa()
+		fmt.Printf("Advertising Router: %s, Sequence: 0x%08x, Age: %d, Links: %d\n",
+			lsa.AdvertisingRouter.String(), lsa.Sequence, lsa.CurrentAge(now), len(lsa.Links))
+		for _, link := range lsa.Links {
+			if link.Type == constants.OspfLinkStub {
+				fmt.Printf("    Stub Network: %s/%d, Cost: %d\n", link.LinkID.String(), link.Mask, link.Cost)
+			} else {
+				fmt.Printf("    Point-to-point: Neighbor ID %s, Interface IP %s, Cost: %d\n", link.LinkID.String(), link.LinkData.String(), link.Cost)
+			}
+		}
+	}
+}
+
+func (ospf *OspfInstance) PrintRoutes() {
+	ospf.Mutex.Lock()
+	defer ospf.Mutex.Unlock()
+
+	for dllRoute := ospf.Routes.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		route := dllRoute.DllToOspfRoute()
+		for _, nextHop := range route.NextHops {
+			fmt.Printf("Destination IP: %s, Mask: %v, Cost: %v, Gateway IP: %s, Interface Name: %s\n",
+				route.DestinationIP.String(), route.Mask, route.Cost, nextHop.GatewayIP.String(), nextHop.InterfaceName.String())
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package data
+
+import (
+	"fmt"
+	"sync"
+	"tcpip/constants"
+)
+
+// Layer3Rib keeps every candidate route per prefix and source, the best candidate of each
+// prefix is installed in the FIB used for forwarding.
+type Layer3Rib struct {
+	Candidates Dll
+	Fib        *Layer3RouteTable
+	Mutex      sync.Mutex
+}
+
+func RouteSourceDistance(source uint8) uint8 {
+	switch source {
+	case constants.RouteSourceConnected:
+		return constants.DistanceConnected
+	case constants.RouteSourceLoopback:
+		return constants.DistanceLoopback
+	case constants.RouteSourceStatic:
+		return constants.DistanceStatic
+	case constants.RouteSourceEBGP:
+		return constants.DistanceEBGP
+	case constants.RouteSourceOSPF:
+		return constants.DistanceOSPF
+	case constants.RouteSourceRIP:
+		return constants.DistanceRIP
+	case constants.RouteSourceIBGP:
+		return constants.DistanceIBGP
+	case constants.RouteSourceDHCP:
+		return constants.DistanceDHCP
+	case constants.RouteSourceRA:
+		return constants.DistanceRA
+	case constants.RouteSourceSPF:
+		return constants.DistanceSPF
+	default:
+		panic("Invalid route source")
+	}
+}
+
+func RouteSourceString(source uint8) string {
+	switch source {
+	case constants.RouteSourceConnected:
+		return "connected"
+	case constants.RouteSourceLoopback:
+		return "loopback"
+	case constants.RouteSourceStatic:
+		return "static"
+	case constants.RouteSourceEBGP:
+		return "ebgp"
+	case constants.RouteSourceOSPF:
+		return "ospf"
+	case constants.RouteSourceRIP:
+		return "rip"
+	case constants.RouteSourceIBGP:
+		return "ibgp"
+	case constants.RouteSourceDHCP:
+		return "dhcp"
+	case constants.RouteSourceRA:
+		return "ra"
+	case constants.RouteSourceSPF:
+		return "spf"
+	default:
+		return "unknown"
+	}
+}
+
+func isRouteSourceDirect(source uint8) bool {
+	return source == constants.RouteSourceConnected || source == constants.RouteSourceLoopback
+}
+
+func (rib *Layer3Rib) lookupCandidate(subnet IPAddress, mask rune, source uint8) *Layer3Route {
+	for dllRoute := rib.Candidates.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		route := dllRoute.DllToRoute()
+		if route.Source == source && route.Mask == mask && route.DestinationIP == subnet {
+			return route
+		}
+	}
+	return nil
+}
+
+// LookupSourceRoutes returns the candidates learned from source.
+func (rib *Layer3Rib) LookupSourceRoutes(source uint8) []*Layer3Route {
+	rib.Mutex.Lock()
+	defer rib.Mutex.Unlock()
+
+	var routes []*Layer3Route
+	for dllRoute := rib.Candidates.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		if route := dllRoute.DllToRoute(); route.Source == source {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+func (rib *Layer3Rib) newCandidate(subnet IPAddress, mask rune, source uint8, metric uint32) *Layer3Route {
+	route := &Layer3Route{
+		DestinationIP: subnet,
+		Mask:          mask,
+		IsDirect:      isRouteSourceDirect(source),
+		Source:        source,
+		Distance:      RouteSourceDistance(source),
+		Metric:        metric,
+	}
+	(&route.RouteGlue).Init()
+	(&rib.Candidates).AddNode(&route.RouteGlue)
+	return route
+}
+
+// AddRoute adds a candidate learned from source. A candidate of the same source and metric
+// gains the gateway as an equal cost next hop, a different metric replaces its next hops.
+// A nil interfaceName leaves the gateway to be resolved recursively.
+func (rib *Layer3Rib) AddRoute(IP IPAddress, mask rune, source uint8, metric uint32, gatewayIP *IPAddress, interfaceName *InterfaceName, weight uint) error {
+	if err := ValidateRoutePrefix(IP, mask); err != nil {
+		return err
+	}
+
+	rib.Mutex.Lock()
+	defer rib.Mutex.Unlock()
+
+	subnet := ApplyMask(IP, mask)
+	route := rib.lookupCandidate(subnet, mask, source)
+
+	if route != nil && route.IsDirect {
+		return ErrRouteExists
+	}
+	if route != nil && route.IsBlackhole {
+		return ErrBlackholeRoute
+	}
+	if route == nil {
+		route = rib.newCandidate(subnet, mask, source, metric)
+	}
+	if route.Metric != metric {
+		route.Metric = metric
+		route.NextHops = [constants.MaxNextHops]*Layer3NextHop{}
+	}
+	if !route.IsDirect && gatewayIP != nil {
+		var intfName InterfaceName
+		if interfaceName != nil {
+			intfName = *interfaceName
+		}
+		if route.LookupNextHop(*gatewayIP, intfName) != nil {
+			return ErrRouteExists
+		}
+		if !route.AddNextHop(*gatewayIP, intfName, weight) {
+			return ErrMaxNextHops
+		}
+	}
+	rib.selectBestRoute(subnet, mask)
+	return nil
+}
+
+// AddBlackholeRoute makes the candidate learned from source discard all traffic to the prefix.
+func (rib *Layer3Rib) AddBlackholeRoute(IP IPAddress, mask rune, source uint8) error {
+	if err := ValidateRoutePrefix(IP, mask); err != nil {
+		return err
+	}
+
+	rib.Mutex.Lock()
+	defer rib.Mutex.Unlock()
+
+	subnet := ApplyMask(IP, mask)
+	route := rib.lookupCandidate(subnet, mask, source)
+	if route != nil && route.IsBlackhole {
+		return ErrRouteExists
+	}
+	if route != nil && route.NextHopCount() != 0 {
+		return ErrRouteExists
+	}
+	if route == nil {
+		route = rib.newCandidate(subnet, mask, source, 0)
+	}
+	route.IsBlackhole = true
+	rib.selectBestRoute(subnet, mask)
+	return nil
+}
+
+// ReplaceRoute sets the metric and the complete next hop set of the candidate learned from source,
+// dynamic protocols use it to push the outcome of their own path selection. An empty next hop
+// set turns the candidate into a blackhole route.
+func (rib *Layer3Rib) ReplaceRoute(IP IPAddress, mask rune, source uint8, metric uint32, nextHops []Layer3NextHop) error {
+	if err := ValidateRoutePrefix(IP, mask); err != nil {
+		return err
+	}
+	if len(nextHops) > constants.MaxNextHops {
+		return ErrMaxNextHops
+	}
+
+	rib.Mutex.Lock()
+	defer rib.Mutex.Unlock()
+
+	subnet := ApplyMask(IP, mask)
+	route := rib.lookupCandidate(subnet, mask, source)
+	if route == nil {
+		route = rib.newCandidate(subnet, mask, source, metric)
+	}
+	route.Metric = metric
+	route.IsBlackhole = len(nextHops) == 0
+	route.NextHops = [constants.MaxNextHops]*Layer3NextHop{}
+	for _, nextHop := range nextHops {
+		route.AddNextHop(nextHop.GatewayIP, nextHop.InterfaceName, nextHop.Weight)
+	}
+	rib.selectBestRoute(subnet, mask)
+	return nil
+}
+
+// ReplaceBlackholeRoute makes the candidate learned from source discard all traffic to the prefix,
+// dropping the next hops it had.
+func (rib *Layer3Rib) ReplaceBlackholeRoute(IP IPAddress, mask rune, source uint8) error {
+	return rib.ReplaceRoute(IP, mask, source, 0, nil)
+}
+
+// DeleteRoute withdraws the candidate learned from source, the next best candidate takes its place in the FIB.
+func (rib *Layer3Rib) DeleteRoute(IP IPAddress, mask rune, source uint8) error {
+	if err := ValidateRoutePrefix(IP, mask); err != nil {
+		return err
+	}
+
+	rib.Mutex.Lock()
+	defer rib.Mutex.Unlock()
+
+	subnet := ApplyMask(IP, mask)
+	route := rib.lookupCandidate(subnet, mask, source)
+	if route == nil {
+		return ErrRouteNotFound
+	}
+	(&route.RouteGlue).RemoveNode()
+	rib.selectBestRoute(subnet, mask)
+	return nil
+}
+
+// DeleteNextHop removes a single next hop of the candidate learned from source, the candidate
+// is withdrawn together with its last next hop.
+func (rib *Layer3Rib) DeleteNextHop(IP IPAddress, mask rune, source uint8, gatewayIP IPAddress, interfaceName InterfaceName) error {
+	if err := ValidateRoutePrefix(IP, mask); err != nil {
+		return err
+	}
+
+	rib.Mutex.Lock()
+	defer rib.Mutex.Unlock()
+
+	subnet := ApplyMask(IP, mask)
+	route := rib.lookupCandidate(subnet, mask, source)
+	if route == nil {
+		return ErrRouteNotFound
+	}
+
+	var nextHops [constants.MaxNextHops]*Layer3NextHop
+	count := 0
+	found := false
+	for _, nextHop := range route.NextHops {
+		if nextHop == nil {
+			break
+		}
+		if nextHop.GatewayIP == gatewayIP && nextHop.InterfaceName == interfaceName {
+			found = true
+			continue
+		}
+		nextHops[count] = nextHop
+		count++
+	}
+	if !found {
+		return ErrNextHopNotFound
+	}
+	route.NextHops = nextHops
+	if count == 0 {
+		(&route.RouteGlue).RemoveNode()
+	}
+	rib.selectBestRoute(subnet, mask)
+	return nil
+}
+
+func (rib *Layer3Rib) selectBestRoute(subnet IPAddress, mask rune) {
+	var bestRoute *Layer3Route
+
+	for dllRoute := rib.Candidates.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		route := dllRoute.DllToRoute()
+		if route.Mask != mask || route.DestinationIP != subnet {
+			continue
+		}
+		if !route.IsDirect && !route.IsBlackhole && route.NextHopCount() == 0 {
+			continue
+		}
+		if bestRoute == nil || route.Distance < bestRoute.Distance ||
+			(route.Distance == bestRoute.Distance && route.Metric < bestRoute.Metric) {
+			bestRoute = route
+		}
+	}
+
+	if bestRoute == nil {
+		rib.Fib.DeleteRoute(subnet, mask)
+		return
+	}
+
+	fibRoute := &Layer3Route{
+		DestinationIP: bestRoute.DestinationIP,
+		Mask:          bestRoute.Mask,
+		IsDirect:      bestRoute.IsDirect,
+		IsBlackhole:   bestRoute.IsBlackhole,
+		Source:        bestRoute.Source,
+		Distance:      bestRoute.Distance,
+		Metric:        bestRoute.Metric,
+	}
+	for i, nextHop := range bestRoute.NextHops {
+		if nextHop == nil {
+			break
+		}
+		fibNextHop := *nextHop
+		fibRoute.NextHops[i] = &fibNextHop
+	}
+	rib.Fib.InstallRoute(fibRoute)
+}
+
+func (rib *Layer3Rib) Print() {
+	rib.Mutex.Lock()
+	defer rib.Mutex.Unlock()
+
+	for dllRoute := rib.Candidates.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		route := dllRoute.DllToRoute()
+		fibRoute := rib.Fib.LookupRoutingTable(route.DestinationIP, route.Mask)
+		if fibRoute != nil && fibRoute.Source == route.Source {
+			fmt.Print("> ")
+		} else {
+			fmt.Print("  ")
+		}
+		route.Print()
+	}
+}
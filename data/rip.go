@@ -0,0 +1,155 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const (
+	ripHeaderSize = 4
+	ripEntrySize  = 20
+)
+
+type RipEntry struct {
+	AddressFamily uint16
+	RouteTag      uint16
+	IP            IPAddress
+	Mask          rune
+	NextHop       IPAddress
+	Metric        uint32
+}
+
+type RipMessage struct {
+	Command uint8
+	Version uint8
+	Entries []RipEntry
+}
+
+type RipInstance struct {
+	Interfaces      [constants.MaxIntfPerNode]*Interface
+	Routes          Dll
+	NextUpdate      time.Time
+	TriggeredUpdate bool
+	Mutex           sync.Mutex
+}
+
+type RipRoute struct {
+	DestinationIP IPAddress
+	Mask          rune
+	Metric        uint32
+	GatewayIP     IPAddress
+	InterfaceName InterfaceName
+	IsConnected   bool
+	IsChanged     bool
+	ExpiresAt     time.Time
+	GarbageAt     time.Time
+	RouteGlue     Dll
+}
+
+func (dll *Dll) DllToRipRoute() *RipRoute {
+	return (*RipRoute)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(RipRoute{}.RouteGlue)))
+}
+
+func (message RipMessage) SerializeRipMessage() []byte {
+	data := make([]byte, ripHeaderSize+len(message.Entries)*ripEntrySize)
+	data[0] = message.Command
+	data[1] = message.Version
+
+	for i, entry := range message.Entries {
+		offset := ripHeaderSize + i*ripEntrySize
+		binary.BigEndian.PutUint16(data[offset:offset+2], entry.AddressFamily)
+		binary.BigEndian.PutUint16(data[offset+2:offset+4], entry.RouteTag)
+		copy(data[offset+4:offset+8], net.IP(entry.IP[:]).To4())
+		copy(data[offset+8:offset+12], net.CIDRMask(int(entry.Mask), 32))
+		copy(data[offset+12:offset+16], net.IP(entry.NextHop[:]).To4())
+		binary.BigEndian.PutUint32(data[offset+16:offset+20], entry.Metric)
+	}
+	return data
+}
+
+func DeserializeRipMessage(data []byte) (*RipMessage, error) {
+	if len(data) < ripHeaderSize || (len(data)-ripHeaderSize)%ripEntrySize != 0 {
+		return nil, errors.New("invalid RIP message length")
+	}
+
+	message := &RipMessage{
+		Command: data[0],
+		Version: data[1],
+	}
+	for offset := ripHeaderSize; offset < len(data); offset += ripEntrySize {
+		entry := RipEntry{
+			AddressFamily: binary.BigEndian.Uint16(data[offset : offset+2]),
+			RouteTag:      binary.BigEndian.Uint16(data[offset+2 : offset+4]),
+			Metric:        binary.BigEndian.Uint32(data[offset+16 : offset+20]),
+		}
+		copy(entry.IP[:], net.IP(data[offset+4:offset+8]).To16())
+		ones, bits := net.IPMask(data[offset+8 : offset+12]).Size()
+		if bits == 0 {
+			return nil, errors.New("invalid RIP subnet mask")
+		}
+		entry.Mask = rune(ones)
+		copy(entry.NextHop[:], net.IP(data[offset+12:offset+16]).To16())
+		message.Entries = append(message.Entries, entry)
+	}
+	return message, nil
+}
+
+func (rip *RipInstance) IsInterfaceEnabled(intf *Interface) bool {
+	for _, ripIntf := range rip.Interfaces {
+		if ripIntf == intf {
+			return true
+		}
+	}
+	return false
+}
+
+func (rip *RipInstance) LookupRoute(IP IPAddress, mask rune) *RipRoute {
+	subnet := ApplyMask(IP, mask)
+	for dllRoute := rip.Routes.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		route := dllRoute.DllToRipRoute()
+		if route.Mask == mask && route.DestinationIP == subnet {
+			return route
+		}
+	}
+	return nil
+}
+
+func (rip *RipInstance) AddRoute(route *RipRoute) {
+	route.DestinationIP = ApplyMask(route.DestinationIP, route.Mask)
+	(&route.RouteGlue).Init()
+	(&rip.Routes).AddNode(&route.RouteGlue)
+}
+
+func (rip *RipInstance) Print() {
+	rip.Mutex.Lock()
+	defer rip.Mutex.Unlock()
+
+	fmt.Print("RIP interfaces:")
+	for _, intf := range rip.Interfaces {
+		if intf != nil {
+			fmt.Print(" ", intf.Name.String())
+		}
+	}
+	fmt.Println()
+
+	now := time.Now()
+	for dllRoute := rip.Routes.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		route := dllRoute.DllToRipRoute()
+		if route.IsConnected {
+			fmt.Printf("Destination IP: %s, Mask: %v, Metric: %v, connected\n", route.DestinationIP.String(), route.Mask, route.Metric)
+			continue
+		}
+		timer := fmt.Sprintf("expires in %ds", int(route.ExpiresAt.Sub(now).Seconds()))
+		if route.Metric >= constants.RipInfinity {
+			timer = fmt.Sprintf("garbage collected in %ds", int(route.GarbageAt.Sub(now).Seconds()))
+		}
+		fmt.Printf("Destination IP: %s, Mask: %v, Metric: %v, Gateway IP: %s, Interface Name: %s, %s\n",
+			route.DestinationIP.String(), route.Mask, route.Metric, route.GatewayIP.String(), route.InterfaceName.String(), timer)
+	}
+}
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"tcpip/constants"
+	"unsafe"
+)
+
+// RouteMapEntry forwards the packets Match matches to NextHop, out of Interface when it is set.
+// Only the addresses, protocol and ports of Match are used, its action is ignored.
+type RouteMapEntry struct {
+	Sequence   int
+	Match      AclRule
+	HasNextHop bool
+	NextHop    IPAddress
+	Interface  InterfaceName
+	Hits       uint64
+}
+
+// RouteMap is evaluated entry by entry in sequence order, the first matching entry decides where
+// the packet goes. Packets no entry matches are routed by destination.
+type RouteMap struct {
+	Name         string
+	Entries      []*RouteMapEntry
+	NoMatchHits  uint64
+	RouteMapGlue Dll
+}
+
+type RouteMapInstance struct {
+	Maps    Dll
+	Applied map[*Interface]*RouteMap
+	Mutex   sync.Mutex
+}
+
+var ErrRouteMapNotFound = errors.New("route map not found")
+
+func (dll *Dll) DllToRouteMap() *RouteMap {
+	return (*RouteMap)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(RouteMap{}.RouteMapGlue)))
+}
+
+func (instance *RouteMapInstance) LookupRouteMap(name string) *RouteMap {
+	for dllMap := instance.Maps.Next; dllMap != nil; dllMap = dllMap.Next {
+		routeMap := dllMap.DllToRouteMap()
+		if routeMap.Name == name {
+			return routeMap
+		}
+	}
+	return nil
+}
+
+func (instance *RouteMapInstance) AddRouteMap(routeMap *RouteMap) {
+	(&routeMap.RouteMapGlue).Init()
+	(&instance.Maps).AddNode(&routeMap.RouteMapGlue)
+}
+
+// AddEntry inserts the entry in sequence order, an entry without sequence number is appended after
+// the last entry. It fails if the sequence number is taken.
+func (routeMap *RouteMap) AddEntry(entry *RouteMapEntry) error {
+	if entry.Sequence == 0 {
+		entry.Sequence = constants.AclSequenceStep
+		if len(routeMap.Entries) != 0 {
+			entry.Sequence = routeMap.Entries[len(routeMap.Entries)-1].Sequence + constants.AclSequenceStep
+		}
+	}
+	for _, other := range routeMap.Entries {
+		if other.Sequence == entry.Sequence {
+			return errors.New("sequence number already in use")
+		}
+	}
+	routeMap.Entries = append(routeMap.Entries, entry)
+	sort.Slice(routeMap.Entries, func(i, j int) bool {
+		return routeMap.Entries[i].Sequence < routeMap.Entries[j].Sequence
+	})
+	return nil
+}
+
+func (routeMap *RouteMap) DeleteEntry(sequence int) bool {
+	for i, entry := range routeMap.Entries {
+		if entry.Sequence == sequence {
+			routeMap.Entries = append(routeMap.Entries[:i], routeMap.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate returns the first entry matching the packet and counts the hit, or nil when the packet
+// is left to the routing table.
+func (routeMap *RouteMap) Evaluate(packet AclPacket) *RouteMapEntry {
+	for _, entry := range routeMap.Entries {
+		if entry.Match.Matches(packet) {
+			entry.Hits++
+			return entry
+		}
+	}
+	routeMap.NoMatchHits++
+	return nil
+}
+
+func (entry *RouteMapEntry) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "%d match %s set", entry.Sequence, entry.Match.MatchString())
+	if entry.HasNextHop {
+		builder.WriteString(" nexthop " + entry.NextHop.String())
+	}
+	if entry.Interface.String() != "" {
+		builder.WriteString(" interface " + entry.Interface.String())
+	}
+	return builder.String()
+}
+
+func (instance *RouteMapInstance) Print() {
+	instance.Mutex.Lock()
+	defer instance.Mutex.Unlock()
+
+	for dllMap := instance.Maps.Next; dllMap != nil; dllMap = dllMap.Next {
+		routeMap := dllMap.DllToRouteMap()
+		fmt.Printf("Route Map: %s\n", routeMap.Name)
+		for _, entry := range routeMap.Entries {
+			fmt.Printf("    %s (%d matches)\n", entry.String(), entry.Hits)
+		}
+		fmt.Printf("    no match, routed by destination (%d matches)\n", routeMap.NoMatchHits)
+	}
+	var interfaces []string
+	for intf, routeMap := range instance.Applied {
+		interfaces = append(interfaces, fmt.Sprintf("Interface: %s, Route Map: %s", intf.Name.String(), routeMap.Name))
+	}
+	sort.Strings(interfaces)
+	for _, line := range interfaces {
+		fmt.Println(line)
+	}
+}
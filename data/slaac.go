@@ -0,0 +1,279 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const ndPrefixInformationSize = 32
+
+type NdPrefixInformation struct {
+	Prefix            IPAddress
+	PrefixLength      uint8
+	OnLink            bool
+	Autonomous        bool
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+}
+
+// RouterAdvertisement is sent by routers periodically and in answer to solicitations, a router
+// lifetime of zero tells hosts not to use the sender as default router.
+type RouterAdvertisement struct {
+	CurHopLimit         uint8
+	RouterLifetime      uint16
+	ReachableTime       uint32
+	RetransTimer        uint32
+	LinkLayerAddress    MacAddress
+	HasLinkLayerAddress bool
+	Prefixes            []NdPrefixInformation
+}
+
+type RouterSolicitation struct {
+	LinkLayerAddress    MacAddress
+	HasLinkLayerAddress bool
+}
+
+// RaPrefix is a prefix a router advertises on top of the global prefix of the interface.
+type RaPrefix struct {
+	Prefix            IPAddress
+	Mask              rune
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+}
+
+type RaInterface struct {
+	Interface         *Interface
+	RouterLifetime    uint16
+	Prefixes          []RaPrefix
+	NextAdvertisement time.Time
+	AdvertiserGlue    Dll
+}
+
+type SlaacRouter struct {
+	IP        IPAddress
+	ExpiresAt time.Time
+}
+
+// SlaacClient autoconfigures the address of a host interface from the prefixes routers advertise,
+// Solicitations counts the router solicitations sent since the interface came up.
+type SlaacClient struct {
+	Interface        *Interface
+	IP               IPAddress
+	Mask             rune
+	PreferredUntil   time.Time
+	ValidUntil       time.Time
+	Routers          []SlaacRouter
+	IsUp             bool
+	Solicitations    int
+	NextSolicitation time.Time
+	ClientGlue       Dll
+}
+
+type SlaacInstance struct {
+	Advertisers Dll
+	Clients     Dll
+	Mutex       sync.Mutex
+}
+
+func (dll *Dll) DllToRaInterface() *RaInterface {
+	return (*RaInterface)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(RaInterface{}.AdvertiserGlue)))
+}
+
+func (dll *Dll) DllToSlaacClient() *SlaacClient {
+	return (*SlaacClient)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(SlaacClient{}.ClientGlue)))
+}
+
+// SerializeRouterAdvertisement encodes the complete ICMPv6 message, the hop limit and router
+// lifetime travel in the last four bytes of the ICMP header.
+func (advertisement RouterAdvertisement) SerializeRouterAdvertisement(sourceIP IPAddress, destinationIP IPAddress) []byte {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint32(body[0:4], advertisement.ReachableTime)
+	binary.BigEndian.PutUint32(body[4:8], advertisement.RetransTimer)
+	if advertisement.HasLinkLayerAddress {
+		body = append(body, linkLayerAddressOption(constants.NdOptionSourceLinkLayerAddress, advertisement.LinkLayerAddress)...)
+	}
+	for _, prefix := range advertisement.Prefixes {
+		option := make([]byte, ndPrefixInformationSize)
+		option[0] = constants.NdOptionPrefixInformation
+		option[1] = ndPrefixInformationSize / 8
+		option[2] = prefix.PrefixLength
+		if prefix.OnLink {
+			option[3] |= constants.NdPrefixFlagOnLink
+		}
+		if prefix.Autonomous {
+			option[3] |= constants.NdPrefixFlagAutonomous
+		}
+		binary.BigEndian.PutUint32(option[4:8], prefix.ValidLifetime)
+		binary.BigEndian.PutUint32(option[8:12], prefix.PreferredLifetime)
+		copy(option[16:32], prefix.Prefix[:])
+		body = append(body, option...)
+	}
+
+	header := IcmpHeader{
+		Type: constants.Icmpv6TypeRouterAdvertisement,
+		Rest: uint32(advertisement.CurHopLimit)<<24 | uint32(advertisement.RouterLifetime),
+	}
+	return header.SerializeIcmpv6Message(sourceIP, destinationIP, body)
+}
+
+// DeserializeRouterAdvertisement decodes the body following the ICMPv6 header.
+func DeserializeRouterAdvertisement(header IcmpHeader, body []byte) (RouterAdvertisement, error) {
+	if len(body) < 8 {
+		return RouterAdvertisement{}, errors.New("invalid router advertisement length")
+	}
+	advertisement := RouterAdvertisement{
+		CurHopLimit:    uint8(header.Rest >> 24),
+		RouterLifetime: uint16(header.Rest),
+		ReachableTime:  binary.BigEndian.Uint32(body[0:4]),
+		RetransTimer:   binary.BigEndian.Uint32(body[4:8]),
+	}
+	var prefixErr error
+	err := forEachNdOption(body[8:], func(optionType uint8, option []byte) {
+		switch optionType {
+		case constants.NdOptionSourceLinkLayerAddress:
+			copy(advertisement.LinkLayerAddress[:], option[2:8])
+			advertisement.HasLinkLayerAddress = true
+		case constants.NdOptionPrefixInformation:
+			if len(option) != ndPrefixInformationSize || option[2] > 128 {
+				prefixErr = errors.New("invalid prefix information option")
+				return
+			}
+			prefix := NdPrefixInformation{
+				PrefixLength:      option[2],
+				OnLink:            option[3]&constants.NdPrefixFlagOnLink != 0,
+				Autonomous:        option[3]&constants.NdPrefixFlagAutonomous != 0,
+				ValidLifetime:     binary.BigEndian.Uint32(option[4:8]),
+				PreferredLifetime: binary.BigEndian.Uint32(option[8:12]),
+			}
+			copy(prefix.Prefix[:], option[16:32])
+			advertisement.Prefixes = append(advertisement.Prefixes, prefix)
+		}
+	})
+	if err == nil {
+		err = prefixErr
+	}
+	if err != nil {
+		return RouterAdvertisement{}, err
+	}
+	return advertisement, nil
+}
+
+func (solicitation RouterSolicitation) SerializeRouterSolicitation(sourceIP IPAddress, destinationIP IPAddress) []byte {
+	var body []byte
+	if solicitation.HasLinkLayerAddress {
+		body = linkLayerAddressOption(constants.NdOptionSourceLinkLayerAddress, solicitation.LinkLayerAddress)
+	}
+	header := IcmpHeader{
+		Type: constants.Icmpv6TypeRouterSolicitation,
+	}
+	return header.SerializeIcmpv6Message(sourceIP, destinationIP, body)
+}
+
+// DeserializeRouterSolicitation decodes the options following the ICMPv6 header.
+func DeserializeRouterSolicitation(body []byte) (RouterSolicitation, error) {
+	var solicitation RouterSolicitation
+	err := forEachNdOption(body, func(optionType uint8, option []byte) {
+		if optionType == constants.NdOptionSourceLinkLayerAddress {
+			copy(solicitation.LinkLayerAddress[:], option[2:8])
+			solicitation.HasLinkLayerAddress = true
+		}
+	})
+	if err != nil {
+		return RouterSolicitation{}, err
+	}
+	return solicitation, nil
+}
+
+// SlaacAddress appends the modified EUI-64 interface identifier of MAC to a /64 prefix.
+func SlaacAddress(prefix IPAddress, MAC MacAddress) IPAddress {
+	var IP IPAddress
+	copy(IP[:8], prefix[:8])
+	copy(IP[8:], EUI64InterfaceID(MAC))
+	return IP
+}
+
+func (slaac *SlaacInstance) LookupAdvertiser(intf *Interface) *RaInterface {
+	for dllAdvertiser := slaac.Advertisers.Next; dllAdvertiser != nil; dllAdvertiser = dllAdvertiser.Next {
+		advertiser := dllAdvertiser.DllToRaInterface()
+		if advertiser.Interface == intf {
+			return advertiser
+		}
+	}
+	return nil
+}
+
+func (slaac *SlaacInstance) LookupClient(intf *Interface) *SlaacClient {
+	for dllClient := slaac.Clients.Next; dllClient != nil; dllClient = dllClient.Next {
+		client := dllClient.DllToSlaacClient()
+		if client.Interface == intf {
+			return client
+		}
+	}
+	return nil
+}
+
+func (slaac *SlaacInstance) AddAdvertiser(advertiser *RaInterface) {
+	(&advertiser.AdvertiserGlue).Init()
+	(&slaac.Advertisers).AddNode(&advertiser.AdvertiserGlue)
+}
+
+func (slaac *SlaacInstance) AddClient(client *SlaacClient) {
+	(&client.ClientGlue).Init()
+	(&slaac.Clients).AddNode(&client.ClientGlue)
+}
+
+// LookupRouter returns the index of the default router IP, or -1.
+func (client *SlaacClient) LookupRouter(IP IPAddress) int {
+	for i, router := range client.Routers {
+		if router.IP == IP {
+			return i
+		}
+	}
+	return -1
+}
+
+func (slaac *SlaacInstance) Print() {
+	slaac.Mutex.Lock()
+	defer slaac.Mutex.Unlock()
+
+	now := time.Now()
+	for dllAdvertiser := slaac.Advertisers.Next; dllAdvertiser != nil; dllAdvertiser = dllAdvertiser.Next {
+		advertiser := dllAdvertiser.DllToRaInterface()
+		intf := advertiser.Interface
+		var prefixes []string
+		if !intf.Properties.IPv6.IsUnspecified() {
+			prefixes = append(prefixes, fmt.Sprintf("%s/%d", ApplyMask(intf.Properties.IPv6, intf.Properties.IPv6Mask).String(), intf.Properties.IPv6Mask))
+		}
+		for _, prefix := range advertiser.Prefixes {
+			prefixes = append(prefixes, fmt.Sprintf("%s/%d", prefix.Prefix.String(), prefix.Mask))
+		}
+		fmt.Printf("Interface: %s, Role: router, Router Lifetime: %ds, Prefixes: %s\n",
+			intf.Name.String(), advertiser.RouterLifetime, strings.Join(prefixes, " "))
+	}
+	for dllClient := slaac.Clients.Next; dllClient != nil; dllClient = dllClient.Next {
+		client := dllClient.DllToSlaacClient()
+		address := "none"
+		if !client.IP.IsUnspecified() {
+			state := "preferred"
+			if !now.Before(client.PreferredUntil) {
+				state = "deprecated"
+			}
+			address = fmt.Sprintf("%s/%d (%s, valid %ds)", client.IP.String(), client.Mask, state, int(client.ValidUntil.Sub(now).Seconds()))
+		}
+		var routers []string
+		for _, router := range client.Routers {
+			routers = append(routers, fmt.Sprintf("%s (expires %ds)", router.IP.String(), int(router.ExpiresAt.Sub(now).Seconds())))
+		}
+		if len(routers) == 0 {
+			routers = append(routers, "none")
+		}
+		fmt.Printf("Interface: %s, Role: host, Address: %s, Default Routers: %s\n",
+			client.Interface.Name.String(), address, strings.Join(routers, ", "))
+	}
+}
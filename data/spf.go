@@ -0,0 +1,188 @@
+package data
+
+import (
+	"bytes"
+	"tcpip/constants"
+)
+
+type spfVertex struct {
+	cost     uint32
+	nextHops []Layer3NextHop
+	done     bool
+}
+
+type spfPrefix struct {
+	IP   IPAddress
+	Mask rune
+}
+
+type spfRoute struct {
+	cost     uint32
+	nextHops []Layer3NextHop
+}
+
+func (node *Node) isLayer3Node() bool {
+	for _, intf := range node.Interfaces {
+		if intf != nil && intf.Properties.IsIpConfigured {
+			return true
+		}
+	}
+	return false
+}
+
+// layer3Neighbor returns the interface at the far end of intf when both ends are up L3 interfaces of the same subnet.
+func (intf *Interface) layer3Neighbor() *Interface {
+	if !intf.Properties.IsIpConfigured || !intf.IsUp() {
+		return nil
+	}
+	remoteIntf := &intf.Link.Interface1
+	if remoteIntf == intf {
+		remoteIntf = &intf.Link.Interface2
+	}
+	if !remoteIntf.Properties.IsIpConfigured || remoteIntf.Properties.Mask != intf.Properties.Mask {
+		return nil
+	}
+	subnet1 := ApplyMask(intf.Properties.IP, intf.Properties.Mask)
+	subnet2 := ApplyMask(remoteIntf.Properties.IP, remoteIntf.Properties.Mask)
+	if !bytes.Equal(subnet1[:], subnet2[:]) {
+		return nil
+	}
+	return remoteIntf
+}
+
+func mergeNextHops(nextHops []Layer3NextHop, more []Layer3NextHop) []Layer3NextHop {
+	nextHops = append([]Layer3NextHop(nil), nextHops...)
+	for _, nextHop := range more {
+		isKnown := false
+		for _, known := range nextHops {
+			if known == nextHop {
+				isKnown = true
+				break
+			}
+		}
+		if !isKnown && len(nextHops) < constants.MaxNextHops {
+			nextHops = append(nextHops, nextHop)
+		}
+	}
+	return nextHops
+}
+
+// shortestPaths runs Dijkstra from source over the link costs between L3 nodes and returns the
+// cost and the equal cost first hops towards every reachable node.
+func (graph *Graph) shortestPaths(source *Node) map[*Node]*spfVertex {
+	vertices := map[*Node]*spfVertex{
+		source: {},
+	}
+
+	for {
+		var current *Node
+		for node, vertex := range vertices {
+			if !vertex.done && (current == nil || vertex.cost < vertices[current].cost) {
+				current = node
+			}
+		}
+		if current == nil {
+			return vertices
+		}
+		currentVertex := vertices[current]
+		currentVertex.done = true
+
+		for _, intf := range current.Interfaces {
+			if intf == nil {
+				break
+			}
+			remoteIntf := intf.layer3Neighbor()
+			if remoteIntf == nil {
+				continue
+			}
+			nextHops := currentVertex.nextHops
+			if current == source {
+				nextHops = []Layer3NextHop{{GatewayIP: remoteIntf.Properties.IP, InterfaceName: intf.Name, Weight: 1}}
+			}
+
+			cost := currentVertex.cost + intf.LinkCost()
+			vertex := vertices[remoteIntf.Node]
+			switch {
+			case vertex == nil:
+				vertices[remoteIntf.Node] = &spfVertex{cost: cost, nextHops: nextHops}
+			case vertex.done:
+			case cost < vertex.cost:
+				vertex.cost = cost
+				vertex.nextHops = nextHops
+			case cost == vertex.cost:
+				vertex.nextHops = mergeNextHops(vertex.nextHops, nextHops)
+			}
+		}
+	}
+}
+
+// ComputeRoutes installs the shortest paths between all L3 nodes of the graph as SPF routes,
+// every interface subnet and loopback address of a node becomes reachable from all other nodes.
+// SPF routes of an earlier run to prefixes that are no longer reachable are withdrawn.
+// It returns the number of routes installed.
+func (graph *Graph) ComputeRoutes() int {
+	count := 0
+
+	for dllNode := graph.Nodes.Next; dllNode != nil; dllNode = dllNode.Next {
+		source := dllNode.DllToNode()
+		if !source.isLayer3Node() {
+			continue
+		}
+
+		localPrefixes := map[spfPrefix]bool{}
+		if source.Properties.IsLbConfigured {
+			localPrefixes[spfPrefix{source.Properties.LB, 32}] = true
+		}
+		for _, intf := range source.Interfaces {
+			if intf != nil && intf.Properties.IsIpConfigured {
+				localPrefixes[spfPrefix{ApplyMask(intf.Properties.IP, intf.Properties.Mask), intf.Properties.Mask}] = true
+			}
+		}
+
+		routes := map[spfPrefix]*spfRoute{}
+		var order []spfPrefix
+		addRoute := func(prefix spfPrefix, vertex *spfVertex) {
+			if localPrefixes[prefix] {
+				return
+			}
+			route := routes[prefix]
+			switch {
+			case route == nil:
+				routes[prefix] = &spfRoute{cost: vertex.cost, nextHops: vertex.nextHops}
+				order = append(order, prefix)
+			case vertex.cost < route.cost:
+				route.cost = vertex.cost
+				route.nextHops = vertex.nextHops
+			case vertex.cost == route.cost:
+				route.nextHops = mergeNextHops(route.nextHops, vertex.nextHops)
+			}
+		}
+
+		for node, vertex := range graph.shortestPaths(source) {
+			if node == source {
+				continue
+			}
+			if node.Properties.IsLbConfigured {
+				addRoute(spfPrefix{node.Properties.LB, 32}, vertex)
+			}
+			for _, intf := range node.Interfaces {
+				if intf != nil && intf.Properties.IsIpConfigured {
+					addRoute(spfPrefix{ApplyMask(intf.Properties.IP, intf.Properties.Mask), intf.Properties.Mask}, vertex)
+				}
+			}
+		}
+
+		for _, prefix := range order {
+			route := routes[prefix]
+			if source.Properties.Rib.ReplaceRoute(prefix.IP, prefix.Mask, constants.RouteSourceSPF, route.cost, route.nextHops) == nil {
+				count++
+			}
+		}
+		for _, route := range source.Properties.Rib.LookupSourceRoutes(constants.RouteSourceSPF) {
+			if routes[spfPrefix{route.DestinationIP, route.Mask}] == nil {
+				source.Properties.Rib.DeleteRoute(route.DestinationIP, route.Mask, constants.RouteSourceSPF)
+			}
+		}
+	}
+	return count
+}
@@ -0,0 +1,295 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const (
+	TcpStateClosed uint8 = iota
+	TcpStateListen
+	TcpStateSynSent
+	TcpStateSynReceived
+	TcpStateEstablished
+	TcpStateFinWait1
+	TcpStateFinWait2
+	TcpStateCloseWait
+	TcpStateClosing
+	TcpStateLastAck
+	TcpStateTimeWait
+)
+
+type TCPHeader struct {
+	SourcePort      uint16
+	DestinationPort uint16
+	Seq             uint32
+	Ack             uint32
+	Flags           uint8
+	Window          uint16
+	Checksum        uint16
+	Urgent          uint16
+}
+
+// TCPConnection is the transmission control block of a connection. SendBuffer holds the data from
+// SndUna on, the part past SndNxt is not sent yet. SndMax is the highest sequence number ever sent,
+// SndNxt falls back to SndUna when a timeout resends the window.
+type TCPConnection struct {
+	LocalIP        IPAddress
+	LocalPort      uint16
+	RemoteIP       IPAddress
+	RemotePort     uint16
+	State          uint8
+	ISS            uint32
+	SndUna         uint32
+	SndNxt         uint32
+	SndMax         uint32
+	SndWnd         uint32
+	IRS            uint32
+	RcvNxt         uint32
+	SendBuffer     []byte
+	RecvBuffer     []byte
+	OutOfOrder     map[uint32][]byte
+	Cwnd           uint32
+	Ssthresh       uint32
+	SRTT           time.Duration
+	RTTVAR         time.Duration
+	RTO            time.Duration
+	RTTSeq         uint32
+	RTTStart       time.Time
+	RetransmitAt   time.Time
+	Retransmits    int
+	DupAcks        int
+	FastRecovery   bool
+	Recover        uint32
+	CloseRequested bool
+	FinSent        bool
+	FinSeq         uint32
+	FinReceived    bool
+	TimeWaitUntil  time.Time
+	Err            error
+	Listener       *TCPListener
+	Changed        *sync.Cond
+	ConnectionGlue Dll
+}
+
+type TCPListener struct {
+	LocalIP      IPAddress
+	LocalPort    uint16
+	AcceptQueue  []*TCPConnection
+	IsClosed     bool
+	Changed      *sync.Cond
+	ListenerGlue Dll
+}
+
+type TcpInstance struct {
+	Connections Dll
+	Listeners   Dll
+	Mutex       sync.Mutex
+}
+
+var (
+	ErrConnectionRefused = errors.New("connection refused")
+	ErrConnectionReset   = errors.New("connection reset by peer")
+	ErrConnectionTimeout = errors.New("connection timed out")
+)
+
+func (dll *Dll) DllToTCPConnection() *TCPConnection {
+	return (*TCPConnection)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(TCPConnection{}.ConnectionGlue)))
+}
+
+func (dll *Dll) DllToTCPListener() *TCPListener {
+	return (*TCPListener)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(TCPListener{}.ListenerGlue)))
+}
+
+// SerializeTCPSegment encodes the header without options followed by payload, the checksum covers
+// the IPv4 pseudo header of the packet.
+func (header TCPHeader) SerializeTCPSegment(sourceIP IPAddress, destinationIP IPAddress, payload []byte) []byte {
+	data := make([]byte, constants.TcpHeaderSize+len(payload))
+	binary.BigEndian.PutUint16(data[0:2], header.SourcePort)
+	binary.BigEndian.PutUint16(data[2:4], header.DestinationPort)
+	binary.BigEndian.PutUint32(data[4:8], header.Seq)
+	binary.BigEndian.PutUint32(data[8:12], header.Ack)
+	data[12] = uint8(constants.TcpHeaderSize/4) << 4
+	data[13] = header.Flags
+	binary.BigEndian.PutUint16(data[14:16], header.Window)
+	binary.BigEndian.PutUint16(data[18:20], header.Urgent)
+	copy(data[constants.TcpHeaderSize:], payload)
+	checksum := InternetChecksum(PseudoHeader(sourceIP, destinationIP, constants.TcpProto, len(data)), data)
+	binary.BigEndian.PutUint16(data[16:18], checksum)
+	return data
+}
+
+// DeserializeTCPSegment checks the checksum of a segment and returns its header and payload.
+func DeserializeTCPSegment(data []byte, sourceIP IPAddress, destinationIP IPAddress) (TCPHeader, []byte, error) {
+	if len(data) < constants.TcpHeaderSize {
+		return TCPHeader{}, nil, errors.New("invalid TCP segment length")
+	}
+	headerLength := int(data[12]>>4) * 4
+	if headerLength < constants.TcpHeaderSize || headerLength > len(data) {
+		return TCPHeader{}, nil, errors.New("invalid TCP data offset")
+	}
+	if InternetChecksum(PseudoHeader(sourceIP, destinationIP, constants.TcpProto, len(data)), data) != 0 {
+		return TCPHeader{}, nil, errors.New("invalid TCP checksum")
+	}
+
+	header := TCPHeader{
+		SourcePort:      binary.BigEndian.Uint16(data[0:2]),
+		DestinationPort: binary.BigEndian.Uint16(data[2:4]),
+		Seq:             binary.BigEndian.Uint32(data[4:8]),
+		Ack:             binary.BigEndian.Uint32(data[8:12]),
+		Flags:           data[13],
+		Window:          binary.BigEndian.Uint16(data[14:16]),
+		Checksum:        binary.BigEndian.Uint16(data[16:18]),
+		Urgent:          binary.BigEndian.Uint16(data[18:20]),
+	}
+	return header, data[headerLength:], nil
+}
+
+// SeqLT compares sequence numbers modulo 2^32.
+func SeqLT(a uint32, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func SeqLE(a uint32, b uint32) bool {
+	return int32(a-b) <= 0
+}
+
+// InFlight is the sequence space sent and not acknowledged yet.
+func (conn *TCPConnection) InFlight() uint32 {
+	return conn.SndNxt - conn.SndUna
+}
+
+// Unsent is the data of the send buffer not sent yet.
+func (conn *TCPConnection) Unsent() []byte {
+	sent := conn.InFlight()
+	if conn.FinSent {
+		sent--
+	}
+	if int(sent) > len(conn.SendBuffer) {
+		return nil
+	}
+	return conn.SendBuffer[sent:]
+}
+
+// ReceiveWindow is the free space of the receive buffer advertised to the peer.
+func (conn *TCPConnection) ReceiveWindow() uint32 {
+	return uint32(constants.TcpReceiveBufferSize - len(conn.RecvBuffer))
+}
+
+func (tcp *TcpInstance) LookupConnection(localIP IPAddress, localPort uint16, remoteIP IPAddress, remotePort uint16) *TCPConnection {
+	for dllConnection := tcp.Connections.Next; dllConnection != nil; dllConnection = dllConnection.Next {
+		conn := dllConnection.DllToTCPConnection()
+		if conn.LocalPort == localPort && conn.RemotePort == remotePort && conn.LocalIP == localIP && conn.RemoteIP == remoteIP {
+			return conn
+		}
+	}
+	return nil
+}
+
+// LookupListener prefers a listener bound to IP over one bound to any address.
+func (tcp *TcpInstance) LookupListener(IP IPAddress, port uint16) *TCPListener {
+	var wildcard *TCPListener
+	for dllListener := tcp.Listeners.Next; dllListener != nil; dllListener = dllListener.Next {
+		listener := dllListener.DllToTCPListener()
+		if listener.LocalPort != port {
+			continue
+		}
+		if listener.LocalIP == IP {
+			return listener
+		}
+		if listener.LocalIP.IsUnspecified() {
+			wildcard = listener
+		}
+	}
+	return wildcard
+}
+
+func (tcp *TcpInstance) IsPortInUse(port uint16) bool {
+	for dllListener := tcp.Listeners.Next; dllListener != nil; dllListener = dllListener.Next {
+		if dllListener.DllToTCPListener().LocalPort == port {
+			return true
+		}
+	}
+	for dllConnection := tcp.Connections.Next; dllConnection != nil; dllConnection = dllConnection.Next {
+		if dllConnection.DllToTCPConnection().LocalPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// EphemeralPort returns a free local port for an outgoing connection, or zero when all are taken.
+func (tcp *TcpInstance) EphemeralPort() uint16 {
+	for port := constants.TcpEphemeralPortStart; port != 0; port++ {
+		if !tcp.IsPortInUse(port) {
+			return port
+		}
+	}
+	return 0
+}
+
+func (tcp *TcpInstance) AddConnection(conn *TCPConnection) {
+	(&conn.ConnectionGlue).Init()
+	(&tcp.Connections).AddNode(&conn.ConnectionGlue)
+}
+
+func (tcp *TcpInstance) AddListener(listener *TCPListener) {
+	(&listener.ListenerGlue).Init()
+	(&tcp.Listeners).AddNode(&listener.ListenerGlue)
+}
+
+func TcpStateString(state uint8) string {
+	switch state {
+	case TcpStateListen:
+		return "Listen"
+	case TcpStateSynSent:
+		return "SynSent"
+	case TcpStateSynReceived:
+		return "SynReceived"
+	case TcpStateEstablished:
+		return "Established"
+	case TcpStateFinWait1:
+		return "FinWait1"
+	case TcpStateFinWait2:
+		return "FinWait2"
+	case TcpStateCloseWait:
+		return "CloseWait"
+	case TcpStateClosing:
+		return "Closing"
+	case TcpStateLastAck:
+		return "LastAck"
+	case TcpStateTimeWait:
+		return "TimeWait"
+	default:
+		return "Closed"
+	}
+}
+
+func tcpEndpointString(IP IPAddress, port uint16) string {
+	if IP.IsUnspecified() {
+		return fmt.Sprintf("*:%d", port)
+	}
+	return fmt.Sprintf("%s:%d", IP.String(), port)
+}
+
+func (tcp *TcpInstance) Print() {
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	for dllListener := tcp.Listeners.Next; dllListener != nil; dllListener = dllListener.Next {
+		listener := dllListener.DllToTCPListener()
+		fmt.Printf("Local: %s, Remote: *:*, State: %s, Accept Queue: %d\n",
+			tcpEndpointString(listener.LocalIP, listener.LocalPort), TcpStateString(TcpStateListen), len(listener.AcceptQueue))
+	}
+	for dllConnection := tcp.Connections.Next; dllConnection != nil; dllConnection = dllConnection.Next {
+		conn := dllConnection.DllToTCPConnection()
+		fmt.Printf("Local: %s, Remote: %s, State: %s, Send Queue: %d, Receive Queue: %d, Send Window: %d, Congestion Window: %d, RTO: %v\n",
+			tcpEndpointString(conn.LocalIP, conn.LocalPort), tcpEndpointString(conn.RemoteIP, conn.RemotePort),
+			TcpStateString(conn.State), len(conn.SendBuffer), len(conn.RecvBuffer), conn.SndWnd, conn.Cwnd, conn.RTO)
+	}
+}
@@ -0,0 +1,200 @@
+package data
+
+import "net"
+
+// ipv4MappedPrefixLength is the length of the ::ffff:0:0/96 prefix IPv4 addresses are stored under.
+const ipv4MappedPrefixLength = 96
+
+const trieKeyBits = len(IPAddress{}) * 8
+
+// prefixTrieNode is a node of a path compressed binary trie. Every node carries the complete
+// masked prefix it stands for, nodes only exist where a route is stored or two prefixes diverge.
+type prefixTrieNode struct {
+	Prefix   IPAddress
+	Length   int
+	Route    *Layer3Route
+	Children [2]*prefixTrieNode
+}
+
+type prefixTrie struct {
+	Root  *prefixTrieNode
+	Count int
+}
+
+// trieKeyLength converts a route mask into a prefix length over the 128 bit key,
+// IPv4 prefixes live below the IPv4-mapped prefix.
+func trieKeyLength(IP IPAddress, mask rune) int {
+	if net.IP(IP[:]).To4() != nil {
+		return ipv4MappedPrefixLength + int(mask)
+	}
+	return int(mask)
+}
+
+func keyBit(key *IPAddress, position int) int {
+	return int(key[position/8]>>(7-uint(position%8))) & 1
+}
+
+func commonPrefixLength(key1, key2 *IPAddress, maxLength int) int {
+	length := 0
+	for i := 0; i < len(key1) && length < maxLength; i++ {
+		diff := key1[i] ^ key2[i]
+		if diff == 0 {
+			length += 8
+			continue
+		}
+		for diff&0x80 == 0 {
+			length++
+			diff <<= 1
+		}
+		break
+	}
+	if length > maxLength {
+		return maxLength
+	}
+	return length
+}
+
+func maskKey(key IPAddress, length int) IPAddress {
+	var masked IPAddress
+	for i := range masked {
+		switch {
+		case length >= (i+1)*8:
+			masked[i] = key[i]
+		case length > i*8:
+			masked[i] = key[i] & (0xFF << uint(8-(length-i*8)))
+		}
+	}
+	return masked
+}
+
+func (trie *prefixTrie) Insert(key IPAddress, length int, route *Layer3Route) {
+	leaf := &prefixTrieNode{
+		Prefix: maskKey(key, length),
+		Length: length,
+		Route:  route,
+	}
+
+	link := &trie.Root
+	for {
+		node := *link
+		if node == nil {
+			*link = leaf
+			trie.Count++
+			return
+		}
+
+		maxLength := length
+		if node.Length < maxLength {
+			maxLength = node.Length
+		}
+		common := commonPrefixLength(&leaf.Prefix, &node.Prefix, maxLength)
+
+		switch {
+		case common == node.Length && common == length:
+			if node.Route == nil {
+				trie.Count++
+			}
+			node.Route = route
+			return
+		case common == node.Length:
+			link = &node.Children[keyBit(&leaf.Prefix, node.Length)]
+			continue
+		case common == length:
+			leaf.Children[keyBit(&node.Prefix, length)] = node
+			*link = leaf
+		default:
+			branch := &prefixTrieNode{
+				Prefix: maskKey(leaf.Prefix, common),
+				Length: common,
+			}
+			branch.Children[keyBit(&leaf.Prefix, common)] = leaf
+			branch.Children[keyBit(&node.Prefix, common)] = node
+			*link = branch
+		}
+		trie.Count++
+		return
+	}
+}
+
+// LookupLPM returns the route of the longest prefix covering key that is at least minLength long.
+func (trie *prefixTrie) LookupLPM(key IPAddress, minLength int) *Layer3Route {
+	var lpmRoute *Layer3Route
+
+	for node := trie.Root; node != nil; {
+		if commonPrefixLength(&key, &node.Prefix, node.Length) < node.Length {
+			break
+		}
+		if node.Route != nil && node.Length >= minLength {
+			lpmRoute = node.Route
+		}
+		if node.Length == trieKeyBits {
+			break
+		}
+		node = node.Children[keyBit(&key, node.Length)]
+	}
+	return lpmRoute
+}
+
+func (trie *prefixTrie) Lookup(key IPAddress, length int) *Layer3Route {
+	prefix := maskKey(key, length)
+
+	for node := trie.Root; node != nil && node.Length <= length; {
+		if commonPrefixLength(&prefix, &node.Prefix, node.Length) < node.Length {
+			return nil
+		}
+		if node.Length == length {
+			return node.Route
+		}
+		node = node.Children[keyBit(&prefix, node.Length)]
+	}
+	return nil
+}
+
+func (trie *prefixTrie) Delete(key IPAddress, length int) *Layer3Route {
+	var deletedRoute *Layer3Route
+	trie.Root, deletedRoute = trie.Root.delete(maskKey(key, length), length)
+	if deletedRoute != nil {
+		trie.Count--
+	}
+	return deletedRoute
+}
+
+func (node *prefixTrieNode) delete(prefix IPAddress, length int) (*prefixTrieNode, *Layer3Route) {
+	if node == nil || node.Length > length || commonPrefixLength(&prefix, &node.Prefix, node.Length) < node.Length {
+		return node, nil
+	}
+
+	var deletedRoute *Layer3Route
+	if node.Length == length {
+		deletedRoute = node.Route
+		node.Route = nil
+	} else {
+		bit := keyBit(&prefix, node.Length)
+		node.Children[bit], deletedRoute = node.Children[bit].delete(prefix, length)
+	}
+
+	// a node without a route only stays while it still separates two subtrees
+	if node.Route != nil || (node.Children[0] != nil && node.Children[1] != nil) {
+		return node, deletedRoute
+	}
+	if node.Children[0] != nil {
+		return node.Children[0], deletedRoute
+	}
+	return node.Children[1], deletedRoute
+}
+
+// Walk visits the routes in ascending prefix order.
+func (trie *prefixTrie) Walk(visit func(*Layer3Route)) {
+	trie.Root.walk(visit)
+}
+
+func (node *prefixTrieNode) walk(visit func(*Layer3Route)) {
+	if node == nil {
+		return
+	}
+	if node.Route != nil {
+		visit(node.Route)
+	}
+	node.Children[0].walk(visit)
+	node.Children[1].walk(visit)
+}
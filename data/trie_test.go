@@ -0,0 +1,104 @@
+package data
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// benchmarkRoutes returns count IPv4 routes with random prefixes of length 8 to 32.
+func benchmarkRoutes(count int) []*Layer3Route {
+	random := rand.New(rand.NewSource(1))
+	routes := make([]*Layer3Route, 0, count)
+	for len(routes) < count {
+		mask := rune(8 + random.Intn(25))
+		routes = append(routes, &Layer3Route{
+			DestinationIP: ApplyMask(uint32ToIPv4(random.Uint32()), mask),
+			Mask:          mask,
+		})
+	}
+	return routes
+}
+
+// benchmarkAddresses returns addresses to look up, half of them inside one of the routes.
+func benchmarkAddresses(routes []*Layer3Route) []IPAddress {
+	random := rand.New(rand.NewSource(2))
+	addresses := make([]IPAddress, 1024)
+	for i := range addresses {
+		if i%2 == 0 {
+			addresses[i] = routes[random.Intn(len(routes))].DestinationIP
+		} else {
+			addresses[i] = uint32ToIPv4(random.Uint32())
+		}
+	}
+	return addresses
+}
+
+// linearLookupLPM is the longest prefix match of the FIB before the trie, a scan of all routes.
+func linearLookupLPM(routes []*Layer3Route, IP IPAddress) *Layer3Route {
+	var best *Layer3Route
+	for _, route := range routes {
+		if (best == nil || route.Mask > best.Mask) && ApplyMask(IP, route.Mask) == route.DestinationIP {
+			best = route
+		}
+	}
+	return best
+}
+
+func benchmarkTrieLookup(b *testing.B, count int) {
+	routes := benchmarkRoutes(count)
+	addresses := benchmarkAddresses(routes)
+	routingTable := &Layer3RouteTable{}
+	for _, route := range routes {
+		routingTable.InstallRoute(route)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		routingTable.LookupRoutingTableLPM(addresses[i%len(addresses)])
+	}
+}
+
+func benchmarkLinearLookup(b *testing.B, count int) {
+	routes := benchmarkRoutes(count)
+	addresses := benchmarkAddresses(routes)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearLookupLPM(routes, addresses[i%len(addresses)])
+	}
+}
+
+func BenchmarkLookup10k(b *testing.B) {
+	benchmarkTrieLookup(b, 10000)
+}
+
+func BenchmarkLinearLookup10k(b *testing.B) {
+	benchmarkLinearLookup(b, 10000)
+}
+
+func BenchmarkLookup100k(b *testing.B) {
+	benchmarkTrieLookup(b, 100000)
+}
+
+func BenchmarkLinearLookup100k(b *testing.B) {
+	benchmarkLinearLookup(b, 100000)
+}
+
+// TestLookupMatchesLinearScan checks the trie against the linear scan the benchmarks compare it to.
+func TestLookupMatchesLinearScan(t *testing.T) {
+	routes := benchmarkRoutes(10000)
+	routingTable := &Layer3RouteTable{}
+	for _, route := range routes {
+		routingTable.InstallRoute(route)
+	}
+	for _, IP := range benchmarkAddresses(routes) {
+		want := linearLookupLPM(routes, IP)
+		got := routingTable.LookupRoutingTableLPM(IP)
+		if want == nil && got == nil {
+			continue
+		}
+		if want == nil || got == nil || got.DestinationIP != want.DestinationIP || got.Mask != want.Mask {
+			t.Fatalf("lookup of %s: got %v, want %v", IP.String(), got, want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"tcpip/constants"
+	"unsafe"
+)
+
+const (
+	TunnelModeGRE uint8 = iota
+	TunnelModeIPIP
+)
+
+// optional fields announced by the flags of a GRE header, they follow the header in this order
+const (
+	greFlagChecksum uint16 = 0x8000
+	greFlagSequence uint16 = 0x1000
+	greVersionMask  uint16 = 0x0007
+)
+
+// GreHeader is the GRE header (RFC 2784) with the key extension of RFC 2890.
+type GreHeader struct {
+	Flags        uint16
+	ProtocolType uint16
+	Key          uint32
+}
+
+// Tunnel is a point-to-point interface carrying IPv4 packets inside IPv4 packets from SourceIP to
+// DestinationIP. Interface holds its name and address, it has no link.
+type Tunnel struct {
+	Interface     Interface
+	Mode          uint8
+	SourceIP      IPAddress
+	DestinationIP IPAddress
+	HasKey        bool
+	Key           uint32
+	PacketsOut    uint64
+	BytesOut      uint64
+	PacketsIn     uint64
+	BytesIn       uint64
+	Drops         uint64
+	TunnelGlue    Dll
+}
+
+type TunnelTable struct {
+	Tunnels Dll
+	Mutex   sync.Mutex
+}
+
+func (dll *Dll) DllToTunnel() *Tunnel {
+	return (*Tunnel)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(Tunnel{}.TunnelGlue)))
+}
+
+// SerializeGreHeader encodes the header followed by the key when the key flag is set.
+func (header GreHeader) SerializeGreHeader() []byte {
+	size := constants.GreHeaderSize
+	if header.Flags&constants.GreFlagKey != 0 {
+		size += constants.GreKeySize
+	}
+	data := make([]byte, size)
+	binary.BigEndian.PutUint16(data[0:2], header.Flags)
+	binary.BigEndian.PutUint16(data[2:4], header.ProtocolType)
+	if header.Flags&constants.GreFlagKey != 0 {
+		binary.BigEndian.PutUint32(data[4:8], header.Key)
+	}
+	return data
+}
+
+// DeserializeGreHeader decodes a GRE header and returns the offset of the packet it carries.
+func DeserializeGreHeader(data []byte) (GreHeader, int, error) {
+	if len(data) < constants.GreHeaderSize {
+		return GreHeader{}, 0, errors.New("invalid GRE header length")
+	}
+	header := GreHeader{
+		Flags:        binary.BigEndian.Uint16(data[0:2]),
+		ProtocolType: binary.BigEndian.Uint16(data[2:4]),
+	}
+	if header.Flags&greVersionMask != 0 {
+		return GreHeader{}, 0, errors.New("unsupported GRE version")
+	}
+	offset := constants.GreHeaderSize
+	if header.Flags&greFlagChecksum != 0 {
+		offset += 4
+	}
+	if header.Flags&constants.GreFlagKey != 0 {
+		if len(data) < offset+constants.GreKeySize {
+			return GreHeader{}, 0, errors.New("invalid GRE header length")
+		}
+		header.Key = binary.BigEndian.Uint32(data[offset:])
+		offset += constants.GreKeySize
+	}
+	if header.Flags&greFlagSequence != 0 {
+		offset += 4
+	}
+	if len(data) < offset {
+		return GreHeader{}, 0, errors.New("invalid GRE header length")
+	}
+	return header, offset, nil
+}
+
+func (table *TunnelTable) AddTunnel(tunnel *Tunnel) {
+	(&tunnel.TunnelGlue).Init()
+	(&table.Tunnels).AddNode(&tunnel.TunnelGlue)
+}
+
+func (table *TunnelTable) LookupTunnel(name string) *Tunnel {
+	for dllTunnel := table.Tunnels.Next; dllTunnel != nil; dllTunnel = dllTunnel.Next {
+		tunnel := dllTunnel.DllToTunnel()
+		if tunnel.Interface.Name.String() == name {
+			return tunnel
+		}
+	}
+	return nil
+}
+
+func (table *TunnelTable) LookupTunnelByInterface(intf *Interface) *Tunnel {
+	for dllTunnel := table.Tunnels.Next; dllTunnel != nil; dllTunnel = dllTunnel.Next {
+		tunnel := dllTunnel.DllToTunnel()
+		if &tunnel.Interface == intf {
+			return tunnel
+		}
+	}
+	return nil
+}
+
+// LookupTunnelByEndpoints returns the tunnel a packet from remoteIP to localIP was sent through, GRE
+// tunnels between the same endpoints are told apart by their key.
+func (table *TunnelTable) LookupTunnelByEndpoints(mode uint8, localIP IPAddress, remoteIP IPAddress, hasKey bool, key uint32) *Tunnel {
+	for dllTunnel := table.Tunnels.Next; dllTunnel != nil; dllTunnel = dllTunnel.Next {
+		tunnel := dllTunnel.DllToTunnel()
+		if tunnel.Mode != mode || tunnel.SourceIP != localIP || tunnel.DestinationIP != remoteIP {
+			continue
+		}
+		if tunnel.HasKey == hasKey && (!hasKey || tunnel.Key == key) {
+			return tunnel
+		}
+	}
+	return nil
+}
+
+// IsTunnelAddress reports whether IP is the address of a tunnel interface.
+func (table *TunnelTable) IsTunnelAddress(IP IPAddress) bool {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	for dllTunnel := table.Tunnels.Next; dllTunnel != nil; dllTunnel = dllTunnel.Next {
+		intf := &dllTunnel.DllToTunnel().Interface
+		if intf.Properties.IsIpConfigured && intf.Properties.IP == IP {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNodeIntfOrTunnelByName returns the interface or the tunnel interface named intfName, for
+// configuration that applies to routed interfaces of both kinds.
+func (node *Node) GetNodeIntfOrTunnelByName(intfName string) *Interface {
+	if intf := node.GetNodeIntfByName(intfName); intf != nil {
+		return intf
+	}
+	table := node.Properties.Tunnels
+	if table == nil {
+		return nil
+	}
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	if tunnel := table.LookupTunnel(intfName); tunnel != nil {
+		return &tunnel.Interface
+	}
+	return nil
+}
+
+func (table *TunnelTable) matchingSubnetInterface(IP IPAddress) *Interface {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	for dllTunnel := table.Tunnels.Next; dllTunnel != nil; dllTunnel = dllTunnel.Next {
+		intf := &dllTunnel.DllToTunnel().Interface
+		if intf.Properties.IsIpConfigured && ApplyMask(IP, intf.Properties.Mask) == ApplyMask(intf.Properties.IP, intf.Properties.Mask) {
+			return intf
+		}
+	}
+	return nil
+}
+
+func tunnelModeString(mode uint8) string {
+	if mode == TunnelModeIPIP {
+		return "ipip"
+	}
+	return "gre"
+}
+
+func (table *TunnelTable) Print() {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	for dllTunnel := table.Tunnels.Next; dllTunnel != nil; dllTunnel = dllTunnel.Next {
+		tunnel := dllTunnel.DllToTunnel()
+		IP := "nil"
+		if tunnel.Interface.Properties.IsIpConfigured {
+			IP = fmt.Sprintf("%s/%d", tunnel.Interface.Properties.IP.String(), tunnel.Interface.Properties.Mask)
+		}
+		fmt.Printf("Tunnel: %s, Mode: %s, Source: %s, Destination: %s, IP: %s", tunnel.Interface.Name.String(),
+			tunnelModeString(tunnel.Mode), tunnel.SourceIP.String(), tunnel.DestinationIP.String(), IP)
+		if tunnel.HasKey {
+			fmt.Printf(", Key: %d", tunnel.Key)
+		}
+		fmt.Println()
+		fmt.Printf("    Packets out: %d, Bytes out: %d, Packets in: %d, Bytes in: %d, Drops: %d\n",
+			tunnel.PacketsOut, tunnel.BytesOut, tunnel.PacketsIn, tunnel.BytesIn, tunnel.Drops)
+	}
+}
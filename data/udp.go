@@ -0,0 +1,307 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const UDPHeaderSize = 8
+
+type UDPHeader struct {
+	SourcePort      uint16
+	DestinationPort uint16
+	Length          uint16
+	Checksum        uint16
+}
+
+type UDPHandler func(node *Node, iif *Interface, ipHeader IPHeader, udpHeader UDPHeader, appData []byte)
+
+// UDPStatistics counts datagrams the way the UDP MIB does, NoPorts are datagrams nobody listened to.
+type UDPStatistics struct {
+	InDatagrams  uint64
+	OutDatagrams uint64
+	NoPorts      uint64
+	InErrors     uint64
+}
+
+type UDPPortTable struct {
+	Handlers   map[uint16]UDPHandler
+	Sockets    map[uint16]*UDPSocket
+	Statistics UDPStatistics
+	Mutex      sync.Mutex
+}
+
+// UDPDatagram is a datagram queued on a socket.
+type UDPDatagram struct {
+	Interface       *Interface
+	SourceIP        IPAddress
+	SourcePort      uint16
+	DestinationIP   IPAddress
+	DestinationPort uint16
+	Data            []byte
+}
+
+// UDPSocket is a port bound by an application, received datagrams wait in Queue until Recv.
+type UDPSocket struct {
+	Node      *Node
+	LocalIP   IPAddress
+	LocalPort uint16
+	Queue     chan UDPDatagram
+	Dropped   uint64
+	IsClosed  bool
+	Mutex     sync.Mutex
+}
+
+// UDPOutput routes a datagram of a socket, the layers package installs it.
+var UDPOutput func(node *Node, sourceIP IPAddress, sourcePort uint16, destinationIP IPAddress, destinationPort uint16, appData []byte) error
+
+var (
+	ErrPortInUse    = errors.New("port already in use")
+	ErrNoFreePort   = errors.New("no free ephemeral port")
+	ErrSocketClosed = errors.New("socket closed")
+	ErrRecvTimeout  = errors.New("receive timed out")
+)
+
+func (header UDPHeader) SerializeUDPHeader() []byte {
+	data := make([]byte, UDPHeaderSize)
+	binary.BigEndian.PutUint16(data[0:2], header.SourcePort)
+	binary.BigEndian.PutUint16(data[2:4], header.DestinationPort)
+	binary.BigEndian.PutUint16(data[4:6], header.Length)
+	binary.BigEndian.PutUint16(data[6:8], header.Checksum)
+	return data
+}
+
+func DeserializeUDPHeader(data []byte) UDPHeader {
+	var header UDPHeader
+	header.SourcePort = binary.BigEndian.Uint16(data[0:2])
+	header.DestinationPort = binary.BigEndian.Uint16(data[2:4])
+	header.Length = binary.BigEndian.Uint16(data[4:6])
+	header.Checksum = binary.BigEndian.Uint16(data[6:8])
+	return header
+}
+
+// SerializeUDPDatagram encodes a datagram with its checksum over the IPv4 pseudo header, a computed
+// checksum of zero is sent as all ones since zero means no checksum.
+func SerializeUDPDatagram(sourceIP IPAddress, sourcePort uint16, destinationIP IPAddress, destinationPort uint16, appData []byte) []byte {
+	header := UDPHeader{
+		SourcePort:      sourcePort,
+		DestinationPort: destinationPort,
+		Length:          uint16(UDPHeaderSize + len(appData)),
+	}
+	data := append(header.SerializeUDPHeader(), appData...)
+	checksum := InternetChecksum(PseudoHeader(sourceIP, destinationIP, constants.UdpProto, len(data)), data)
+	if checksum == 0 {
+		checksum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(data[6:8], checksum)
+	return data
+}
+
+// VerifyUDPChecksum checks a datagram of header.Length bytes, datagrams sent without a checksum pass.
+func VerifyUDPChecksum(sourceIP IPAddress, destinationIP IPAddress, data []byte) bool {
+	if binary.BigEndian.Uint16(data[6:8]) == 0 {
+		return true
+	}
+	return InternetChecksum(PseudoHeader(sourceIP, destinationIP, constants.UdpProto, len(data)), data) == 0
+}
+
+func (portTable *UDPPortTable) Register(port uint16, handler UDPHandler) {
+	portTable.Mutex.Lock()
+	defer portTable.Mutex.Unlock()
+
+	if portTable.Handlers == nil {
+		portTable.Handlers = make(map[uint16]UDPHandler)
+	}
+	portTable.Handlers[port] = handler
+}
+
+func (portTable *UDPPortTable) Unregister(port uint16) {
+	portTable.Mutex.Lock()
+	defer portTable.Mutex.Unlock()
+
+	delete(portTable.Handlers, port)
+	delete(portTable.Sockets, port)
+}
+
+func (portTable *UDPPortTable) Lookup(port uint16) UDPHandler {
+	portTable.Mutex.Lock()
+	defer portTable.Mutex.Unlock()
+
+	return portTable.Handlers[port]
+}
+
+func (portTable *UDPPortTable) LookupSocket(port uint16) *UDPSocket {
+	portTable.Mutex.Lock()
+	defer portTable.Mutex.Unlock()
+
+	return portTable.Sockets[port]
+}
+
+func (portTable *UDPPortTable) CountIn() {
+	atomic.AddUint64(&portTable.Statistics.InDatagrams, 1)
+}
+
+func (portTable *UDPPortTable) CountOut() {
+	atomic.AddUint64(&portTable.Statistics.OutDatagrams, 1)
+}
+
+func (portTable *UDPPortTable) CountNoPort() {
+	atomic.AddUint64(&portTable.Statistics.NoPorts, 1)
+}
+
+func (portTable *UDPPortTable) CountError() {
+	atomic.AddUint64(&portTable.Statistics.InErrors, 1)
+}
+
+// BindUDP opens a socket on port of the local address IP, an unspecified IP accepts datagrams to
+// any address of the node and port zero picks a free ephemeral port.
+func (node *Node) BindUDP(IP IPAddress, port uint16) (*UDPSocket, error) {
+	portTable := node.Properties.UDPPorts
+	portTable.Mutex.Lock()
+	defer portTable.Mutex.Unlock()
+
+	if portTable.Handlers == nil {
+		portTable.Handlers = make(map[uint16]UDPHandler)
+	}
+	if portTable.Sockets == nil {
+		portTable.Sockets = make(map[uint16]*UDPSocket)
+	}
+	if port == 0 {
+		for candidate := constants.UdpEphemeralPortStart; candidate != 0; candidate++ {
+			if portTable.Handlers[candidate] == nil {
+				port = candidate
+				break
+			}
+		}
+		if port == 0 {
+			return nil, ErrNoFreePort
+		}
+	} else if portTable.Handlers[port] != nil {
+		return nil, ErrPortInUse
+	}
+
+	socket := &UDPSocket{
+		Node:      node,
+		LocalIP:   IP,
+		LocalPort: port,
+		Queue:     make(chan UDPDatagram, constants.UdpSocketQueueSize),
+	}
+	portTable.Handlers[port] = socket.enqueue
+	portTable.Sockets[port] = socket
+	return socket, nil
+}
+
+func (socket *UDPSocket) enqueue(node *Node, iif *Interface, ipHeader IPHeader, udpHeader UDPHeader, appData []byte) {
+	if !socket.LocalIP.IsUnspecified() && ipHeader.DestinationIP != socket.LocalIP {
+		return
+	}
+	datagram := UDPDatagram{
+		Interface:       iif,
+		SourceIP:        ipHeader.SourceIP,
+		SourcePort:      udpHeader.SourcePort,
+		DestinationIP:   ipHeader.DestinationIP,
+		DestinationPort: udpHeader.DestinationPort,
+		Data:            append([]byte(nil), appData...),
+	}
+	socket.Mutex.Lock()
+	defer socket.Mutex.Unlock()
+
+	if socket.IsClosed {
+		return
+	}
+	select {
+	case socket.Queue <- datagram:
+	default:
+		socket.Dropped++
+	}
+}
+
+// SendTo sends appData from the local address and port of the socket.
+func (socket *UDPSocket) SendTo(IP IPAddress, port uint16, appData []byte) error {
+	socket.Mutex.Lock()
+	isClosed := socket.IsClosed
+	socket.Mutex.Unlock()
+
+	if isClosed {
+		return ErrSocketClosed
+	}
+	if len(appData) > constants.MaxPayloadSize-int(unsafe.Sizeof(IPHeader{}))-UDPHeaderSize {
+		return errors.New("datagram too large")
+	}
+	return UDPOutput(socket.Node, socket.LocalIP, socket.LocalPort, IP, port, appData)
+}
+
+// Recv waits for the next datagram, a timeout of zero waits until one arrives or the socket closes.
+func (socket *UDPSocket) Recv(timeout time.Duration) (UDPDatagram, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case datagram, ok := <-socket.Queue:
+		if !ok {
+			return UDPDatagram{}, ErrSocketClosed
+		}
+		return datagram, nil
+	case <-expired:
+		return UDPDatagram{}, ErrRecvTimeout
+	}
+}
+
+// Close releases the port, pending and future Recv calls return ErrSocketClosed.
+func (socket *UDPSocket) Close() {
+	socket.Mutex.Lock()
+	if socket.IsClosed {
+		socket.Mutex.Unlock()
+		return
+	}
+	socket.IsClosed = true
+	close(socket.Queue)
+	socket.Mutex.Unlock()
+
+	portTable := socket.Node.Properties.UDPPorts
+	portTable.Mutex.Lock()
+	defer portTable.Mutex.Unlock()
+
+	if portTable.Sockets[socket.LocalPort] == socket {
+		delete(portTable.Handlers, socket.LocalPort)
+		delete(portTable.Sockets, socket.LocalPort)
+	}
+}
+
+func (portTable *UDPPortTable) Print() {
+	portTable.Mutex.Lock()
+	defer portTable.Mutex.Unlock()
+
+	ports := make([]int, 0, len(portTable.Handlers))
+	for port := range portTable.Handlers {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		if socket := portTable.Sockets[uint16(port)]; socket != nil {
+			local := "*"
+			if !socket.LocalIP.IsUnspecified() {
+				local = socket.LocalIP.String()
+			}
+			socket.Mutex.Lock()
+			fmt.Printf("Port: %d, Owner: socket, Local IP: %s, Queued: %d, Dropped: %d\n",
+				port, local, len(socket.Queue), socket.Dropped)
+			socket.Mutex.Unlock()
+		} else {
+			fmt.Printf("Port: %d, Owner: protocol\n", port)
+		}
+	}
+	fmt.Printf("In Datagrams: %d, Out Datagrams: %d, No Ports: %d, In Errors: %d\n",
+		atomic.LoadUint64(&portTable.Statistics.InDatagrams), atomic.LoadUint64(&portTable.Statistics.OutDatagrams),
+		atomic.LoadUint64(&portTable.Statistics.NoPorts), atomic.LoadUint64(&portTable.Statistics.InErrors))
+}
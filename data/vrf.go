@@ -0,0 +1,132 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Vrf is a routing instance of the node. The interfaces assigned to it route IPv4 and IPv6 with the
+// FIB, RIB and ARP table of the VRF and never exchange packets with the interfaces of other VRFs,
+// the same subnet can be configured in several VRFs. Interfaces outside any VRF use the tables of
+// the node.
+type Vrf struct {
+	Name         string
+	RoutingTable *Layer3RouteTable
+	Rib          *Layer3Rib
+	ArpTable     *ArpTable
+	VrfGlue      Dll
+}
+
+type VrfTable struct {
+	Vrfs  Dll
+	Mutex sync.Mutex
+}
+
+var ErrVrfNotFound = errors.New("VRF not found")
+
+func (dll *Dll) DllToVrf() *Vrf {
+	return (*Vrf)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(Vrf{}.VrfGlue)))
+}
+
+// NewVrf creates a VRF with empty tables.
+func NewVrf(name string) *Vrf {
+	vrf := &Vrf{
+		Name:         name,
+		RoutingTable: &Layer3RouteTable{},
+		ArpTable: &ArpTable{
+			ArpEntries: Dll{},
+		},
+	}
+	vrf.Rib = &Layer3Rib{
+		Candidates: Dll{},
+		Fib:        vrf.RoutingTable,
+	}
+	(&vrf.ArpTable.ArpEntries).Init()
+	(&vrf.Rib.Candidates).Init()
+	return vrf
+}
+
+// String returns the name of the VRF, the nil VRF is the default VRF of the node.
+func (vrf *Vrf) String() string {
+	if vrf == nil {
+		return "default"
+	}
+	return vrf.Name
+}
+
+func (table *VrfTable) LookupVrf(name string) *Vrf {
+	for dllVrf := table.Vrfs.Next; dllVrf != nil; dllVrf = dllVrf.Next {
+		vrf := dllVrf.DllToVrf()
+		if vrf.Name == name {
+			return vrf
+		}
+	}
+	return nil
+}
+
+func (table *VrfTable) AddVrf(vrf *Vrf) {
+	(&vrf.VrfGlue).Init()
+	(&table.Vrfs).AddNode(&vrf.VrfGlue)
+}
+
+// LookupVrf returns the VRF name of the node, or nil when the node has no such VRF.
+func (node *Node) LookupVrf(name string) *Vrf {
+	table := node.Properties.Vrfs
+	if table == nil {
+		return nil
+	}
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	return table.LookupVrf(name)
+}
+
+// VrfRoutingTable returns the FIB of vrf, the nil VRF routes with the FIB of the node.
+func (node *Node) VrfRoutingTable(vrf *Vrf) *Layer3RouteTable {
+	if vrf == nil {
+		return node.Properties.RoutingTable
+	}
+	return vrf.RoutingTable
+}
+
+// VrfRib returns the RIB of vrf, the nil VRF routes with the RIB of the node.
+func (node *Node) VrfRib(vrf *Vrf) *Layer3Rib {
+	if vrf == nil {
+		return node.Properties.Rib
+	}
+	return vrf.Rib
+}
+
+// VrfArpTable returns the ARP table of vrf, the nil VRF resolves with the ARP table of the node.
+func (node *Node) VrfArpTable(vrf *Vrf) *ArpTable {
+	if vrf == nil {
+		return node.Properties.ArpTable
+	}
+	return vrf.ArpTable
+}
+
+// Print lists the VRFs of the node with their interfaces.
+func (table *VrfTable) Print(node *Node) {
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	var lines []string
+	for dllVrf := table.Vrfs.Next; dllVrf != nil; dllVrf = dllVrf.Next {
+		vrf := dllVrf.DllToVrf()
+		var interfaces []string
+		for _, intf := range node.Interfaces {
+			if intf != nil && intf.Properties.Vrf == vrf {
+				interfaces = append(interfaces, intf.Name.String())
+			}
+		}
+		sort.Strings(interfaces)
+		lines = append(lines, fmt.Sprintf("VRF: %s, Interfaces: %s", vrf.Name, strings.Join(interfaces, ", ")))
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
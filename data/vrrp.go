@@ -0,0 +1,182 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"tcpip/constants"
+	"time"
+	"unsafe"
+)
+
+const vrrpHeaderSize = 8
+
+const (
+	VrrpStateInitialize uint8 = iota
+	VrrpStateBackup
+	VrrpStateMaster
+)
+
+type VrrpAdvertisement struct {
+	Version           uint8
+	Type              uint8
+	VRID              uint8
+	Priority          uint8
+	MaxAdvertInterval uint16
+	VirtualIPs        []IPAddress
+}
+
+type VrrpGroup struct {
+	Interface         *Interface
+	VRID              uint8
+	VirtualIP         IPAddress
+	Priority          uint8
+	Preempt           bool
+	State             uint8
+	MasterIP          IPAddress
+	MasterDownAt      time.Time
+	NextAdvertisement time.Time
+	GroupGlue         Dll
+}
+
+type VrrpInstance struct {
+	Groups Dll
+	Mutex  sync.Mutex
+}
+
+func (dll *Dll) DllToVrrpGroup() *VrrpGroup {
+	return (*VrrpGroup)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(VrrpGroup{}.GroupGlue)))
+}
+
+// SerializeVrrpAdvertisement encodes the advertisement, the checksum covers the IPv4 pseudo header of the packet.
+func (advertisement VrrpAdvertisement) SerializeVrrpAdvertisement(sourceIP IPAddress, destinationIP IPAddress) []byte {
+	data := make([]byte, vrrpHeaderSize+4*len(advertisement.VirtualIPs))
+	data[0] = advertisement.Version<<4 | advertisement.Type&0x0F
+	data[1] = advertisement.VRID
+	data[2] = advertisement.Priority
+	data[3] = uint8(len(advertisement.VirtualIPs))
+	binary.BigEndian.PutUint16(data[4:6], advertisement.MaxAdvertInterval&0x0FFF)
+	for i, IP := range advertisement.VirtualIPs {
+		copy(data[vrrpHeaderSize+4*i:], net.IP(IP[:]).To4())
+	}
+	checksum := InternetChecksum(PseudoHeader(sourceIP, destinationIP, constants.VrrpProto, len(data)), data)
+	binary.BigEndian.PutUint16(data[6:8], checksum)
+	return data
+}
+
+func DeserializeVrrpAdvertisement(data []byte, sourceIP IPAddress, destinationIP IPAddress) (*VrrpAdvertisement, error) {
+	if len(data) < vrrpHeaderSize {
+		return nil, errors.New("invalid VRRP advertisement length")
+	}
+	length := vrrpHeaderSize + 4*int(data[3])
+	if len(data) < length {
+		return nil, errors.New("invalid VRRP address count")
+	}
+	data = data[:length]
+	if InternetChecksum(PseudoHeader(sourceIP, destinationIP, constants.VrrpProto, length), data) != 0 {
+		return nil, errors.New("invalid VRRP checksum")
+	}
+
+	advertisement := &VrrpAdvertisement{
+		Version:           data[0] >> 4,
+		Type:              data[0] & 0x0F,
+		VRID:              data[1],
+		Priority:          data[2],
+		MaxAdvertInterval: binary.BigEndian.Uint16(data[4:6]) & 0x0FFF,
+	}
+	for offset := vrrpHeaderSize; offset < length; offset += 4 {
+		var IP IPAddress
+		copy(IP[:], net.IP(data[offset:offset+4]).To16())
+		advertisement.VirtualIPs = append(advertisement.VirtualIPs, IP)
+	}
+	return advertisement, nil
+}
+
+// VirtualMAC returns the 00:00:5e:00:01:<VRID> address owned by the master of the group.
+func (group *VrrpGroup) VirtualMAC() MacAddress {
+	return MacAddress{0x00, 0x00, 0x5E, 0x00, 0x01, group.VRID}
+}
+
+// IsOwner reports a group whose virtual IP is the address of its interface.
+func (group *VrrpGroup) IsOwner() bool {
+	return group.VirtualIP == group.Interface.Properties.IP
+}
+
+// SkewTime delays the take over of a backup by its priority, higher priority backups take over first.
+func (group *VrrpGroup) SkewTime() time.Duration {
+	interval := time.Duration(constants.VrrpAdvertisementSeconds) * time.Second
+	return interval * time.Duration(256-int(group.Priority)) / 256
+}
+
+// MasterDownInterval is the time a backup waits without advertisements before it becomes master.
+func (group *VrrpGroup) MasterDownInterval() time.Duration {
+	return 3*time.Duration(constants.VrrpAdvertisementSeconds)*time.Second + group.SkewTime()
+}
+
+func (vrrp *VrrpInstance) LookupGroup(intf *Interface, VRID uint8) *VrrpGroup {
+	for dllGroup := vrrp.Groups.Next; dllGroup != nil; dllGroup = dllGroup.Next {
+		group := dllGroup.DllToVrrpGroup()
+		if group.Interface == intf && group.VRID == VRID {
+			return group
+		}
+	}
+	return nil
+}
+
+// LookupMasterByIP returns the group of intf that is master for the virtual IP.
+func (vrrp *VrrpInstance) LookupMasterByIP(intf *Interface, IP IPAddress) *VrrpGroup {
+	for dllGroup := vrrp.Groups.Next; dllGroup != nil; dllGroup = dllGroup.Next {
+		group := dllGroup.DllToVrrpGroup()
+		if group.State == VrrpStateMaster && group.VirtualIP == IP && (intf == nil || group.Interface == intf) {
+			return group
+		}
+	}
+	return nil
+}
+
+// LookupMasterByMAC returns the group of intf that is master for the virtual MAC.
+func (vrrp *VrrpInstance) LookupMasterByMAC(intf *Interface, MAC MacAddress) *VrrpGroup {
+	for dllGroup := vrrp.Groups.Next; dllGroup != nil; dllGroup = dllGroup.Next {
+		group := dllGroup.DllToVrrpGroup()
+		if group.State == VrrpStateMaster && group.Interface == intf && group.VirtualMAC() == MAC {
+			return group
+		}
+	}
+	return nil
+}
+
+func (vrrp *VrrpInstance) AddGroup(group *VrrpGroup) {
+	(&group.GroupGlue).Init()
+	(&vrrp.Groups).AddNode(&group.GroupGlue)
+}
+
+func vrrpStateString(state uint8) string {
+	switch state {
+	case VrrpStateBackup:
+		return "Backup"
+	case VrrpStateMaster:
+		return "Master"
+	default:
+		return "Initialize"
+	}
+}
+
+func (vrrp *VrrpInstance) Print() {
+	vrrp.Mutex.Lock()
+	defer vrrp.Mutex.Unlock()
+
+	for dllGroup := vrrp.Groups.Next; dllGroup != nil; dllGroup = dllGroup.Next {
+		group := dllGroup.DllToVrrpGroup()
+		master := "unknown"
+		if group.State == VrrpStateMaster {
+			master = "local"
+		} else if group.MasterIP != (IPAddress{}) {
+			master = group.MasterIP.String()
+		}
+		fmt.Printf("Interface Name: %s, VRID: %d, Virtual IP: %s, Virtual MAC: %s, Priority: %d, Preempt: %v, State: %s, Master: %s\n",
+			group.Interface.Name.String(), group.VRID, group.VirtualIP.String(), group.VirtualMAC().String(),
+			group.Priority, group.Preempt, vrrpStateString(group.State), master)
+	}
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"tcpip/constants"
+	"unsafe"
+)
+
+// VxlanHeader is the VXLAN header (RFC 7348), the flags mark the VNI as valid.
+type VxlanHeader struct {
+	Flags uint8
+	Vni   uint32
+}
+
+// VxlanVni stretches Vlan of the switch to the remote VTEPs of FloodList, broadcast and unknown
+// unicast frames of the VLAN are replicated to every one of them.
+type VxlanVni struct {
+	Vni       uint32
+	Vlan      uint
+	FloodList []IPAddress
+	FramesOut uint64
+	FramesIn  uint64
+	VniGlue   Dll
+}
+
+// VxlanInstance is the VTEP of a switch, it encapsulates frames from the address of
+// SourceInterface or from the loopback address of the node.
+type VxlanInstance struct {
+	SourceInterface  *Interface
+	IsSourceLoopback bool
+	Vnis             Dll
+	Mutex            sync.Mutex
+}
+
+func (dll *Dll) DllToVxlanVni() *VxlanVni {
+	return (*VxlanVni)(unsafe.Pointer(uintptr(unsafe.Pointer(dll)) - unsafe.Offsetof(VxlanVni{}.VniGlue)))
+}
+
+func (header VxlanHeader) SerializeVxlanHeader() []byte {
+	data := make([]byte, constants.VxlanHeaderSize)
+	data[0] = header.Flags
+	binary.BigEndian.PutUint32(data[4:8], header.Vni<<8)
+	return data
+}
+
+func DeserializeVxlanHeader(data []byte) (VxlanHeader, error) {
+	if len(data) < constants.VxlanHeaderSize {
+		return VxlanHeader{}, errors.New("invalid VXLAN header length")
+	}
+	return VxlanHeader{
+		Flags: data[0],
+		Vni:   binary.BigEndian.Uint32(data[4:8]) >> 8,
+	}, nil
+}
+
+func (vxlan *VxlanInstance) AddVni(vni *VxlanVni) {
+	(&vni.VniGlue).Init()
+	(&vxlan.Vnis).AddNode(&vni.VniGlue)
+}
+
+func (vxlan *VxlanInstance) LookupVni(vni uint32) *VxlanVni {
+	for dllVni := vxlan.Vnis.Next; dllVni != nil; dllVni = dllVni.Next {
+		entry := dllVni.DllToVxlanVni()
+		if entry.Vni == vni {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (vxlan *VxlanInstance) LookupVniByVlan(vlan uint) *VxlanVni {
+	for dllVni := vxlan.Vnis.Next; dllVni != nil; dllVni = dllVni.Next {
+		entry := dllVni.DllToVxlanVni()
+		if entry.Vlan == vlan {
+			return entry
+		}
+	}
+	return nil
+}
+
+func (vxlan *VxlanInstance) Print(node *Node) {
+	vxlan.Mutex.Lock()
+	defer vxlan.Mutex.Unlock()
+
+	switch {
+	case vxlan.IsSourceLoopback:
+		fmt.Printf("VTEP source: loopback %s\n", node.Properties.LB.String())
+	case vxlan.SourceInterface != nil:
+		fmt.Printf("VTEP source: %s %s\n", vxlan.SourceInterface.Name.String(), vxlan.SourceInterface.Properties.IP.String())
+	default:
+		fmt.Println("VTEP source: nil")
+	}
+	for dllVni := vxlan.Vnis.Next; dllVni != nil; dllVni = dllVni.Next {
+		entry := dllVni.DllToVxlanVni()
+		var peers []string
+		for _, IP := range entry.FloodList {
+			peers = append(peers, IP.String())
+		}
+		fmt.Printf("VNI: %d, VLAN: %d, Flood list: [%s], Frames out: %d, Frames in: %d\n",
+			entry.Vni, entry.Vlan, strings.Join(peers, " "), entry.FramesOut, entry.FramesIn)
+	}
+}
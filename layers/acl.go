@@ -0,0 +1,187 @@
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+	"unsafe"
+)
+
+// getACLInstance returns the access lists of the node, the first access list creates them.
+func getACLInstance(node *data.Node) *data.AclInstance {
+	if node.Properties.Acl == nil {
+		acl := &data.AclInstance{
+			Lists:    data.Dll{},
+			Inbound:  map[*data.Interface]*data.AccessList{},
+			Outbound: map[*data.Interface]*data.AccessList{},
+		}
+		(&acl.Lists).Init()
+		node.Properties.Acl = acl
+	}
+	return node.Properties.Acl
+}
+
+// AddACLRule adds a rule to the access list name, creating the list if needed. The prefixes of the
+// rule are masked, a rule without sequence number goes after the last rule of the list.
+func AddACLRule(node *data.Node, name string, rule data.AclRule) error {
+	if !rule.SourceIP.IsIPv4() || !rule.DestinationIP.IsIPv4() {
+		return errors.New("access lists match IPv4 addresses only")
+	}
+	rule.SourceIP = data.ApplyMask(rule.SourceIP, rule.SourceMask)
+	rule.DestinationIP = data.ApplyMask(rule.DestinationIP, rule.DestinationMask)
+	rule.Hits = 0
+
+	acl := getACLInstance(node)
+	acl.Mutex.Lock()
+	defer acl.Mutex.Unlock()
+
+	list := acl.LookupList(name)
+	if list == nil {
+		list = &data.AccessList{
+			Name: name,
+		}
+		acl.AddList(list)
+	}
+	return list.AddRule(&rule)
+}
+
+// DeleteACLRule removes the rule with the sequence number from the access list name.
+func DeleteACLRule(node *data.Node, name string, sequence int) error {
+	acl := node.Properties.Acl
+	if acl == nil {
+		return data.ErrAclNotFound
+	}
+	acl.Mutex.Lock()
+	defer acl.Mutex.Unlock()
+
+	list := acl.LookupList(name)
+	if list == nil {
+		return data.ErrAclNotFound
+	}
+	if !list.DeleteRule(sequence) {
+		return errors.New("rule not found")
+	}
+	return nil
+}
+
+// DeleteACL removes the access list name, it must not be applied to an interface.
+func DeleteACL(node *data.Node, name string) error {
+	acl := node.Properties.Acl
+	if acl == nil {
+		return data.ErrAclNotFound
+	}
+	acl.Mutex.Lock()
+	defer acl.Mutex.Unlock()
+
+	list := acl.LookupList(name)
+	if list == nil {
+		return data.ErrAclNotFound
+	}
+	for _, bindings := range []map[*data.Interface]*data.AccessList{acl.Inbound, acl.Outbound} {
+		for intf, boundList := range bindings {
+			if boundList == list {
+				return fmt.Errorf("access list is applied to interface %s", intf.Name.String())
+			}
+		}
+	}
+	(&list.ListGlue).RemoveNode()
+	return nil
+}
+
+// ApplyACL filters the packets received on the interface (AclDirectionIn) or routed out of it
+// (AclDirectionOut) with the access list name, replacing the list applied before.
+func ApplyACL(node *data.Node, intfName string, name string, direction uint8) error {
+	intf := node.GetNodeIntfOrTunnelByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	acl := getACLInstance(node)
+	acl.Mutex.Lock()
+	defer acl.Mutex.Unlock()
+
+	list := acl.LookupList(name)
+	if list == nil {
+		return data.ErrAclNotFound
+	}
+	if direction == constants.AclDirectionIn {
+		acl.Inbound[intf] = list
+	} else {
+		acl.Outbound[intf] = list
+	}
+	return nil
+}
+
+// RemoveACL stops filtering the interface in the direction.
+func RemoveACL(node *data.Node, intfName string, direction uint8) error {
+	intf := node.GetNodeIntfOrTunnelByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	acl := node.Properties.Acl
+	if acl == nil {
+		return data.ErrAclNotFound
+	}
+	acl.Mutex.Lock()
+	defer acl.Mutex.Unlock()
+
+	bindings := acl.Inbound
+	if direction == constants.AclDirectionOut {
+		bindings = acl.Outbound
+	}
+	if bindings[intf] == nil {
+		return errors.New("no access list applied to interface")
+	}
+	delete(bindings, intf)
+	return nil
+}
+
+// aclPacket extracts the fields access lists match on from an IPv4 packet.
+func aclPacket(ipHeader data.IPHeader, payload *data.Payload) data.AclPacket {
+	packet := data.AclPacket{
+		Protocol:      ipHeader.Protocol,
+		SourceIP:      ipHeader.SourceIP,
+		DestinationIP: ipHeader.DestinationIP,
+	}
+	l4 := payload[unsafe.Sizeof(data.IPHeader{}):]
+	switch ipHeader.Protocol {
+	case constants.TcpProto, constants.UdpProto:
+		packet.HasPorts = true
+		packet.SourcePort = binary.BigEndian.Uint16(l4[0:2])
+		packet.DestinationPort = binary.BigEndian.Uint16(l4[2:4])
+	case constants.IcmpProto:
+		packet.HasIcmpType = true
+		packet.IcmpType = l4[0]
+	}
+	return packet
+}
+
+// aclPermits evaluates the access list applied to the interface in the direction, packets pass
+// interfaces without access list.
+func aclPermits(node *data.Node, intf *data.Interface, direction uint8, ipHeader data.IPHeader, payload *data.Payload) bool {
+	acl := node.Properties.Acl
+	if acl == nil || intf == nil {
+		return true
+	}
+	acl.Mutex.Lock()
+	defer acl.Mutex.Unlock()
+
+	list := acl.Inbound[intf]
+	if direction == constants.AclDirectionOut {
+		list = acl.Outbound[intf]
+	}
+	if list == nil {
+		return true
+	}
+	packet := aclPacket(ipHeader, payload)
+	rule := list.Evaluate(packet)
+	if rule != nil && rule.IsLog {
+		action := "denied"
+		if rule.IsPermit {
+			action = "permitted"
+		}
+		fmt.Println("ACL", list.Name, "rule", rule.Sequence, action, packet.String(), "on interface", intf.Name.String(), "of node", node.NodeName)
+	}
+	return rule != nil && rule.IsPermit
+}
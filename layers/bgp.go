@@ -0,0 +1,488 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+)
+
+// BGP sessions are carried in UDP datagrams on port 179 between the session addresses, the
+// hold timer takes the place of the TCP connection to detect a lost peer. Nothing retransmits a
+// lost UPDATE, instead every speaker periodically resends its full table to each peer followed by
+// an End-of-RIB marker, an empty UPDATE. The peer withdraws the paths missing from that table.
+
+// ConfigureBGP starts the BGP speaker of the node in the given AS, the loopback address is its router ID.
+func ConfigureBGP(node *data.Node, AS uint16) error {
+	if AS == 0 {
+		return errors.New("invalid AS number")
+	}
+	if !node.Properties.IsLbConfigured {
+		return errors.New("node has no loopback address to use as router ID")
+	}
+	if node.Properties.Bgp != nil {
+		if node.Properties.Bgp.AS != AS {
+			return fmt.Errorf("BGP is already running in AS %d", node.Properties.Bgp.AS)
+		}
+		return nil
+	}
+
+	bgp := &data.BgpInstance{
+		AS:       AS,
+		RouterID: node.Properties.LB,
+		Peers:    data.Dll{},
+		Paths:    data.Dll{},
+	}
+	(&bgp.Peers).Init()
+	(&bgp.Paths).Init()
+	node.Properties.Bgp = bgp
+	node.Properties.UDPPorts.Register(constants.BgpPort, processBGPMessage)
+	go bgpTimer(node)
+	return nil
+}
+
+// AddBGPPeer configures a peer, peers on a connected subnet use the address of that interface
+// as session address, all others the loopback address.
+func AddBGPPeer(node *data.Node, peerIP data.IPAddress, remoteAS uint16, nextHopSelf bool, localPref uint32) error {
+	bgp := node.Properties.Bgp
+	if bgp == nil {
+		return errors.New("BGP is not configured on node")
+	}
+	if remoteAS == 0 {
+		return errors.New("invalid AS number")
+	}
+
+	bgp.Mutex.Lock()
+	defer bgp.Mutex.Unlock()
+
+	peer := bgp.LookupPeer(peerIP)
+	if peer == nil {
+		peer = &data.BgpPeer{
+			IP:               peerIP,
+			NextConnectRetry: time.Now(),
+		}
+		(&peer.PeerGlue).Init()
+		(&bgp.Peers).AddNode(&peer.PeerGlue)
+	} else if peer.RemoteAS != remoteAS {
+		bgpResetPeer(node, peer, true)
+	}
+
+	peer.RemoteAS = remoteAS
+	peer.NextHopSelf = nextHopSelf
+	peer.LocalPref = localPref
+	peer.LocalIP = node.Properties.LB
+	if intf := node.GetMatchingSubnetInterface(peerIP); intf != nil {
+		peer.LocalIP = intf.Properties.IP
+	}
+	return nil
+}
+
+// AddBGPNetwork originates the prefix into BGP.
+func AddBGPNetwork(node *data.Node, IP data.IPAddress, mask rune, med uint32, hasMed bool) error {
+	bgp := node.Properties.Bgp
+	if bgp == nil {
+		return errors.New("BGP is not configured on node")
+	}
+	if err := data.ValidateRoutePrefix(IP, mask); err != nil {
+		return err
+	}
+
+	bgp.Mutex.Lock()
+	defer bgp.Mutex.Unlock()
+
+	prefix := data.ApplyMask(IP, mask)
+	path := bgp.LookupPath(prefix, mask, nil)
+	if path == nil {
+		path = &data.BgpPath{Prefix: prefix, Mask: mask}
+		bgp.AddPath(path)
+	}
+	path.Attributes = data.BgpPathAttributes{Med: med, HasMed: hasMed}
+	bgpDecide(node, prefix, mask, true)
+	return nil
+}
+
+// AddBGPPrefixFilter appends an entry to the inbound or outbound prefix filter of the peer, the
+// session is reset so that the filter applies to all prefixes exchanged so far.
+func AddBGPPrefixFilter(node *data.Node, peerIP data.IPAddress, isInbound bool, entry data.BgpPrefixFilterEntry) error {
+	bgp := node.Properties.Bgp
+	if bgp == nil {
+		return errors.New("BGP is not configured on node")
+	}
+
+	bgp.Mutex.Lock()
+	defer bgp.Mutex.Unlock()
+
+	peer := bgp.LookupPeer(peerIP)
+	if peer == nil {
+		return errors.New("BGP peer not found")
+	}
+	entry.IP = data.ApplyMask(entry.IP, entry.Mask)
+	if isInbound {
+		peer.ImportFilter.Entries = append(peer.ImportFilter.Entries, entry)
+	} else {
+		peer.ExportFilter.Entries = append(peer.ExportFilter.Entries, entry)
+	}
+	bgpResetPeer(node, peer, true)
+	return nil
+}
+
+func sendBGPMessage(node *data.Node, peer *data.BgpPeer, message data.BgpMessage) {
+	UDPSend(node, peer.LocalIP, constants.BgpPort, peer.IP, constants.BgpPort, message.SerializeBgpMessage())
+}
+
+func sendBGPOpen(node *data.Node, peer *data.BgpPeer) {
+	bgp := node.Properties.Bgp
+	sendBGPMessage(node, peer, data.BgpMessage{
+		Type: constants.BgpMessageOpen,
+		Open: &data.BgpOpen{
+			AS:       bgp.AS,
+			HoldTime: uint16(constants.BgpHoldTimeSeconds),
+			RouterID: bgp.RouterID,
+		},
+	})
+}
+
+func sendBGPEndOfRib(node *data.Node, peer *data.BgpPeer) {
+	sendBGPMessage(node, peer, data.BgpMessage{
+		Type:   constants.BgpMessageUpdate,
+		Update: &data.BgpUpdate{},
+	})
+}
+
+func sendBGPKeepalive(node *data.Node, peer *data.BgpPeer) {
+	sendBGPMessage(node, peer, data.BgpMessage{Type: constants.BgpMessageKeepalive})
+	peer.NextKeepalive = time.Now().Add(time.Duration(constants.BgpKeepaliveSeconds) * time.Second)
+}
+
+// bgpResetPeer tears the session down and withdraws everything learned from the peer.
+func bgpResetPeer(node *data.Node, peer *data.BgpPeer, sendNotification bool) {
+	bgp := node.Properties.Bgp
+	if sendNotification && peer.State != data.BgpStateIdle {
+		sendBGPMessage(node, peer, data.BgpMessage{Type: constants.BgpMessageNotification})
+	}
+	peer.State = data.BgpStateIdle
+	peer.NextConnectRetry = time.Now().Add(time.Duration(constants.BgpConnectRetrySeconds) * time.Second)
+
+	var prefixes []data.BgpPrefix
+	for dllPath := bgp.Paths.Next; dllPath != nil; {
+		path := dllPath.DllToBgpPath()
+		dllPath = dllPath.Next
+		if path.Peer == peer {
+			(&path.PathGlue).RemoveNode()
+			prefixes = append(prefixes, data.BgpPrefix{IP: path.Prefix, Mask: path.Mask})
+		}
+	}
+	for _, prefix := range prefixes {
+		bgpDecide(node, prefix.IP, prefix.Mask, true)
+	}
+}
+
+// bgpIsNextHopReachable accepts next hops resolved by any route not learned through BGP itself.
+func bgpIsNextHopReachable(node *data.Node, nextHop data.IPAddress) bool {
+	route := node.Properties.RoutingTable.LookupRoutingTableLPM(nextHop)
+	return route != nil && !route.IsBlackhole && route.Source != constants.RouteSourceEBGP && route.Source != constants.RouteSourceIBGP
+}
+
+// bgpDecide reruns best path selection for the prefix, installs the winner in the RIB and
+// advertises it when it changed or when forced because its attributes changed or a path was
+// removed, a removed best path no longer shows as the old best.
+func bgpDecide(node *data.Node, IP data.IPAddress, mask rune, force bool) {
+	bgp := node.Properties.Bgp
+
+	var oldBest *data.BgpPath
+	for dllPath := bgp.Paths.Next; dllPath != nil; dllPath = dllPath.Next {
+		path := dllPath.DllToBgpPath()
+		if path.IsBest && path.Mask == mask && path.Prefix == IP {
+			oldBest = path
+		}
+	}
+	best := bgp.SelectBestPath(IP, mask, func(nextHop data.IPAddress) bool {
+		return bgpIsNextHopReachable(node, nextHop)
+	})
+	if best == oldBest && !force {
+		return
+	}
+
+	rib := node.Properties.Rib
+	if best == nil || best.Peer == nil {
+		rib.DeleteRoute(IP, mask, constants.RouteSourceEBGP)
+		rib.DeleteRoute(IP, mask, constants.RouteSourceIBGP)
+	} else {
+		source, otherSource := constants.RouteSourceEBGP, constants.RouteSourceIBGP
+		if best.Peer.IsIBGP(bgp) {
+			source, otherSource = otherSource, source
+		}
+		nextHops := []data.Layer3NextHop{{GatewayIP: best.Attributes.NextHop, Weight: 1}}
+		if err := rib.ReplaceRoute(IP, mask, source, best.Attributes.Med, nextHops); err != nil {
+			fmt.Println("BGP: failed to install route", IP.String(), "on node", node.NodeName, ":", err)
+		}
+		rib.DeleteRoute(IP, mask, otherSource)
+	}
+
+	for dllPeer := bgp.Peers.Next; dllPeer != nil; dllPeer = dllPeer.Next {
+		bgpAdvertise(node, dllPeer.DllToBgpPeer(), IP, mask, best)
+	}
+}
+
+// bgpAdvertise sends the best path to the peer, or withdraws the prefix when the path may not be
+// advertised to it: paths are never sent back to the peer they came from, iBGP learned paths
+// are not passed on to iBGP peers and the outbound filter applies.
+func bgpAdvertise(node *data.Node, peer *data.BgpPeer, IP data.IPAddress, mask rune, best *data.BgpPath) {
+	bgp := node.Properties.Bgp
+	if peer.State != data.BgpStateEstablished {
+		return
+	}
+	prefix := data.BgpPrefix{IP: IP, Mask: mask}
+
+	isAdvertised := best != nil && best.Peer != peer && peer.ExportFilter.Permits(IP, mask) &&
+		!(best.Peer != nil && best.Peer.IsIBGP(bgp) && peer.IsIBGP(bgp))
+	if !isAdvertised {
+		sendBGPMessage(node, peer, data.BgpMessage{
+			Type:   constants.BgpMessageUpdate,
+			Update: &data.BgpUpdate{Withdrawn: []data.BgpPrefix{prefix}},
+		})
+		return
+	}
+
+	attributes := data.BgpPathAttributes{
+		AsPath:  append([]uint16(nil), best.Attributes.AsPath...),
+		NextHop: best.Attributes.NextHop,
+		Med:     best.Attributes.Med,
+		HasMed:  best.Attributes.HasMed,
+	}
+	if best.Peer == nil {
+		attributes.NextHop = peer.LocalIP
+	}
+	if peer.IsIBGP(bgp) {
+		attributes.LocalPref = constants.BgpDefaultLocalPref
+		if best.Attributes.HasLocalPref {
+			attributes.LocalPref = best.Attributes.LocalPref
+		}
+		attributes.HasLocalPref = true
+		if peer.NextHopSelf {
+			attributes.NextHop = peer.LocalIP
+		}
+	} else {
+		attributes.AsPath = append([]uint16{bgp.AS}, attributes.AsPath...)
+		attributes.NextHop = peer.LocalIP
+		// MED is only compared between paths from the same neighboring AS, learned values stay inside the AS
+		if best.Peer != nil {
+			attributes.Med = 0
+			attributes.HasMed = false
+		}
+	}
+
+	sendBGPMessage(node, peer, data.BgpMessage{
+		Type: constants.BgpMessageUpdate,
+		Update: &data.BgpUpdate{
+			Attributes: attributes,
+			Nlri:       []data.BgpPrefix{prefix},
+		},
+	})
+}
+
+// bgpAdvertiseAll sends the full table to the peer and marks its end.
+func bgpAdvertiseAll(node *data.Node, peer *data.BgpPeer) {
+	bgp := node.Properties.Bgp
+	for dllPath := bgp.Paths.Next; dllPath != nil; dllPath = dllPath.Next {
+		path := dllPath.DllToBgpPath()
+		if path.IsBest {
+			bgpAdvertise(node, peer, path.Prefix, path.Mask, path)
+		}
+	}
+	sendBGPEndOfRib(node, peer)
+	peer.NextRefresh = time.Now().Add(time.Duration(constants.BgpRefreshSeconds) * time.Second)
+}
+
+func processBGPMessage(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, udpHeader data.UDPHeader, appData []byte) {
+	bgp := node.Properties.Bgp
+	if bgp == nil {
+		return
+	}
+
+	message, err := data.DeserializeBgpMessage(appData)
+	if err != nil {
+		fmt.Println("processBGPMessage: BGP message from", ipHeader.SourceIP.String(), "dropped on node", node.NodeName, ":", err)
+		return
+	}
+
+	bgp.Mutex.Lock()
+	defer bgp.Mutex.Unlock()
+
+	peer := bgp.LookupPeer(ipHeader.SourceIP)
+	if peer == nil || ipHeader.DestinationIP != peer.LocalIP {
+		fmt.Println("processBGPMessage: BGP message from unknown peer", ipHeader.SourceIP.String(), "dropped on node", node.NodeName)
+		return
+	}
+	now := time.Now()
+
+	switch message.Type {
+	case constants.BgpMessageOpen:
+		if message.Open.AS != peer.RemoteAS {
+			fmt.Println("processBGPMessage: peer", peer.IP.String(), "of node", node.NodeName, "sent AS", message.Open.AS, "instead of", peer.RemoteAS)
+			sendBGPMessage(node, peer, data.BgpMessage{Type: constants.BgpMessageNotification})
+			bgpResetPeer(node, peer, false)
+			return
+		}
+		if peer.State == data.BgpStateEstablished {
+			// the peer restarted the session
+			bgpResetPeer(node, peer, false)
+		}
+		if peer.State == data.BgpStateIdle {
+			sendBGPOpen(node, peer)
+		}
+		peer.RouterID = message.Open.RouterID
+		peer.State = data.BgpStateOpenConfirm
+		peer.NextConnectRetry = now.Add(time.Duration(constants.BgpConnectRetrySeconds) * time.Second)
+		peer.HoldExpiresAt = now.Add(time.Duration(constants.BgpHoldTimeSeconds) * time.Second)
+		sendBGPKeepalive(node, peer)
+	case constants.BgpMessageKeepalive:
+		peer.HoldExpiresAt = now.Add(time.Duration(constants.BgpHoldTimeSeconds) * time.Second)
+		if peer.State == data.BgpStateOpenConfirm {
+			bgpEstablish(node, peer, now)
+		}
+	case constants.BgpMessageUpdate:
+		// messages of the two sessions may overtake each other, an UPDATE after our OPEN was
+		// answered proves the peer is established as well
+		if peer.State == data.BgpStateOpenConfirm {
+			bgpEstablish(node, peer, now)
+		}
+		if peer.State != data.BgpStateEstablished {
+			return
+		}
+		peer.HoldExpiresAt = now.Add(time.Duration(constants.BgpHoldTimeSeconds) * time.Second)
+		if len(message.Update.Withdrawn) == 0 && len(message.Update.Nlri) == 0 {
+			bgpEndOfRib(node, peer, now)
+			return
+		}
+		processBGPUpdate(node, peer, message.Update, now)
+	case constants.BgpMessageNotification:
+		bgpResetPeer(node, peer, false)
+	}
+}
+
+func bgpEstablish(node *data.Node, peer *data.BgpPeer, now time.Time) {
+	peer.State = data.BgpStateEstablished
+	peer.EstablishedAt = now
+	peer.LastEndOfRib = time.Time{}
+	bgpAdvertiseAll(node, peer)
+}
+
+// bgpEndOfRib withdraws the paths of the peer it did not advertise again since its previous
+// End-of-RIB marker, the UPDATE withdrawing them was lost.
+func bgpEndOfRib(node *data.Node, peer *data.BgpPeer, now time.Time) {
+	bgp := node.Properties.Bgp
+
+	var prefixes []data.BgpPrefix
+	for dllPath := bgp.Paths.Next; dllPath != nil; {
+		path := dllPath.DllToBgpPath()
+		dllPath = dllPath.Next
+		if path.Peer == peer && path.RefreshedAt.Before(peer.LastEndOfRib) {
+			(&path.PathGlue).RemoveNode()
+			prefixes = append(prefixes, data.BgpPrefix{IP: path.Prefix, Mask: path.Mask})
+		}
+	}
+	for _, prefix := range prefixes {
+		bgpDecide(node, prefix.IP, prefix.Mask, true)
+	}
+	peer.LastEndOfRib = now
+}
+
+func processBGPUpdate(node *data.Node, peer *data.BgpPeer, update *data.BgpUpdate, now time.Time) {
+	bgp := node.Properties.Bgp
+
+	withdraw := func(prefix data.BgpPrefix) {
+		if path := bgp.LookupPath(prefix.IP, prefix.Mask, peer); path != nil {
+			(&path.PathGlue).RemoveNode()
+			bgpDecide(node, prefix.IP, prefix.Mask, true)
+		}
+	}
+	for _, prefix := range update.Withdrawn {
+		withdraw(prefix)
+	}
+	if len(update.Nlri) == 0 {
+		return
+	}
+
+	attributes := update.Attributes
+	isLoop := false
+	for _, AS := range attributes.AsPath {
+		if AS == bgp.AS {
+			isLoop = true
+		}
+	}
+	if !peer.IsIBGP(bgp) {
+		attributes.HasLocalPref = false
+		attributes.LocalPref = 0
+	}
+	if peer.LocalPref != 0 {
+		attributes.LocalPref = peer.LocalPref
+		attributes.HasLocalPref = true
+	}
+
+	for _, prefix := range update.Nlri {
+		if isLoop || !peer.ImportFilter.Permits(prefix.IP, prefix.Mask) {
+			withdraw(prefix)
+			continue
+		}
+		path := bgp.LookupPath(prefix.IP, prefix.Mask, peer)
+		if path == nil {
+			path = &data.BgpPath{Prefix: prefix.IP, Mask: prefix.Mask, Peer: peer}
+			bgp.AddPath(path)
+		}
+		path.Attributes = attributes
+		path.Attributes.AsPath = append([]uint16(nil), attributes.AsPath...)
+		path.RefreshedAt = now
+		bgpDecide(node, prefix.IP, prefix.Mask, true)
+	}
+}
+
+// bgpTimer opens sessions, sends keepalives, resends the full table, expires hold timers and follows
+// changes of next hop reachability in the routing table.
+func bgpTimer(node *data.Node) {
+	ticker := time.NewTicker(time.Second)
+	for now := range ticker.C {
+		bgp := node.Properties.Bgp
+		bgp.Mutex.Lock()
+
+		for dllPeer := bgp.Peers.Next; dllPeer != nil; dllPeer = dllPeer.Next {
+			peer := dllPeer.DllToBgpPeer()
+			switch {
+			case peer.State == data.BgpStateEstablished && now.After(peer.HoldExpiresAt):
+				fmt.Println("BGP: hold timer of peer", peer.IP.String(), "expired on node", node.NodeName)
+				bgpResetPeer(node, peer, true)
+			case peer.State == data.BgpStateEstablished && !now.Before(peer.NextKeepalive):
+				sendBGPKeepalive(node, peer)
+			case peer.State != data.BgpStateEstablished && !now.Before(peer.NextConnectRetry):
+				peer.State = data.BgpStateOpenSent
+				peer.NextConnectRetry = now.Add(time.Duration(constants.BgpConnectRetrySeconds) * time.Second)
+				sendBGPOpen(node, peer)
+			}
+			if peer.State == data.BgpStateEstablished && !now.Before(peer.NextRefresh) {
+				bgpAdvertiseAll(node, peer)
+			}
+		}
+
+		var prefixes []data.BgpPrefix
+		for dllPath := bgp.Paths.Next; dllPath != nil; dllPath = dllPath.Next {
+			path := dllPath.DllToBgpPath()
+			prefix := data.BgpPrefix{IP: path.Prefix, Mask: path.Mask}
+			isKnown := false
+			for _, known := range prefixes {
+				if known == prefix {
+					isKnown = true
+					break
+				}
+			}
+			if !isKnown {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+		for _, prefix := range prefixes {
+			bgpDecide(node, prefix.IP, prefix.Mask, false)
+		}
+
+		bgp.Mutex.Unlock()
+	}
+}
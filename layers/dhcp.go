@@ -0,0 +1,466 @@
+package layers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+)
+
+var dhcpUnspecifiedIP = data.StringToIPAddress("0.0.0.0")
+
+// getDHCPInstance returns the DHCP instance of the node, the first server pool or client creates it.
+func getDHCPInstance(node *data.Node) *data.DhcpInstance {
+	if node.Properties.Dhcp == nil {
+		dhcp := &data.DhcpInstance{
+			Pools:   data.Dll{},
+			Leases:  data.Dll{},
+			Clients: data.Dll{},
+			Relays:  data.Dll{},
+		}
+		(&dhcp.Pools).Init()
+		(&dhcp.Leases).Init()
+		(&dhcp.Clients).Init()
+		(&dhcp.Relays).Init()
+		node.Properties.Dhcp = dhcp
+		go dhcpTimer(node)
+	}
+	return node.Properties.Dhcp
+}
+
+// AddDHCPPool makes the node a DHCP server for the subnet, leasing the addresses from start to end.
+// The pool serves clients on a connected interface of the subnet.
+func AddDHCPPool(node *data.Node, name string, network data.IPAddress, mask rune, start data.IPAddress, end data.IPAddress, gateway data.IPAddress, leaseSeconds uint32) error {
+	if mask < 1 || mask > 30 {
+		return data.ErrInvalidRouteMask
+	}
+	network = data.ApplyMask(network, mask)
+	if data.ApplyMask(start, mask) != network || data.ApplyMask(end, mask) != network {
+		return errors.New("address range is not in the subnet of the pool")
+	}
+	if bytes.Compare(start[:], end[:]) > 0 {
+		return errors.New("range start is above range end")
+	}
+	if !gateway.IsUnspecified() && data.ApplyMask(gateway, mask) != network {
+		return errors.New("gateway is not in the subnet of the pool")
+	}
+	if leaseSeconds == 0 {
+		leaseSeconds = constants.DhcpDefaultLeaseSeconds
+	}
+
+	dhcp := getDHCPInstance(node)
+	dhcp.Mutex.Lock()
+	defer dhcp.Mutex.Unlock()
+
+	if dhcp.LookupPool(name) != nil {
+		return errors.New("pool already exists")
+	}
+	if dhcp.LookupPoolBySubnet(network) != nil {
+		return errors.New("subnet is already served by another pool")
+	}
+	pool := &data.DhcpPool{
+		Name:         name,
+		Network:      network,
+		Mask:         mask,
+		RangeStart:   start,
+		RangeEnd:     end,
+		Gateway:      gateway,
+		LeaseSeconds: leaseSeconds,
+	}
+	dhcp.AddPool(pool)
+	node.Properties.UDPPorts.Register(constants.DhcpServerPort, processDHCPServerMessage)
+	return nil
+}
+
+// AddDHCPBinding reserves IP for the client with the MAC address.
+func AddDHCPBinding(node *data.Node, poolName string, MAC data.MacAddress, IP data.IPAddress) error {
+	dhcp := node.Properties.Dhcp
+	if dhcp == nil {
+		return errors.New("DHCP server is not configured on node")
+	}
+	dhcp.Mutex.Lock()
+	defer dhcp.Mutex.Unlock()
+
+	pool := dhcp.LookupPool(poolName)
+	if pool == nil {
+		return errors.New("pool not found")
+	}
+	if !pool.Contains(IP) {
+		return errors.New("address is not in the subnet of the pool")
+	}
+	pool.Bindings[MAC] = IP
+	if lease := dhcp.LookupLease(pool, MAC); lease != nil && lease.IP != IP {
+		// the client gets the reserved address with its next request
+		(&lease.LeaseGlue).RemoveNode()
+	}
+	return nil
+}
+
+// AddDHCPRelay relays the DHCP broadcasts received on the interface to the server as unicast,
+// like an ip helper address. The replies of the server are sent back to the segment of the interface.
+func AddDHCPRelay(node *data.Node, intfName string, serverIP data.IPAddress) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	if !intf.Properties.IsIpConfigured || intf.Properties.IP.IsUnspecified() {
+		return errors.New("interface has no IP address")
+	}
+	if serverIP.IsUnspecified() {
+		return errors.New("invalid server IP address")
+	}
+
+	dhcp := getDHCPInstance(node)
+	dhcp.Mutex.Lock()
+	defer dhcp.Mutex.Unlock()
+
+	if dhcp.LookupRelay(intf, serverIP) != nil {
+		return errors.New("relay already exists")
+	}
+	dhcp.AddRelay(&data.DhcpRelay{
+		Interface: intf,
+		ServerIP:  serverIP,
+	})
+	node.Properties.UDPPorts.Register(constants.DhcpServerPort, processDHCPServerMessage)
+	return nil
+}
+
+// EnableDHCPClient drops the address of the interface and leases one from a DHCP server of the LAN.
+func EnableDHCPClient(node *data.Node, intfName string) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	if !intf.Properties.IsIpConfigured {
+		return errors.New("interface is not in L3 mode")
+	}
+
+	dhcp := getDHCPInstance(node)
+	dhcp.Mutex.Lock()
+	defer dhcp.Mutex.Unlock()
+
+	if dhcp.LookupClient(intf) != nil {
+		return errors.New("DHCP client already enabled on interface")
+	}
+	dhcpClearAddress(node, intf)
+
+	client := &data.DhcpClient{
+		Interface: intf,
+		State:     data.DhcpStateInit,
+	}
+	dhcp.AddClient(client)
+	node.Properties.UDPPorts.Register(constants.DhcpClientPort, processDHCPClientMessage)
+	sendDHCPDiscover(node, client)
+	return nil
+}
+
+// dhcpClearAddress leaves the interface without an address, it still sends and receives the
+// broadcasts of the DHCP exchange.
+func dhcpClearAddress(node *data.Node, intf *data.Interface) {
+	if intf.Properties.IsIpConfigured && !intf.Properties.IP.IsUnspecified() {
+		node.VrfRib(intf.Properties.Vrf).DeleteRoute(intf.Properties.IP, intf.Properties.Mask, constants.RouteSourceConnected)
+	}
+	intf.Properties.IsIpConfigured = true
+	intf.Properties.IP = dhcpUnspecifiedIP
+	intf.Properties.Mask = 32
+}
+
+func sendDHCPMessage(node *data.Node, client *data.DhcpClient, message data.DhcpMessage) {
+	message.Op = constants.DhcpOpRequest
+	message.XID = client.XID
+	message.ClientMAC = client.Interface.Properties.MAC
+	if client.State == data.DhcpStateRenewing {
+		// a renewal is unicast to the server that granted the lease
+		UDPSend(node, client.IP, constants.DhcpClientPort, client.ServerID, constants.DhcpServerPort, message.SerializeDhcpMessage())
+		return
+	}
+	message.Flags = constants.DhcpFlagBroadcast
+	UDPSendLinkLocal(node, client.Interface, constants.DhcpClientPort, constants.LimitedBroadcastIP, constants.DhcpServerPort, message.SerializeDhcpMessage())
+}
+
+func sendDHCPDiscover(node *data.Node, client *data.DhcpClient) {
+	client.XID = rand.Uint32()
+	client.State = data.DhcpStateSelecting
+	client.NextRetransmit = time.Now().Add(time.Duration(constants.DhcpRetransmitSeconds) * time.Second)
+	sendDHCPMessage(node, client, data.DhcpMessage{MessageType: constants.DhcpDiscover})
+}
+
+func sendDHCPRequest(node *data.Node, client *data.DhcpClient) {
+	message := data.DhcpMessage{MessageType: constants.DhcpRequest}
+	if client.State == data.DhcpStateRequesting {
+		message.RequestedIP = client.IP
+		message.ServerID = client.ServerID
+	} else {
+		message.ClientIP = client.IP
+	}
+	client.NextRetransmit = time.Now().Add(time.Duration(constants.DhcpRetransmitSeconds) * time.Second)
+	sendDHCPMessage(node, client, message)
+}
+
+// dhcpInstallLease configures the leased address on the interface and the default route over the router.
+func dhcpInstallLease(node *data.Node, client *data.DhcpClient) {
+	intf := client.Interface
+	if intf.Properties.IP != client.IP || intf.Properties.Mask != client.Mask {
+		dhcpClearAddress(node, intf)
+		node.SetIntfIPAddress(intf.Name.String(), client.IP, client.Mask)
+		fmt.Println("DHCP: node", node.NodeName, "leased", client.IP.String(), "on interface", intf.Name.String(), "from server", client.ServerID.String())
+	}
+	if !client.Router.IsUnspecified() {
+		node.VrfRib(intf.Properties.Vrf).ReplaceRoute(dhcpUnspecifiedIP, 0, constants.RouteSourceDHCP, 0,
+			[]data.Layer3NextHop{{GatewayIP: client.Router, InterfaceName: intf.Name, Weight: 1}})
+	}
+}
+
+func dhcpReleaseLease(node *data.Node, client *data.DhcpClient) {
+	fmt.Println("DHCP: node", node.NodeName, "lost the lease of", client.IP.String(), "on interface", client.Interface.Name.String())
+	node.VrfRib(client.Interface.Properties.Vrf).DeleteRoute(dhcpUnspecifiedIP, 0, constants.RouteSourceDHCP)
+	dhcpClearAddress(node, client.Interface)
+	client.IP = data.IPAddress{}
+}
+
+func processDHCPClientMessage(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, udpHeader data.UDPHeader, appData []byte) {
+	dhcp := node.Properties.Dhcp
+	if dhcp == nil || iif == nil {
+		return
+	}
+	message, err := data.DeserializeDhcpMessage(appData)
+	if err != nil {
+		fmt.Println("processDHCPClientMessage:", err, "on node", node.NodeName)
+		return
+	}
+
+	dhcp.Mutex.Lock()
+	defer dhcp.Mutex.Unlock()
+
+	client := dhcp.LookupClient(iif)
+	if client == nil || message.Op != constants.DhcpOpReply || message.XID != client.XID || message.ClientMAC != iif.Properties.MAC {
+		return
+	}
+
+	switch {
+	case message.MessageType == constants.DhcpOffer && client.State == data.DhcpStateSelecting:
+		// the first offer wins
+		client.IP = message.YourIP
+		client.ServerID = message.ServerID
+		client.State = data.DhcpStateRequesting
+		sendDHCPRequest(node, client)
+	case message.MessageType == constants.DhcpAck && client.State >= data.DhcpStateRequesting:
+		client.IP = message.YourIP
+		client.Mask = message.SubnetMask
+		client.Router = message.Router
+		client.ServerID = message.ServerID
+		client.LeaseSeconds = message.LeaseSeconds
+		client.LeasedAt = time.Now()
+		client.State = data.DhcpStateBound
+		dhcpInstallLease(node, client)
+	case message.MessageType == constants.DhcpNak && client.State >= data.DhcpStateRequesting:
+		if client.State != data.DhcpStateRequesting {
+			dhcpReleaseLease(node, client)
+		}
+		sendDHCPDiscover(node, client)
+	}
+}
+
+func processDHCPServerMessage(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, udpHeader data.UDPHeader, appData []byte) {
+	dhcp := node.Properties.Dhcp
+	if dhcp == nil {
+		return
+	}
+	message, err := data.DeserializeDhcpMessage(appData)
+	if err != nil {
+		fmt.Println("processDHCPServerMessage:", err, "on node", node.NodeName)
+		return
+	}
+	// a message the node sent to itself has no ingress interface, only relayed messages are sent that way
+	if iif == nil && message.GatewayIP.IsUnspecified() {
+		return
+	}
+
+	dhcp.Mutex.Lock()
+	defer dhcp.Mutex.Unlock()
+
+	if message.Op == constants.DhcpOpReply {
+		dhcpRelayReply(node, message)
+		return
+	}
+	if message.Op != constants.DhcpOpRequest {
+		return
+	}
+	if dhcp.LookupRelay(iif, data.IPAddress{}) != nil {
+		dhcpRelayRequest(node, iif, message)
+		return
+	}
+
+	// relayed requests are served from the pool of the relay address, renewals from the pool of the
+	// client address and broadcasts from the pool of the receiving interface
+	var subnetIP data.IPAddress
+	switch {
+	case !message.GatewayIP.IsUnspecified():
+		subnetIP = message.GatewayIP
+	case !message.ClientIP.IsUnspecified():
+		subnetIP = message.ClientIP
+	default:
+		subnetIP = iif.Properties.IP
+	}
+	pool := dhcp.LookupPoolBySubnet(subnetIP)
+	if pool == nil {
+		fmt.Println("processDHCPServerMessage: no pool for", subnetIP.String(), "on node", node.NodeName)
+		return
+	}
+	serverID := ipHeader.DestinationIP
+	if iif != nil {
+		serverID = iif.Properties.IP
+	}
+	now := time.Now()
+
+	reply := data.DhcpMessage{
+		Op:           constants.DhcpOpReply,
+		XID:          message.XID,
+		Flags:        message.Flags,
+		ClientIP:     message.ClientIP,
+		GatewayIP:    message.GatewayIP,
+		ClientMAC:    message.ClientMAC,
+		SubnetMask:   pool.Mask,
+		Router:       pool.Gateway,
+		LeaseSeconds: pool.LeaseSeconds,
+		ServerID:     serverID,
+	}
+
+	switch message.MessageType {
+	case constants.DhcpDiscover:
+		lease := dhcp.AllocateLease(pool, message.ClientMAC)
+		if lease == nil {
+			fmt.Println("processDHCPServerMessage: pool", pool.Name, "of node", node.NodeName, "is exhausted")
+			return
+		}
+		if !lease.IsBound {
+			lease.ExpiresAt = now.Add(time.Duration(constants.DhcpOfferHoldSeconds) * time.Second)
+		}
+		reply.MessageType = constants.DhcpOffer
+		reply.YourIP = lease.IP
+	case constants.DhcpRequest:
+		if !message.ServerID.IsUnspecified() && message.ServerID != serverID {
+			// the client accepted the offer of another server
+			if lease := dhcp.LookupLease(pool, message.ClientMAC); lease != nil && !lease.IsBound {
+				(&lease.LeaseGlue).RemoveNode()
+			}
+			return
+		}
+		requestedIP := message.RequestedIP
+		if requestedIP.IsUnspecified() {
+			requestedIP = message.ClientIP
+		}
+		lease := dhcp.LookupLease(pool, message.ClientMAC)
+		if lease == nil || lease.IP != requestedIP {
+			reply.MessageType = constants.DhcpNak
+			reply.SubnetMask, reply.Router, reply.LeaseSeconds = 0, data.IPAddress{}, 0
+			break
+		}
+		lease.IsBound = true
+		lease.ExpiresAt = now.Add(time.Duration(pool.LeaseSeconds) * time.Second)
+		reply.MessageType = constants.DhcpAck
+		reply.YourIP = lease.IP
+	case constants.DhcpRelease:
+		if lease := dhcp.LookupLease(pool, message.ClientMAC); lease != nil {
+			(&lease.LeaseGlue).RemoveNode()
+		}
+		return
+	default:
+		return
+	}
+
+	switch {
+	case !message.GatewayIP.IsUnspecified():
+		UDPSend(node, serverID, constants.DhcpServerPort, message.GatewayIP, constants.DhcpServerPort, reply.SerializeDhcpMessage())
+	case !message.ClientIP.IsUnspecified():
+		UDPSend(node, serverID, constants.DhcpServerPort, message.ClientIP, constants.DhcpClientPort, reply.SerializeDhcpMessage())
+	default:
+		// the client has no address yet
+		UDPSendLinkLocal(node, iif, constants.DhcpServerPort, constants.LimitedBroadcastIP, constants.DhcpClientPort, reply.SerializeDhcpMessage())
+	}
+}
+
+// dhcpRelayRequest forwards a request received on a relay interface to all servers of the interface,
+// the address of the interface tells the servers which pool to lease from.
+func dhcpRelayRequest(node *data.Node, iif *data.Interface, message *data.DhcpMessage) {
+	if message.Hops >= constants.DhcpMaxHops {
+		return
+	}
+	message.Hops++
+	if message.GatewayIP.IsUnspecified() {
+		message.GatewayIP = iif.Properties.IP
+	}
+
+	for dllRelay := node.Properties.Dhcp.Relays.Next; dllRelay != nil; dllRelay = dllRelay.Next {
+		relay := dllRelay.DllToDhcpRelay()
+		if relay.Interface == iif {
+			UDPSend(node, iif.Properties.IP, constants.DhcpServerPort, relay.ServerIP, constants.DhcpServerPort, message.SerializeDhcpMessage())
+		}
+	}
+}
+
+// dhcpRelayReply sends a reply of a server back to the segment of the relay interface it was requested on.
+func dhcpRelayReply(node *data.Node, message *data.DhcpMessage) {
+	relay := node.Properties.Dhcp.LookupRelayByAddress(message.GatewayIP)
+	if relay == nil {
+		return
+	}
+	oif := relay.Interface
+	if !message.ClientIP.IsUnspecified() {
+		UDPSend(node, oif.Properties.IP, constants.DhcpServerPort, message.ClientIP, constants.DhcpClientPort, message.SerializeDhcpMessage())
+		return
+	}
+	UDPSendLinkLocal(node, oif, constants.DhcpServerPort, constants.LimitedBroadcastIP, constants.DhcpClientPort, message.SerializeDhcpMessage())
+}
+
+func dhcpTimer(node *data.Node) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		dhcp := node.Properties.Dhcp
+		dhcp.Mutex.Lock()
+
+		now := time.Now()
+		for dllLease := dhcp.Leases.Next; dllLease != nil; {
+			lease := dllLease.DllToDhcpLease()
+			dllLease = dllLease.Next
+			if now.After(lease.ExpiresAt) {
+				(&lease.LeaseGlue).RemoveNode()
+			}
+		}
+
+		for dllClient := dhcp.Clients.Next; dllClient != nil; dllClient = dllClient.Next {
+			client := dllClient.DllToDhcpClient()
+			switch client.State {
+			case data.DhcpStateInit:
+				sendDHCPDiscover(node, client)
+			case data.DhcpStateSelecting, data.DhcpStateRequesting:
+				if !now.Before(client.NextRetransmit) {
+					sendDHCPDiscover(node, client)
+				}
+			case data.DhcpStateBound:
+				if !now.Before(client.RenewAt()) {
+					client.State = data.DhcpStateRenewing
+					sendDHCPRequest(node, client)
+				}
+			case data.DhcpStateRenewing, data.DhcpStateRebinding:
+				switch {
+				case !now.Before(client.ExpiresAt()):
+					dhcpReleaseLease(node, client)
+					sendDHCPDiscover(node, client)
+				case client.State == data.DhcpStateRenewing && !now.Before(client.RebindAt()):
+					// the server does not answer, ask any server on the LAN
+					client.State = data.DhcpStateRebinding
+					sendDHCPRequest(node, client)
+				case !now.Before(client.NextRetransmit):
+					sendDHCPRequest(node, client)
+				}
+			}
+		}
+		dhcp.Mutex.Unlock()
+	}
+}
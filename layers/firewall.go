@@ -0,0 +1,246 @@
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+	"unsafe"
+)
+
+// getFirewallInstance returns the firewall of the node, the first zone or policy creates it.
+func getFirewallInstance(node *data.Node) *data.FirewallInstance {
+	if node.Properties.Firewall == nil {
+		firewall := &data.FirewallInstance{
+			Zones:       map[*data.Interface]string{},
+			Policies:    map[data.FirewallZonePair]bool{},
+			Connections: data.Dll{},
+		}
+		(&firewall.Connections).Init()
+		node.Properties.Firewall = firewall
+		go firewallTimer(node)
+	}
+	return node.Properties.Firewall
+}
+
+// SetFirewallZone puts the interface in the zone, zone "none" takes it out of its zone. Packets
+// routed between interfaces of the same zone pass, packets between different zones are inspected.
+func SetFirewallZone(node *data.Node, intfName string, zone string) error {
+	intf := node.GetNodeIntfOrTunnelByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	if zone == "" {
+		return errors.New("zone name is empty")
+	}
+
+	firewall := getFirewallInstance(node)
+	firewall.Mutex.Lock()
+	defer firewall.Mutex.Unlock()
+
+	if zone == "none" {
+		delete(firewall.Zones, intf)
+	} else {
+		firewall.Zones[intf] = zone
+	}
+	return nil
+}
+
+// SetFirewallPolicy permits or stops new connections from zone from to zone to, the replies of
+// permitted connections pass without a policy in the other direction.
+func SetFirewallPolicy(node *data.Node, from string, to string, isPermit bool) error {
+	if from == "" || to == "" {
+		return errors.New("zone name is empty")
+	}
+	if from == to {
+		return errors.New("traffic within a zone is always permitted")
+	}
+
+	firewall := getFirewallInstance(node)
+	firewall.Mutex.Lock()
+	defer firewall.Mutex.Unlock()
+
+	pair := data.FirewallZonePair{From: from, To: to}
+	if isPermit {
+		firewall.Policies[pair] = true
+	} else {
+		delete(firewall.Policies, pair)
+	}
+	return nil
+}
+
+// ClearConntrack removes the tracked connections of the node and returns how many there were.
+func ClearConntrack(node *data.Node) int {
+	firewall := node.Properties.Firewall
+	if firewall == nil {
+		return 0
+	}
+	firewall.Mutex.Lock()
+	defer firewall.Mutex.Unlock()
+
+	return firewall.ClearConnections()
+}
+
+// conntrackTuple returns the tuple of an IPv4 packet, it returns false for packets connection
+// tracking does not follow.
+func conntrackTuple(ipHeader data.IPHeader, l4 []byte) (data.ConntrackTuple, bool) {
+	tuple := data.ConntrackTuple{
+		Protocol:      ipHeader.Protocol,
+		SourceIP:      ipHeader.SourceIP,
+		DestinationIP: ipHeader.DestinationIP,
+	}
+	switch ipHeader.Protocol {
+	case constants.TcpProto, constants.UdpProto:
+		tuple.SourcePort = binary.BigEndian.Uint16(l4[0:2])
+		tuple.DestinationPort = binary.BigEndian.Uint16(l4[2:4])
+	case constants.IcmpProto:
+		if l4[0] != constants.IcmpTypeEchoRequest && l4[0] != constants.IcmpTypeEchoReply {
+			return tuple, false
+		}
+		tuple.SourcePort = binary.BigEndian.Uint16(l4[4:6])
+		tuple.DestinationPort = tuple.SourcePort
+	default:
+		return tuple, false
+	}
+	return tuple, true
+}
+
+// conntrackUpdate accounts a packet of the connection, follows the TCP handshake and teardown and
+// restarts the timeout of the connection for its state.
+func conntrackUpdate(entry *data.ConntrackEntry, l4 []byte, isReply bool, now time.Time) {
+	if isReply {
+		entry.ReplyPackets++
+		entry.State = data.ConntrackStateEstablished
+	} else {
+		entry.Packets++
+	}
+
+	timeout := constants.ConntrackIcmpTimeoutSeconds
+	switch entry.Original.Protocol {
+	case constants.UdpProto:
+		timeout = constants.ConntrackUdpTimeoutSeconds
+		if entry.State == data.ConntrackStateEstablished {
+			timeout = constants.ConntrackUdpStreamTimeoutSeconds
+		}
+	case constants.TcpProto:
+		flags := l4[13]
+		switch {
+		case flags&constants.TcpFlagRst != 0:
+			entry.TcpState = data.TcpConntrackClose
+		case flags&constants.TcpFlagFin != 0:
+			if entry.TcpState != data.TcpConntrackClose {
+				entry.TcpState = data.TcpConntrackFinWait
+			}
+		case entry.TcpState == data.TcpConntrackSynSent && isReply && flags&constants.TcpFlagSyn != 0 && flags&constants.TcpFlagAck != 0:
+			entry.TcpState = data.TcpConntrackSynReceived
+		case entry.TcpState == data.TcpConntrackSynReceived && !isReply && flags&constants.TcpFlagAck != 0:
+			entry.TcpState = data.TcpConntrackEstablished
+		}
+		switch entry.TcpState {
+		case data.TcpConntrackSynSent, data.TcpConntrackSynReceived:
+			timeout = constants.ConntrackTcpSynTimeoutSeconds
+		case data.TcpConntrackEstablished:
+			timeout = constants.ConntrackTcpTimeoutSeconds
+		default:
+			timeout = constants.ConntrackTcpCloseTimeoutSeconds
+		}
+	}
+	entry.ExpiresAt = now.Add(time.Duration(timeout) * time.Second)
+}
+
+// firewallDrop reports a packet dropped by the firewall of the node.
+func firewallDrop(node *data.Node, state uint8, packet string, from string, to string) {
+	fmt.Println("Firewall: dropped", data.ConntrackStateString(state), packet, "from zone", from, "to zone", to, "on node", node.NodeName)
+}
+
+// firewallPermits inspects a packet routed from iif to oif. Replies of tracked connections
+// (ESTABLISHED) and ICMP errors quoting them (RELATED) pass, a packet opening a connection (NEW)
+// passes when a policy permits its zone pair and is tracked from then on. Packets routed within a
+// zone, ICMP errors included, and between interfaces without zone are not inspected.
+func firewallPermits(node *data.Node, iif *data.Interface, oif *data.Interface, ipHeader data.IPHeader, payload *data.Payload) bool {
+	firewall := node.Properties.Firewall
+	if firewall == nil || iif == nil || oif == nil {
+		return true
+	}
+	firewall.Mutex.Lock()
+	defer firewall.Mutex.Unlock()
+
+	from, to := firewall.Zones[iif], firewall.Zones[oif]
+	if from == to {
+		return true
+	}
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	l4 := payload[l4Offset:]
+
+	if ipHeader.Protocol == constants.IcmpProto && isICMPError(l4) {
+		quoted := l4[constants.IcmpHeaderSize:]
+		quotedHeader := data.DeserializeIPHeader(quoted[:l4Offset])
+		if quotedTuple, ok := conntrackTuple(quotedHeader, quoted[l4Offset:]); ok {
+			if entry, _ := firewall.LookupConnection(quotedTuple); entry != nil {
+				return true
+			}
+		}
+		firewallDrop(node, data.ConntrackStateInvalid, "icmp error "+ipHeader.SourceIP.String()+" -> "+ipHeader.DestinationIP.String(), from, to)
+		return false
+	}
+
+	tuple, isTracked := conntrackTuple(ipHeader, l4)
+	if isTracked {
+		if entry, isReply := firewall.LookupConnection(tuple); entry != nil {
+			conntrackUpdate(entry, l4, isReply, time.Now())
+			return true
+		}
+	}
+
+	packet := tuple.String()
+	if !isTracked {
+		packet = fmt.Sprintf("protocol %d %s -> %s", ipHeader.Protocol, ipHeader.SourceIP.String(), ipHeader.DestinationIP.String())
+	}
+	if !firewall.Policies[data.FirewallZonePair{From: from, To: to}] {
+		firewallDrop(node, data.ConntrackStateNew, packet, from, to)
+		return false
+	}
+	if !isTracked {
+		return true
+	}
+	// TCP connections are opened by a SYN, anything else belongs to a connection we do not know
+	if ipHeader.Protocol == constants.TcpProto && l4[13]&(constants.TcpFlagSyn|constants.TcpFlagAck|constants.TcpFlagRst) != constants.TcpFlagSyn {
+		firewallDrop(node, data.ConntrackStateInvalid, packet, from, to)
+		return false
+	}
+	// only echo requests open ICMP connections
+	if ipHeader.Protocol == constants.IcmpProto && l4[0] != constants.IcmpTypeEchoRequest {
+		firewallDrop(node, data.ConntrackStateInvalid, packet, from, to)
+		return false
+	}
+	entry := &data.ConntrackEntry{
+		Original: tuple,
+		State:    data.ConntrackStateNew,
+		TcpState: data.TcpConntrackSynSent,
+	}
+	conntrackUpdate(entry, l4, false, time.Now())
+	firewall.AddConnection(entry)
+	return true
+}
+
+func firewallTimer(node *data.Node) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		firewall := node.Properties.Firewall
+		firewall.Mutex.Lock()
+
+		now := time.Now()
+		var next *data.Dll
+		for dllEntry := firewall.Connections.Next; dllEntry != nil; dllEntry = next {
+			next = dllEntry.Next
+			if !now.Before(dllEntry.DllToConntrackEntry().ExpiresAt) {
+				dllEntry.RemoveNode()
+			}
+		}
+		firewall.Mutex.Unlock()
+	}
+}
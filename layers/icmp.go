@@ -0,0 +1,351 @@
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+	"unsafe"
+)
+
+func processICMPMessage(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, payload data.Payload) {
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	icmpHeader, err := data.DeserializeIcmpHeader(payload[l4Offset:])
+	if err != nil {
+		fmt.Println("processICMPMessage:", err, "on node", node.NodeName)
+		return
+	}
+
+	switch icmpHeader.Type {
+	case constants.IcmpTypeDestinationUnreachable:
+		// the message quotes the header and the first eight bytes of the offending packet
+		quoted := payload[l4Offset+constants.IcmpHeaderSize:]
+		originalHeader := data.DeserializeIPHeader(quoted[:l4Offset])
+		if tracerouteReport(node, originalHeader, quoted[l4Offset:], ipHeader.SourceIP, icmpHeader.Type) {
+			return
+		}
+		if icmpHeader.Code == constants.IcmpCodePortUnreachable && originalHeader.Protocol == constants.UdpProto {
+			port := binary.BigEndian.Uint16(quoted[l4Offset+2 : l4Offset+4])
+			fmt.Println("ICMP: port", port, "unreachable at", originalHeader.DestinationIP.String(), "reported to node", node.NodeName)
+		} else {
+			fmt.Println("ICMP: destination", originalHeader.DestinationIP.String(), "unreachable, code", icmpHeader.Code, "reported by", ipHeader.SourceIP.String())
+		}
+	case constants.IcmpTypeTimeExceeded:
+		quoted := payload[l4Offset+constants.IcmpHeaderSize:]
+		originalHeader := data.DeserializeIPHeader(quoted[:l4Offset])
+		if tracerouteReport(node, originalHeader, quoted[l4Offset:], ipHeader.SourceIP, icmpHeader.Type) {
+			return
+		}
+		fmt.Println("ICMP: TTL of packet to", originalHeader.DestinationIP.String(), "exceeded at", ipHeader.SourceIP.String(), "reported to node", node.NodeName)
+	case constants.IcmpTypeEchoRequest:
+		fmt.Println("IP Address: ", ipHeader.DestinationIP.String(), ", ping received")
+		sendICMPEchoReply(node, iif, ipHeader, icmpHeader, payload)
+	case constants.IcmpTypeEchoReply:
+		if tracerouteReport(node, ipHeader, payload[l4Offset:], ipHeader.SourceIP, icmpHeader.Type) {
+			return
+		}
+		fmt.Println("ICMP: echo reply from", ipHeader.SourceIP.String(), "seq", icmpHeader.Rest&0xFFFF, "received by node", node.NodeName)
+	default:
+		break
+	}
+}
+
+func sendICMPEchoReply(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, request data.IcmpHeader, payload data.Payload) {
+	// requests to broadcast and multicast groups are not answered
+	if isLinkLocalDestination(ipHeader.DestinationIP) {
+		return
+	}
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	var body []byte
+	if length := int(ipHeader.Length) - l4Offset - constants.IcmpHeaderSize; length > 0 && l4Offset+constants.IcmpHeaderSize+length <= len(payload) {
+		body = payload[l4Offset+constants.IcmpHeaderSize : l4Offset+constants.IcmpHeaderSize+length]
+	}
+	icmpHeader := data.IcmpHeader{
+		Type: constants.IcmpTypeEchoReply,
+		Rest: request.Rest,
+	}
+	message := icmpHeader.SerializeIcmpMessage(body)
+	ipSend(node, intfVrf(iif), newIPHeader(ipHeader.DestinationIP, constants.IcmpProto, ipHeader.SourceIP, len(message)), message)
+}
+
+var icmpEchoSequence uint32
+
+// Ping sends an ICMP echo request to IP in the VRF vrfName, an empty name is the default VRF. The
+// default VRF sends from the loopback address of the node when it has one.
+func Ping(node *data.Node, IP data.IPAddress, vrfName string) error {
+	vrf, err := lookupVrfOption(node, vrfName)
+	if err != nil {
+		return err
+	}
+	sourceIP := node.Properties.LB
+	if vrf != nil || !node.Properties.IsLbConfigured {
+		if sourceIP, err = sourceAddressFor(node, vrf, IP); err != nil {
+			return err
+		}
+	}
+	sequence := atomic.AddUint32(&icmpEchoSequence, 1)
+	icmpHeader := data.IcmpHeader{
+		Type: constants.IcmpTypeEchoRequest,
+		Rest: uint32(node.UDPPortNumber)<<16 | sequence&0xFFFF,
+	}
+	message := icmpHeader.SerializeIcmpMessage(nil)
+	ipSend(node, vrf, newIPHeader(sourceIP, constants.IcmpProto, IP, len(message)), message)
+	return nil
+}
+
+// lookupVrfOption returns the VRF named by a command option, nil for an empty name.
+func lookupVrfOption(node *data.Node, vrfName string) (*data.Vrf, error) {
+	if vrfName == "" {
+		return nil, nil
+	}
+	vrf := node.LookupVrf(vrfName)
+	if vrf == nil {
+		return nil, data.ErrVrfNotFound
+	}
+	return vrf, nil
+}
+
+// tracerouteProbe is the echo request in flight of the traceroute running on a node, the ICMP
+// messages answering it are reported on replies.
+type tracerouteProbe struct {
+	rest    uint32
+	replies chan tracerouteReply
+}
+
+type tracerouteReply struct {
+	from        data.IPAddress
+	messageType uint8
+}
+
+var (
+	traceroutes      = map[*data.Node]*tracerouteProbe{}
+	traceroutesMutex sync.Mutex
+)
+
+// Traceroute sends echo requests of growing TTL to IP in the VRF vrfName and prints the address of
+// every router reporting the expired TTL, until IP answers or constants.TracerouteMaxHops routers
+// were probed. Hops not answering within constants.TracerouteProbeTimeoutMs are printed as "*".
+func Traceroute(node *data.Node, IP data.IPAddress, vrfName string) error {
+	vrf, err := lookupVrfOption(node, vrfName)
+	if err != nil {
+		return err
+	}
+	if !IP.IsIPv4() {
+		return errors.New("traceroute supports IPv4 destinations only")
+	}
+	sourceIP, err := sourceAddressFor(node, vrf, IP)
+	if err != nil {
+		return err
+	}
+
+	traceroutesMutex.Lock()
+	if traceroutes[node] != nil {
+		traceroutesMutex.Unlock()
+		return errors.New("a traceroute is already running on the node")
+	}
+	probe := &tracerouteProbe{
+		replies: make(chan tracerouteReply, 1),
+	}
+	traceroutes[node] = probe
+	traceroutesMutex.Unlock()
+	defer func() {
+		traceroutesMutex.Lock()
+		delete(traceroutes, node)
+		traceroutesMutex.Unlock()
+	}()
+
+	fmt.Printf("traceroute to %s from %s in VRF %s, %d hops max\n", IP.String(), sourceIP.String(), vrf.String(), constants.TracerouteMaxHops)
+	for hop := 1; hop <= constants.TracerouteMaxHops; hop++ {
+		sequence := atomic.AddUint32(&icmpEchoSequence, 1)
+		icmpHeader := data.IcmpHeader{
+			Type: constants.IcmpTypeEchoRequest,
+			Rest: uint32(node.UDPPortNumber)<<16 | sequence&0xFFFF,
+		}
+		traceroutesMutex.Lock()
+		probe.rest = icmpHeader.Rest
+		traceroutesMutex.Unlock()
+
+		message := icmpHeader.SerializeIcmpMessage(nil)
+		ipHeader := newIPHeader(sourceIP, constants.IcmpProto, IP, len(message))
+		ipHeader.TTL = uint8(hop)
+		ipSend(node, vrf, ipHeader, message)
+
+		select {
+		case reply := <-probe.replies:
+			switch reply.messageType {
+			case constants.IcmpTypeEchoReply:
+				fmt.Printf("%2d  %s\n", hop, reply.from.String())
+				return nil
+			case constants.IcmpTypeDestinationUnreachable:
+				fmt.Printf("%2d  %s !U\n", hop, reply.from.String())
+				return nil
+			default:
+				fmt.Printf("%2d  %s\n", hop, reply.from.String())
+			}
+		case <-time.After(time.Duration(constants.TracerouteProbeTimeoutMs) * time.Millisecond):
+			fmt.Printf("%2d  *\n", hop)
+		}
+	}
+	return nil
+}
+
+// tracerouteReport hands an ICMP message answering the probe of the traceroute running on the node
+// to the traceroute. probeHeader and probeMessage are the echo request, or the echo reply echoing
+// it, as far as the message carries them.
+func tracerouteReport(node *data.Node, probeHeader data.IPHeader, probeMessage []byte, from data.IPAddress, messageType uint8) bool {
+	if probeHeader.Protocol != constants.IcmpProto {
+		return false
+	}
+	request, err := data.DeserializeIcmpHeader(probeMessage)
+	if err != nil {
+		return false
+	}
+	traceroutesMutex.Lock()
+	defer traceroutesMutex.Unlock()
+
+	probe := traceroutes[node]
+	if probe == nil || probe.rest != request.Rest {
+		return false
+	}
+	select {
+	case probe.replies <- tracerouteReply{from: from, messageType: messageType}:
+	default:
+	}
+	return true
+}
+
+// sendICMPDestinationUnreachable reports an undeliverable packet to its source from the address it was sent to.
+func sendICMPDestinationUnreachable(node *data.Node, iif *data.Interface, code uint8, ipHeader data.IPHeader, payload data.Payload) {
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	icmpHeader := data.IcmpHeader{
+		Type: constants.IcmpTypeDestinationUnreachable,
+		Code: code,
+	}
+	message := icmpHeader.SerializeIcmpMessage(payload[:l4Offset+8])
+	ipSend(node, intfVrf(iif), newIPHeader(ipHeader.DestinationIP, constants.IcmpProto, ipHeader.SourceIP, len(message)), message)
+}
+
+// sendICMPTimeExceeded reports a packet whose TTL ran out to its source from the address of the
+// interface it was received on. ICMP errors are never answered with an error.
+func sendICMPTimeExceeded(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, payload data.Payload) {
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	if ipHeader.Protocol == constants.IcmpProto && isICMPError(payload[l4Offset:]) {
+		return
+	}
+	vrf := intfVrf(iif)
+	var sourceIP data.IPAddress
+	if iif != nil && iif.Properties.IsIpConfigured {
+		sourceIP = iif.Properties.IP
+	} else if vrf == nil && node.Properties.IsLbConfigured {
+		sourceIP = node.Properties.LB
+	} else {
+		return
+	}
+	icmpHeader := data.IcmpHeader{
+		Type: constants.IcmpTypeTimeExceeded,
+		Code: constants.IcmpCodeTtlExceeded,
+	}
+	message := icmpHeader.SerializeIcmpMessage(payload[:l4Offset+8])
+	ipSend(node, vrf, newIPHeader(sourceIP, constants.IcmpProto, ipHeader.SourceIP, len(message)), message)
+}
+
+func processICMPv6Message(node *data.Node, iif *data.Interface, ipHeader data.IPv6Header, appData []byte) {
+	icmpHeader, err := data.DeserializeIcmpHeader(appData)
+	if err == nil && !data.VerifyIcmpv6Checksum(ipHeader.SourceIP, ipHeader.DestinationIP, appData) {
+		err = errors.New("invalid ICMPv6 checksum")
+	}
+	if err != nil {
+		fmt.Println("processICMPv6Message:", err, "on node", node.NodeName)
+		return
+	}
+	body := appData[constants.IcmpHeaderSize:]
+
+	switch icmpHeader.Type {
+	case constants.Icmpv6TypeNeighborSolicitation, constants.Icmpv6TypeNeighborAdvertisement,
+		constants.Icmpv6TypeRouterSolicitation, constants.Icmpv6TypeRouterAdvertisement:
+		// neighbor discovery only accepts messages that were not forwarded by a router
+		if iif == nil || ipHeader.HopLimit != constants.NdHopLimit {
+			return
+		}
+		switch icmpHeader.Type {
+		case constants.Icmpv6TypeNeighborSolicitation:
+			processNeighborSolicitation(node, iif, ipHeader, body)
+		case constants.Icmpv6TypeNeighborAdvertisement:
+			processNeighborAdvertisement(node, iif, body)
+		case constants.Icmpv6TypeRouterSolicitation:
+			processRouterSolicitation(node, iif, ipHeader, body)
+		default:
+			processRouterAdvertisement(node, iif, ipHeader, icmpHeader, body)
+		}
+	case constants.Icmpv6TypeEchoRequest:
+		fmt.Println("IP Address: ", ipHeader.DestinationIP.String(), ", ping received")
+		sendICMPv6EchoReply(node, iif, ipHeader, icmpHeader, body)
+	case constants.Icmpv6TypeEchoReply:
+		fmt.Println("ICMPv6: echo reply from", ipHeader.SourceIP.String(), "seq", icmpHeader.Rest&0xFFFF, "received by node", node.NodeName)
+	default:
+		break
+	}
+}
+
+func sendICMPv6EchoReply(node *data.Node, iif *data.Interface, ipHeader data.IPv6Header, request data.IcmpHeader, body []byte) {
+	// a reply to a multicast request comes from an address of the receiving interface
+	sourceIP := ipHeader.DestinationIP
+	if sourceIP.IsMulticast() {
+		if iif == nil {
+			return
+		}
+		sourceIP = iif.Properties.LinkLocalIP
+	}
+	icmpHeader := data.IcmpHeader{
+		Type: constants.Icmpv6TypeEchoReply,
+		Rest: request.Rest,
+	}
+	message := icmpHeader.SerializeIcmpv6Message(sourceIP, ipHeader.SourceIP, body)
+
+	if ipHeader.SourceIP.IsLinkLocalUnicast() {
+		if iif == nil {
+			return
+		}
+		packetSendIPv6OnLink(node, iif, sourceIP, ipHeader.SourceIP, constants.Icmpv6Proto, message)
+		return
+	}
+	if err := PacketSendIPv6(node, intfVrf(iif), sourceIP, message, constants.Icmpv6Proto, ipHeader.SourceIP); err != nil {
+		fmt.Println("sendICMPv6EchoReply:", err, "on node", node.NodeName)
+	}
+}
+
+// PingIPv6 sends an ICMPv6 echo request to IP in the VRF vrfName, link-local destinations are
+// reached through oif.
+func PingIPv6(node *data.Node, oif *data.Interface, IP data.IPAddress, vrfName string) error {
+	vrf, err := lookupVrfOption(node, vrfName)
+	if err != nil {
+		return err
+	}
+	sequence := atomic.AddUint32(&icmpEchoSequence, 1)
+	icmpHeader := data.IcmpHeader{
+		Type: constants.Icmpv6TypeEchoRequest,
+		Rest: uint32(node.UDPPortNumber)<<16 | sequence&0xFFFF,
+	}
+
+	if IP.IsLinkLocalUnicast() {
+		if oif == nil {
+			return errors.New("link-local destination requires an outgoing interface")
+		}
+		if !oif.Properties.IsIpv6Enabled {
+			return fmt.Errorf("IPv6 is not enabled on interface %s", oif.Name.String())
+		}
+		sourceIP := oif.Properties.LinkLocalIP
+		packetSendIPv6OnLink(node, oif, sourceIP, IP, constants.Icmpv6Proto, icmpHeader.SerializeIcmpv6Message(sourceIP, IP, nil))
+		return nil
+	}
+
+	sourceIP, err := sourceAddressFor(node, vrf, IP)
+	if err != nil {
+		return err
+	}
+	return PacketSendIPv6(node, vrf, sourceIP, icmpHeader.SerializeIcmpv6Message(sourceIP, IP, nil), constants.Icmpv6Proto, IP)
+}
@@ -0,0 +1,183 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"tcpip/constants"
+	"tcpip/data"
+)
+
+func PacketReceiveIPv6(node *data.Node, iif *data.Interface, payload data.Payload) {
+	ipHeader, err := data.DeserializeIPv6Header(payload[:])
+	if err != nil {
+		fmt.Println("PacketReceiveIPv6:", err, "on node", node.NodeName)
+		return
+	}
+
+	if ipHeader.DestinationIP.IsMulticast() {
+		ipv6LocalDeliver(node, iif, ipHeader, payload)
+		return
+	}
+	if ipHeader.DestinationIP.IsLinkLocalUnicast() {
+		// link-local packets never leave the link they were sent on
+		if iif != nil && ipHeader.DestinationIP != iif.Properties.LinkLocalIP {
+			return
+		}
+		ipv6LocalDeliver(node, iif, ipHeader, payload)
+		return
+	}
+
+	vrf := intfVrf(iif)
+	route := node.VrfRoutingTable(vrf).LookupRoutingTableLPM(ipHeader.DestinationIP)
+	if route == nil {
+		fmt.Println("No route found")
+		return
+	}
+	if route.IsBlackhole {
+		fmt.Println("Packet to", ipHeader.DestinationIP.String(), "discarded by blackhole route")
+		return
+	}
+	if route.IsDirect {
+		if IsVrfLocalDelivery(node, vrf, ipHeader.DestinationIP) {
+			ipv6LocalDeliver(node, iif, ipHeader, payload)
+			return
+		}
+		oif := node.GetVrfMatchingSubnetInterface(ipHeader.DestinationIP, vrf)
+		if oif == nil {
+			fmt.Println("No eligible subnet for neighbor solicitation")
+			return
+		}
+		PacketDemoteToLayer2(node, ipHeader.DestinationIP, oif, payload, constants.EthernetIpv6Proto)
+		return
+	}
+
+	if ipHeader.HopLimit <= 1 {
+		fmt.Println("Hop limit expired")
+		return
+	}
+	// the hop limit is the only field a router changes, IPv6 has no header checksum to update
+	payload[7] = ipHeader.HopLimit - 1
+	gatewayIP, oif, ok := resolveNextHop(node, vrf, route, FlowHashIPv6(ipHeader))
+	if !ok {
+		return
+	}
+	PacketDemoteToLayer2(node, gatewayIP, oif, payload, constants.EthernetIpv6Proto)
+}
+
+func ipv6LocalDeliver(node *data.Node, iif *data.Interface, ipHeader data.IPv6Header, payload data.Payload) {
+	appData := payload[constants.Ipv6HeaderSize : constants.Ipv6HeaderSize+int(ipHeader.PayloadLength)]
+
+	switch ipHeader.NextHeader {
+	case constants.Icmpv6Proto:
+		processICMPv6Message(node, iif, ipHeader, appData)
+	default:
+		break
+	}
+}
+
+func ipv6Payload(ipHeader data.IPv6Header, appData []byte) data.Payload {
+	var payload data.Payload
+	ipHeader.PayloadLength = uint16(len(appData))
+	copy(payload[:], ipHeader.SerializeIPv6Header())
+	copy(payload[constants.Ipv6HeaderSize:], appData)
+	return payload
+}
+
+// PacketSendIPv6 routes appData to destinationIP in vrf, an unspecified sourceIP is replaced by the
+// global address of the outgoing interface.
+func PacketSendIPv6(node *data.Node, vrf *data.Vrf, sourceIP data.IPAddress, appData []byte, nextHeader uint8, destinationIP data.IPAddress) error {
+	if destinationIP.IsLinkLocalUnicast() || destinationIP.IsMulticast() {
+		return errors.New("link-local destination requires an outgoing interface")
+	}
+	if len(appData) > constants.MaxPayloadSize-constants.Ipv6HeaderSize {
+		return errors.New("packet too large")
+	}
+
+	route := node.VrfRoutingTable(vrf).LookupRoutingTableLPM(destinationIP)
+	if route == nil {
+		return errors.New("no route found")
+	}
+	if route.IsBlackhole {
+		return fmt.Errorf("packet to %s discarded by blackhole route", destinationIP.String())
+	}
+
+	var gatewayIP data.IPAddress
+	var oif *data.Interface
+	if route.IsDirect {
+		gatewayIP = destinationIP
+		if vrf != nil {
+			// a nil interface would resolve the neighbor in the default VRF
+			if oif = node.GetVrfMatchingSubnetInterface(destinationIP, vrf); oif == nil {
+				return errors.New("no eligible subnet for neighbor solicitation")
+			}
+		}
+	} else {
+		var ok bool
+		if gatewayIP, oif, ok = resolveNextHop(node, vrf, route, 0); !ok {
+			return errors.New("next hop unreachable")
+		}
+	}
+
+	if sourceIP.IsUnspecified() {
+		sourceInterface := oif
+		if sourceInterface == nil {
+			sourceInterface = node.GetVrfMatchingSubnetInterface(destinationIP, vrf)
+		}
+		if sourceInterface == nil || sourceInterface.Properties.IPv6.IsUnspecified() {
+			return errors.New("no IPv6 source address")
+		}
+		sourceIP = sourceInterface.Properties.IPv6
+	}
+
+	ipHeader := data.IPv6Header{
+		NextHeader:    nextHeader,
+		HopLimit:      constants.Ipv6DefaultHopLimit,
+		SourceIP:      sourceIP,
+		DestinationIP: destinationIP,
+	}
+	PacketDemoteToLayer2(node, gatewayIP, oif, ipv6Payload(ipHeader, appData), constants.EthernetIpv6Proto)
+	return nil
+}
+
+// packetSendIPv6OnLink sends appData to a neighbor on the link of oif, the source is the link-local
+// address of oif unless the caller picks one.
+func packetSendIPv6OnLink(node *data.Node, oif *data.Interface, sourceIP data.IPAddress, destinationIP data.IPAddress, nextHeader uint8, appData []byte) {
+	if sourceIP.IsUnspecified() {
+		sourceIP = oif.Properties.LinkLocalIP
+	}
+	ipHeader := data.IPv6Header{
+		NextHeader:    nextHeader,
+		HopLimit:      constants.Ipv6DefaultHopLimit,
+		SourceIP:      sourceIP,
+		DestinationIP: destinationIP,
+	}
+	PacketDemoteToLayer2(node, destinationIP, oif, ipv6Payload(ipHeader, appData), constants.EthernetIpv6Proto)
+}
+
+// ipv6Frame builds a frame to a known MAC address, used by neighbor discovery which cannot wait for
+// the resolution it performs.
+func ipv6Frame(oif *data.Interface, sourceIP data.IPAddress, destinationIP data.IPAddress, destinationMAC data.MacAddress, nextHeader uint8, hopLimit uint8, appData []byte) *data.EthernetHeader {
+	ipHeader := data.IPv6Header{
+		NextHeader:    nextHeader,
+		HopLimit:      hopLimit,
+		SourceIP:      sourceIP,
+		DestinationIP: destinationIP,
+	}
+	ethernetHeader := &data.EthernetHeader{
+		Type:    constants.EthernetIpv6Proto,
+		Payload: ipv6Payload(ipHeader, appData),
+	}
+	copy(ethernetHeader.DestinationMAC[:], destinationMAC[:])
+	copy(ethernetHeader.SourceMAC[:], oif.Properties.MAC[:])
+	return ethernetHeader
+}
+
+// FlowHashIPv6 hashes the addresses, next header and flow label, every packet of a flow maps to the same ECMP next hop.
+func FlowHashIPv6(ipHeader data.IPv6Header) uint32 {
+	hash := fnv.New32a()
+	hash.Write(ipHeader.SourceIP[:])
+	hash.Write(ipHeader.DestinationIP[:])
+	hash.Write([]byte{ipHeader.NextHeader, uint8(ipHeader.FlowLabel >> 16), uint8(ipHeader.FlowLabel >> 8), uint8(ipHeader.FlowLabel)})
+	return hash.Sum32()
+}
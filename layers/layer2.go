@@ -18,10 +18,27 @@ func IsFrameReceivedOnIntfQualifying(intf *data.Interface, packet data.Packet, o
 		ethernetHeader = packet.DeserializeEthernetHeader()
 	}
 
-	if !intf.Properties.IsIpConfigured && intf.Properties.IntfL2Mode == constants.L2ModeUnknown {
+	if !intf.IsL3Mode() && intf.Properties.IntfL2Mode == constants.L2ModeUnknown {
 		return false
 	}
 
+	if intf.Properties.IntfL2Mode == constants.PROVIDER_EDGE {
+		// customer frames are accepted tagged or untagged and get the port S-VLAN pushed on top
+		if intf.Properties.Vlans[0] == 0 || IsPacketSVLANTagged(packet) != nil {
+			return false
+		}
+		*outputVLANID = intf.Properties.Vlans[0]
+		return true
+	}
+
+	if intf.Properties.IntfL2Mode == constants.PROVIDER_CORE {
+		sTaggedHeader := IsPacketSVLANTagged(packet)
+		if sTaggedHeader == nil {
+			return false
+		}
+		return intf.IsTrunkInterfaceVLANEnabled(uint(sTaggedHeader.Tag.GetVlanID()))
+	}
+
 	if intf.Properties.IntfL2Mode == constants.ACCESS && intf.Properties.Vlans[0] == 0 {
 		if vlanEthernetHeader == nil {
 			return true
@@ -68,20 +85,53 @@ func IsFrameReceivedOnIntfQualifying(intf *data.Interface, packet data.Packet, o
 		}
 	}
 
-	if intf.Properties.IsIpConfigured && vlanEthernetHeader != nil {
+	if intf.IsL3Mode() && vlanEthernetHeader != nil {
 		return false
 	}
 
-	if intf.Properties.IsIpConfigured && bytes.Equal(intf.Properties.MAC[:], ethernetHeader.DestinationMAC[:]) {
+	if intf.IsL3Mode() && bytes.Equal(intf.Properties.MAC[:], ethernetHeader.DestinationMAC[:]) {
+		return true
+	}
+
+	if intf.IsL3Mode() && bytes.Equal(constants.BroadcastMacAddress[:], ethernetHeader.DestinationMAC[:]) {
 		return true
 	}
 
-	if intf.Properties.IsIpConfigured && bytes.Equal(constants.BroadcastMacAddress[:], ethernetHeader.DestinationMAC[:]) {
+	if intf.IsL3Mode() && isMulticastMacAddress(ethernetHeader.DestinationMAC) {
+		return true
+	}
+
+	if intf.IsL3Mode() && isVRRPMasterMAC(intf, ethernetHeader.DestinationMAC) {
 		return true
 	}
 	return false
 }
 
+// isMulticastMacAddress reports the 01:00:5e and 33:33 group addresses IPv4 and IPv6 multicast map onto,
+// the group bit alone does not tell as the generated interface MACs may have it set.
+func isMulticastMacAddress(mac data.MacAddress) bool {
+	return (mac[0] == 0x01 && mac[1] == 0x00 && mac[2] == 0x5E) || (mac[0] == 0x33 && mac[1] == 0x33)
+}
+
+// ipMulticastMacAddress maps an IPv4 multicast group onto its 01:00:5e Ethernet group address.
+func ipMulticastMacAddress(IP data.IPAddress) data.MacAddress {
+	return data.MacAddress{0x01, 0x00, 0x5E, IP[13] & 0x7F, IP[14], IP[15]}
+}
+
+// ipv6MulticastMacAddress maps an IPv6 multicast group onto its 33:33 Ethernet group address.
+func ipv6MulticastMacAddress(IP data.IPAddress) data.MacAddress {
+	return data.MacAddress{0x33, 0x33, IP[12], IP[13], IP[14], IP[15]}
+}
+
+// sendNeighborRequest resolves the MAC address of IP with ARP, or with neighbor discovery for IPv6.
+func sendNeighborRequest(node *data.Node, oif *data.Interface, IP data.IPAddress) {
+	if IP.IsIPv4() {
+		SendARPBroadcastRequest(node, oif, IP)
+		return
+	}
+	SendNeighborSolicitation(node, oif, IP)
+}
+
 func SendARPBroadcastRequest(node *data.Node, oif *data.Interface, IP data.IPAddress) {
 	if oif == nil {
 		oif = node.GetMatchingSubnetInterface(IP)
@@ -113,6 +163,10 @@ func SendARPBroadcastRequest(node *data.Node, oif *data.Interface, IP data.IPAdd
 }
 
 func sendARPReplyMessage(ethernetHeaderIn *data.EthernetHeader, oif *data.Interface) {
+	sendARPReply(ethernetHeaderIn, oif, oif.Properties.IP, oif.Properties.MAC)
+}
+
+func sendARPReply(ethernetHeaderIn *data.EthernetHeader, oif *data.Interface, IP data.IPAddress, MAC data.MacAddress) {
 	arpHeaderIn := data.DeserializeArpHeader(ethernetHeaderIn.Payload[:])
 
 	arpHeader := data.ArpHeader{
@@ -122,16 +176,41 @@ func sendARPReplyMessage(ethernetHeaderIn *data.EthernetHeader, oif *data.Interf
 		ProtocolAddressLength: 4,
 		OpCode:                constants.ArpReply,
 	}
-	copy(arpHeader.SourceIP[:], oif.Properties.IP[:])
+	copy(arpHeader.SourceIP[:], IP[:])
 	copy(arpHeader.DestinationIP[:], arpHeaderIn.SourceIP[:])
-	copy(arpHeader.SourceMAC[:], oif.Properties.MAC[:])
+	copy(arpHeader.SourceMAC[:], MAC[:])
 	copy(arpHeader.DestinationMAC[:], arpHeaderIn.SourceMAC[:])
 
 	ethernetHeader := &data.EthernetHeader{
 		Type: constants.ArpMessage,
 	}
 	copy(ethernetHeader.DestinationMAC[:], arpHeaderIn.SourceMAC[:])
-	copy(ethernetHeader.SourceMAC[:], oif.Properties.MAC[:])
+	copy(ethernetHeader.SourceMAC[:], MAC[:])
+	copy(ethernetHeader.Payload[:], arpHeader.SerializeArpHeader())
+
+	send.PacketSend((*ethernetHeader).SerializeEthernetHeader(), oif)
+}
+
+// SendGratuitousARP broadcasts an unsolicited ARP reply, neighbors update their ARP tables and
+// switches learn the port of the MAC address.
+func SendGratuitousARP(oif *data.Interface, IP data.IPAddress, MAC data.MacAddress) {
+	arpHeader := data.ArpHeader{
+		HardwareType:          1,
+		ProtocolType:          constants.EthernetIpProto,
+		HardwareAddressLength: 6,
+		ProtocolAddressLength: 4,
+		OpCode:                constants.ArpReply,
+	}
+	copy(arpHeader.SourceIP[:], IP[:])
+	copy(arpHeader.DestinationIP[:], IP[:])
+	copy(arpHeader.SourceMAC[:], MAC[:])
+	copy(arpHeader.DestinationMAC[:], constants.BroadcastMacAddress[:])
+
+	ethernetHeader := &data.EthernetHeader{
+		Type: constants.ArpMessage,
+	}
+	copy(ethernetHeader.DestinationMAC[:], constants.BroadcastMacAddress[:])
+	copy(ethernetHeader.SourceMAC[:], MAC[:])
 	copy(ethernetHeader.Payload[:], arpHeader.SerializeArpHeader())
 
 	send.PacketSend((*ethernetHeader).SerializeEthernetHeader(), oif)
@@ -140,7 +219,7 @@ func sendARPReplyMessage(ethernetHeaderIn *data.EthernetHeader, oif *data.Interf
 func processARPReplyMessage(node *data.Node, iif *data.Interface, ethernetHdr *data.EthernetHeader) {
 	fmt.Println("processARPReplyMessage: ARP reply message received on interface", iif.Name.String(),"of node", node.NodeName)
 
-	UpdateFromArpReply(node.Properties.ArpTable, data.DeserializeArpHeader(ethernetHdr.Payload[:]), iif)
+	UpdateFromArpReply(node.VrfArpTable(iif.Properties.Vrf), data.DeserializeArpHeader(ethernetHdr.Payload[:]), iif)
 }
 
 func processARPBroadcastRequest(node *data.Node, iif *data.Interface, ethernetHdr *data.EthernetHeader) {
@@ -148,6 +227,12 @@ func processARPBroadcastRequest(node *data.Node, iif *data.Interface, ethernetHd
 
 	arpHdr := data.DeserializeArpHeader(ethernetHdr.Payload[:])
 
+	if virtualMAC, ok := vrrpMasterMAC(node, iif, arpHdr.DestinationIP); ok {
+		// the master of a virtual router answers for the virtual IP with the virtual MAC
+		sendARPReply(ethernetHdr, iif, arpHdr.DestinationIP, virtualMAC)
+		return
+	}
+
 	if !bytes.Equal(iif.Properties.IP[:], arpHdr.DestinationIP[:]) {
 		fmt.Println("processARPBroadcastRequest: ARP Broadcast request message dropped, Destination IP address did not match")
 		return
@@ -160,6 +245,11 @@ func FrameReceive(node *data.Node, intf *data.Interface, packet data.Packet) {
 	var vlanIdToTag uint = 0
 	ethernetHdr := packet.DeserializeEthernetHeader()
 
+	if ethernetHdr.Type == constants.LldpProto {
+		processLLDPFrame(node, intf, ethernetHdr)
+		return
+	}
+
 	if !IsFrameReceivedOnIntfQualifying(intf, packet, &vlanIdToTag) {
 		fmt.Println(node.NodeName, "rejected L2 Frame")
 		return
@@ -167,7 +257,7 @@ func FrameReceive(node *data.Node, intf *data.Interface, packet data.Packet) {
 
 	fmt.Println(node.NodeName, " accepted L2 Frame")
 
-	if intf.Properties.IsIpConfigured {
+	if intf.IsL3Mode() {
 		switch ethernetHdr.Type {
 		case constants.ArpMessage:
 			arpHdr := data.DeserializeArpHeader(ethernetHdr.Payload[:])
@@ -180,7 +270,7 @@ func FrameReceive(node *data.Node, intf *data.Interface, packet data.Packet) {
 				break
 			}
 		default:
-			PacketPromoteToLayer3(node, ethernetHdr.Payload, ethernetHdr.Type)
+			PacketPromoteToLayer3(node, intf, ethernetHdr.Payload, ethernetHdr.Type)
 		}
 	} else if intf.Properties.IntfL2Mode == constants.ACCESS || intf.Properties.IntfL2Mode == constants.TRUNK {
 		if vlanIdToTag != 0 {
@@ -188,6 +278,11 @@ func FrameReceive(node *data.Node, intf *data.Interface, packet data.Packet) {
 			copy(packet[:], taggedPacket[:])
 		}
 		SwitchFrameReceive(intf, packet)
+	} else if intf.Properties.IntfL2Mode == constants.PROVIDER_EDGE || intf.Properties.IntfL2Mode == constants.PROVIDER_CORE {
+		if vlanIdToTag != 0 {
+			packet = PushSVLANTag(packet, vlanIdToTag)
+		}
+		SwitchFrameReceive(intf, packet)
 	} else {
 		return //drop packet
 	}
@@ -195,9 +290,9 @@ func FrameReceive(node *data.Node, intf *data.Interface, packet data.Packet) {
 }
 
 func FrameReceiveFromTop(node *data.Node, gatewayIP data.IPAddress, intf *data.Interface, payload data.Payload, protocolNumber uint16) {
-	if protocolNumber == constants.EthernetIpProto {
+	if protocolNumber == constants.EthernetIpProto || protocolNumber == constants.EthernetIpv6Proto || protocolNumber == constants.EthernetMplsProto {
 		ethernetHeader := &data.EthernetHeader{
-			Type: constants.EthernetIpProto,
+			Type: protocolNumber,
 		}
 		copy(ethernetHeader.Payload[:], payload[:])
 		ForwardFrame(node, gatewayIP, intf, ethernetHeader)
@@ -210,25 +305,17 @@ func ForwardFrame(node *data.Node, gatewayIP data.IPAddress, intf *data.Interfac
 
 	if intf != nil {
 		oif = intf
-
-		arpEntry = data.ArpTableLookup(node.Properties.ArpTable, gatewayIP)
-		if arpEntry == nil {
-			CreateArpSaneEntry(node.Properties.ArpTable, gatewayIP, ethernetHeader.SerializeEthernetHeader())
-			SendARPBroadcastRequest(node, oif, gatewayIP)
+	} else {
+		if IsRouteLocalDelivery(node, gatewayIP) {
+			packetLoopback(node, ethernetHeader.Payload, ethernetHeader.Type)
 			return
-		} else {
-			goto framePrepare
 		}
-	}
-	if IsRouteLocalDelivery(node, gatewayIP) {
-		PacketPromoteToLayer3(node, ethernetHeader.Payload, ethernetHeader.Type)
-		return
-	}
 
-	oif = node.GetMatchingSubnetInterface(gatewayIP)
-	if oif == nil {
-		fmt.Println("No eligible subnet for ARP resolution")
-		return
+		oif = node.GetMatchingSubnetInterface(gatewayIP)
+		if oif == nil {
+			fmt.Println("No eligible subnet for ARP resolution")
+			return
+		}
 	}
 
 	if bytes.Equal(oif.Properties.IP[:], gatewayIP[:]) {
@@ -238,17 +325,22 @@ func ForwardFrame(node *data.Node, gatewayIP data.IPAddress, intf *data.Interfac
 		return
 	}
 
-	arpEntry = data.ArpTableLookup(node.Properties.ArpTable, gatewayIP)
+	// neighbors are resolved in the ARP table of the VRF of the outgoing interface
+	arpTable := node.VrfArpTable(oif.Properties.Vrf)
+	arpTable.Mutex.Lock()
+	arpEntry = data.ArpTableLookup(arpTable, gatewayIP)
 
 	if arpEntry == nil || (arpEntry != nil && arpEntry.IsSane) {
-		CreateArpSaneEntry(node.Properties.ArpTable, gatewayIP, ethernetHeader.SerializeEthernetHeader())
-		SendARPBroadcastRequest(node, oif, gatewayIP)
+		CreateArpSaneEntry(arpTable, gatewayIP, ethernetHeader.SerializeEthernetHeader())
+		arpTable.Mutex.Unlock()
+		sendNeighborRequest(node, oif, gatewayIP)
 		return
 	}
+	MAC := arpEntry.MAC
+	arpTable.Mutex.Unlock()
 
-framePrepare:
 	copy(ethernetHeader.SourceMAC[:], oif.Properties.MAC[:])
-	copy(ethernetHeader.DestinationMAC[:], arpEntry.MAC[:])
+	copy(ethernetHeader.DestinationMAC[:], MAC[:])
 	send.PacketSend(ethernetHeader.SerializeEthernetHeader(), oif)
 }
 
@@ -259,13 +351,11 @@ func pendingArpEntryCallback(node *data.Node, intf *data.Interface, arpEntry *da
 	send.PacketSend(ethernetHeader.SerializeEthernetHeader(), intf)
 }
 
+// CreateArpSaneEntry queues packet until IP is resolved, the caller holds the lock of the table.
 func CreateArpSaneEntry(arpTable *data.ArpTable, ipAddress data.IPAddress, packet data.Packet) {
 	arpEntry := data.ArpTableLookup(arpTable, ipAddress)
 
 	if arpEntry != nil {
-		if !arpEntry.IsSane {
-			panic("Arp Entry is not sane")
-		}
 		arpEntry.AddPendingArpTableEntry(pendingArpEntryCallback, packet)
 		return
 	}
@@ -274,22 +364,29 @@ func CreateArpSaneEntry(arpTable *data.ArpTable, ipAddress data.IPAddress, packe
 	copy(arpEntry.IP[:], ipAddress[:])
 	arpEntry.IsSane = true
 	(*arpEntry).AddPendingArpTableEntry(pendingArpEntryCallback, packet)
-	if !data.AddArpTableEntry(arpTable, arpEntry, nil) {
-		panic("Arp Entry already exists")
-	}
+	data.AddArpTableEntry(arpTable, arpEntry, nil)
 }
 
 func UpdateFromArpReply(arpTable *data.ArpTable, arpHeader *data.ArpHeader, intf *data.Interface) {
 	if arpHeader.OpCode != constants.ArpReply {
 		panic("Not an Arp Reply")
 	}
+	updateNeighborEntry(arpTable, arpHeader.SourceIP, arpHeader.SourceMAC, intf)
+}
+
+// updateNeighborEntry records the MAC address of IP learned from an ARP reply or a neighbor
+// advertisement and sends the frames waiting for the resolution.
+func updateNeighborEntry(arpTable *data.ArpTable, IP data.IPAddress, MAC data.MacAddress, intf *data.Interface) {
+	arpTable.Mutex.Lock()
+	defer arpTable.Mutex.Unlock()
+
 	var arpPendingList *data.Dll = nil
 	arpEntry := &data.ArpEntry{
 		IsSane: false,
 	}
 
-	copy(arpEntry.IP[:], arpHeader.SourceIP[:])
-	copy(arpEntry.MAC[:], arpHeader.SourceMAC[:])
+	copy(arpEntry.IP[:], IP[:])
+	copy(arpEntry.MAC[:], MAC[:])
 	copy(arpEntry.InterfaceName[:], intf.Name[:])
 
 	rc := data.AddArpTableEntry(arpTable, arpEntry, &arpPendingList)
@@ -299,7 +396,7 @@ func UpdateFromArpReply(arpTable *data.ArpTable, arpHeader *data.ArpHeader, intf
 			arpPendingEntry.ArpCallback(intf.Node, intf, arpEntry, arpPendingEntry)
 			arpPendingEntry.ArpPendingEntryGlue.RemoveNode()
 		}
-		arpPendingList.DllToArpEntry().IsSane = false
+		data.ArpTableLookup(arpTable, IP).IsSane = false
 	}
 	if !rc {
 		arpEntry.DeleteArpEntry()
@@ -314,8 +411,9 @@ func SetIntfL2Mode(node *data.Node, intfName string, mode int) {
 		panic("Invalid L2 mode option")
 	}
 
-	if intf.Properties.IsIpConfigured {
+	if intf.IsL3Mode() {
 		intf.Properties.IsIpConfigured = false
+		intf.Properties.IsIpv6Enabled = false
 		intf.Properties.IsIpConfigBackup = true
 		intf.Properties.IntfL2Mode = mode
 		return
@@ -330,34 +428,35 @@ func SetIntfL2Mode(node *data.Node, intfName string, mode int) {
 		return
 	}
 
+	// the VLAN of an access port stays a member when the port becomes a trunk. Any other change
+	// resets the VLANs: the trunk VLANs do not carry over to an access port, the S-VLAN of a
+	// provider edge port is not a core VLAN, and customer and provider ports use different VLAN IDs
 	if intf.Properties.IntfL2Mode == constants.ACCESS && mode == constants.TRUNK {
 		intf.Properties.IntfL2Mode = mode
 		return
 	}
 
-	if intf.Properties.IntfL2Mode == constants.TRUNK && mode == constants.ACCESS {
-		intf.Properties.IntfL2Mode = mode
+	intf.Properties.IntfL2Mode = mode
 
-		for i := 0; i < int(constants.MaxVlanMembership); i++ {
-			intf.Properties.Vlans[i] = 0
-		}
+	for i := 0; i < int(constants.MaxVlanMembership); i++ {
+		intf.Properties.Vlans[i] = 0
 	}
 }
 
 func SetIntfVLAN(node *data.Node, intfName string, vlanID uint) {
 	intf := node.GetNodeIntfByName(intfName)
 
-	if intf.Properties.IsIpConfigured {
+	if intf.IsL3Mode() {
 		fmt.Printf("Error: Interface %s: L3 mode enabled\n", intf.Name.String())
 		return
 	}
 
-	if intf.Properties.IntfL2Mode != constants.ACCESS && intf.Properties.IntfL2Mode != constants.TRUNK {
+	if intf.Properties.IntfL2Mode == constants.L2ModeUnknown {
 		fmt.Printf("Error: Interface %s: L2 mode not enabled\n", intf.Name.String())
 		return
 	}
 
-	if intf.Properties.IntfL2Mode == constants.ACCESS {
+	if intf.Properties.IntfL2Mode == constants.ACCESS || intf.Properties.IntfL2Mode == constants.PROVIDER_EDGE {
 		for i, v := range intf.Properties.Vlans {
 			if v != 0 {
 				intf.Properties.Vlans[i] = vlanID
@@ -368,7 +467,7 @@ func SetIntfVLAN(node *data.Node, intfName string, vlanID uint) {
 		return
 	}
 
-	if intf.Properties.IntfL2Mode == constants.TRUNK {
+	if intf.Properties.IntfL2Mode == constants.TRUNK || intf.Properties.IntfL2Mode == constants.PROVIDER_CORE {
 		for i, v := range intf.Properties.Vlans {
 			if v == 0 {
 				intf.Properties.Vlans[i] = vlanID
@@ -390,6 +489,78 @@ func IsPacketVLANTagged(packet data.Packet) *data.VLANEthernetHeader {
 	return nil
 }
 
+func IsPacketSVLANTagged(packet data.Packet) *data.VLANEthernetHeader {
+	vlanEthernetHeader := packet.DeserializeVLANEthernetHeader()
+
+	if vlanEthernetHeader.Tag.TPID == constants.Vlan8021adProto {
+		return vlanEthernetHeader
+	}
+	return nil
+}
+
+// PushSVLANTag pushes an outer 802.1ad S-tag onto a customer frame, an existing C-tag is kept as the inner tag.
+func PushSVLANTag(packet data.Packet, sVlanID uint) data.Packet {
+	cTaggedHeader := IsPacketVLANTagged(packet)
+
+	if cTaggedHeader != nil {
+		qinqEthernetHeader := &data.QinQEthernetHeader{
+			STag: data.SetSVLAN(sVlanID),
+			CTag: cTaggedHeader.Tag,
+			Type: cTaggedHeader.Type,
+			FCS:  cTaggedHeader.FCS,
+		}
+		copy(qinqEthernetHeader.DestinationMAC[:], cTaggedHeader.DestinationMAC[:])
+		copy(qinqEthernetHeader.SourceMAC[:], cTaggedHeader.SourceMAC[:])
+		copy(qinqEthernetHeader.Payload[:], cTaggedHeader.Payload[:])
+
+		return qinqEthernetHeader.SerializeQinQEthernetHeader()
+	}
+
+	ethernetHeader := packet.DeserializeEthernetHeader()
+	sTaggedHeader := &data.VLANEthernetHeader{
+		Tag:  data.SetSVLAN(sVlanID),
+		Type: ethernetHeader.Type,
+		FCS:  ethernetHeader.FCS,
+	}
+	copy(sTaggedHeader.DestinationMAC[:], ethernetHeader.DestinationMAC[:])
+	copy(sTaggedHeader.SourceMAC[:], ethernetHeader.SourceMAC[:])
+	copy(sTaggedHeader.Payload[:], ethernetHeader.Payload[:])
+
+	return sTaggedHeader.SerializeVLANEthernetHeader()
+}
+
+// PopSVLANTag removes the outer 802.1ad S-tag and returns the customer frame as it was received on the provider edge.
+func PopSVLANTag(packet data.Packet) data.Packet {
+	sTaggedHeader := IsPacketSVLANTagged(packet)
+	if sTaggedHeader == nil {
+		return packet
+	}
+
+	if sTaggedHeader.Type == constants.Vlan8021qProto {
+		qinqEthernetHeader := packet.DeserializeQinQEthernetHeader()
+		cTaggedHeader := &data.VLANEthernetHeader{
+			Tag:  qinqEthernetHeader.CTag,
+			Type: qinqEthernetHeader.Type,
+			FCS:  qinqEthernetHeader.FCS,
+		}
+		copy(cTaggedHeader.DestinationMAC[:], qinqEthernetHeader.DestinationMAC[:])
+		copy(cTaggedHeader.SourceMAC[:], qinqEthernetHeader.SourceMAC[:])
+		copy(cTaggedHeader.Payload[:], qinqEthernetHeader.Payload[:])
+
+		return cTaggedHeader.SerializeVLANEthernetHeader()
+	}
+
+	ethernetHeader := &data.EthernetHeader{
+		Type: sTaggedHeader.Type,
+		FCS:  sTaggedHeader.FCS,
+	}
+	copy(ethernetHeader.DestinationMAC[:], sTaggedHeader.DestinationMAC[:])
+	copy(ethernetHeader.SourceMAC[:], sTaggedHeader.SourceMAC[:])
+	copy(ethernetHeader.Payload[:], sTaggedHeader.Payload[:])
+
+	return ethernetHeader.SerializeEthernetHeader()
+}
+
 func TagPacketWithVLANId(packet data.Packet, vlanID uint) *data.VLANEthernetHeader {
 	vlanEthernetHeader := IsPacketVLANTagged(packet)
 
@@ -451,7 +622,8 @@ func DeleteMacTableEntry(macTable *data.MacTable, mac data.MacAddress) {
 func AddMacTableEntry(macTable *data.MacTable, macEntry *data.MacEntry) bool {
 	oldEntry := MacTableLookup(macTable, macEntry.MAC)
 
-	if oldEntry != nil && bytes.Equal(oldEntry.MAC[:], macEntry.MAC[:]) && bytes.Equal(oldEntry.InterfaceName[:], macEntry.InterfaceName[:]) {
+	if oldEntry != nil && bytes.Equal(oldEntry.MAC[:], macEntry.MAC[:]) && bytes.Equal(oldEntry.InterfaceName[:], macEntry.InterfaceName[:]) &&
+		oldEntry.IsRemote == macEntry.IsRemote && oldEntry.VtepIP == macEntry.VtepIP {
 		return false
 	}
 
@@ -485,12 +657,18 @@ func switchFrameForward(node *data.Node, intf *data.Interface, packet data.Packe
 	ethernetHeader := packet.DeserializeEthernetHeader()
 	if bytes.Equal(ethernetHeader.DestinationMAC[:], constants.BroadcastMacAddress[:]) {
 		FloodPacket(node, intf, packet)
+		vxlanForward(node, packet, nil)
 		return
 	}
 	macEntry := MacTableLookup(node.Properties.MacTable, ethernetHeader.DestinationMAC)
 
 	if macEntry == nil {
 		FloodPacket(node, intf, packet)
+		vxlanForward(node, packet, nil)
+		return
+	}
+	if macEntry.IsRemote {
+		vxlanForward(node, packet, &macEntry.VtepIP)
 		return
 	}
 	oif := node.GetNodeIntfByName(macEntry.InterfaceName.String())
@@ -505,7 +683,8 @@ func FloodPacket(node *data.Node, excludedIntf *data.Interface, packet data.Pack
 		if intf == nil {
 			return
 		}
-		if bytes.Equal(intf.Name[:], excludedIntf.Name[:]) {
+		// routed interfaces of a VTEP are not part of the switched network
+		if intf.IsL3Mode() || (excludedIntf != nil && bytes.Equal(intf.Name[:], excludedIntf.Name[:])) {
 			continue
 		}
 		SwitchSendPacketOut(packet, intf)
@@ -513,7 +692,7 @@ func FloodPacket(node *data.Node, excludedIntf *data.Interface, packet data.Pack
 }
 
 func SwitchSendPacketOut(packet data.Packet, intf *data.Interface) bool {
-	if intf.Properties.IsIpConfigured {
+	if intf.IsL3Mode() {
 		panic("Invalid operation: Attempting to send a packet out of an L3 mode interface")
 	}
 
@@ -521,9 +700,29 @@ func SwitchSendPacketOut(packet data.Packet, intf *data.Interface) bool {
 		return false
 	}
 
+	sTaggedHeader := IsPacketSVLANTagged(packet)
 	vlanEthernetHeader := IsPacketVLANTagged(packet)
 
+	// S-tagged frames never leave the provider network through a customer facing port
+	if sTaggedHeader != nil && intf.Properties.IntfL2Mode != constants.PROVIDER_EDGE && intf.Properties.IntfL2Mode != constants.PROVIDER_CORE {
+		return false
+	}
+
 	switch intf.Properties.IntfL2Mode {
+	case constants.PROVIDER_EDGE:
+		if sTaggedHeader == nil || intf.Properties.Vlans[0] != uint(sTaggedHeader.Tag.GetVlanID()) {
+			return false
+		}
+		send.PacketSend(PopSVLANTag(packet), intf)
+		return true
+
+	case constants.PROVIDER_CORE:
+		if sTaggedHeader != nil && intf.IsTrunkInterfaceVLANEnabled(uint(sTaggedHeader.Tag.GetVlanID())) {
+			send.PacketSend(packet, intf)
+			return true
+		}
+		return false
+
 	case constants.ACCESS:
 
 		if intf.Properties.Vlans[0] == 0 && vlanEthernetHeader == nil {
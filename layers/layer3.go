@@ -3,91 +3,313 @@ package layers
 import (
 	"bytes"
 	"fmt"
+	"hash/fnv"
+	"net"
+	"tcpip/cmd/communication/send"
 	"tcpip/constants"
 	"tcpip/data"
 	"unsafe"
 )
 
-func PacketReceive(node *data.Node, payload data.Payload) {
+func PacketReceive(node *data.Node, iif *data.Interface, payload data.Payload) {
 	ipHeader := data.DeserializeIPHeader(payload[:unsafe.Sizeof(data.IPHeader{})])
+	if !aclPermits(node, iif, constants.AclDirectionIn, ipHeader, &payload) {
+		return
+	}
+
+	if isLinkLocalDestination(ipHeader.DestinationIP) {
+		ipLocalDeliver(node, iif, ipHeader, payload)
+		return
+	}
+	natTranslateInbound(node, iif, &ipHeader, &payload)
+
+	// the packet is routed in the VRF of the interface it was received on
+	vrf := intfVrf(iif)
+	isLocalDelivery := IsVrfLocalDelivery(node, vrf, ipHeader.DestinationIP)
+
+	// policy routing overrides the routing table for the transit packets its route map matches
+	if !isLocalDelivery {
+		if gatewayIP, oif, ok := routeMapNextHop(node, iif, ipHeader, &payload); ok {
+			if !ipDecrementTTL(node, iif, &ipHeader, &payload) {
+				return
+			}
+			if !ipForwardPermits(node, iif, oif, &ipHeader, &payload) {
+				return
+			}
+			ipOutput(node, gatewayIP, oif, payload)
+			return
+		}
+	}
 
-	route := node.Properties.RoutingTable.LookupRoutingTableLPM(ipHeader.DestinationIP)
+	route := node.VrfRoutingTable(vrf).LookupRoutingTableLPM(ipHeader.DestinationIP)
 	if route == nil {
 		fmt.Println("No route found")
 		return
 	}
+	if route.IsBlackhole {
+		fmt.Println("Packet to", ipHeader.DestinationIP.String(), "discarded by blackhole route")
+		return
+	}
 	if route.IsDirect {
-		if IsRouteLocalDelivery(node, ipHeader.DestinationIP) {
-			switch ipHeader.Protocol {
-			case constants.IcmpProto:
-				fmt.Println("IP Address: ", ipHeader.DestinationIP.String(), ", ping received")
-			case constants.IpInIpProto:
-				_payload := data.Payload{}
-				copy(_payload[:], payload[unsafe.Sizeof(data.IPHeader{}):])
-				PacketReceive(node, _payload)
-			default:
-				break
-			}
-
+		if isLocalDelivery {
+			ipLocalDeliver(node, iif, ipHeader, payload)
 		} else {
-			PacketDemoteToLayer2(node, data.IPAddress{}, nil, payload, constants.EthernetIpProto)
+			oif := node.GetVrfMatchingSubnetInterface(ipHeader.DestinationIP, vrf)
+			if oif == nil {
+				fmt.Println("No eligible subnet for ARP resolution")
+				return
+			}
+			if !ipDecrementTTL(node, iif, &ipHeader, &payload) {
+				return
+			}
+			if !ipForwardPermits(node, iif, oif, &ipHeader, &payload) {
+				return
+			}
+			ipOutput(node, ipHeader.DestinationIP, oif, payload)
 		}
 	} else {
-		ipHeader.TTL--
-		if ipHeader.TTL == 0 {
-			fmt.Println("TTL expired")
+		if !ipDecrementTTL(node, iif, &ipHeader, &payload) {
+			return
+		}
+		gatewayIP, oif, ok := resolveNextHop(node, vrf, route, FlowHash(ipHeader, payload))
+		if !ok {
+			return
+		}
+		if !ipForwardPermits(node, iif, oif, &ipHeader, &payload) {
+			return
+		}
+		// label switched paths are set up in the default VRF only
+		if vrf == nil && mplsImpose(node, route, ipHeader, payload) {
 			return
 		}
-		PacketDemoteToLayer2(node, route.GatewayIP, node.GetNodeIntfByName(route.InterfaceName.String()), payload, constants.EthernetIpProto)
+		ipOutput(node, gatewayIP, oif, payload)
+	}
+}
+
+// ipDecrementTTL counts the hop of a routed packet in its header and payload. A packet whose TTL
+// runs out is dropped and reported to its source with an ICMP time exceeded message.
+func ipDecrementTTL(node *data.Node, iif *data.Interface, ipHeader *data.IPHeader, payload *data.Payload) bool {
+	if ipHeader.TTL <= 1 {
+		fmt.Println("TTL expired")
+		sendICMPTimeExceeded(node, iif, *ipHeader, *payload)
+		return false
+	}
+	ipHeader.TTL--
+	payload[ipTTLOffset] = ipHeader.TTL
+	return true
+}
+
+// ipForwardPermits runs the checks of a packet routed from iif to oif: the firewall, source NAT and
+// the outbound access list of oif.
+func ipForwardPermits(node *data.Node, iif *data.Interface, oif *data.Interface, ipHeader *data.IPHeader, payload *data.Payload) bool {
+	return firewallPermits(node, iif, oif, *ipHeader, payload) && natTranslateOutbound(node, iif, oif, ipHeader, payload) &&
+		aclPermits(node, oif, constants.AclDirectionOut, *ipHeader, payload)
+}
+
+// isLinkLocalDestination reports the limited broadcast and IPv4 multicast destinations, they are
+// consumed by the receiving node and never routed.
+func isLinkLocalDestination(IP data.IPAddress) bool {
+	return net.IP(IP[:]).IsMulticast() || IP == constants.LimitedBroadcastIP
+}
+
+func ipLocalDeliver(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, payload data.Payload) {
+	switch ipHeader.Protocol {
+	case constants.IcmpProto:
+		processICMPMessage(node, iif, ipHeader, payload)
+	case constants.IpInIpProto, constants.GreProto:
+		tunnelDecapsulate(node, iif, ipHeader, payload)
+	case constants.UdpProto:
+		UDPReceive(node, iif, ipHeader, payload)
+	case constants.TcpProto:
+		TCPReceive(node, ipHeader, payload)
+	case constants.OspfProto:
+		processOSPFPacket(node, iif, ipHeader, payload[unsafe.Sizeof(data.IPHeader{}):])
+	case constants.VrrpProto:
+		processVRRPAdvertisement(node, iif, ipHeader, payload[unsafe.Sizeof(data.IPHeader{}):])
+	default:
+		break
 	}
 }
 
+// PacketSendLinkLocal sends appData in an IP packet with a TTL of one out of oif, used by protocols
+// talking to their direct neighbors over multicast or broadcast.
+func PacketSendLinkLocal(node *data.Node, oif *data.Interface, destinationIP data.IPAddress, protocolNumber uint8, appData []byte) {
+	send.PacketSend(linkLocalFrame(oif, destinationIP, protocolNumber, appData).SerializeEthernetHeader(), oif)
+}
+
+func linkLocalFrame(oif *data.Interface, destinationIP data.IPAddress, protocolNumber uint8, appData []byte) *data.EthernetHeader {
+	ipHeader := &data.IPHeader{}
+	ipHeader.Init()
+
+	ipHeader.Protocol = protocolNumber
+	ipHeader.TTL = 1
+	copy(ipHeader.SourceIP[:], oif.Properties.IP[:])
+	copy(ipHeader.DestinationIP[:], destinationIP[:])
+	ipHeader.IHL = uint8(unsafe.Sizeof(data.IPHeader{}) / 4)
+	ipHeader.Length = uint16(unsafe.Sizeof(data.IPHeader{})) + uint16(len(appData))
+
+	ethernetHeader := &data.EthernetHeader{
+		Type: constants.EthernetIpProto,
+	}
+	copy(ethernetHeader.Payload[:], ipHeader.SerializeIPHeader())
+	copy(ethernetHeader.Payload[unsafe.Sizeof(data.IPHeader{}):], appData)
+
+	if destinationIP == constants.LimitedBroadcastIP {
+		copy(ethernetHeader.DestinationMAC[:], constants.BroadcastMacAddress[:])
+	} else {
+		ethernetHeader.DestinationMAC = ipMulticastMacAddress(destinationIP)
+	}
+	copy(ethernetHeader.SourceMAC[:], oif.Properties.MAC[:])
+	return ethernetHeader
+}
+
 func PacketReceiveFromTop(node *data.Node, appData []byte, protocolNumber uint8, destinationIP data.IPAddress) {
+	PacketSendFromSource(node, node.Properties.LB, appData, protocolNumber, destinationIP)
+}
+
+// PacketSendFromSource routes appData to destinationIP in an IP packet with the given source address.
+func PacketSendFromSource(node *data.Node, sourceIP data.IPAddress, appData []byte, protocolNumber uint8, destinationIP data.IPAddress) {
+	ipSend(node, nil, newIPHeader(sourceIP, protocolNumber, destinationIP, len(appData)), appData)
+}
+
+func newIPHeader(sourceIP data.IPAddress, protocolNumber uint8, destinationIP data.IPAddress, length int) *data.IPHeader {
 	ipHeader := &data.IPHeader{}
 	ipHeader.Init()
 
 	ipHeader.Protocol = protocolNumber
 	copy(ipHeader.DestinationIP[:], destinationIP[:])
-	copy(ipHeader.SourceIP[:], node.Properties.LB[:])
+	copy(ipHeader.SourceIP[:], sourceIP[:])
 
 	ipHeader.IHL = uint8(unsafe.Sizeof(data.IPHeader{}) / 4)
+	ipHeader.Length = uint16(unsafe.Sizeof(data.IPHeader{})) + uint16(length)
+	return ipHeader
+}
 
-	route := node.Properties.RoutingTable.LookupRoutingTableLPM(ipHeader.DestinationIP)
+// ipSend routes appData behind ipHeader with the routing table of vrf.
+func ipSend(node *data.Node, vrf *data.Vrf, ipHeader *data.IPHeader, appData []byte) {
+	route := node.VrfRoutingTable(vrf).LookupRoutingTableLPM(ipHeader.DestinationIP)
 	if route == nil {
 		fmt.Println("No route found")
 		return
 	}
+	if route.IsBlackhole {
+		fmt.Println("Packet to", ipHeader.DestinationIP.String(), "discarded by blackhole route")
+		return
+	}
 	var payload data.Payload
 	gatewayIP := data.IPAddress{}
 
-	if !route.IsDirect {
-		copy(gatewayIP[:], route.GatewayIP[:])
-	} else {
-		copy(gatewayIP[:], ipHeader.DestinationIP[:])
-	}
-
 	ipHeaderSerialized := ipHeader.SerializeIPHeader()
 	copy(payload[:], ipHeaderSerialized[:])
 	copy(payload[unsafe.Sizeof(data.IPHeader{}):], appData[:])
 
 	if route.IsDirect {
-		fmt.Println("Route is direct")
-		PacketDemoteToLayer2(node, gatewayIP, nil, payload, constants.EthernetIpProto)
+		copy(gatewayIP[:], ipHeader.DestinationIP[:])
+		var oif *data.Interface
+		if vrf != nil {
+			// a nil interface would resolve the gateway in the default VRF
+			if oif = node.GetVrfMatchingSubnetInterface(gatewayIP, vrf); oif == nil {
+				fmt.Println("No eligible subnet for ARP resolution")
+				return
+			}
+		}
+		ipOutput(node, gatewayIP, oif, payload)
 	} else {
-		PacketDemoteToLayer2(node, gatewayIP, node.GetNodeIntfByName(route.InterfaceName.String()), payload, constants.EthernetIpProto)
+		if vrf == nil && mplsImpose(node, route, *ipHeader, payload) {
+			return
+		}
+		gatewayIP, oif, ok := resolveNextHop(node, vrf, route, FlowHash(*ipHeader, payload))
+		if !ok {
+			return
+		}
+		ipOutput(node, gatewayIP, oif, payload)
 	}
 
 }
 
+// resolveNextHop selects the next hop of route for the flow and resolves a gateway without
+// interface recursively through the routing table of vrf.
+func resolveNextHop(node *data.Node, vrf *data.Vrf, route *data.Layer3Route, flowHash uint32) (data.IPAddress, *data.Interface, bool) {
+	for depth := 0; depth < constants.MaxRecursiveRouteDepth; depth++ {
+		if route.IsBlackhole {
+			fmt.Println("Packet discarded by blackhole route")
+			return data.IPAddress{}, nil, false
+		}
+		nextHop := route.SelectNextHop(flowHash)
+		if nextHop == nil {
+			fmt.Println("No next hop found")
+			return data.IPAddress{}, nil, false
+		}
+		if nextHop.InterfaceName.String() != "" {
+			return nextHop.GatewayIP, node.GetNodeIntfOrTunnelByName(nextHop.InterfaceName.String()), true
+		}
+
+		gatewayRoute := node.VrfRoutingTable(vrf).LookupRoutingTableLPM(nextHop.GatewayIP)
+		if gatewayRoute == nil {
+			fmt.Println("Recursive next hop", nextHop.GatewayIP.String(), "is unreachable")
+			return data.IPAddress{}, nil, false
+		}
+		if gatewayRoute.IsDirect {
+			oif := node.GetVrfMatchingSubnetInterface(nextHop.GatewayIP, vrf)
+			if oif == nil {
+				fmt.Println("Recursive next hop", nextHop.GatewayIP.String(), "is unreachable")
+				return data.IPAddress{}, nil, false
+			}
+			return nextHop.GatewayIP, oif, true
+		}
+		route = gatewayRoute
+	}
+	fmt.Println("Recursive next hop resolution too deep")
+	return data.IPAddress{}, nil, false
+}
+
+// FlowHash hashes the 5-tuple of an IP packet, every packet of a flow maps to the same ECMP next hop.
+func FlowHash(ipHeader data.IPHeader, payload data.Payload) uint32 {
+	hash := fnv.New32a()
+	hash.Write(ipHeader.SourceIP[:])
+	hash.Write(ipHeader.DestinationIP[:])
+	hash.Write([]byte{ipHeader.Protocol})
+
+	if ipHeader.Protocol == constants.TcpProto || ipHeader.Protocol == constants.UdpProto {
+		// source and destination ports lead the transport header
+		l4Offset := unsafe.Sizeof(data.IPHeader{})
+		hash.Write(payload[l4Offset : l4Offset+4])
+	}
+	return hash.Sum32()
+}
+
 func IsRouteLocalDelivery(node *data.Node, destinationIP data.IPAddress) bool {
-	if node.Properties.IsLbConfigured && bytes.Equal(destinationIP[:], node.Properties.LB[:]) {
-		return true
+	return IsVrfLocalDelivery(node, nil, destinationIP)
+}
+
+// IsVrfLocalDelivery reports the addresses of the node in vrf. The loopback, virtual router and
+// tunnel addresses belong to the default VRF.
+func IsVrfLocalDelivery(node *data.Node, vrf *data.Vrf, destinationIP data.IPAddress) bool {
+	if vrf == nil {
+		if node.Properties.IsLbConfigured && bytes.Equal(destinationIP[:], node.Properties.LB[:]) {
+			return true
+		}
+		if isVRRPMasterIP(node, nil, destinationIP) {
+			return true
+		}
+		if isTunnelAddress(node, destinationIP) {
+			return true
+		}
 	}
 
 	for _, intf := range node.Interfaces {
 		if intf == nil {
 			return false
 		}
+		if intf.Properties.Vrf != vrf {
+			continue
+		}
+		if intf.Properties.IsIpv6Enabled && !destinationIP.IsIPv4() {
+			if destinationIP == intf.Properties.LinkLocalIP || destinationIP == intf.Properties.IPv6 {
+				return true
+			}
+			continue
+		}
 		if !intf.Properties.IsIpConfigured {
 			continue
 		}
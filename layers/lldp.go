@@ -0,0 +1,81 @@
+package layers
+
+import (
+	"fmt"
+	"tcpip/cmd/communication/send"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+)
+
+func nodeSystemCapabilities(node *data.Node) uint16 {
+	var capabilities uint16
+	for _, intf := range node.Interfaces {
+		if intf == nil {
+			break
+		}
+		if intf.Properties.IsIpConfigured {
+			capabilities |= constants.LldpCapabilityRouter
+		} else {
+			capabilities |= constants.LldpCapabilityBridge
+		}
+	}
+	return capabilities
+}
+
+// SendLLDPFrame advertises the node out of intf, LLDP frames are link local and bypass the L2 mode of the interface.
+func SendLLDPFrame(node *data.Node, intf *data.Interface) {
+	capabilities := nodeSystemCapabilities(node)
+	lldpDU := data.LldpDU{
+		ChassisID:           node.NodeName,
+		PortID:              intf.Name.String(),
+		TTL:                 uint16(constants.LldpTxIntervalSeconds * constants.LldpTxHoldMultiplier),
+		SystemName:          node.NodeName,
+		SystemCapabilities:  capabilities,
+		EnabledCapabilities: capabilities,
+	}
+	if node.Properties.IsLbConfigured {
+		copy(lldpDU.ManagementIP[:], node.Properties.LB[:])
+	}
+
+	ethernetHeader := &data.EthernetHeader{
+		Type: constants.LldpProto,
+	}
+	copy(ethernetHeader.DestinationMAC[:], constants.LldpMulticastMacAddress[:])
+	copy(ethernetHeader.SourceMAC[:], intf.Properties.MAC[:])
+	copy(ethernetHeader.Payload[:], lldpDU.SerializeLldpDU())
+
+	send.PacketSend(ethernetHeader.SerializeEthernetHeader(), intf)
+}
+
+func processLLDPFrame(node *data.Node, iif *data.Interface, ethernetHdr *data.EthernetHeader) {
+	lldpDU, err := data.DeserializeLldpDU(ethernetHdr.Payload[:])
+	if err != nil {
+		fmt.Println("processLLDPFrame: LLDP frame dropped on interface", iif.Name.String(), "of node", node.NodeName, ":", err)
+		return
+	}
+	node.Properties.LldpTable.UpdateNeighbor(iif.Name, lldpDU)
+}
+
+// StartLLDPAgent periodically sends LLDPDUs out of every interface of every node and ages out stale neighbors.
+func StartLLDPAgent(topology *data.Graph) {
+	transmit := func() {
+		now := time.Now()
+		for dllNode := topology.Nodes.Next; dllNode != nil; dllNode = dllNode.Next {
+			node := dllNode.DllToNode()
+			node.Properties.LldpTable.AgeOut(now)
+			for _, intf := range node.Interfaces {
+				if intf == nil {
+					break
+				}
+				SendLLDPFrame(node, intf)
+			}
+		}
+	}
+
+	transmit()
+	ticker := time.NewTicker(time.Duration(constants.LldpTxIntervalSeconds) * time.Second)
+	for range ticker.C {
+		transmit()
+	}
+}
@@ -0,0 +1,407 @@
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+	"unsafe"
+)
+
+// offset of the TTL in a serialized IP header
+const ipTTLOffset = 8
+
+// getMPLSInstance returns the MPLS state of the node, the first label configuration creates it.
+func getMPLSInstance(node *data.Node) *data.MplsInstance {
+	if node.Properties.Mpls == nil {
+		mpls := &data.MplsInstance{
+			Lfib:           data.Dll{},
+			Lsps:           data.Dll{},
+			Ftns:           data.Dll{},
+			IsTtlPropagate: true,
+		}
+		(&mpls.Lfib).Init()
+		(&mpls.Lsps).Init()
+		(&mpls.Ftns).Init()
+		node.Properties.Mpls = mpls
+	}
+	return node.Properties.Mpls
+}
+
+func validateMplsNextHop(node *data.Node, nextHop data.IPAddress) error {
+	if !nextHop.IsIPv4() {
+		return errors.New("next hop must be an IPv4 address")
+	}
+	if IsRouteLocalDelivery(node, nextHop) {
+		return errors.New("next hop is an address of the node")
+	}
+	return nil
+}
+
+// validateMplsOutLabels checks labels to be pushed, reserved labels other than explicit null
+// never go on the wire.
+func validateMplsOutLabels(labels []uint32) error {
+	if len(labels) == 0 || len(labels) > constants.MplsMaxLabelStackDepth {
+		return errors.New("invalid number of labels")
+	}
+	for _, label := range labels {
+		if label > constants.MplsMaxLabel || (label != constants.MplsLabelExplicitNull && label < constants.MplsMinStaticLabel) {
+			return fmt.Errorf("invalid label %d", label)
+		}
+	}
+	return nil
+}
+
+// SetLfibEntry programs what the node does with packets arriving with inLabel on top. A swap to
+// implicit null pops the label towards the next hop, this is penultimate hop popping; a pop
+// without next hop hands the rest of the packet to the node itself.
+func SetLfibEntry(node *data.Node, inLabel uint32, operation uint8, outLabels []uint32, nextHop *data.IPAddress) error {
+	if inLabel < constants.MplsMinStaticLabel || inLabel > constants.MplsMaxLabel {
+		return errors.New("invalid incoming label")
+	}
+	switch operation {
+	case data.MplsOpSwap:
+		if len(outLabels) != 1 {
+			return errors.New("swap takes one outgoing label")
+		}
+		if outLabels[0] != constants.MplsLabelImplicitNull {
+			if err := validateMplsOutLabels(outLabels); err != nil {
+				return err
+			}
+		}
+	case data.MplsOpPush:
+		if err := validateMplsOutLabels(outLabels); err != nil {
+			return err
+		}
+		// the labels go on top of the incoming label, which stays on the stack
+		if len(outLabels) >= constants.MplsMaxLabelStackDepth {
+			return errors.New("invalid number of labels")
+		}
+	case data.MplsOpPop:
+		if len(outLabels) != 0 {
+			return errors.New("pop takes no outgoing label")
+		}
+	}
+	if nextHop == nil && operation != data.MplsOpPop {
+		return errors.New("next hop is required")
+	}
+	if nextHop != nil {
+		if err := validateMplsNextHop(node, *nextHop); err != nil {
+			return err
+		}
+	}
+
+	mpls := getMPLSInstance(node)
+	mpls.Mutex.Lock()
+	defer mpls.Mutex.Unlock()
+
+	entry := mpls.LookupLfibEntry(inLabel)
+	if entry == nil {
+		entry = &data.LfibEntry{
+			InLabel: inLabel,
+		}
+		mpls.AddLfibEntry(entry)
+	}
+	entry.Operation = operation
+	entry.OutLabels = append([]uint32(nil), outLabels...)
+	entry.HasNextHop = nextHop != nil
+	entry.NextHop = data.IPAddress{}
+	if nextHop != nil {
+		entry.NextHop = *nextHop
+	}
+	return nil
+}
+
+func DeleteLfibEntry(node *data.Node, inLabel uint32) error {
+	mpls := node.Properties.Mpls
+	if mpls == nil {
+		return errors.New("LFIB entry not found")
+	}
+	mpls.Mutex.Lock()
+	defer mpls.Mutex.Unlock()
+
+	entry := mpls.LookupLfibEntry(inLabel)
+	if entry == nil {
+		return errors.New("LFIB entry not found")
+	}
+	(&entry.LfibGlue).RemoveNode()
+	return nil
+}
+
+// SetLsp creates the static LSP name, or changes its labels and next hop. labels lists the stack
+// the ingress pushes, top first.
+func SetLsp(node *data.Node, name string, labels []uint32, nextHop data.IPAddress) error {
+	if name == "" || name == "none" {
+		return errors.New("invalid LSP name")
+	}
+	if err := validateMplsOutLabels(labels); err != nil {
+		return err
+	}
+	if err := validateMplsNextHop(node, nextHop); err != nil {
+		return err
+	}
+
+	mpls := getMPLSInstance(node)
+	mpls.Mutex.Lock()
+	defer mpls.Mutex.Unlock()
+
+	lsp := mpls.LookupLsp(name)
+	if lsp == nil {
+		lsp = &data.MplsLsp{
+			Name: name,
+		}
+		mpls.AddLsp(lsp)
+	}
+	lsp.Labels = append([]uint32(nil), labels...)
+	lsp.NextHop = nextHop
+	return nil
+}
+
+// DeleteLsp removes the LSP together with the prefixes mapped to it.
+func DeleteLsp(node *data.Node, name string) error {
+	mpls := node.Properties.Mpls
+	if mpls == nil {
+		return errors.New("LSP not found")
+	}
+	mpls.Mutex.Lock()
+	defer mpls.Mutex.Unlock()
+
+	lsp := mpls.LookupLsp(name)
+	if lsp == nil {
+		return errors.New("LSP not found")
+	}
+	var next *data.Dll
+	for dllFtn := mpls.Ftns.Next; dllFtn != nil; dllFtn = next {
+		next = dllFtn.Next
+		if dllFtn.DllToMplsFtn().LspName == name {
+			dllFtn.RemoveNode()
+		}
+	}
+	(&lsp.LspGlue).RemoveNode()
+	return nil
+}
+
+// SetFtn makes the packets forwarded by the route of the prefix enter the LSP, lspName "none"
+// returns them to plain IP forwarding.
+func SetFtn(node *data.Node, IP data.IPAddress, mask rune, lspName string) error {
+	IP = data.ApplyMask(IP, mask)
+	if lspName == "none" {
+		mpls := node.Properties.Mpls
+		if mpls == nil {
+			return errors.New("prefix is not mapped to an LSP")
+		}
+		mpls.Mutex.Lock()
+		defer mpls.Mutex.Unlock()
+
+		ftn := mpls.LookupFtn(IP, mask)
+		if ftn == nil {
+			return errors.New("prefix is not mapped to an LSP")
+		}
+		(&ftn.FtnGlue).RemoveNode()
+		return nil
+	}
+	if !IP.IsIPv4() {
+		return errors.New("LSPs carry IPv4 only")
+	}
+	if node.Properties.RoutingTable.LookupRoutingTable(IP, mask) == nil {
+		return errors.New("prefix is not in the routing table")
+	}
+
+	mpls := getMPLSInstance(node)
+	mpls.Mutex.Lock()
+	defer mpls.Mutex.Unlock()
+
+	if mpls.LookupLsp(lspName) == nil {
+		return errors.New("LSP not found")
+	}
+	ftn := mpls.LookupFtn(IP, mask)
+	if ftn == nil {
+		ftn = &data.MplsFtn{
+			DestinationIP: IP,
+			Mask:          mask,
+		}
+		mpls.AddFtn(ftn)
+	}
+	ftn.LspName = lspName
+	return nil
+}
+
+func SetMplsTtlPropagation(node *data.Node, isPropagate bool) {
+	mpls := getMPLSInstance(node)
+	mpls.Mutex.Lock()
+	defer mpls.Mutex.Unlock()
+
+	mpls.IsTtlPropagate = isPropagate
+}
+
+// mplsImpose sends the IPv4 packet in payload into the LSP its route is mapped to and reports
+// whether it did. Only routes through a gateway are mapped, connected prefixes stay IP.
+func mplsImpose(node *data.Node, route *data.Layer3Route, ipHeader data.IPHeader, payload data.Payload) bool {
+	mpls := node.Properties.Mpls
+	if mpls == nil || route.IsDirect {
+		return false
+	}
+	mpls.Mutex.Lock()
+	var lsp *data.MplsLsp
+	if ftn := mpls.LookupFtn(route.DestinationIP, route.Mask); ftn != nil {
+		lsp = mpls.LookupLsp(ftn.LspName)
+	}
+	if lsp == nil {
+		mpls.Mutex.Unlock()
+		return false
+	}
+	lsp.Packets++
+	labels, nextHop := lsp.Labels, lsp.NextHop
+	TTL := constants.MplsMaxTTL
+	if mpls.IsTtlPropagate {
+		TTL = ipHeader.TTL
+	}
+	mpls.Mutex.Unlock()
+
+	stack := make([]data.MplsLabel, len(labels))
+	for i, label := range labels {
+		stack[i] = data.MplsLabel{Label: label, TTL: TTL}
+	}
+	mplsSend(node, nextHop, stack, mplsIPPacket(payload[:]))
+	return true
+}
+
+// mplsIPPacket returns the IPv4 packet at the start of packet without the padding behind it.
+func mplsIPPacket(packet []byte) []byte {
+	headerSize := int(unsafe.Sizeof(data.IPHeader{}))
+	if len(packet) < headerSize {
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if length < headerSize || length > len(packet) {
+		return nil
+	}
+	return packet[:length]
+}
+
+// mplsSend sends the IP packet with the label stack to the next hop, a packet whose last label was
+// popped leaves as a plain IP packet.
+func mplsSend(node *data.Node, nextHop data.IPAddress, stack []data.MplsLabel, ipPacket []byte) {
+	if ipPacket == nil {
+		fmt.Println("MPLS: packet with invalid IP length dropped on node", node.NodeName)
+		return
+	}
+	var payload data.Payload
+	if len(stack) == 0 {
+		copy(payload[:], ipPacket)
+		ipOutput(node, nextHop, nil, payload)
+		return
+	}
+	labels := data.SerializeMplsLabelStack(stack)
+	if len(labels)+len(ipPacket) > len(payload) {
+		fmt.Println("MPLS: labeled packet too big dropped on node", node.NodeName)
+		return
+	}
+	copy(payload[:], labels)
+	copy(payload[len(labels):], ipPacket)
+	PacketDemoteToLayer2(node, nextHop, nil, payload, constants.EthernetMplsProto)
+}
+
+// mplsReceive switches a labeled packet by the LFIB entry of its top label. The label TTL is
+// decremented by every LSR, with TTL propagation a popped label passes the lower TTL on to the
+// label or IP header below it.
+func mplsReceive(node *data.Node, iif *data.Interface, payload data.Payload) {
+	stack, err := data.DeserializeMplsLabelStack(payload[:])
+	if err != nil {
+		fmt.Println("MPLS: packet dropped on node", node.NodeName+":", err)
+		return
+	}
+	ipPacket := mplsIPPacket(payload[len(stack)*constants.MplsLabelSize:])
+	top := stack[0]
+
+	mpls := node.Properties.Mpls
+	if mpls == nil {
+		fmt.Println("MPLS: labeled packet dropped, MPLS is not configured on node", node.NodeName)
+		return
+	}
+	mpls.Mutex.Lock()
+	entry := mpls.LookupLfibEntry(top.Label)
+	var operation uint8
+	var outLabels []uint32
+	var hasNextHop bool
+	var nextHop data.IPAddress
+	switch {
+	case entry != nil:
+		entry.Packets++
+		operation, outLabels, hasNextHop, nextHop = entry.Operation, entry.OutLabels, entry.HasNextHop, entry.NextHop
+	case top.Label == constants.MplsLabelExplicitNull:
+		// the egress asked for explicit null, the label only carries the TTL and traffic class
+		operation = data.MplsOpPop
+	}
+	isTtlPropagate := mpls.IsTtlPropagate
+	mpls.Mutex.Unlock()
+
+	if entry == nil && top.Label != constants.MplsLabelExplicitNull {
+		fmt.Println("MPLS: no LFIB entry for label", top.Label, "on node", node.NodeName)
+		return
+	}
+	if top.TTL <= 1 {
+		fmt.Println("MPLS: TTL expired on label", top.Label, "at node", node.NodeName)
+		return
+	}
+	TTL := top.TTL - 1
+
+	switch operation {
+	case data.MplsOpSwap:
+		if outLabels[0] == constants.MplsLabelImplicitNull {
+			stack = mplsPop(stack, ipPacket, TTL, isTtlPropagate)
+		} else {
+			stack[0].Label, stack[0].TTL = outLabels[0], TTL
+		}
+	case data.MplsOpPush:
+		stack[0].TTL = TTL
+		pushed := make([]data.MplsLabel, len(outLabels), len(outLabels)+len(stack))
+		for i, label := range outLabels {
+			pushed[i] = data.MplsLabel{Label: label, TC: top.TC, TTL: TTL}
+		}
+		stack = append(pushed, stack...)
+		if len(stack) > constants.MplsMaxLabelStackDepth {
+			fmt.Println("MPLS: label stack too deep, packet dropped on node", node.NodeName)
+			return
+		}
+	case data.MplsOpPop:
+		stack = mplsPop(stack, ipPacket, TTL, isTtlPropagate)
+	}
+
+	if hasNextHop {
+		mplsSend(node, nextHop, stack, ipPacket)
+		return
+	}
+	// the node is the end of the LSP, it switches the next label or routes the IP packet itself
+	if ipPacket == nil {
+		fmt.Println("MPLS: packet with invalid IP length dropped on node", node.NodeName)
+		return
+	}
+	var inner data.Payload
+	labels := data.SerializeMplsLabelStack(stack)
+	copy(inner[:], labels)
+	copy(inner[len(labels):], ipPacket)
+	if len(stack) > 0 {
+		mplsReceive(node, iif, inner)
+		return
+	}
+	PacketReceive(node, iif, inner)
+}
+
+// mplsPop removes the top label carrying TTL, with TTL propagation a lower TTL is copied to the
+// label below or, at the bottom of the stack, to the IP header.
+func mplsPop(stack []data.MplsLabel, ipPacket []byte, TTL uint8, isTtlPropagate bool) []data.MplsLabel {
+	stack = stack[1:]
+	if !isTtlPropagate {
+		return stack
+	}
+	if len(stack) > 0 {
+		if TTL < stack[0].TTL {
+			stack[0].TTL = TTL
+		}
+	} else if ipPacket != nil && TTL < ipPacket[ipTTLOffset] {
+		ipPacket[ipTTLOffset] = TTL
+	}
+	return stack
+}
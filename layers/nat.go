@@ -0,0 +1,432 @@
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+	"unsafe"
+)
+
+// offsets of the addresses in a serialized IP header
+const (
+	ipSourceIPOffset      = 12
+	ipDestinationIPOffset = 28
+)
+
+// getNATInstance returns the NAT instance of the node, the first NAT configuration creates it.
+func getNATInstance(node *data.Node) *data.NatInstance {
+	if node.Properties.Nat == nil {
+		nat := &data.NatInstance{
+			Roles:        map[*data.Interface]uint8{},
+			Translations: data.Dll{},
+		}
+		(&nat.Translations).Init()
+		node.Properties.Nat = nat
+		go natTimer(node)
+	}
+	return node.Properties.Nat
+}
+
+// SetNATInterface makes the interface an inside or outside interface, packets routed from an inside
+// to an outside interface get their source translated and packets received on an outside interface
+// get their destination translated back.
+func SetNATInterface(node *data.Node, intfName string, role uint8) error {
+	intf := node.GetNodeIntfOrTunnelByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	if !intf.Properties.IsIpConfigured {
+		return errors.New("interface has no IP address")
+	}
+
+	nat := getNATInstance(node)
+	nat.Mutex.Lock()
+	defer nat.Mutex.Unlock()
+
+	nat.Roles[intf] = role
+	return nil
+}
+
+// AddNATStatic maps insideLocalIP to insideGlobalIP permanently, hosts on the outside can open
+// connections to the inside host through its global address.
+func AddNATStatic(node *data.Node, insideLocalIP data.IPAddress, insideGlobalIP data.IPAddress) error {
+	if !insideLocalIP.IsIPv4() || !insideGlobalIP.IsIPv4() {
+		return errors.New("NAT translates IPv4 addresses only")
+	}
+
+	nat := getNATInstance(node)
+	nat.Mutex.Lock()
+	defer nat.Mutex.Unlock()
+
+	if translation := nat.LookupByInsideLocal(0, insideLocalIP, 0); translation != nil && translation.Type == data.NatTypeStatic {
+		return errors.New("static translation already exists for inside address")
+	}
+	if translation := nat.LookupByInsideGlobal(0, insideGlobalIP, 0); translation != nil && translation.Type != data.NatTypePAT {
+		return errors.New("inside global address already in use")
+	}
+	nat.AddTranslation(&data.NatTranslation{
+		Type:           data.NatTypeStatic,
+		InsideLocalIP:  insideLocalIP,
+		InsideGlobalIP: insideGlobalIP,
+	})
+	return nil
+}
+
+// SetNATPool sets the range of global addresses handed out to inside hosts one to one.
+func SetNATPool(node *data.Node, start data.IPAddress, end data.IPAddress) error {
+	if !start.IsIPv4() || !end.IsIPv4() {
+		return errors.New("NAT translates IPv4 addresses only")
+	}
+	if binary.BigEndian.Uint32(net.IP(start[:]).To4()) > binary.BigEndian.Uint32(net.IP(end[:]).To4()) {
+		return errors.New("pool start is above pool end")
+	}
+
+	nat := getNATInstance(node)
+	nat.Mutex.Lock()
+	defer nat.Mutex.Unlock()
+
+	nat.PoolStart = start
+	nat.PoolEnd = end
+	nat.IsPoolConfigured = true
+	return nil
+}
+
+// SetNATOverload enables port address translation onto the address of the interface, or onto the
+// loopback address of the node for intfName "loopback". PAT takes over once the pool is exhausted.
+func SetNATOverload(node *data.Node, intfName string) error {
+	var intf *data.Interface
+	if intfName == "loopback" {
+		if !node.Properties.IsLbConfigured {
+			return errors.New("node has no loopback address")
+		}
+	} else {
+		if intf = node.GetNodeIntfByName(intfName); intf == nil {
+			return errors.New("interface not found")
+		}
+		if !intf.Properties.IsIpConfigured {
+			return errors.New("interface has no IP address")
+		}
+	}
+
+	nat := getNATInstance(node)
+	nat.Mutex.Lock()
+	defer nat.Mutex.Unlock()
+
+	nat.OverloadInterface = intf
+	nat.IsOverloadLoopback = intf == nil
+	return nil
+}
+
+// ClearNATTranslations removes the dynamic and PAT translations of the node and returns how many there were.
+func ClearNATTranslations(node *data.Node) int {
+	nat := node.Properties.Nat
+	if nat == nil {
+		return 0
+	}
+	nat.Mutex.Lock()
+	defer nat.Mutex.Unlock()
+
+	return nat.ClearTranslations()
+}
+
+func natOverloadAddress(node *data.Node, nat *data.NatInstance) (data.IPAddress, bool) {
+	if nat.IsOverloadLoopback {
+		return node.Properties.LB, node.Properties.IsLbConfigured
+	}
+	if nat.OverloadInterface != nil && nat.OverloadInterface.Properties.IsIpConfigured {
+		return nat.OverloadInterface.Properties.IP, true
+	}
+	return data.IPAddress{}, false
+}
+
+// natPortOffset returns the offset of the port translated for protocol in the transport header,
+// ICMP queries are translated by their identifier. It returns false for packets without a port.
+func natPortOffset(protocol uint8, l4 []byte, isSource bool) (int, bool) {
+	switch protocol {
+	case constants.TcpProto, constants.UdpProto:
+		if isSource {
+			return 0, true
+		}
+		return 2, true
+	case constants.IcmpProto:
+		if l4[0] == constants.IcmpTypeEchoRequest || l4[0] == constants.IcmpTypeEchoReply {
+			return 4, true
+		}
+	}
+	return 0, false
+}
+
+func natChecksumOffset(protocol uint8) (int, bool) {
+	switch protocol {
+	case constants.TcpProto:
+		return 16, true
+	case constants.UdpProto:
+		return 6, true
+	case constants.IcmpProto:
+		return 2, true
+	}
+	return 0, false
+}
+
+// natRewrite replaces the address at ipOffset of the IP header in packet and the port at portOffset of
+// its transport header, and fixes the transport checksum for both. A negative portOffset leaves the
+// port alone.
+func natRewrite(packet []byte, protocol uint8, ipOffset int, IP data.IPAddress, portOffset int, port uint16) {
+	l4 := packet[unsafe.Sizeof(data.IPHeader{}):]
+	checksumOffset, hasChecksum := natChecksumOffset(protocol)
+	var checksum uint16
+	if hasChecksum {
+		checksum = binary.BigEndian.Uint16(l4[checksumOffset:])
+	}
+	// UDP datagrams sent without checksum keep it that way
+	if protocol == constants.UdpProto && checksum == 0 {
+		hasChecksum = false
+	}
+
+	var oldIP data.IPAddress
+	copy(oldIP[:], packet[ipOffset:ipOffset+16])
+	if hasChecksum && protocol != constants.IcmpProto {
+		// only the pseudo header of TCP and UDP covers the addresses
+		checksum = data.AdjustChecksum(checksum, net.IP(oldIP[:]).To4(), net.IP(IP[:]).To4())
+	}
+	copy(packet[ipOffset:ipOffset+16], IP[:])
+
+	if portOffset >= 0 {
+		var newPort [2]byte
+		binary.BigEndian.PutUint16(newPort[:], port)
+		if hasChecksum {
+			checksum = data.AdjustChecksum(checksum, l4[portOffset:portOffset+2], newPort[:])
+		}
+		copy(l4[portOffset:portOffset+2], newPort[:])
+	}
+
+	if hasChecksum {
+		if protocol == constants.UdpProto && checksum == 0 {
+			checksum = 0xFFFF
+		}
+		binary.BigEndian.PutUint16(l4[checksumOffset:], checksum)
+	}
+}
+
+// natRefresh restarts the timeout of a translation, TCP translations time out quickly once a FIN
+// or RST went through.
+func natRefresh(translation *data.NatTranslation, l4 []byte, now time.Time) {
+	if translation.Type == data.NatTypeStatic {
+		return
+	}
+	timeout := constants.NatAddressTimeoutSeconds
+	if translation.Type == data.NatTypePAT {
+		switch translation.Protocol {
+		case constants.IcmpProto:
+			timeout = constants.NatIcmpTimeoutSeconds
+		case constants.UdpProto:
+			timeout = constants.NatUdpTimeoutSeconds
+		case constants.TcpProto:
+			if l4[13]&(constants.TcpFlagFin|constants.TcpFlagRst) != 0 {
+				translation.IsClosing = true
+			}
+			timeout = constants.NatTcpTimeoutSeconds
+			if translation.IsClosing {
+				timeout = constants.NatTcpClosingTimeoutSeconds
+			}
+		}
+	}
+	translation.ExpiresAt = now.Add(time.Duration(timeout) * time.Second)
+}
+
+// natCreateTranslation gives an inside host a free pool address, or a port of the overload address
+// once the pool is exhausted. It returns nil when neither is available.
+func natCreateTranslation(node *data.Node, nat *data.NatInstance, protocol uint8, IP data.IPAddress, port uint16, hasPort bool) *data.NatTranslation {
+	translation := &data.NatTranslation{
+		InsideLocalIP: IP,
+	}
+	if globalIP, ok := nat.AllocatePoolAddress(); ok {
+		translation.Type = data.NatTypeDynamic
+		translation.InsideGlobalIP = globalIP
+	} else {
+		globalIP, ok := natOverloadAddress(node, nat)
+		if !ok || !hasPort {
+			return nil
+		}
+		globalPort, ok := nat.AllocatePort(protocol, globalIP)
+		if !ok {
+			return nil
+		}
+		translation.Type = data.NatTypePAT
+		translation.Protocol = protocol
+		translation.InsideLocalPort = port
+		translation.InsideGlobalIP = globalIP
+		translation.InsideGlobalPort = globalPort
+	}
+	nat.AddTranslation(translation)
+	return translation
+}
+
+func isICMPError(l4 []byte) bool {
+	return l4[0] == constants.IcmpTypeDestinationUnreachable || l4[0] == constants.IcmpTypeTimeExceeded
+}
+
+// natTranslateICMPError translates an ICMP error by the packet it quotes, the quoted packet was
+// translated the other way when it passed the router.
+func natTranslateICMPError(nat *data.NatInstance, ipHeader *data.IPHeader, payload *data.Payload, isOutbound bool) bool {
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	quoted := payload[l4Offset+constants.IcmpHeaderSize:]
+	quotedHeader := data.DeserializeIPHeader(quoted[:l4Offset])
+	quotedL4 := quoted[l4Offset:]
+
+	// the quoted packet travelled in the opposite direction of the error
+	quotedIPOffset, outerIPOffset := ipSourceIPOffset, ipDestinationIPOffset
+	quotedIP := quotedHeader.SourceIP
+	if isOutbound {
+		quotedIPOffset, outerIPOffset = ipDestinationIPOffset, ipSourceIPOffset
+		quotedIP = quotedHeader.DestinationIP
+	}
+	portOffset, hasPort := natPortOffset(quotedHeader.Protocol, quotedL4, !isOutbound)
+	var port uint16
+	if hasPort {
+		port = binary.BigEndian.Uint16(quotedL4[portOffset:])
+	}
+
+	var translation *data.NatTranslation
+	var IP data.IPAddress
+	var newPort uint16
+	if isOutbound {
+		translation = nat.LookupByInsideLocal(quotedHeader.Protocol, quotedIP, port)
+		if translation == nil {
+			return false
+		}
+		IP, newPort = translation.InsideGlobalIP, translation.InsideGlobalPort
+	} else {
+		translation = nat.LookupByInsideGlobal(quotedHeader.Protocol, quotedIP, port)
+		if translation == nil {
+			return false
+		}
+		IP, newPort = translation.InsideLocalIP, translation.InsideLocalPort
+	}
+	// only the first eight bytes of the quoted transport header are present, its checksum stays as is
+	copy(quoted[quotedIPOffset:quotedIPOffset+16], IP[:])
+	if hasPort && translation.Type == data.NatTypePAT {
+		binary.BigEndian.PutUint16(quotedL4[portOffset:], newPort)
+	}
+	copy(payload[outerIPOffset:outerIPOffset+16], IP[:])
+	if isOutbound {
+		ipHeader.SourceIP = IP
+	} else {
+		ipHeader.DestinationIP = IP
+	}
+
+	// the ICMP checksum covers the quoted packet
+	length := int(ipHeader.Length) - l4Offset
+	if length < constants.IcmpHeaderSize || l4Offset+length > len(payload) {
+		return true
+	}
+	message := payload[l4Offset : l4Offset+length]
+	binary.BigEndian.PutUint16(message[2:4], 0)
+	binary.BigEndian.PutUint16(message[2:4], data.InternetChecksum(message))
+	return true
+}
+
+// natTranslateInbound translates the destination of a packet received on an outside interface back
+// to the inside local address, packets without translation are left alone.
+func natTranslateInbound(node *data.Node, iif *data.Interface, ipHeader *data.IPHeader, payload *data.Payload) {
+	nat := node.Properties.Nat
+	if nat == nil || iif == nil {
+		return
+	}
+	nat.Mutex.Lock()
+	defer nat.Mutex.Unlock()
+
+	if nat.Roles[iif] != constants.NatRoleOutside {
+		return
+	}
+	l4 := payload[unsafe.Sizeof(data.IPHeader{}):]
+	if ipHeader.Protocol == constants.IcmpProto && isICMPError(l4) {
+		natTranslateICMPError(nat, ipHeader, payload, false)
+		return
+	}
+
+	portOffset, hasPort := natPortOffset(ipHeader.Protocol, l4, false)
+	var port uint16
+	if hasPort {
+		port = binary.BigEndian.Uint16(l4[portOffset:])
+	}
+	translation := nat.LookupByInsideGlobal(ipHeader.Protocol, ipHeader.DestinationIP, port)
+	if translation == nil {
+		return
+	}
+	natRefresh(translation, l4, time.Now())
+	if translation.Type != data.NatTypePAT {
+		portOffset = -1
+	}
+	natRewrite(payload[:], ipHeader.Protocol, ipDestinationIPOffset, translation.InsideLocalIP, portOffset, translation.InsideLocalPort)
+	ipHeader.DestinationIP = translation.InsideLocalIP
+}
+
+// natTranslateOutbound translates the source of a packet routed from an inside to an outside
+// interface, it returns false when the packet has to be dropped for lack of a translation.
+func natTranslateOutbound(node *data.Node, iif *data.Interface, oif *data.Interface, ipHeader *data.IPHeader, payload *data.Payload) bool {
+	nat := node.Properties.Nat
+	if nat == nil || iif == nil || oif == nil {
+		return true
+	}
+	nat.Mutex.Lock()
+	defer nat.Mutex.Unlock()
+
+	if nat.Roles[iif] != constants.NatRoleInside || nat.Roles[oif] != constants.NatRoleOutside {
+		return true
+	}
+	l4 := payload[unsafe.Sizeof(data.IPHeader{}):]
+	if ipHeader.Protocol == constants.IcmpProto && isICMPError(l4) {
+		if !natTranslateICMPError(nat, ipHeader, payload, true) {
+			fmt.Println("NAT: ICMP error from", ipHeader.SourceIP.String(), "matches no translation on node", node.NodeName)
+			return false
+		}
+		return true
+	}
+
+	portOffset, hasPort := natPortOffset(ipHeader.Protocol, l4, true)
+	var port uint16
+	if hasPort {
+		port = binary.BigEndian.Uint16(l4[portOffset:])
+	}
+	translation := nat.LookupByInsideLocal(ipHeader.Protocol, ipHeader.SourceIP, port)
+	if translation == nil {
+		translation = natCreateTranslation(node, nat, ipHeader.Protocol, ipHeader.SourceIP, port, hasPort)
+		if translation == nil {
+			fmt.Println("NAT: no translation available for", ipHeader.SourceIP.String(), "on node", node.NodeName)
+			return false
+		}
+	}
+	natRefresh(translation, l4, time.Now())
+	if translation.Type != data.NatTypePAT {
+		portOffset = -1
+	}
+	natRewrite(payload[:], ipHeader.Protocol, ipSourceIPOffset, translation.InsideGlobalIP, portOffset, translation.InsideGlobalPort)
+	ipHeader.SourceIP = translation.InsideGlobalIP
+	return true
+}
+
+func natTimer(node *data.Node) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		nat := node.Properties.Nat
+		nat.Mutex.Lock()
+
+		now := time.Now()
+		var next *data.Dll
+		for dllTranslation := nat.Translations.Next; dllTranslation != nil; dllTranslation = next {
+			next = dllTranslation.Next
+			translation := dllTranslation.DllToNatTranslation()
+			if translation.Type != data.NatTypeStatic && !now.Before(translation.ExpiresAt) {
+				dllTranslation.RemoveNode()
+			}
+		}
+		nat.Mutex.Unlock()
+	}
+}
@@ -0,0 +1,129 @@
+package layers
+
+import (
+	"encoding/binary"
+	"tcpip/constants"
+	"tcpip/data"
+	"testing"
+	"unsafe"
+)
+
+var (
+	natInsideLocalIP  = data.StringToIPAddress("10.0.0.1")
+	natInsideGlobalIP = data.StringToIPAddress("203.0.113.1")
+	natOutsideIP      = data.StringToIPAddress("198.51.100.7")
+)
+
+// natPacket returns the payload of an IPv4 packet from sourceIP to destinationIP carrying l4.
+func natPacket(sourceIP data.IPAddress, protocol uint8, destinationIP data.IPAddress, l4 []byte) *data.Payload {
+	payload := &data.Payload{}
+	ipHeader := newIPHeader(sourceIP, protocol, destinationIP, len(l4))
+	copy(payload[:], ipHeader.SerializeIPHeader())
+	copy(payload[unsafe.Sizeof(data.IPHeader{}):], l4)
+	return payload
+}
+
+// natRecompute returns the checksum at checksumOffset computed over the whole message again, the
+// pseudo header of TCP and UDP covers the addresses of the IP header in payload.
+func natRecompute(payload *data.Payload, protocol uint8, length int, checksumOffset int) uint16 {
+	ipHeader := data.DeserializeIPHeader(payload[:unsafe.Sizeof(data.IPHeader{})])
+	l4 := append([]byte(nil), payload[unsafe.Sizeof(data.IPHeader{}):int(unsafe.Sizeof(data.IPHeader{}))+length]...)
+	binary.BigEndian.PutUint16(l4[checksumOffset:], 0)
+	if protocol == constants.IcmpProto {
+		return data.InternetChecksum(l4)
+	}
+	checksum := data.InternetChecksum(data.PseudoHeader(ipHeader.SourceIP, ipHeader.DestinationIP, protocol, length), l4)
+	if protocol == constants.UdpProto && checksum == 0 {
+		checksum = 0xFFFF
+	}
+	return checksum
+}
+
+// natChecksum returns the checksum at checksumOffset of the transport header in payload.
+func natChecksum(payload *data.Payload, checksumOffset int) uint16 {
+	return binary.BigEndian.Uint16(payload[int(unsafe.Sizeof(data.IPHeader{}))+checksumOffset:])
+}
+
+// TestNatRewriteChecksum checks the incrementally adjusted checksum of translated packets against a full recompute.
+func TestNatRewriteChecksum(t *testing.T) {
+	tcp := data.TCPHeader{SourcePort: 40000, DestinationPort: 80, Seq: 1, Flags: constants.TcpFlagSyn, Window: 65535}
+	udp := data.SerializeUDPDatagram(natInsideLocalIP, 40000, natOutsideIP, 53, []byte("query"))
+	echo := data.IcmpHeader{Type: constants.IcmpTypeEchoRequest, Rest: 0x1234<<16 | 1}
+
+	tests := []struct {
+		name     string
+		protocol uint8
+		l4       []byte
+	}{
+		{"tcp", constants.TcpProto, tcp.SerializeTCPSegment(natInsideLocalIP, natOutsideIP, []byte("hello"))},
+		{"udp", constants.UdpProto, udp},
+		{"icmp echo", constants.IcmpProto, echo.SerializeIcmpMessage([]byte("ping"))},
+	}
+	for _, test := range tests {
+		payload := natPacket(natInsideLocalIP, test.protocol, natOutsideIP, test.l4)
+		portOffset, _ := natPortOffset(test.protocol, test.l4, true)
+		checksumOffset, _ := natChecksumOffset(test.protocol)
+
+		natRewrite(payload[:], test.protocol, ipSourceIPOffset, natInsideGlobalIP, portOffset, 1024)
+
+		l4 := payload[unsafe.Sizeof(data.IPHeader{}):]
+		if port := binary.BigEndian.Uint16(l4[portOffset:]); port != 1024 {
+			t.Fatalf("%s: port %d, want 1024", test.name, port)
+		}
+		want := natRecompute(payload, test.protocol, len(test.l4), checksumOffset)
+		if got := natChecksum(payload, checksumOffset); got != want {
+			t.Fatalf("%s: checksum %#04x, want %#04x", test.name, got, want)
+		}
+	}
+}
+
+// TestNatRewriteUDPWithoutChecksum checks that datagrams sent without checksum keep it that way.
+func TestNatRewriteUDPWithoutChecksum(t *testing.T) {
+	udp := data.SerializeUDPDatagram(natInsideLocalIP, 40000, natOutsideIP, 53, []byte("query"))
+	binary.BigEndian.PutUint16(udp[6:8], 0)
+	payload := natPacket(natInsideLocalIP, constants.UdpProto, natOutsideIP, udp)
+
+	natRewrite(payload[:], constants.UdpProto, ipSourceIPOffset, natInsideGlobalIP, 0, 1024)
+
+	if got := natChecksum(payload, 6); got != 0 {
+		t.Fatalf("checksum %#04x, want 0", got)
+	}
+}
+
+// TestNatTranslateICMPErrorChecksum checks the checksum of an ICMP error whose quoted packet is
+// translated back to the inside host.
+func TestNatTranslateICMPErrorChecksum(t *testing.T) {
+	nat := &data.NatInstance{}
+	(&nat.Translations).Init()
+	nat.AddTranslation(&data.NatTranslation{
+		Type:             data.NatTypePAT,
+		Protocol:         constants.UdpProto,
+		InsideLocalIP:    natInsideLocalIP,
+		InsideLocalPort:  40000,
+		InsideGlobalIP:   natInsideGlobalIP,
+		InsideGlobalPort: 1024,
+	})
+
+	// the datagram as it left the router and the time exceeded error quoting it
+	udp := data.SerializeUDPDatagram(natInsideGlobalIP, 1024, natOutsideIP, 53, []byte("query"))
+	quoted := natPacket(natInsideGlobalIP, constants.UdpProto, natOutsideIP, udp)
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	icmpHeader := data.IcmpHeader{Type: constants.IcmpTypeTimeExceeded, Code: constants.IcmpCodeTtlExceeded}
+	message := icmpHeader.SerializeIcmpMessage(quoted[:l4Offset+8])
+	payload := natPacket(natOutsideIP, constants.IcmpProto, natInsideGlobalIP, message)
+	ipHeader := data.DeserializeIPHeader(payload[:l4Offset])
+
+	if !natTranslateICMPError(nat, &ipHeader, payload, false) {
+		t.Fatal("no translation found for the quoted packet")
+	}
+
+	quotedHeader := data.DeserializeIPHeader(payload[l4Offset+constants.IcmpHeaderSize:])
+	quotedPort := binary.BigEndian.Uint16(payload[2*l4Offset+constants.IcmpHeaderSize:])
+	if ipHeader.DestinationIP != natInsideLocalIP || quotedHeader.SourceIP != natInsideLocalIP || quotedPort != 40000 {
+		t.Fatalf("translated to %s, quoting %s port %d", ipHeader.DestinationIP.String(), quotedHeader.SourceIP.String(), quotedPort)
+	}
+	want := natRecompute(payload, constants.IcmpProto, len(message), 2)
+	if got := natChecksum(payload, 2); got != want {
+		t.Fatalf("checksum %#04x, want %#04x", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package layers
+
+import (
+	"fmt"
+	"tcpip/cmd/communication/send"
+	"tcpip/constants"
+	"tcpip/data"
+)
+
+// SendNeighborSolicitation asks for the MAC address of IP on the solicited-node group of IP, the
+// IPv6 counterpart of an ARP broadcast request.
+func SendNeighborSolicitation(node *data.Node, oif *data.Interface, IP data.IPAddress) {
+	if oif == nil {
+		oif = node.GetMatchingSubnetInterface(IP)
+		if oif == nil {
+			fmt.Println("No eligible subnet for neighbor solicitation")
+			return
+		}
+	}
+	if !oif.Properties.IsIpv6Enabled {
+		fmt.Println("IPv6 is not enabled on interface", oif.Name.String())
+		return
+	}
+
+	message := data.NdMessage{
+		Target:              IP,
+		LinkLayerAddress:    oif.Properties.MAC,
+		HasLinkLayerAddress: true,
+	}
+	sourceIP := oif.Properties.LinkLocalIP
+	destinationIP := data.SolicitedNodeAddress(IP)
+	icmpHeader := data.IcmpHeader{
+		Type: constants.Icmpv6TypeNeighborSolicitation,
+	}
+	appData := icmpHeader.SerializeIcmpv6Message(sourceIP, destinationIP, message.SerializeNdMessage(constants.NdOptionSourceLinkLayerAddress))
+
+	frame := ipv6Frame(oif, sourceIP, destinationIP, ipv6MulticastMacAddress(destinationIP), constants.Icmpv6Proto, constants.NdHopLimit, appData)
+	send.PacketSend(frame.SerializeEthernetHeader(), oif)
+}
+
+func sendNeighborAdvertisement(iif *data.Interface, target data.IPAddress, destinationIP data.IPAddress, destinationMAC data.MacAddress, flags uint32) {
+	message := data.NdMessage{
+		Target:              target,
+		LinkLayerAddress:    iif.Properties.MAC,
+		HasLinkLayerAddress: true,
+	}
+	icmpHeader := data.IcmpHeader{
+		Type: constants.Icmpv6TypeNeighborAdvertisement,
+		Rest: flags,
+	}
+	appData := icmpHeader.SerializeIcmpv6Message(target, destinationIP, message.SerializeNdMessage(constants.NdOptionTargetLinkLayerAddress))
+
+	frame := ipv6Frame(iif, target, destinationIP, destinationMAC, constants.Icmpv6Proto, constants.NdHopLimit, appData)
+	send.PacketSend(frame.SerializeEthernetHeader(), iif)
+}
+
+func isIntfIPv6Address(intf *data.Interface, IP data.IPAddress) bool {
+	return intf.Properties.IsIpv6Enabled && !IP.IsUnspecified() && (IP == intf.Properties.LinkLocalIP || IP == intf.Properties.IPv6)
+}
+
+func processNeighborSolicitation(node *data.Node, iif *data.Interface, ipHeader data.IPv6Header, body []byte) {
+	message, err := data.DeserializeNdMessage(body)
+	if err != nil {
+		fmt.Println("processNeighborSolicitation:", err, "on node", node.NodeName)
+		return
+	}
+	if !isIntfIPv6Address(iif, message.Target) {
+		fmt.Println("processNeighborSolicitation: Neighbor solicitation dropped, target address did not match")
+		return
+	}
+	fmt.Println("processNeighborSolicitation: Neighbor solicitation received on interface", iif.Name.String(), "of node", node.NodeName)
+
+	// duplicate address detection probes come from the unspecified address and are answered to all nodes
+	if ipHeader.SourceIP.IsUnspecified() {
+		allNodes := data.IPAddress(constants.Ipv6AllNodesIP)
+		sendNeighborAdvertisement(iif, message.Target, allNodes, ipv6MulticastMacAddress(allNodes), constants.NdFlagOverride)
+		return
+	}
+	if !message.HasLinkLayerAddress {
+		return
+	}
+	updateNeighborEntry(node.VrfArpTable(iif.Properties.Vrf), ipHeader.SourceIP, message.LinkLayerAddress, iif)
+	sendNeighborAdvertisement(iif, message.Target, ipHeader.SourceIP, message.LinkLayerAddress, constants.NdFlagSolicited|constants.NdFlagOverride)
+}
+
+func processNeighborAdvertisement(node *data.Node, iif *data.Interface, body []byte) {
+	message, err := data.DeserializeNdMessage(body)
+	if err != nil {
+		fmt.Println("processNeighborAdvertisement:", err, "on node", node.NodeName)
+		return
+	}
+	if !message.HasLinkLayerAddress {
+		return
+	}
+	fmt.Println("processNeighborAdvertisement: Neighbor advertisement received on interface", iif.Name.String(), "of node", node.NodeName)
+
+	updateNeighborEntry(node.VrfArpTable(iif.Properties.Vrf), message.Target, message.LinkLayerAddress, iif)
+}
@@ -0,0 +1,549 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+	"unsafe"
+)
+
+// ospfMaxPacketSize keeps link state updates within the IP payload of a single frame.
+const ospfMaxPacketSize = constants.MaxPayloadSize - int(unsafe.Sizeof(data.IPHeader{}))
+
+// EnableOSPF runs the link state protocol on the interface, the loopback address of the node is its router ID.
+func EnableOSPF(node *data.Node, intfName string) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	if !intf.Properties.IsIpConfigured {
+		return errors.New("interface has no IP address")
+	}
+	if !node.Properties.IsLbConfigured {
+		return errors.New("node has no loopback address to use as router ID")
+	}
+
+	if node.Properties.Ospf == nil {
+		ospf := &data.OspfInstance{
+			RouterID:  node.Properties.LB,
+			Neighbors: data.Dll{},
+			Lsdb:      data.Dll{},
+			Routes:    data.Dll{},
+			Sequence:  constants.OspfInitialSequence - 1,
+		}
+		(&ospf.Neighbors).Init()
+		(&ospf.Lsdb).Init()
+		(&ospf.Routes).Init()
+		node.Properties.Ospf = ospf
+		go ospfTimer(node)
+	}
+
+	ospf := node.Properties.Ospf
+	ospf.Mutex.Lock()
+	defer ospf.Mutex.Unlock()
+
+	if ospf.IsInterfaceEnabled(intf) {
+		return errors.New("OSPF already enabled on interface")
+	}
+	slot := -1
+	for i, ospfIntf := range ospf.Interfaces {
+		if ospfIntf == nil {
+			slot = i
+			break
+		}
+	}
+	if slot == -1 {
+		return errors.New("no free OSPF interface slot")
+	}
+	ospf.Interfaces[slot] = intf
+
+	ospfOriginateRouterLsa(node)
+	sendOSPFHello(node, intf)
+	return nil
+}
+
+func DisableOSPF(node *data.Node, intfName string) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	ospf := node.Properties.Ospf
+	if ospf == nil {
+		return errors.New("OSPF is not enabled on interface")
+	}
+
+	ospf.Mutex.Lock()
+	defer ospf.Mutex.Unlock()
+
+	if !ospf.IsInterfaceEnabled(intf) {
+		return errors.New("OSPF is not enabled on interface")
+	}
+	for i, ospfIntf := range ospf.Interfaces {
+		if ospfIntf == intf {
+			ospf.Interfaces[i] = nil
+		}
+	}
+	ospfDropNeighbors(ospf, intf)
+	ospfOriginateRouterLsa(node)
+	return nil
+}
+
+func ospfDropNeighbors(ospf *data.OspfInstance, intf *data.Interface) {
+	for dllNeighbor := ospf.Neighbors.Next; dllNeighbor != nil; {
+		neighbor := dllNeighbor.DllToOspfNeighbor()
+		dllNeighbor = dllNeighbor.Next
+		if neighbor.InterfaceName == intf.Name {
+			(&neighbor.NeighborGlue).RemoveNode()
+		}
+	}
+}
+
+// ospfOriginateRouterLsa describes the enabled interfaces of the node in a new instance of its
+// router LSA and floods it to all adjacent neighbors.
+func ospfOriginateRouterLsa(node *data.Node) {
+	ospf := node.Properties.Ospf
+
+	ospf.Sequence++
+	lsa := &data.OspfLsa{
+		AdvertisingRouter: ospf.RouterID,
+		Sequence:          ospf.Sequence,
+		InstalledAt:       time.Now(),
+	}
+	lsa.Links = append(lsa.Links, data.OspfLink{
+		Type:   constants.OspfLinkStub,
+		LinkID: ospf.RouterID,
+		Mask:   32,
+	})
+
+	for _, intf := range ospf.Interfaces {
+		if intf == nil || !intf.IsUp() {
+			continue
+		}
+		cost := uint16(intf.LinkCost())
+		for dllNeighbor := ospf.Neighbors.Next; dllNeighbor != nil; dllNeighbor = dllNeighbor.Next {
+			neighbor := dllNeighbor.DllToOspfNeighbor()
+			if neighbor.InterfaceName != intf.Name || neighbor.State != data.OspfNeighborFull {
+				continue
+			}
+			lsa.Links = append(lsa.Links, data.OspfLink{
+				Type:     constants.OspfLinkPointToPoint,
+				Cost:     cost,
+				LinkID:   neighbor.RouterID,
+				LinkData: intf.Properties.IP,
+			})
+		}
+		lsa.Links = append(lsa.Links, data.OspfLink{
+			Type:   constants.OspfLinkStub,
+			Cost:   cost,
+			LinkID: data.ApplyMask(intf.Properties.IP, intf.Properties.Mask),
+			Mask:   intf.Properties.Mask,
+		})
+	}
+
+	ospf.InstallLsa(lsa)
+	ospf.RefreshAt = lsa.InstalledAt.Add(time.Duration(constants.OspfLsRefreshSeconds) * time.Second)
+	ospf.SpfPending = true
+	ospfFlood(node, []*data.OspfLsa{lsa}, nil)
+}
+
+func sendOSPFPacket(node *data.Node, oif *data.Interface, packet data.OspfPacket) {
+	PacketSendLinkLocal(node, oif, constants.OspfAllSPFRoutersIP, constants.OspfProto, packet.SerializeOspfPacket())
+}
+
+func sendOSPFHello(node *data.Node, oif *data.Interface) {
+	ospf := node.Properties.Ospf
+	hello := &data.OspfHello{
+		NetworkMask:   oif.Properties.Mask,
+		HelloInterval: uint16(constants.OspfHelloIntervalSeconds),
+		DeadInterval:  uint16(constants.OspfDeadIntervalSeconds),
+	}
+	for dllNeighbor := ospf.Neighbors.Next; dllNeighbor != nil; dllNeighbor = dllNeighbor.Next {
+		neighbor := dllNeighbor.DllToOspfNeighbor()
+		if neighbor.InterfaceName == oif.Name {
+			hello.Neighbors = append(hello.Neighbors, neighbor.RouterID)
+		}
+	}
+	sendOSPFPacket(node, oif, data.OspfPacket{
+		Type:     constants.OspfPacketHello,
+		RouterID: ospf.RouterID,
+		Hello:    hello,
+	})
+}
+
+// sendOSPFLsas sends the LSAs out of oif, split over as many link state updates as needed.
+func sendOSPFLsas(node *data.Node, oif *data.Interface, lsas []*data.OspfLsa) {
+	ospf := node.Properties.Ospf
+	for len(lsas) > 0 {
+		packet := data.OspfPacket{
+			Type:     constants.OspfPacketLinkStateUpdate,
+			RouterID: ospf.RouterID,
+		}
+		for len(lsas) > 0 {
+			packet.Lsas = append(packet.Lsas, lsas[0])
+			if len(packet.Lsas) > 1 && len(packet.SerializeOspfPacket()) > ospfMaxPacketSize {
+				packet.Lsas = packet.Lsas[:len(packet.Lsas)-1]
+				break
+			}
+			lsas = lsas[1:]
+		}
+		sendOSPFPacket(node, oif, packet)
+	}
+}
+
+// ospfFlood sends the LSAs to every fully adjacent neighbor except those behind iif.
+func ospfFlood(node *data.Node, lsas []*data.OspfLsa, iif *data.Interface) {
+	ospf := node.Properties.Ospf
+	for _, intf := range ospf.Interfaces {
+		if intf == nil || intf == iif || !intf.IsUp() {
+			continue
+		}
+		for dllNeighbor := ospf.Neighbors.Next; dllNeighbor != nil; dllNeighbor = dllNeighbor.Next {
+			neighbor := dllNeighbor.DllToOspfNeighbor()
+			if neighbor.InterfaceName == intf.Name && neighbor.State == data.OspfNeighborFull {
+				sendOSPFLsas(node, intf, lsas)
+				break
+			}
+		}
+	}
+}
+
+func ospfDatabase(ospf *data.OspfInstance) []*data.OspfLsa {
+	var lsas []*data.OspfLsa
+	for dllLsa := ospf.Lsdb.Next; dllLsa != nil; dllLsa = dllLsa.Next {
+		lsas = append(lsas, dllLsa.DllToOspfLsa())
+	}
+	return lsas
+}
+
+func processOSPFPacket(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, payload []byte) {
+	ospf := node.Properties.Ospf
+	if iif == nil || ospf == nil {
+		return
+	}
+
+	packet, err := data.DeserializeOspfPacket(payload)
+	if err != nil {
+		fmt.Println("processOSPFPacket: OSPF packet dropped on interface", iif.Name.String(), "of node", node.NodeName, ":", err)
+		return
+	}
+
+	ospf.Mutex.Lock()
+	defer ospf.Mutex.Unlock()
+
+	if !ospf.IsInterfaceEnabled(iif) || packet.RouterID == ospf.RouterID {
+		return
+	}
+
+	switch packet.Type {
+	case constants.OspfPacketHello:
+		processOSPFHello(node, iif, ipHeader.SourceIP, packet)
+	case constants.OspfPacketLinkStateUpdate:
+		if ospf.LookupNeighbor(packet.RouterID, iif.Name) == nil {
+			return
+		}
+		processOSPFLinkStateUpdate(node, iif, packet)
+	}
+}
+
+func processOSPFHello(node *data.Node, iif *data.Interface, sourceIP data.IPAddress, packet *data.OspfPacket) {
+	ospf := node.Properties.Ospf
+	hello := packet.Hello
+
+	if hello.NetworkMask != iif.Properties.Mask || node.GetMatchingSubnetInterface(sourceIP) != iif ||
+		hello.HelloInterval != uint16(constants.OspfHelloIntervalSeconds) || hello.DeadInterval != uint16(constants.OspfDeadIntervalSeconds) {
+		fmt.Println("processOSPFHello: hello parameters of", packet.RouterID.String(), "do not match interface", iif.Name.String(), "of node", node.NodeName)
+		return
+	}
+
+	neighbor := ospf.LookupNeighbor(packet.RouterID, iif.Name)
+	if neighbor == nil {
+		neighbor = &data.OspfNeighbor{
+			RouterID:      packet.RouterID,
+			InterfaceName: iif.Name,
+			State:         data.OspfNeighborInit,
+		}
+		(&neighbor.NeighborGlue).Init()
+		(&ospf.Neighbors).AddNode(&neighbor.NeighborGlue)
+		// answer right away so the neighbor sees itself without waiting for the next hello
+		defer sendOSPFHello(node, iif)
+	}
+	neighbor.IP = sourceIP
+	neighbor.DeadAt = time.Now().Add(time.Duration(constants.OspfDeadIntervalSeconds) * time.Second)
+
+	isTwoWay := false
+	for _, routerID := range hello.Neighbors {
+		if routerID == ospf.RouterID {
+			isTwoWay = true
+			break
+		}
+	}
+
+	switch {
+	case isTwoWay && neighbor.State != data.OspfNeighborFull:
+		// point to point adjacencies are synchronized by sending the whole database
+		neighbor.State = data.OspfNeighborFull
+		ospfOriginateRouterLsa(node)
+		sendOSPFLsas(node, iif, ospfDatabase(ospf))
+	case !isTwoWay && neighbor.State == data.OspfNeighborFull:
+		neighbor.State = data.OspfNeighborInit
+		ospfOriginateRouterLsa(node)
+	}
+}
+
+func processOSPFLinkStateUpdate(node *data.Node, iif *data.Interface, packet *data.OspfPacket) {
+	ospf := node.Properties.Ospf
+	now := time.Now()
+	var newLsas []*data.OspfLsa
+	var staleLsas []*data.OspfLsa
+
+	for _, lsa := range packet.Lsas {
+		lsa.InstalledAt = now
+		oldLsa := ospf.LookupLsa(lsa.AdvertisingRouter)
+
+		if lsa.AdvertisingRouter == ospf.RouterID {
+			// an instance of our own LSA from before a restart, continue after its sequence number
+			if oldLsa == nil || lsa.Sequence > oldLsa.Sequence {
+				ospf.Sequence = lsa.Sequence
+				ospfOriginateRouterLsa(node)
+			}
+			continue
+		}
+
+		switch {
+		case oldLsa == nil || lsa.Sequence > oldLsa.Sequence:
+			if int(lsa.Age) >= constants.OspfMaxAgeSeconds {
+				if oldLsa != nil {
+					(&oldLsa.LsaGlue).RemoveNode()
+					ospf.SpfPending = true
+				}
+				continue
+			}
+			ospf.InstallLsa(lsa)
+			ospf.SpfPending = true
+			newLsas = append(newLsas, lsa)
+		case lsa.Sequence < oldLsa.Sequence:
+			staleLsas = append(staleLsas, oldLsa)
+		}
+	}
+
+	if len(newLsas) > 0 {
+		ospfFlood(node, newLsas, iif)
+	}
+	if len(staleLsas) > 0 {
+		sendOSPFLsas(node, iif, staleLsas)
+	}
+}
+
+type ospfVertex struct {
+	lsa      *data.OspfLsa
+	cost     uint32
+	nextHops []data.Layer3NextHop
+	done     bool
+}
+
+// ospfHasLink reports whether lsa advertises a point to point adjacency to routerID.
+func ospfHasLink(lsa *data.OspfLsa, routerID data.IPAddress) bool {
+	for _, link := range lsa.Links {
+		if link.Type == constants.OspfLinkPointToPoint && link.LinkID == routerID {
+			return true
+		}
+	}
+	return false
+}
+
+func ospfMergeNextHops(nextHops []data.Layer3NextHop, more []data.Layer3NextHop) []data.Layer3NextHop {
+	for _, nextHop := range more {
+		isKnown := false
+		for _, known := range nextHops {
+			if known == nextHop {
+				isKnown = true
+				break
+			}
+		}
+		if !isKnown && len(nextHops) < constants.MaxNextHops {
+			nextHops = append(nextHops, nextHop)
+		}
+	}
+	return nextHops
+}
+
+// ospfRunSpf runs Dijkstra over the router LSAs of the LSDB and installs the shortest paths to
+// every stub network into the RIB, equal cost paths become multipath routes.
+func ospfRunSpf(node *data.Node) {
+	ospf := node.Properties.Ospf
+	ospf.SpfPending = false
+
+	root := ospf.LookupLsa(ospf.RouterID)
+	if root == nil {
+		return
+	}
+	vertices := map[data.IPAddress]*ospfVertex{
+		ospf.RouterID: {lsa: root},
+	}
+
+	// the first hops come from the neighbor table, it knows the addresses of the neighbors
+	for dllNeighbor := ospf.Neighbors.Next; dllNeighbor != nil; dllNeighbor = dllNeighbor.Next {
+		neighbor := dllNeighbor.DllToOspfNeighbor()
+		lsa := ospf.LookupLsa(neighbor.RouterID)
+		intf := node.GetNodeIntfByName(neighbor.InterfaceName.String())
+		if neighbor.State != data.OspfNeighborFull || lsa == nil || intf == nil || !intf.IsUp() || !ospfHasLink(lsa, ospf.RouterID) {
+			continue
+		}
+		nextHops := []data.Layer3NextHop{{GatewayIP: neighbor.IP, InterfaceName: neighbor.InterfaceName, Weight: 1}}
+		cost := intf.LinkCost()
+
+		vertex := vertices[neighbor.RouterID]
+		switch {
+		case vertex == nil:
+			vertices[neighbor.RouterID] = &ospfVertex{lsa: lsa, cost: cost, nextHops: nextHops}
+		case cost < vertex.cost:
+			vertex.cost = cost
+			vertex.nextHops = nextHops
+		case cost == vertex.cost:
+			vertex.nextHops = ospfMergeNextHops(vertex.nextHops, nextHops)
+		}
+	}
+	vertices[ospf.RouterID].done = true
+
+	for {
+		var current *ospfVertex
+		for _, vertex := range vertices {
+			if !vertex.done && (current == nil || vertex.cost < current.cost) {
+				current = vertex
+			}
+		}
+		if current == nil {
+			break
+		}
+		current.done = true
+
+		for _, link := range current.lsa.Links {
+			if link.Type != constants.OspfLinkPointToPoint {
+				continue
+			}
+			lsa := ospf.LookupLsa(link.LinkID)
+			if lsa == nil || !ospfHasLink(lsa, current.lsa.AdvertisingRouter) {
+				continue
+			}
+			cost := current.cost + uint32(link.Cost)
+			vertex := vertices[link.LinkID]
+			switch {
+			case vertex == nil:
+				vertices[link.LinkID] = &ospfVertex{lsa: lsa, cost: cost, nextHops: append([]data.Layer3NextHop(nil), current.nextHops...)}
+			case vertex.done:
+			case cost < vertex.cost:
+				vertex.cost = cost
+				vertex.nextHops = append([]data.Layer3NextHop(nil), current.nextHops...)
+			case cost == vertex.cost:
+				vertex.nextHops = ospfMergeNextHops(vertex.nextHops, current.nextHops)
+			}
+		}
+	}
+
+	type prefix struct {
+		IP   data.IPAddress
+		Mask rune
+	}
+	localPrefixes := map[prefix]bool{}
+	for _, link := range root.Links {
+		if link.Type == constants.OspfLinkStub {
+			localPrefixes[prefix{link.LinkID, link.Mask}] = true
+		}
+	}
+
+	routes := map[prefix]*data.OspfRoute{}
+	var order []prefix
+	for routerID, vertex := range vertices {
+		if routerID == ospf.RouterID || len(vertex.nextHops) == 0 {
+			continue
+		}
+		for _, link := range vertex.lsa.Links {
+			key := prefix{link.LinkID, link.Mask}
+			if link.Type != constants.OspfLinkStub || localPrefixes[key] {
+				continue
+			}
+			cost := vertex.cost + uint32(link.Cost)
+			route := routes[key]
+			switch {
+			case route == nil:
+				routes[key] = &data.OspfRoute{DestinationIP: link.LinkID, Mask: link.Mask, Cost: cost, NextHops: vertex.nextHops}
+				order = append(order, key)
+			case cost < route.Cost:
+				route.Cost = cost
+				route.NextHops = vertex.nextHops
+			case cost == route.Cost:
+				route.NextHops = ospfMergeNextHops(append([]data.Layer3NextHop(nil), route.NextHops...), vertex.nextHops)
+			}
+		}
+	}
+
+	// withdraw what is no longer reachable, then install the new shortest paths
+	for dllRoute := ospf.Routes.Next; dllRoute != nil; {
+		route := dllRoute.DllToOspfRoute()
+		dllRoute = dllRoute.Next
+		if routes[prefix{route.DestinationIP, route.Mask}] == nil {
+			node.Properties.Rib.DeleteRoute(route.DestinationIP, route.Mask, constants.RouteSourceOSPF)
+		}
+		(&route.RouteGlue).RemoveNode()
+	}
+	for _, key := range order {
+		route := routes[key]
+		err := node.Properties.Rib.ReplaceRoute(route.DestinationIP, route.Mask, constants.RouteSourceOSPF, route.Cost, route.NextHops)
+		if err != nil {
+			fmt.Println("OSPF: failed to install route", route.DestinationIP.String(), "on node", node.NodeName, ":", err)
+		}
+		(&route.RouteGlue).Init()
+		(&ospf.Routes).AddNode(&route.RouteGlue)
+	}
+}
+
+// ospfTimer sends hellos, expires dead neighbors and neighbors behind failed links, ages the
+// LSDB, refreshes the own router LSA and reruns SPF when the LSDB changed.
+func ospfTimer(node *data.Node) {
+	ticker := time.NewTicker(time.Second)
+	for now := range ticker.C {
+		ospf := node.Properties.Ospf
+		ospf.Mutex.Lock()
+
+		isTopologyChanged := false
+		for dllNeighbor := ospf.Neighbors.Next; dllNeighbor != nil; {
+			neighbor := dllNeighbor.DllToOspfNeighbor()
+			dllNeighbor = dllNeighbor.Next
+			intf := node.GetNodeIntfByName(neighbor.InterfaceName.String())
+			if intf != nil && intf.IsUp() && now.Before(neighbor.DeadAt) {
+				continue
+			}
+			(&neighbor.NeighborGlue).RemoveNode()
+			isTopologyChanged = true
+		}
+		if isTopologyChanged || !now.Before(ospf.RefreshAt) {
+			ospfOriginateRouterLsa(node)
+		}
+
+		for dllLsa := ospf.Lsdb.Next; dllLsa != nil; {
+			lsa := dllLsa.DllToOspfLsa()
+			dllLsa = dllLsa.Next
+			if lsa.AdvertisingRouter != ospf.RouterID && int(lsa.CurrentAge(now)) >= constants.OspfMaxAgeSeconds {
+				(&lsa.LsaGlue).RemoveNode()
+				ospf.SpfPending = true
+			}
+		}
+
+		if !now.Before(ospf.NextHello) {
+			for _, intf := range ospf.Interfaces {
+				if intf != nil && intf.IsUp() {
+					sendOSPFHello(node, intf)
+				}
+			}
+			ospf.NextHello = now.Add(time.Duration(constants.OspfHelloIntervalSeconds) * time.Second)
+		}
+
+		if ospf.SpfPending {
+			ospfRunSpf(node)
+		}
+
+		ospf.Mutex.Unlock()
+	}
+}
@@ -0,0 +1,324 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+)
+
+// EnableRIP runs RIPv2 on the interface, the RIP instance of the node is created with its first interface.
+func EnableRIP(node *data.Node, intfName string) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	if !intf.Properties.IsIpConfigured {
+		return errors.New("interface has no IP address")
+	}
+
+	if node.Properties.Rip == nil {
+		rip := &data.RipInstance{
+			Routes:     data.Dll{},
+			NextUpdate: time.Now(),
+		}
+		(&rip.Routes).Init()
+		if node.Properties.IsLbConfigured {
+			rip.AddRoute(&data.RipRoute{
+				DestinationIP: node.Properties.LB,
+				Mask:          32,
+				Metric:        1,
+				IsConnected:   true,
+				IsChanged:     true,
+			})
+		}
+		node.Properties.Rip = rip
+		node.Properties.UDPPorts.Register(constants.RipPort, processRIPMessage)
+		go ripTimer(node)
+	}
+
+	rip := node.Properties.Rip
+	rip.Mutex.Lock()
+	defer rip.Mutex.Unlock()
+
+	if rip.IsInterfaceEnabled(intf) {
+		return errors.New("RIP already enabled on interface")
+	}
+	slot := -1
+	for i, ripIntf := range rip.Interfaces {
+		if ripIntf == nil {
+			slot = i
+			break
+		}
+	}
+	if slot == -1 {
+		return errors.New("no free RIP interface slot")
+	}
+	rip.Interfaces[slot] = intf
+
+	route := rip.LookupRoute(intf.Properties.IP, intf.Properties.Mask)
+	if route == nil {
+		route = &data.RipRoute{
+			DestinationIP: intf.Properties.IP,
+			Mask:          intf.Properties.Mask,
+		}
+		rip.AddRoute(route)
+	} else if !route.IsConnected {
+		node.Properties.Rib.DeleteRoute(route.DestinationIP, route.Mask, constants.RouteSourceRIP)
+	}
+	route.Metric = intf.LinkCost()
+	route.InterfaceName = intf.Name
+	route.GatewayIP = data.IPAddress{}
+	route.IsConnected = true
+	route.IsChanged = true
+	rip.TriggeredUpdate = true
+
+	sendRIPRequest(node, intf)
+	return nil
+}
+
+// DisableRIP stops RIP on the interface, routes learned over it are poisoned towards the remaining neighbors.
+func DisableRIP(node *data.Node, intfName string) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	rip := node.Properties.Rip
+	if rip == nil {
+		return errors.New("RIP is not enabled on interface")
+	}
+
+	rip.Mutex.Lock()
+	defer rip.Mutex.Unlock()
+
+	if !rip.IsInterfaceEnabled(intf) {
+		return errors.New("RIP is not enabled on interface")
+	}
+	for i, ripIntf := range rip.Interfaces {
+		if ripIntf == intf {
+			rip.Interfaces[i] = nil
+		}
+	}
+
+	now := time.Now()
+	for dllRoute := rip.Routes.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		route := dllRoute.DllToRipRoute()
+		if route.InterfaceName != intf.Name || route.Metric >= constants.RipInfinity {
+			continue
+		}
+		if !route.IsConnected {
+			node.Properties.Rib.DeleteRoute(route.DestinationIP, route.Mask, constants.RouteSourceRIP)
+		}
+		route.IsConnected = false
+		ripPoisonRoute(rip, route, now)
+	}
+	return nil
+}
+
+func ripPoisonRoute(rip *data.RipInstance, route *data.RipRoute, now time.Time) {
+	route.Metric = constants.RipInfinity
+	route.GarbageAt = now.Add(time.Duration(constants.RipGarbageCollectionSeconds) * time.Second)
+	route.IsChanged = true
+	rip.TriggeredUpdate = true
+}
+
+func ripInstallRoute(node *data.Node, route *data.RipRoute) {
+	nextHops := []data.Layer3NextHop{{GatewayIP: route.GatewayIP, InterfaceName: route.InterfaceName, Weight: 1}}
+	err := node.Properties.Rib.ReplaceRoute(route.DestinationIP, route.Mask, constants.RouteSourceRIP, route.Metric, nextHops)
+	if err != nil {
+		fmt.Println("RIP: failed to install route", route.DestinationIP.String(), "on node", node.NodeName, ":", err)
+	}
+}
+
+func sendRIPRequest(node *data.Node, oif *data.Interface) {
+	// a single entry with address family zero and an infinite metric asks for the whole table
+	message := data.RipMessage{
+		Command: constants.RipCommandRequest,
+		Version: constants.RipVersion,
+		Entries: []data.RipEntry{{Metric: constants.RipInfinity}},
+	}
+	UDPSendLinkLocal(node, oif, constants.RipPort, constants.RipMulticastIP, constants.RipPort, message.SerializeRipMessage())
+}
+
+// sendRIPUpdate advertises the RIP table out of oif, routes learned over oif are poisoned (split horizon with poison reverse).
+func sendRIPUpdate(node *data.Node, oif *data.Interface, changedOnly bool) {
+	rip := node.Properties.Rip
+	var entries []data.RipEntry
+
+	for dllRoute := rip.Routes.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		route := dllRoute.DllToRipRoute()
+		if changedOnly && !route.IsChanged {
+			continue
+		}
+		entry := data.RipEntry{
+			AddressFamily: constants.RipAddressFamilyIP,
+			IP:            route.DestinationIP,
+			Mask:          route.Mask,
+			Metric:        route.Metric,
+		}
+		if !route.IsConnected && route.InterfaceName == oif.Name {
+			entry.Metric = constants.RipInfinity
+		}
+		entries = append(entries, entry)
+	}
+
+	for len(entries) > 0 {
+		count := len(entries)
+		if count > constants.RipMaxEntriesPerMessage {
+			count = constants.RipMaxEntriesPerMessage
+		}
+		message := data.RipMessage{
+			Command: constants.RipCommandResponse,
+			Version: constants.RipVersion,
+			Entries: entries[:count],
+		}
+		UDPSendLinkLocal(node, oif, constants.RipPort, constants.RipMulticastIP, constants.RipPort, message.SerializeRipMessage())
+		entries = entries[count:]
+	}
+}
+
+func sendRIPUpdates(node *data.Node, changedOnly bool) {
+	rip := node.Properties.Rip
+	for _, intf := range rip.Interfaces {
+		if intf != nil {
+			sendRIPUpdate(node, intf, changedOnly)
+		}
+	}
+	for dllRoute := rip.Routes.Next; dllRoute != nil; dllRoute = dllRoute.Next {
+		dllRoute.DllToRipRoute().IsChanged = false
+	}
+	rip.TriggeredUpdate = false
+}
+
+func processRIPMessage(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, udpHeader data.UDPHeader, appData []byte) {
+	rip := node.Properties.Rip
+	if iif == nil || rip == nil {
+		return
+	}
+
+	message, err := data.DeserializeRipMessage(appData)
+	if err != nil {
+		fmt.Println("processRIPMessage: RIP message dropped on interface", iif.Name.String(), "of node", node.NodeName, ":", err)
+		return
+	}
+	if message.Version != constants.RipVersion || udpHeader.SourcePort != constants.RipPort {
+		return
+	}
+
+	rip.Mutex.Lock()
+	defer rip.Mutex.Unlock()
+
+	if !rip.IsInterfaceEnabled(iif) {
+		return
+	}
+
+	switch message.Command {
+	case constants.RipCommandRequest:
+		sendRIPUpdate(node, iif, false)
+	case constants.RipCommandResponse:
+		if node.GetMatchingSubnetInterface(ipHeader.SourceIP) != iif {
+			return
+		}
+		processRIPResponse(node, iif, ipHeader.SourceIP, message)
+	}
+}
+
+func processRIPResponse(node *data.Node, iif *data.Interface, sourceIP data.IPAddress, message *data.RipMessage) {
+	rip := node.Properties.Rip
+	now := time.Now()
+	expiresAt := now.Add(time.Duration(constants.RipTimeoutSeconds) * time.Second)
+
+	for _, entry := range message.Entries {
+		if entry.AddressFamily != constants.RipAddressFamilyIP || entry.Metric == 0 || entry.Metric > constants.RipInfinity {
+			continue
+		}
+		metric := entry.Metric + iif.LinkCost()
+		if metric > constants.RipInfinity {
+			metric = constants.RipInfinity
+		}
+		gatewayIP := sourceIP
+		if entry.NextHop != (data.IPAddress{}) && entry.NextHop != data.StringToIPAddress("0.0.0.0") &&
+			node.GetMatchingSubnetInterface(entry.NextHop) == iif {
+			gatewayIP = entry.NextHop
+		}
+
+		route := rip.LookupRoute(entry.IP, entry.Mask)
+		switch {
+		case route == nil:
+			if metric >= constants.RipInfinity {
+				continue
+			}
+			route = &data.RipRoute{
+				DestinationIP: entry.IP,
+				Mask:          entry.Mask,
+				Metric:        metric,
+				GatewayIP:     gatewayIP,
+				InterfaceName: iif.Name,
+				ExpiresAt:     expiresAt,
+				IsChanged:     true,
+			}
+			rip.AddRoute(route)
+			ripInstallRoute(node, route)
+			rip.TriggeredUpdate = true
+		case route.IsConnected:
+			continue
+		case route.GatewayIP == gatewayIP && route.InterfaceName == iif.Name:
+			if metric < constants.RipInfinity {
+				route.ExpiresAt = expiresAt
+			}
+			if metric == route.Metric {
+				continue
+			}
+			if metric >= constants.RipInfinity {
+				node.Properties.Rib.DeleteRoute(route.DestinationIP, route.Mask, constants.RouteSourceRIP)
+				ripPoisonRoute(rip, route, now)
+				continue
+			}
+			route.Metric = metric
+			route.IsChanged = true
+			rip.TriggeredUpdate = true
+			ripInstallRoute(node, route)
+		case metric < route.Metric:
+			route.Metric = metric
+			route.GatewayIP = gatewayIP
+			route.InterfaceName = iif.Name
+			route.ExpiresAt = expiresAt
+			route.IsChanged = true
+			rip.TriggeredUpdate = true
+			ripInstallRoute(node, route)
+		}
+	}
+}
+
+// ripTimer drives the periodic updates, the route timeouts, garbage collection and triggered updates of the node.
+func ripTimer(node *data.Node) {
+	ticker := time.NewTicker(time.Second)
+	for now := range ticker.C {
+		rip := node.Properties.Rip
+		rip.Mutex.Lock()
+
+		for dllRoute := rip.Routes.Next; dllRoute != nil; {
+			route := dllRoute.DllToRipRoute()
+			dllRoute = dllRoute.Next
+			if route.IsConnected {
+				continue
+			}
+			if route.Metric < constants.RipInfinity && now.After(route.ExpiresAt) {
+				node.Properties.Rib.DeleteRoute(route.DestinationIP, route.Mask, constants.RouteSourceRIP)
+				ripPoisonRoute(rip, route, now)
+			} else if route.Metric >= constants.RipInfinity && now.After(route.GarbageAt) {
+				(&route.RouteGlue).RemoveNode()
+			}
+		}
+
+		if !now.Before(rip.NextUpdate) {
+			sendRIPUpdates(node, false)
+			rip.NextUpdate = now.Add(time.Duration(constants.RipUpdateIntervalSeconds) * time.Second)
+		} else if rip.TriggeredUpdate {
+			sendRIPUpdates(node, true)
+		}
+
+		rip.Mutex.Unlock()
+	}
+}
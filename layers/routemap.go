@@ -0,0 +1,184 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"tcpip/data"
+)
+
+// getRouteMapInstance returns the route maps of the node, the first route map creates them.
+func getRouteMapInstance(node *data.Node) *data.RouteMapInstance {
+	if node.Properties.RouteMaps == nil {
+		instance := &data.RouteMapInstance{
+			Maps:    data.Dll{},
+			Applied: map[*data.Interface]*data.RouteMap{},
+		}
+		(&instance.Maps).Init()
+		node.Properties.RouteMaps = instance
+	}
+	return node.Properties.RouteMaps
+}
+
+// AddRouteMapEntry adds an entry to the route map name, creating the map if needed. The entry sets
+// a next hop on a connected subnet, an outgoing interface, or both.
+func AddRouteMapEntry(node *data.Node, name string, entry data.RouteMapEntry) error {
+	match := &entry.Match
+	if !match.SourceIP.IsIPv4() || !match.DestinationIP.IsIPv4() {
+		return errors.New("route maps match IPv4 addresses only")
+	}
+	if !entry.HasNextHop && entry.Interface.String() == "" {
+		return errors.New("route map entry sets no next hop or interface")
+	}
+	if entry.HasNextHop && !entry.NextHop.IsIPv4() {
+		return errors.New("next hop must be an IPv4 address")
+	}
+	if entry.Interface.String() != "" {
+		intf := node.GetNodeIntfOrTunnelByName(entry.Interface.String())
+		if intf == nil {
+			return errors.New("interface not found")
+		}
+		if !intf.Properties.IsIpConfigured {
+			return errors.New("interface has no IP address")
+		}
+	}
+	match.SourceIP = data.ApplyMask(match.SourceIP, match.SourceMask)
+	match.DestinationIP = data.ApplyMask(match.DestinationIP, match.DestinationMask)
+	entry.Hits = 0
+
+	instance := getRouteMapInstance(node)
+	instance.Mutex.Lock()
+	defer instance.Mutex.Unlock()
+
+	routeMap := instance.LookupRouteMap(name)
+	if routeMap == nil {
+		routeMap = &data.RouteMap{
+			Name: name,
+		}
+		instance.AddRouteMap(routeMap)
+	}
+	return routeMap.AddEntry(&entry)
+}
+
+// DeleteRouteMapEntry removes the entry with the sequence number from the route map name.
+func DeleteRouteMapEntry(node *data.Node, name string, sequence int) error {
+	instance := node.Properties.RouteMaps
+	if instance == nil {
+		return data.ErrRouteMapNotFound
+	}
+	instance.Mutex.Lock()
+	defer instance.Mutex.Unlock()
+
+	routeMap := instance.LookupRouteMap(name)
+	if routeMap == nil {
+		return data.ErrRouteMapNotFound
+	}
+	if !routeMap.DeleteEntry(sequence) {
+		return errors.New("entry not found")
+	}
+	return nil
+}
+
+// DeleteRouteMap removes the route map name, it must not be applied to an interface.
+func DeleteRouteMap(node *data.Node, name string) error {
+	instance := node.Properties.RouteMaps
+	if instance == nil {
+		return data.ErrRouteMapNotFound
+	}
+	instance.Mutex.Lock()
+	defer instance.Mutex.Unlock()
+
+	routeMap := instance.LookupRouteMap(name)
+	if routeMap == nil {
+		return data.ErrRouteMapNotFound
+	}
+	for intf, applied := range instance.Applied {
+		if applied == routeMap {
+			return fmt.Errorf("route map is applied to interface %s", intf.Name.String())
+		}
+	}
+	(&routeMap.RouteMapGlue).RemoveNode()
+	return nil
+}
+
+// ApplyRouteMap routes the packets received on the interface with the route map name, replacing
+// the route map applied before.
+func ApplyRouteMap(node *data.Node, intfName string, name string) error {
+	intf := node.GetNodeIntfOrTunnelByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	instance := getRouteMapInstance(node)
+	instance.Mutex.Lock()
+	defer instance.Mutex.Unlock()
+
+	routeMap := instance.LookupRouteMap(name)
+	if routeMap == nil {
+		return data.ErrRouteMapNotFound
+	}
+	instance.Applied[intf] = routeMap
+	return nil
+}
+
+// RemoveRouteMap returns the packets received on the interface to destination based routing.
+func RemoveRouteMap(node *data.Node, intfName string) error {
+	intf := node.GetNodeIntfOrTunnelByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	instance := node.Properties.RouteMaps
+	if instance == nil {
+		return errors.New("no route map applied to interface")
+	}
+	instance.Mutex.Lock()
+	defer instance.Mutex.Unlock()
+
+	if instance.Applied[intf] == nil {
+		return errors.New("no route map applied to interface")
+	}
+	delete(instance.Applied, intf)
+	return nil
+}
+
+// routeMapNextHop evaluates the route map applied to the ingress interface and returns where the
+// matching entry sends the packet. It returns false when no entry matches or the next hop the entry
+// sets cannot be reached, the packet then follows the routing table.
+func routeMapNextHop(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, payload *data.Payload) (data.IPAddress, *data.Interface, bool) {
+	instance := node.Properties.RouteMaps
+	if instance == nil || iif == nil {
+		return data.IPAddress{}, nil, false
+	}
+	instance.Mutex.Lock()
+	routeMap := instance.Applied[iif]
+	var entry *data.RouteMapEntry
+	if routeMap != nil {
+		entry = routeMap.Evaluate(aclPacket(ipHeader, payload))
+	}
+	var hasNextHop bool
+	var nextHop data.IPAddress
+	var intfName string
+	if entry != nil {
+		hasNextHop, nextHop, intfName = entry.HasNextHop, entry.NextHop, entry.Interface.String()
+	}
+	instance.Mutex.Unlock()
+	if entry == nil {
+		return data.IPAddress{}, nil, false
+	}
+
+	// without next hop the destination is resolved on the link of the interface
+	gatewayIP := ipHeader.DestinationIP
+	if hasNextHop {
+		gatewayIP = nextHop
+	}
+	// the packet never leaves the VRF it was received in
+	vrf := intfVrf(iif)
+	var oif *data.Interface
+	if intfName != "" {
+		oif = node.GetNodeIntfOrTunnelByName(intfName)
+	} else {
+		oif = node.GetVrfMatchingSubnetInterface(nextHop, vrf)
+	}
+	if oif == nil || !oif.Properties.IsIpConfigured || oif.Properties.Vrf != vrf || IsVrfLocalDelivery(node, vrf, gatewayIP) {
+		return data.IPAddress{}, nil, false
+	}
+	return gatewayIP, oif, true
+}
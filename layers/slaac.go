@@ -0,0 +1,336 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"tcpip/cmd/communication/send"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+)
+
+// getSLAACInstance returns the SLAAC instance of the node, the first advertising or autoconfiguring
+// interface creates it.
+func getSLAACInstance(node *data.Node) *data.SlaacInstance {
+	if node.Properties.Slaac == nil {
+		slaac := &data.SlaacInstance{
+			Advertisers: data.Dll{},
+			Clients:     data.Dll{},
+		}
+		(&slaac.Advertisers).Init()
+		(&slaac.Clients).Init()
+		node.Properties.Slaac = slaac
+		go slaacTimer(node)
+	}
+	return node.Properties.Slaac
+}
+
+// EnableRouterAdvertisement makes the node advertise itself as IPv6 router on the interface. The
+// global prefix of the interface is always advertised, prefixes are advertised on top of it and a
+// router lifetime of zero keeps hosts from using the node as default router.
+func EnableRouterAdvertisement(node *data.Node, intfName string, routerLifetime uint16, prefixes []data.RaPrefix) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	if !intf.Properties.IsIpv6Enabled {
+		return errors.New("IPv6 is not enabled on interface")
+	}
+	for i := range prefixes {
+		prefix := &prefixes[i]
+		if prefix.Prefix.IsIPv4() || prefix.Mask < 1 || prefix.Mask > 128 {
+			return data.ErrInvalidRouteMask
+		}
+		prefix.Prefix = data.ApplyMask(prefix.Prefix, prefix.Mask)
+		if prefix.ValidLifetime == 0 {
+			prefix.ValidLifetime = constants.RaDefaultValidLifetimeSeconds
+		}
+		if prefix.PreferredLifetime == 0 {
+			prefix.PreferredLifetime = constants.RaDefaultPreferredLifetimeSeconds
+		}
+		if prefix.PreferredLifetime > prefix.ValidLifetime {
+			return errors.New("preferred lifetime exceeds valid lifetime")
+		}
+	}
+
+	slaac := getSLAACInstance(node)
+	slaac.Mutex.Lock()
+	defer slaac.Mutex.Unlock()
+
+	if slaac.LookupClient(intf) != nil {
+		return errors.New("interface autoconfigures its address")
+	}
+	advertiser := slaac.LookupAdvertiser(intf)
+	if advertiser == nil {
+		advertiser = &data.RaInterface{
+			Interface: intf,
+		}
+		slaac.AddAdvertiser(advertiser)
+	}
+	advertiser.RouterLifetime = routerLifetime
+	advertiser.Prefixes = prefixes
+	advertiser.NextAdvertisement = time.Now()
+	return nil
+}
+
+// EnableSLAAC makes the interface autoconfigure a global address and default routers from the
+// router advertisements it receives, IPv6 is enabled on the interface if it is not yet.
+func EnableSLAAC(node *data.Node, intfName string) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	slaac := getSLAACInstance(node)
+	slaac.Mutex.Lock()
+	defer slaac.Mutex.Unlock()
+
+	if slaac.LookupAdvertiser(intf) != nil {
+		return errors.New("interface sends router advertisements")
+	}
+	if slaac.LookupClient(intf) != nil {
+		return errors.New("SLAAC already enabled on interface")
+	}
+	if !intf.Properties.IsIpv6Enabled {
+		node.EnableIntfIPv6(intfName)
+	}
+	slaac.AddClient(&data.SlaacClient{
+		Interface: intf,
+	})
+	return nil
+}
+
+func sendRouterAdvertisement(advertiser *data.RaInterface, destinationIP data.IPAddress) {
+	intf := advertiser.Interface
+	advertisement := data.RouterAdvertisement{
+		CurHopLimit:         constants.Ipv6DefaultHopLimit,
+		RouterLifetime:      advertiser.RouterLifetime,
+		LinkLayerAddress:    intf.Properties.MAC,
+		HasLinkLayerAddress: true,
+	}
+	if !intf.Properties.IPv6.IsUnspecified() {
+		advertisement.Prefixes = append(advertisement.Prefixes, data.NdPrefixInformation{
+			Prefix:            data.ApplyMask(intf.Properties.IPv6, intf.Properties.IPv6Mask),
+			PrefixLength:      uint8(intf.Properties.IPv6Mask),
+			OnLink:            true,
+			Autonomous:        intf.Properties.IPv6Mask == constants.SlaacPrefixLength,
+			ValidLifetime:     constants.RaDefaultValidLifetimeSeconds,
+			PreferredLifetime: constants.RaDefaultPreferredLifetimeSeconds,
+		})
+	}
+	for _, prefix := range advertiser.Prefixes {
+		advertisement.Prefixes = append(advertisement.Prefixes, data.NdPrefixInformation{
+			Prefix:            prefix.Prefix,
+			PrefixLength:      uint8(prefix.Mask),
+			OnLink:            true,
+			Autonomous:        prefix.Mask == constants.SlaacPrefixLength,
+			ValidLifetime:     prefix.ValidLifetime,
+			PreferredLifetime: prefix.PreferredLifetime,
+		})
+	}
+
+	sourceIP := intf.Properties.LinkLocalIP
+	appData := advertisement.SerializeRouterAdvertisement(sourceIP, destinationIP)
+	frame := ipv6Frame(intf, sourceIP, destinationIP, ipv6MulticastMacAddress(destinationIP), constants.Icmpv6Proto, constants.NdHopLimit, appData)
+	send.PacketSend(frame.SerializeEthernetHeader(), intf)
+}
+
+func sendRouterSolicitation(client *data.SlaacClient) {
+	intf := client.Interface
+	solicitation := data.RouterSolicitation{
+		LinkLayerAddress:    intf.Properties.MAC,
+		HasLinkLayerAddress: true,
+	}
+	sourceIP := intf.Properties.LinkLocalIP
+	destinationIP := data.IPAddress(constants.Ipv6AllRoutersIP)
+	appData := solicitation.SerializeRouterSolicitation(sourceIP, destinationIP)
+	frame := ipv6Frame(intf, sourceIP, destinationIP, ipv6MulticastMacAddress(destinationIP), constants.Icmpv6Proto, constants.NdHopLimit, appData)
+	send.PacketSend(frame.SerializeEthernetHeader(), intf)
+}
+
+func processRouterSolicitation(node *data.Node, iif *data.Interface, ipHeader data.IPv6Header, body []byte) {
+	slaac := node.Properties.Slaac
+	if slaac == nil {
+		return
+	}
+	solicitation, err := data.DeserializeRouterSolicitation(body)
+	if err != nil {
+		fmt.Println("processRouterSolicitation:", err, "on node", node.NodeName)
+		return
+	}
+
+	slaac.Mutex.Lock()
+	defer slaac.Mutex.Unlock()
+
+	advertiser := slaac.LookupAdvertiser(iif)
+	if advertiser == nil {
+		return
+	}
+	if solicitation.HasLinkLayerAddress && !ipHeader.SourceIP.IsUnspecified() {
+		updateNeighborEntry(node.VrfArpTable(iif.Properties.Vrf), ipHeader.SourceIP, solicitation.LinkLayerAddress, iif)
+	}
+	sendRouterAdvertisement(advertiser, constants.Ipv6AllNodesIP)
+}
+
+// slaacLifetime converts a lifetime in seconds into an expiry time, all ones means forever.
+func slaacLifetime(now time.Time, seconds uint32) time.Time {
+	if seconds == 0xFFFFFFFF {
+		return now.AddDate(100, 0, 0)
+	}
+	return now.Add(time.Duration(seconds) * time.Second)
+}
+
+func processRouterAdvertisement(node *data.Node, iif *data.Interface, ipHeader data.IPv6Header, icmpHeader data.IcmpHeader, body []byte) {
+	slaac := node.Properties.Slaac
+	if slaac == nil || !ipHeader.SourceIP.IsLinkLocalUnicast() {
+		return
+	}
+	advertisement, err := data.DeserializeRouterAdvertisement(icmpHeader, body)
+	if err != nil {
+		fmt.Println("processRouterAdvertisement:", err, "on node", node.NodeName)
+		return
+	}
+
+	slaac.Mutex.Lock()
+	defer slaac.Mutex.Unlock()
+
+	client := slaac.LookupClient(iif)
+	if client == nil {
+		return
+	}
+	if advertisement.HasLinkLayerAddress {
+		updateNeighborEntry(node.VrfArpTable(iif.Properties.Vrf), ipHeader.SourceIP, advertisement.LinkLayerAddress, iif)
+	}
+
+	now := time.Now()
+	routerIP := ipHeader.SourceIP
+	index := client.LookupRouter(routerIP)
+	switch {
+	case advertisement.RouterLifetime == 0 && index >= 0:
+		client.Routers = append(client.Routers[:index], client.Routers[index+1:]...)
+		fmt.Println("SLAAC: router", routerIP.String(), "is no longer a default router of node", node.NodeName)
+		slaacUpdateDefaultRoute(node, slaac)
+	case advertisement.RouterLifetime != 0 && index < 0:
+		client.Routers = append(client.Routers, data.SlaacRouter{
+			IP:        routerIP,
+			ExpiresAt: now.Add(time.Duration(advertisement.RouterLifetime) * time.Second),
+		})
+		fmt.Println("SLAAC: node", node.NodeName, "learned default router", routerIP.String(), "on interface", iif.Name.String())
+		slaacUpdateDefaultRoute(node, slaac)
+	case advertisement.RouterLifetime != 0:
+		client.Routers[index].ExpiresAt = now.Add(time.Duration(advertisement.RouterLifetime) * time.Second)
+	}
+
+	for _, prefix := range advertisement.Prefixes {
+		if !prefix.Autonomous || rune(prefix.PrefixLength) != constants.SlaacPrefixLength || prefix.Prefix.IsLinkLocalUnicast() {
+			continue
+		}
+		if prefix.PreferredLifetime > prefix.ValidLifetime {
+			continue
+		}
+		IP := data.SlaacAddress(prefix.Prefix, iif.Properties.MAC)
+		if client.IP.IsUnspecified() {
+			if !iif.Properties.IPv6.IsUnspecified() || prefix.ValidLifetime == 0 {
+				// a manually configured address takes precedence
+				continue
+			}
+			node.SetIntfIPv6Address(iif.Name.String(), IP, constants.SlaacPrefixLength)
+			client.IP = IP
+			client.Mask = constants.SlaacPrefixLength
+			fmt.Println("SLAAC: node", node.NodeName, "autoconfigured", IP.String(), "on interface", iif.Name.String())
+		}
+		if client.IP == IP {
+			client.ValidUntil = slaacLifetime(now, prefix.ValidLifetime)
+			client.PreferredUntil = slaacLifetime(now, prefix.PreferredLifetime)
+		}
+	}
+}
+
+// slaacUpdateDefaultRoute installs the default route of every VRF over the default routers of its interfaces.
+func slaacUpdateDefaultRoute(node *data.Node, slaac *data.SlaacInstance) {
+	nextHops := map[*data.Vrf][]data.Layer3NextHop{nil: nil}
+	for dllClient := slaac.Clients.Next; dllClient != nil; dllClient = dllClient.Next {
+		client := dllClient.DllToSlaacClient()
+		vrf := client.Interface.Properties.Vrf
+		vrfNextHops := nextHops[vrf]
+		for _, router := range client.Routers {
+			if len(vrfNextHops) < constants.MaxNextHops {
+				vrfNextHops = append(vrfNextHops, data.Layer3NextHop{GatewayIP: router.IP, InterfaceName: client.Interface.Name, Weight: 1})
+			}
+		}
+		nextHops[vrf] = vrfNextHops
+	}
+	for vrf, vrfNextHops := range nextHops {
+		if len(vrfNextHops) == 0 {
+			node.VrfRib(vrf).DeleteRoute(data.IPAddress{}, 0, constants.RouteSourceRA)
+			continue
+		}
+		node.VrfRib(vrf).ReplaceRoute(data.IPAddress{}, 0, constants.RouteSourceRA, 0, vrfNextHops)
+	}
+}
+
+func slaacRemoveAddress(node *data.Node, client *data.SlaacClient) {
+	intf := client.Interface
+	fmt.Println("SLAAC: address", client.IP.String(), "of node", node.NodeName, "expired on interface", intf.Name.String())
+	node.VrfRib(intf.Properties.Vrf).DeleteRoute(client.IP, client.Mask, constants.RouteSourceConnected)
+	if intf.Properties.IPv6 == client.IP {
+		intf.Properties.IPv6 = data.IPAddress{}
+		intf.Properties.IPv6Mask = 0
+	}
+	client.IP = data.IPAddress{}
+}
+
+func slaacTimer(node *data.Node) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		slaac := node.Properties.Slaac
+		slaac.Mutex.Lock()
+
+		now := time.Now()
+		for dllAdvertiser := slaac.Advertisers.Next; dllAdvertiser != nil; dllAdvertiser = dllAdvertiser.Next {
+			advertiser := dllAdvertiser.DllToRaInterface()
+			if advertiser.Interface.IsUp() && !now.Before(advertiser.NextAdvertisement) {
+				sendRouterAdvertisement(advertiser, constants.Ipv6AllNodesIP)
+				advertiser.NextAdvertisement = now.Add(time.Duration(constants.RaIntervalSeconds) * time.Second)
+			}
+		}
+
+		routersChanged := false
+		for dllClient := slaac.Clients.Next; dllClient != nil; dllClient = dllClient.Next {
+			client := dllClient.DllToSlaacClient()
+
+			// an interface coming up solicits the routers instead of waiting for their next advertisement
+			isUp := client.Interface.IsUp()
+			if isUp && !client.IsUp {
+				client.Solicitations = 0
+				client.NextSolicitation = now
+			}
+			client.IsUp = isUp
+			if isUp && len(client.Routers) == 0 && client.Solicitations < constants.RsMaxSolicitations && !now.Before(client.NextSolicitation) {
+				sendRouterSolicitation(client)
+				client.Solicitations++
+				client.NextSolicitation = now.Add(time.Duration(constants.RsIntervalSeconds) * time.Second)
+			}
+
+			routers := client.Routers[:0]
+			for _, router := range client.Routers {
+				if now.Before(router.ExpiresAt) {
+					routers = append(routers, router)
+					continue
+				}
+				fmt.Println("SLAAC: default router", router.IP.String(), "of node", node.NodeName, "expired")
+				routersChanged = true
+			}
+			client.Routers = routers
+
+			if !client.IP.IsUnspecified() && !now.Before(client.ValidUntil) {
+				slaacRemoveAddress(node, client)
+			}
+		}
+		if routersChanged {
+			slaacUpdateDefaultRoute(node, slaac)
+		}
+		slaac.Mutex.Unlock()
+	}
+}
@@ -0,0 +1,905 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+	"unsafe"
+)
+
+// TCPConn is an established connection of a node, it implements net.Conn.
+type TCPConn struct {
+	node          *data.Node
+	conn          *data.TCPConnection
+	isClosed      bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// TCPListener accepts the connections of a port of a node, it implements net.Listener.
+type TCPListener struct {
+	node     *data.Node
+	listener *data.TCPListener
+}
+
+var (
+	_ net.Conn     = (*TCPConn)(nil)
+	_ net.Listener = (*TCPListener)(nil)
+)
+
+func getTCPInstance(node *data.Node) *data.TcpInstance {
+	if node.Properties.Tcp == nil {
+		tcp := &data.TcpInstance{
+			Connections: data.Dll{},
+			Listeners:   data.Dll{},
+		}
+		(&tcp.Connections).Init()
+		(&tcp.Listeners).Init()
+		node.Properties.Tcp = tcp
+		go tcpTimer(node)
+	}
+	return node.Properties.Tcp
+}
+
+// DialTCP opens a connection from the address of the outgoing interface and waits for the handshake.
+func DialTCP(node *data.Node, remoteIP data.IPAddress, remotePort uint16) (*TCPConn, error) {
+	if remotePort == 0 || isLinkLocalDestination(remoteIP) || remoteIP.IsUnspecified() {
+		return nil, errors.New("invalid remote address")
+	}
+	localIP, err := sourceAddressFor(node, nil, remoteIP)
+	if err != nil {
+		return nil, err
+	}
+	tcp := getTCPInstance(node)
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	localPort := tcp.EphemeralPort()
+	if localPort == 0 {
+		return nil, errors.New("no free ephemeral port")
+	}
+	now := time.Now()
+	conn := newTCPConnection(tcp, localIP, localPort, remoteIP, remotePort)
+	conn.State = data.TcpStateSynSent
+	tcp.AddConnection(conn)
+	tcpSendSegment(node, conn, constants.TcpFlagSyn, conn.ISS, nil)
+	tcpStartRTTSample(conn, conn.SndNxt, now)
+	conn.RetransmitAt = now.Add(conn.RTO)
+
+	for conn.State == data.TcpStateSynSent {
+		conn.Changed.Wait()
+	}
+	if conn.State != data.TcpStateEstablished && conn.State != data.TcpStateCloseWait {
+		if conn.Err != nil {
+			return nil, conn.Err
+		}
+		return nil, data.ErrConnectionRefused
+	}
+	return &TCPConn{node: node, conn: conn}, nil
+}
+
+// ListenTCP accepts connections to port on IP, an unspecified IP accepts them on any address of the node.
+func ListenTCP(node *data.Node, IP data.IPAddress, port uint16) (*TCPListener, error) {
+	if port == 0 {
+		return nil, errors.New("invalid port")
+	}
+	tcp := getTCPInstance(node)
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	for dllListener := tcp.Listeners.Next; dllListener != nil; dllListener = dllListener.Next {
+		if dllListener.DllToTCPListener().LocalPort == port {
+			return nil, errors.New("port already in use")
+		}
+	}
+	listener := &data.TCPListener{
+		LocalIP:   IP,
+		LocalPort: port,
+		Changed:   sync.NewCond(&tcp.Mutex),
+	}
+	tcp.AddListener(listener)
+	return &TCPListener{node: node, listener: listener}, nil
+}
+
+func newTCPConnection(tcp *data.TcpInstance, localIP data.IPAddress, localPort uint16, remoteIP data.IPAddress, remotePort uint16) *data.TCPConnection {
+	ISS := rand.Uint32()
+	return &data.TCPConnection{
+		LocalIP:    localIP,
+		LocalPort:  localPort,
+		RemoteIP:   remoteIP,
+		RemotePort: remotePort,
+		ISS:        ISS,
+		SndUna:     ISS,
+		SndNxt:     ISS + 1,
+		SndMax:     ISS + 1,
+		OutOfOrder: make(map[uint32][]byte),
+		Cwnd:       constants.TcpInitialWindow,
+		Ssthresh:   uint32(constants.TcpSendBufferSize),
+		RTO:        time.Duration(constants.TcpInitialRtoMs) * time.Millisecond,
+		Changed:    sync.NewCond(&tcp.Mutex),
+	}
+}
+
+func tcpSendSegment(node *data.Node, conn *data.TCPConnection, flags uint8, seq uint32, payload []byte) {
+	header := data.TCPHeader{
+		SourcePort:      conn.LocalPort,
+		DestinationPort: conn.RemotePort,
+		Seq:             seq,
+		Flags:           flags,
+		Window:          uint16(conn.ReceiveWindow()),
+	}
+	if flags&constants.TcpFlagAck != 0 {
+		header.Ack = conn.RcvNxt
+	}
+	segment := header.SerializeTCPSegment(conn.LocalIP, conn.RemoteIP, payload)
+	PacketSendFromSource(node, conn.LocalIP, segment, constants.TcpProto, conn.RemoteIP)
+}
+
+// tcpSendReset answers a segment that belongs to no connection.
+func tcpSendReset(node *data.Node, ipHeader data.IPHeader, header data.TCPHeader, payloadLength int) {
+	reset := data.TCPHeader{
+		SourcePort:      header.DestinationPort,
+		DestinationPort: header.SourcePort,
+	}
+	if header.Flags&constants.TcpFlagAck != 0 {
+		reset.Seq = header.Ack
+		reset.Flags = constants.TcpFlagRst
+	} else {
+		reset.Ack = header.Seq + uint32(payloadLength)
+		if header.Flags&constants.TcpFlagSyn != 0 {
+			reset.Ack++
+		}
+		if header.Flags&constants.TcpFlagFin != 0 {
+			reset.Ack++
+		}
+		reset.Flags = constants.TcpFlagRst | constants.TcpFlagAck
+	}
+	segment := reset.SerializeTCPSegment(ipHeader.DestinationIP, ipHeader.SourceIP, nil)
+	PacketSendFromSource(node, ipHeader.DestinationIP, segment, constants.TcpProto, ipHeader.SourceIP)
+}
+
+func TCPReceive(node *data.Node, ipHeader data.IPHeader, payload data.Payload) {
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	if int(ipHeader.Length) < l4Offset || int(ipHeader.Length) > len(payload) {
+		fmt.Println("TCPReceive: invalid IP length, segment dropped")
+		return
+	}
+	if isLinkLocalDestination(ipHeader.DestinationIP) {
+		return
+	}
+	header, body, err := data.DeserializeTCPSegment(payload[l4Offset:ipHeader.Length], ipHeader.SourceIP, ipHeader.DestinationIP)
+	if err != nil {
+		fmt.Println("TCPReceive:", err, "on node", node.NodeName)
+		return
+	}
+
+	tcp := node.Properties.Tcp
+	if tcp == nil {
+		if header.Flags&constants.TcpFlagRst == 0 {
+			tcpSendReset(node, ipHeader, header, len(body))
+		}
+		return
+	}
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	conn := tcp.LookupConnection(ipHeader.DestinationIP, header.DestinationPort, ipHeader.SourceIP, header.SourcePort)
+	if conn == nil {
+		listener := tcp.LookupListener(ipHeader.DestinationIP, header.DestinationPort)
+		if listener != nil && header.Flags&(constants.TcpFlagSyn|constants.TcpFlagAck|constants.TcpFlagRst) == constants.TcpFlagSyn {
+			tcpAcceptSyn(node, tcp, listener, ipHeader, header)
+			return
+		}
+		if header.Flags&constants.TcpFlagRst == 0 {
+			tcpSendReset(node, ipHeader, header, len(body))
+		}
+		return
+	}
+	tcpProcessSegment(node, conn, header, body, time.Now())
+}
+
+func tcpAcceptSyn(node *data.Node, tcp *data.TcpInstance, listener *data.TCPListener, ipHeader data.IPHeader, header data.TCPHeader) {
+	if len(listener.AcceptQueue) >= constants.TcpListenBacklog {
+		return
+	}
+	now := time.Now()
+	conn := newTCPConnection(tcp, ipHeader.DestinationIP, header.DestinationPort, ipHeader.SourceIP, header.SourcePort)
+	conn.State = data.TcpStateSynReceived
+	conn.IRS = header.Seq
+	conn.RcvNxt = header.Seq + 1
+	conn.SndWnd = uint32(header.Window)
+	conn.Listener = listener
+	tcp.AddConnection(conn)
+
+	tcpSendSegment(node, conn, constants.TcpFlagSyn|constants.TcpFlagAck, conn.ISS, nil)
+	tcpStartRTTSample(conn, conn.SndNxt, now)
+	conn.RetransmitAt = now.Add(conn.RTO)
+}
+
+func tcpProcessSegment(node *data.Node, conn *data.TCPConnection, header data.TCPHeader, body []byte, now time.Time) {
+	flags := header.Flags
+
+	switch conn.State {
+	case data.TcpStateSynSent:
+		if flags&constants.TcpFlagAck != 0 && header.Ack != conn.ISS+1 {
+			if flags&constants.TcpFlagRst == 0 {
+				tcpSendSegment(node, conn, constants.TcpFlagRst, header.Ack, nil)
+			}
+			return
+		}
+		if flags&constants.TcpFlagRst != 0 {
+			if flags&constants.TcpFlagAck != 0 {
+				tcpClose(conn, data.ErrConnectionRefused)
+			}
+			return
+		}
+		if flags&constants.TcpFlagSyn != 0 && flags&constants.TcpFlagAck != 0 {
+			conn.IRS = header.Seq
+			conn.RcvNxt = header.Seq + 1
+			conn.SndWnd = uint32(header.Window)
+			tcpAdvanceSndUna(conn, header.Ack, now)
+			conn.State = data.TcpStateEstablished
+			tcpSendSegment(node, conn, constants.TcpFlagAck, conn.SndNxt, nil)
+			conn.Changed.Broadcast()
+		}
+		return
+	case data.TcpStateTimeWait:
+		// the last ACK got lost, the peer retransmits its FIN
+		if flags&constants.TcpFlagFin != 0 {
+			tcpSendSegment(node, conn, constants.TcpFlagAck, conn.SndNxt, nil)
+			conn.TimeWaitUntil = now.Add(2 * time.Duration(constants.TcpMslSeconds) * time.Second)
+		}
+		return
+	}
+
+	if flags&constants.TcpFlagRst != 0 {
+		if tcpInReceiveWindow(conn, header.Seq) {
+			tcpClose(conn, data.ErrConnectionReset)
+		}
+		return
+	}
+	if flags&constants.TcpFlagSyn != 0 {
+		if conn.State == data.TcpStateSynReceived && header.Seq == conn.IRS {
+			// our SYN ACK got lost
+			tcpSendSegment(node, conn, constants.TcpFlagSyn|constants.TcpFlagAck, conn.ISS, nil)
+		} else {
+			tcpSendSegment(node, conn, constants.TcpFlagAck, conn.SndNxt, nil)
+		}
+		return
+	}
+	if flags&constants.TcpFlagAck == 0 {
+		return
+	}
+
+	if conn.State == data.TcpStateSynReceived {
+		if header.Ack != conn.ISS+1 {
+			tcpSendSegment(node, conn, constants.TcpFlagRst, header.Ack, nil)
+			return
+		}
+		tcpAdvanceSndUna(conn, header.Ack, now)
+		conn.SndWnd = uint32(header.Window)
+		conn.State = data.TcpStateEstablished
+		tcpQueueAccept(node, conn)
+	}
+
+	if !tcpProcessAck(node, conn, header, len(body) > 0 || flags&constants.TcpFlagFin != 0, now) {
+		tcpSendSegment(node, conn, constants.TcpFlagAck, conn.SndNxt, nil)
+		return
+	}
+	finAcked := conn.FinSent && conn.SndUna == conn.FinSeq+1
+	switch {
+	case conn.State == data.TcpStateFinWait1 && finAcked:
+		conn.State = data.TcpStateFinWait2
+	case conn.State == data.TcpStateClosing && finAcked:
+		tcpEnterTimeWait(conn, now)
+	case conn.State == data.TcpStateLastAck && finAcked:
+		tcpClose(conn, nil)
+		return
+	}
+
+	ackNeeded := false
+	if conn.State == data.TcpStateEstablished || conn.State == data.TcpStateFinWait1 || conn.State == data.TcpStateFinWait2 {
+		if len(body) > 0 {
+			tcpReceiveData(conn, header.Seq, body)
+			ackNeeded = true
+		}
+		if flags&constants.TcpFlagFin != 0 {
+			ackNeeded = true
+			if header.Seq+uint32(len(body)) == conn.RcvNxt {
+				conn.RcvNxt++
+				conn.FinReceived = true
+				conn.Changed.Broadcast()
+				switch conn.State {
+				case data.TcpStateEstablished:
+					conn.State = data.TcpStateCloseWait
+				case data.TcpStateFinWait1:
+					if finAcked {
+						tcpEnterTimeWait(conn, now)
+					} else {
+						conn.State = data.TcpStateClosing
+					}
+				case data.TcpStateFinWait2:
+					tcpEnterTimeWait(conn, now)
+				}
+			}
+		}
+	} else if len(body) > 0 || flags&constants.TcpFlagFin != 0 {
+		// a retransmission of data or FIN already received
+		ackNeeded = true
+	}
+
+	if ackNeeded {
+		tcpSendSegment(node, conn, constants.TcpFlagAck, conn.SndNxt, nil)
+	}
+	tcpOutput(node, conn, now)
+}
+
+// tcpProcessAck updates the send side with the acknowledgment of a segment, it returns false for an
+// acknowledgment of data never sent.
+func tcpProcessAck(node *data.Node, conn *data.TCPConnection, header data.TCPHeader, hasData bool, now time.Time) bool {
+	if data.SeqLT(conn.SndMax, header.Ack) {
+		return false
+	}
+	if data.SeqLT(header.Ack, conn.SndUna) {
+		// an old acknowledgment overtaken by newer ones carries a stale window too
+		return true
+	}
+	window := uint32(header.Window)
+
+	if data.SeqLT(conn.SndUna, header.Ack) {
+		if conn.FastRecovery {
+			if data.SeqLT(header.Ack, conn.Recover) {
+				// a partial acknowledgment points at the next segment lost from the same window
+				tcpAdvanceSndUna(conn, header.Ack, now)
+				conn.SndWnd = window
+				tcpRetransmit(node, conn)
+				conn.RetransmitAt = now.Add(conn.RTO)
+				return true
+			}
+			conn.FastRecovery = false
+			conn.Cwnd = conn.Ssthresh
+		} else if conn.Cwnd < conn.Ssthresh {
+			conn.Cwnd += constants.TcpMss
+		} else {
+			conn.Cwnd += constants.TcpMss * constants.TcpMss / conn.Cwnd
+		}
+		tcpAdvanceSndUna(conn, header.Ack, now)
+		conn.SndWnd = window
+		return true
+	}
+
+	// a duplicate acknowledgment reports a segment missing at the peer
+	if header.Ack == conn.SndUna && !hasData && window == conn.SndWnd && conn.InFlight() > 0 {
+		conn.DupAcks++
+		switch {
+		case conn.FastRecovery:
+			conn.Cwnd += constants.TcpMss
+		case conn.DupAcks == constants.TcpDupAckThreshold:
+			conn.FastRecovery = true
+			conn.Recover = conn.SndMax
+			conn.Ssthresh = tcpHalfFlight(conn)
+			conn.Cwnd = conn.Ssthresh + uint32(constants.TcpDupAckThreshold)*constants.TcpMss
+			conn.RTTStart = time.Time{}
+			tcpRetransmit(node, conn)
+			conn.RetransmitAt = now.Add(conn.RTO)
+		}
+	}
+	conn.SndWnd = window
+	return true
+}
+
+// tcpAdvanceSndUna drops the acknowledged data from the send buffer and restarts the retransmission timer.
+func tcpAdvanceSndUna(conn *data.TCPConnection, ack uint32, now time.Time) {
+	acked := ack - conn.SndUna
+	switch {
+	case conn.State == data.TcpStateSynSent || conn.State == data.TcpStateSynReceived:
+		// the SYN takes a sequence number but no data
+		acked--
+	case conn.FinSent && ack == conn.FinSeq+1:
+		acked--
+	}
+	if int(acked) > len(conn.SendBuffer) {
+		acked = uint32(len(conn.SendBuffer))
+	}
+	conn.SendBuffer = conn.SendBuffer[acked:]
+	conn.SndUna = ack
+	if data.SeqLT(conn.SndNxt, ack) {
+		conn.SndNxt = ack
+	}
+
+	if !conn.RTTStart.IsZero() && data.SeqLE(conn.RTTSeq, ack) {
+		tcpUpdateRTO(conn, now.Sub(conn.RTTStart))
+		conn.RTTStart = time.Time{}
+	}
+	conn.Retransmits = 0
+	conn.DupAcks = 0
+	if conn.InFlight() == 0 {
+		conn.RetransmitAt = time.Time{}
+	} else {
+		conn.RetransmitAt = now.Add(conn.RTO)
+	}
+	conn.Changed.Broadcast()
+}
+
+func tcpSetSndNxt(conn *data.TCPConnection, seq uint32) {
+	conn.SndNxt = seq
+	if data.SeqLT(conn.SndMax, seq) {
+		conn.SndMax = seq
+	}
+}
+
+func tcpStartRTTSample(conn *data.TCPConnection, seq uint32, now time.Time) {
+	if conn.RTTStart.IsZero() {
+		conn.RTTSeq = seq
+		conn.RTTStart = now
+	}
+}
+
+// tcpUpdateRTO folds a round trip sample into the smoothed estimates of RFC 6298.
+func tcpUpdateRTO(conn *data.TCPConnection, sample time.Duration) {
+	if conn.SRTT == 0 {
+		conn.SRTT = sample
+		conn.RTTVAR = sample / 2
+	} else {
+		delta := conn.SRTT - sample
+		if delta < 0 {
+			delta = -delta
+		}
+		conn.RTTVAR = (3*conn.RTTVAR + delta) / 4
+		conn.SRTT = (7*conn.SRTT + sample) / 8
+	}
+	conn.RTO = tcpClampRTO(conn.SRTT + 4*conn.RTTVAR)
+}
+
+func tcpClampRTO(RTO time.Duration) time.Duration {
+	minimum := time.Duration(constants.TcpMinRtoMs) * time.Millisecond
+	maximum := time.Duration(constants.TcpMaxRtoMs) * time.Millisecond
+	if RTO < minimum {
+		return minimum
+	}
+	if RTO > maximum {
+		return maximum
+	}
+	return RTO
+}
+
+func tcpHalfFlight(conn *data.TCPConnection) uint32 {
+	half := conn.InFlight() / 2
+	if half < 2*constants.TcpMss {
+		return 2 * constants.TcpMss
+	}
+	return half
+}
+
+func tcpInReceiveWindow(conn *data.TCPConnection, seq uint32) bool {
+	return data.SeqLE(conn.RcvNxt, seq) && data.SeqLT(seq, conn.RcvNxt+conn.ReceiveWindow()+1)
+}
+
+// tcpReceiveData queues in order data for the application and holds segments past a hole until it fills.
+func tcpReceiveData(conn *data.TCPConnection, seq uint32, body []byte) {
+	end := seq + uint32(len(body))
+	if data.SeqLE(end, conn.RcvNxt) {
+		return
+	}
+	if data.SeqLT(conn.RcvNxt, seq) {
+		if data.SeqLE(end, conn.RcvNxt+conn.ReceiveWindow()) {
+			conn.OutOfOrder[seq] = append([]byte(nil), body...)
+		}
+		return
+	}
+	tcpAppendReceived(conn, body[conn.RcvNxt-seq:])
+
+	for filled := true; filled; {
+		filled = false
+		for segmentSeq, segment := range conn.OutOfOrder {
+			if data.SeqLT(conn.RcvNxt, segmentSeq) {
+				continue
+			}
+			delete(conn.OutOfOrder, segmentSeq)
+			if segmentEnd := segmentSeq + uint32(len(segment)); data.SeqLT(conn.RcvNxt, segmentEnd) {
+				tcpAppendReceived(conn, segment[conn.RcvNxt-segmentSeq:])
+				filled = true
+			}
+		}
+	}
+	conn.Changed.Broadcast()
+}
+
+func tcpAppendReceived(conn *data.TCPConnection, body []byte) {
+	if space := int(conn.ReceiveWindow()); len(body) > space {
+		body = body[:space]
+	}
+	conn.RecvBuffer = append(conn.RecvBuffer, body...)
+	conn.RcvNxt += uint32(len(body))
+}
+
+func tcpQueueAccept(node *data.Node, conn *data.TCPConnection) {
+	listener := conn.Listener
+	if listener == nil {
+		return
+	}
+	if listener.IsClosed {
+		tcpSendSegment(node, conn, constants.TcpFlagRst, conn.SndNxt, nil)
+		tcpClose(conn, data.ErrConnectionReset)
+		return
+	}
+	listener.AcceptQueue = append(listener.AcceptQueue, conn)
+	listener.Changed.Broadcast()
+}
+
+// tcpOutput sends the data the send and congestion windows allow, and the FIN once the application
+// closed the connection and all data is sent.
+func tcpOutput(node *data.Node, conn *data.TCPConnection, now time.Time) {
+	if conn.State != data.TcpStateEstablished && conn.State != data.TcpStateCloseWait {
+		return
+	}
+	for {
+		unsent := conn.Unsent()
+		window := conn.SndWnd
+		if conn.Cwnd < window {
+			window = conn.Cwnd
+		}
+		if len(unsent) == 0 || conn.InFlight() >= window {
+			break
+		}
+		size := window - conn.InFlight()
+		if size > constants.TcpMss {
+			size = constants.TcpMss
+		}
+		if size > uint32(len(unsent)) {
+			size = uint32(len(unsent))
+		}
+		flags := constants.TcpFlagAck
+		if size == uint32(len(unsent)) {
+			flags |= constants.TcpFlagPsh
+		}
+		tcpSendSegment(node, conn, flags, conn.SndNxt, unsent[:size])
+		if conn.SndNxt == conn.SndMax {
+			// Karn: time new data only
+			tcpStartRTTSample(conn, conn.SndNxt+size, now)
+		}
+		tcpSetSndNxt(conn, conn.SndNxt+size)
+		if conn.RetransmitAt.IsZero() {
+			conn.RetransmitAt = now.Add(conn.RTO)
+		}
+	}
+
+	if conn.CloseRequested && !conn.FinSent && len(conn.Unsent()) == 0 {
+		tcpSendSegment(node, conn, constants.TcpFlagFin|constants.TcpFlagAck, conn.SndNxt, nil)
+		conn.FinSeq = conn.SndNxt
+		tcpSetSndNxt(conn, conn.SndNxt+1)
+		conn.FinSent = true
+		if conn.State == data.TcpStateEstablished {
+			conn.State = data.TcpStateFinWait1
+		} else {
+			conn.State = data.TcpStateLastAck
+		}
+		if conn.RetransmitAt.IsZero() {
+			conn.RetransmitAt = now.Add(conn.RTO)
+		}
+	}
+
+	// probe a zero window, the timer sends one byte past it
+	if conn.InFlight() == 0 && len(conn.Unsent()) > 0 && conn.RetransmitAt.IsZero() {
+		conn.RetransmitAt = now.Add(conn.RTO)
+	}
+}
+
+// tcpRetransmit resends the oldest unacknowledged segment.
+func tcpRetransmit(node *data.Node, conn *data.TCPConnection) {
+	switch conn.State {
+	case data.TcpStateSynSent:
+		tcpSendSegment(node, conn, constants.TcpFlagSyn, conn.ISS, nil)
+		return
+	case data.TcpStateSynReceived:
+		tcpSendSegment(node, conn, constants.TcpFlagSyn|constants.TcpFlagAck, conn.ISS, nil)
+		return
+	}
+
+	outstanding := conn.InFlight()
+	if conn.FinSent {
+		outstanding--
+	}
+	if outstanding > 0 {
+		size := outstanding
+		if size > constants.TcpMss {
+			size = constants.TcpMss
+		}
+		tcpSendSegment(node, conn, constants.TcpFlagAck|constants.TcpFlagPsh, conn.SndUna, conn.SendBuffer[:size])
+	} else if conn.FinSent {
+		tcpSendSegment(node, conn, constants.TcpFlagFin|constants.TcpFlagAck, conn.FinSeq, nil)
+	}
+}
+
+func tcpEnterTimeWait(conn *data.TCPConnection, now time.Time) {
+	conn.State = data.TcpStateTimeWait
+	conn.RetransmitAt = time.Time{}
+	conn.TimeWaitUntil = now.Add(2 * time.Duration(constants.TcpMslSeconds) * time.Second)
+	conn.Changed.Broadcast()
+}
+
+// tcpClose removes the connection, err tells the application why when it did not close it itself.
+func tcpClose(conn *data.TCPConnection, err error) {
+	if err != nil && conn.Err == nil {
+		conn.Err = err
+	}
+	conn.State = data.TcpStateClosed
+	conn.RetransmitAt = time.Time{}
+	(&conn.ConnectionGlue).RemoveNode()
+	conn.Changed.Broadcast()
+}
+
+func tcpTimer(node *data.Node) {
+	ticker := time.NewTicker(time.Second / 20)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		tcp := node.Properties.Tcp
+		tcp.Mutex.Lock()
+
+		now := time.Now()
+		var next *data.Dll
+		for dllConnection := tcp.Connections.Next; dllConnection != nil; dllConnection = next {
+			next = dllConnection.Next
+			conn := dllConnection.DllToTCPConnection()
+
+			if conn.State == data.TcpStateTimeWait {
+				if !now.Before(conn.TimeWaitUntil) {
+					tcpClose(conn, nil)
+				}
+				continue
+			}
+			if conn.RetransmitAt.IsZero() || now.Before(conn.RetransmitAt) {
+				continue
+			}
+
+			if conn.InFlight() == 0 {
+				// the peer closed its window, send one byte to learn when it opens
+				if unsent := conn.Unsent(); len(unsent) > 0 {
+					tcpSendSegment(node, conn, constants.TcpFlagAck, conn.SndNxt, unsent[:1])
+					tcpSetSndNxt(conn, conn.SndNxt+1)
+				}
+				conn.RTO = tcpClampRTO(2 * conn.RTO)
+				conn.RetransmitAt = now.Add(conn.RTO)
+				continue
+			}
+
+			maxRetransmits := constants.TcpMaxRetransmits
+			if conn.State == data.TcpStateSynSent || conn.State == data.TcpStateSynReceived {
+				maxRetransmits = constants.TcpMaxSynRetransmits
+			}
+			conn.Retransmits++
+			if conn.Retransmits > maxRetransmits {
+				if conn.State != data.TcpStateSynSent {
+					tcpSendSegment(node, conn, constants.TcpFlagRst, conn.SndNxt, nil)
+				}
+				tcpClose(conn, data.ErrConnectionTimeout)
+				continue
+			}
+			// Karn: no round trip sample from retransmitted segments
+			conn.RTTStart = time.Time{}
+			conn.Ssthresh = tcpHalfFlight(conn)
+			conn.Cwnd = constants.TcpMss
+			conn.DupAcks = 0
+			conn.FastRecovery = false
+			conn.RTO = tcpClampRTO(2 * conn.RTO)
+			conn.RetransmitAt = now.Add(conn.RTO)
+			if conn.State == data.TcpStateEstablished || conn.State == data.TcpStateCloseWait {
+				// resend the whole window in slow start, the peer acknowledges what it holds already
+				conn.SndNxt = conn.SndUna
+				tcpOutput(node, conn, now)
+			} else {
+				tcpRetransmit(node, conn)
+			}
+		}
+		tcp.Mutex.Unlock()
+	}
+}
+
+// tcpWait blocks on cond until it is signalled or the deadline passes.
+func tcpWait(cond *sync.Cond, deadline time.Time) {
+	if deadline.IsZero() {
+		cond.Wait()
+		return
+	}
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		cond.L.Lock()
+		cond.Broadcast()
+		cond.L.Unlock()
+	})
+	cond.Wait()
+	timer.Stop()
+}
+
+func tcpDeadlinePassed(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+func (c *TCPConn) Read(b []byte) (int, error) {
+	tcp := c.node.Properties.Tcp
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	conn := c.conn
+	if c.isClosed {
+		return 0, net.ErrClosed
+	}
+	for len(conn.RecvBuffer) == 0 {
+		switch {
+		case c.isClosed:
+			return 0, net.ErrClosed
+		case conn.FinReceived:
+			return 0, io.EOF
+		case conn.Err != nil:
+			return 0, conn.Err
+		case conn.State == data.TcpStateClosed:
+			return 0, io.EOF
+		case tcpDeadlinePassed(c.readDeadline):
+			return 0, os.ErrDeadlineExceeded
+		}
+		tcpWait(conn.Changed, c.readDeadline)
+	}
+
+	windowBefore := conn.ReceiveWindow()
+	n := copy(b, conn.RecvBuffer)
+	conn.RecvBuffer = conn.RecvBuffer[n:]
+	// tell a peer stalled on a small window that it opened
+	if windowBefore < constants.TcpMss && conn.ReceiveWindow() >= constants.TcpMss && conn.State != data.TcpStateClosed {
+		tcpSendSegment(c.node, conn, constants.TcpFlagAck, conn.SndNxt, nil)
+	}
+	return n, nil
+}
+
+func (c *TCPConn) Write(b []byte) (int, error) {
+	tcp := c.node.Properties.Tcp
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	conn := c.conn
+	written := 0
+	for written < len(b) {
+		switch {
+		case conn.CloseRequested:
+			return written, net.ErrClosed
+		case conn.Err != nil:
+			return written, conn.Err
+		case conn.State != data.TcpStateEstablished && conn.State != data.TcpStateCloseWait:
+			return written, errors.New("connection is not open for sending")
+		case tcpDeadlinePassed(c.writeDeadline):
+			return written, os.ErrDeadlineExceeded
+		}
+		space := constants.TcpSendBufferSize - len(conn.SendBuffer)
+		if space == 0 {
+			tcpWait(conn.Changed, c.writeDeadline)
+			continue
+		}
+		if space > len(b)-written {
+			space = len(b) - written
+		}
+		conn.SendBuffer = append(conn.SendBuffer, b[written:written+space]...)
+		written += space
+		tcpOutput(c.node, conn, time.Now())
+	}
+	return written, nil
+}
+
+// Close sends a FIN after the queued data, the connection lingers in the background until the peer
+// acknowledges it.
+func (c *TCPConn) Close() error {
+	tcp := c.node.Properties.Tcp
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	if c.isClosed {
+		return net.ErrClosed
+	}
+	c.isClosed = true
+	c.closeWrite()
+	return nil
+}
+
+// CloseWrite sends a FIN after the queued data and keeps receiving until the peer closes too.
+func (c *TCPConn) CloseWrite() error {
+	tcp := c.node.Properties.Tcp
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	if c.isClosed || c.conn.CloseRequested {
+		return net.ErrClosed
+	}
+	c.closeWrite()
+	return nil
+}
+
+func (c *TCPConn) closeWrite() {
+	conn := c.conn
+	if conn.State == data.TcpStateSynSent || conn.State == data.TcpStateSynReceived {
+		tcpClose(conn, nil)
+		return
+	}
+	conn.CloseRequested = true
+	tcpOutput(c.node, conn, time.Now())
+	conn.Changed.Broadcast()
+}
+
+func (c *TCPConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IP(c.conn.LocalIP[:]), Port: int(c.conn.LocalPort)}
+}
+
+func (c *TCPConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IP(c.conn.RemoteIP[:]), Port: int(c.conn.RemotePort)}
+}
+
+func (c *TCPConn) SetDeadline(deadline time.Time) error {
+	c.SetReadDeadline(deadline)
+	return c.SetWriteDeadline(deadline)
+}
+
+func (c *TCPConn) SetReadDeadline(deadline time.Time) error {
+	tcp := c.node.Properties.Tcp
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	c.readDeadline = deadline
+	c.conn.Changed.Broadcast()
+	return nil
+}
+
+func (c *TCPConn) SetWriteDeadline(deadline time.Time) error {
+	tcp := c.node.Properties.Tcp
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	c.writeDeadline = deadline
+	c.conn.Changed.Broadcast()
+	return nil
+}
+
+func (l *TCPListener) Accept() (net.Conn, error) {
+	tcp := l.node.Properties.Tcp
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	listener := l.listener
+	for len(listener.AcceptQueue) == 0 {
+		if listener.IsClosed {
+			return nil, net.ErrClosed
+		}
+		listener.Changed.Wait()
+	}
+	conn := listener.AcceptQueue[0]
+	listener.AcceptQueue = listener.AcceptQueue[1:]
+	return &TCPConn{node: l.node, conn: conn}, nil
+}
+
+// Close stops accepting connections and resets the ones not accepted yet.
+func (l *TCPListener) Close() error {
+	tcp := l.node.Properties.Tcp
+	tcp.Mutex.Lock()
+	defer tcp.Mutex.Unlock()
+
+	listener := l.listener
+	if listener.IsClosed {
+		return net.ErrClosed
+	}
+	listener.IsClosed = true
+	(&listener.ListenerGlue).RemoveNode()
+	for _, conn := range listener.AcceptQueue {
+		tcpSendSegment(l.node, conn, constants.TcpFlagRst, conn.SndNxt, nil)
+		tcpClose(conn, data.ErrConnectionReset)
+	}
+	listener.AcceptQueue = nil
+	listener.Changed.Broadcast()
+	return nil
+}
+
+func (l *TCPListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IP(l.listener.LocalIP[:]), Port: int(l.listener.LocalPort)}
+}
@@ -13,11 +13,46 @@ func PacketDemoteToLayer2(node *data.Node, gatewayIP data.IPAddress, intf *data.
 	FrameReceiveFromTop(node, gatewayIP, intf, payload, protocolNumber)
 }
 
-func PacketPromoteToLayer3(node *data.Node, payload data.Payload, protocolNumber uint16) {
+func PacketPromoteToLayer3(node *data.Node, iif *data.Interface, payload data.Payload, protocolNumber uint16) {
 	switch protocolNumber {
 	case constants.EthernetIpProto:
-		PacketReceive(node, payload)
+		PacketReceive(node, iif, payload)
+	case constants.EthernetIpv6Proto:
+		PacketReceiveIPv6(node, iif, payload)
+	case constants.EthernetMplsProto:
+		mplsReceive(node, iif, payload)
 	default:
 		break
 	}
 }
+
+// packetLoopback hands a packet the node sends to one of its own addresses to layer 3 from another
+// goroutine, in the order the packets were sent. The sender may hold the lock of the protocol the
+// packet is delivered to, delivering it synchronously would deadlock.
+func packetLoopback(node *data.Node, payload data.Payload, protocolNumber uint16) {
+	queue := node.Properties.Loopback
+	queue.Mutex.Lock()
+	defer queue.Mutex.Unlock()
+
+	queue.Packets = append(queue.Packets, data.LoopbackPacket{Payload: payload, ProtocolNumber: protocolNumber})
+	if !queue.IsDelivering {
+		queue.IsDelivering = true
+		go loopbackDeliver(node, queue)
+	}
+}
+
+func loopbackDeliver(node *data.Node, queue *data.LoopbackQueue) {
+	for {
+		queue.Mutex.Lock()
+		if len(queue.Packets) == 0 {
+			queue.IsDelivering = false
+			queue.Mutex.Unlock()
+			return
+		}
+		packet := queue.Packets[0]
+		queue.Packets = queue.Packets[1:]
+		queue.Mutex.Unlock()
+
+		PacketPromoteToLayer3(node, nil, packet.Payload, packet.ProtocolNumber)
+	}
+}
@@ -0,0 +1,261 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+	"unsafe"
+)
+
+// getTunnelTable returns the tunnels of the node, the first tunnel creates the table.
+func getTunnelTable(node *data.Node) *data.TunnelTable {
+	if node.Properties.Tunnels == nil {
+		table := &data.TunnelTable{
+			Tunnels: data.Dll{},
+		}
+		(&table.Tunnels).Init()
+		node.Properties.Tunnels = table
+	}
+	return node.Properties.Tunnels
+}
+
+// AddTunnel creates the tunnel interface name, or moves it to new endpoints. Packets routed out of
+// it are carried in IPv4 packets from sourceIP, an address of the node, to destinationIP; GRE
+// tunnels between the same endpoints need different keys.
+func AddTunnel(node *data.Node, name string, mode uint8, sourceIP data.IPAddress, destinationIP data.IPAddress, hasKey bool, key uint32) error {
+	if len(name) == 0 || len(name) >= len(data.InterfaceName{}) {
+		return errors.New("invalid tunnel name")
+	}
+	if node.GetNodeIntfByName(name) != nil {
+		return errors.New("name is taken by an interface")
+	}
+	if !sourceIP.IsIPv4() || !destinationIP.IsIPv4() {
+		return errors.New("tunnels run over IPv4 only")
+	}
+	if !IsRouteLocalDelivery(node, sourceIP) {
+		return errors.New("tunnel source is not an address of the node")
+	}
+	if IsRouteLocalDelivery(node, destinationIP) {
+		return errors.New("tunnel destination is an address of the node")
+	}
+	if hasKey && mode != data.TunnelModeGRE {
+		return errors.New("only GRE tunnels have a key")
+	}
+
+	table := getTunnelTable(node)
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	tunnel := table.LookupTunnel(name)
+	if other := table.LookupTunnelByEndpoints(mode, sourceIP, destinationIP, hasKey, key); other != nil && other != tunnel {
+		return fmt.Errorf("tunnel %s has the same endpoints", other.Interface.Name.String())
+	}
+	if tunnel == nil {
+		tunnel = &data.Tunnel{
+			Interface: data.Interface{
+				Name: data.StringToInterfaceName(name),
+				Node: node,
+			},
+		}
+		(&tunnel.Interface.Properties).InitIntfProperty()
+		table.AddTunnel(tunnel)
+	}
+	tunnel.Mode = mode
+	tunnel.SourceIP = sourceIP
+	tunnel.DestinationIP = destinationIP
+	tunnel.HasKey = hasKey
+	tunnel.Key = key
+	return nil
+}
+
+// SetTunnelAddress sets the address of the tunnel interface, its subnet becomes a connected route
+// out of the tunnel.
+func SetTunnelAddress(node *data.Node, name string, IP data.IPAddress, mask rune) error {
+	if !IP.IsIPv4() {
+		return errors.New("tunnels carry IPv4 only")
+	}
+	table := node.Properties.Tunnels
+	if table == nil {
+		return errors.New("tunnel not found")
+	}
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	tunnel := table.LookupTunnel(name)
+	if tunnel == nil {
+		return errors.New("tunnel not found")
+	}
+	properties := &tunnel.Interface.Properties
+	if properties.IsIpConfigured {
+		node.Properties.Rib.DeleteRoute(properties.IP, properties.Mask, constants.RouteSourceConnected)
+	}
+	properties.IP = IP
+	properties.Mask = mask
+	properties.IsIpConfigured = true
+	node.Properties.Rib.AddRoute(IP, mask, constants.RouteSourceConnected, 0, nil, nil, 0)
+	return nil
+}
+
+// DeleteTunnel removes the tunnel interface and its connected route.
+func DeleteTunnel(node *data.Node, name string) error {
+	table := node.Properties.Tunnels
+	if table == nil {
+		return errors.New("tunnel not found")
+	}
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	tunnel := table.LookupTunnel(name)
+	if tunnel == nil {
+		return errors.New("tunnel not found")
+	}
+	if properties := tunnel.Interface.Properties; properties.IsIpConfigured {
+		node.Properties.Rib.DeleteRoute(properties.IP, properties.Mask, constants.RouteSourceConnected)
+	}
+	(&tunnel.TunnelGlue).RemoveNode()
+	return nil
+}
+
+func isTunnelAddress(node *data.Node, IP data.IPAddress) bool {
+	return node.Properties.Tunnels != nil && node.Properties.Tunnels.IsTunnelAddress(IP)
+}
+
+// ipOutput hands a routed IPv4 packet to layer 2, or encapsulates it when it leaves through a
+// tunnel interface. Without oif the packet leaves through the interface of the subnet of gatewayIP.
+func ipOutput(node *data.Node, gatewayIP data.IPAddress, oif *data.Interface, payload data.Payload) {
+	table := node.Properties.Tunnels
+	if table == nil {
+		PacketDemoteToLayer2(node, gatewayIP, oif, payload, constants.EthernetIpProto)
+		return
+	}
+	if oif == nil && !IsRouteLocalDelivery(node, gatewayIP) {
+		oif = node.GetMatchingSubnetInterface(gatewayIP)
+	}
+	table.Mutex.Lock()
+	tunnel := table.LookupTunnelByInterface(oif)
+	table.Mutex.Unlock()
+	if tunnel == nil {
+		PacketDemoteToLayer2(node, gatewayIP, oif, payload, constants.EthernetIpProto)
+		return
+	}
+	tunnelEncapsulate(node, table, tunnel, payload)
+}
+
+// tunnelEncapsulate sends the packet in payload to the remote end of the tunnel. The tunnel
+// destination must be reached over a physical interface, a route through a tunnel would loop.
+func tunnelEncapsulate(node *data.Node, table *data.TunnelTable, tunnel *data.Tunnel, payload data.Payload) {
+	table.Mutex.Lock()
+	name, mode := tunnel.Interface.Name.String(), tunnel.Mode
+	sourceIP, destinationIP := tunnel.SourceIP, tunnel.DestinationIP
+	hasKey, key := tunnel.HasKey, tunnel.Key
+	table.Mutex.Unlock()
+
+	drop := func(reason string) {
+		fmt.Println("Tunnel", name, "of node", node.NodeName, "dropped packet:", reason)
+		table.Mutex.Lock()
+		tunnel.Drops++
+		table.Mutex.Unlock()
+	}
+
+	headerSize := int(unsafe.Sizeof(data.IPHeader{}))
+	ipHeader := data.DeserializeIPHeader(payload[:headerSize])
+	length := int(ipHeader.Length)
+	if length < headerSize || length > len(payload) {
+		drop("invalid packet length")
+		return
+	}
+	packet := payload[:length]
+
+	protocol := constants.IpInIpProto
+	var appData []byte
+	if mode == data.TunnelModeGRE {
+		protocol = constants.GreProto
+		greHeader := data.GreHeader{
+			ProtocolType: constants.EthernetIpProto,
+		}
+		if hasKey {
+			greHeader.Flags |= constants.GreFlagKey
+			greHeader.Key = key
+		}
+		appData = greHeader.SerializeGreHeader()
+	}
+	appData = append(appData, packet...)
+	if headerSize+len(appData) > len(payload) {
+		drop("packet too big for the tunnel")
+		return
+	}
+
+	route := node.Properties.RoutingTable.LookupRoutingTableLPM(destinationIP)
+	if route == nil {
+		drop("no route to tunnel destination " + destinationIP.String())
+		return
+	}
+	var underlay *data.Interface
+	if route.IsDirect {
+		underlay = node.GetMatchingSubnetInterface(destinationIP)
+	} else if _, nextHopIntf, ok := resolveNextHop(node, nil, route, 0); ok {
+		underlay = nextHopIntf
+	}
+	table.Mutex.Lock()
+	isRecursive := underlay != nil && table.LookupTunnelByInterface(underlay) != nil
+	if !isRecursive {
+		tunnel.PacketsOut++
+		tunnel.BytesOut += uint64(length)
+	}
+	table.Mutex.Unlock()
+	if isRecursive {
+		drop("tunnel destination " + destinationIP.String() + " is routed through a tunnel")
+		return
+	}
+	PacketSendFromSource(node, sourceIP, appData, protocol, destinationIP)
+}
+
+// tunnelDecapsulate receives the packet carried in a GRE or IP-in-IP packet on the tunnel
+// interface it belongs to. IP-in-IP packets of no configured tunnel are received on iif.
+func tunnelDecapsulate(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, payload data.Payload) {
+	headerSize := int(unsafe.Sizeof(data.IPHeader{}))
+	end := int(ipHeader.Length)
+	if end < headerSize || end > len(payload) {
+		end = len(payload)
+	}
+	appData := payload[headerSize:end]
+
+	mode := data.TunnelModeIPIP
+	var greHeader data.GreHeader
+	if ipHeader.Protocol == constants.GreProto {
+		mode = data.TunnelModeGRE
+		header, offset, err := data.DeserializeGreHeader(appData)
+		if err != nil {
+			fmt.Println("GRE packet from", ipHeader.SourceIP.String(), "dropped on node", node.NodeName+":", err)
+			return
+		}
+		if header.ProtocolType != constants.EthernetIpProto {
+			fmt.Println("GRE packet from", ipHeader.SourceIP.String(), "dropped on node", node.NodeName+": unsupported protocol type", header.ProtocolType)
+			return
+		}
+		greHeader = header
+		appData = appData[offset:]
+	}
+
+	var tunnel *data.Tunnel
+	if table := node.Properties.Tunnels; table != nil {
+		table.Mutex.Lock()
+		tunnel = table.LookupTunnelByEndpoints(mode, ipHeader.DestinationIP, ipHeader.SourceIP, greHeader.Flags&constants.GreFlagKey != 0, greHeader.Key)
+		if tunnel != nil {
+			tunnel.PacketsIn++
+			tunnel.BytesIn += uint64(len(appData))
+		}
+		table.Mutex.Unlock()
+	}
+	if tunnel != nil {
+		iif = &tunnel.Interface
+	} else if mode == data.TunnelModeGRE {
+		fmt.Println("GRE packet from", ipHeader.SourceIP.String(), "matches no tunnel of node", node.NodeName)
+		return
+	}
+
+	innerPayload := data.Payload{}
+	copy(innerPayload[:], appData)
+	PacketReceive(node, iif, innerPayload)
+}
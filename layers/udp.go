@@ -0,0 +1,106 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+	"unsafe"
+)
+
+func init() {
+	data.UDPOutput = udpSocketOutput
+}
+
+func UDPReceive(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, payload data.Payload) {
+	portTable := node.Properties.UDPPorts
+	l4Offset := int(unsafe.Sizeof(data.IPHeader{}))
+	udpHeader := data.DeserializeUDPHeader(payload[l4Offset : l4Offset+data.UDPHeaderSize])
+
+	if int(udpHeader.Length) < data.UDPHeaderSize || l4Offset+int(udpHeader.Length) > len(payload) {
+		fmt.Println("UDPReceive: invalid UDP length, datagram dropped")
+		portTable.CountError()
+		return
+	}
+	datagram := payload[l4Offset : l4Offset+int(udpHeader.Length)]
+	if !data.VerifyUDPChecksum(ipHeader.SourceIP, ipHeader.DestinationIP, datagram) {
+		fmt.Println("UDPReceive: invalid UDP checksum, datagram dropped")
+		portTable.CountError()
+		return
+	}
+
+	handler := portTable.Lookup(udpHeader.DestinationPort)
+	if handler == nil {
+		fmt.Println("UDPReceive: no listener on port", udpHeader.DestinationPort, "of node", node.NodeName)
+		portTable.CountNoPort()
+		// broadcasts and multicasts nobody listens to are dropped silently
+		if !isLinkLocalDestination(ipHeader.DestinationIP) {
+			sendICMPDestinationUnreachable(node, iif, constants.IcmpCodePortUnreachable, ipHeader, payload)
+		}
+		return
+	}
+	portTable.CountIn()
+	handler(node, iif, ipHeader, udpHeader, datagram[data.UDPHeaderSize:])
+}
+
+// UDPSendLinkLocal sends a datagram to a multicast group or the limited broadcast address out of oif.
+func UDPSendLinkLocal(node *data.Node, oif *data.Interface, sourcePort uint16, destinationIP data.IPAddress, destinationPort uint16, appData []byte) {
+	datagram := data.SerializeUDPDatagram(oif.Properties.IP, sourcePort, destinationIP, destinationPort, appData)
+	node.Properties.UDPPorts.CountOut()
+	PacketSendLinkLocal(node, oif, destinationIP, constants.UdpProto, datagram)
+}
+
+// UDPSend routes a datagram from sourceIP to destinationIP.
+func UDPSend(node *data.Node, sourceIP data.IPAddress, sourcePort uint16, destinationIP data.IPAddress, destinationPort uint16, appData []byte) {
+	datagram := data.SerializeUDPDatagram(sourceIP, sourcePort, destinationIP, destinationPort, appData)
+	node.Properties.UDPPorts.CountOut()
+	PacketSendFromSource(node, sourceIP, datagram, constants.UdpProto, destinationIP)
+}
+
+// udpSocketOutput sends the datagrams of sockets, a socket bound to no address sends from the
+// address of the outgoing interface.
+func udpSocketOutput(node *data.Node, sourceIP data.IPAddress, sourcePort uint16, destinationIP data.IPAddress, destinationPort uint16, appData []byte) error {
+	if isLinkLocalDestination(destinationIP) {
+		return errors.New("sockets send to unicast addresses only")
+	}
+	if sourceIP.IsUnspecified() {
+		IP, err := sourceAddressFor(node, nil, destinationIP)
+		if err != nil {
+			return err
+		}
+		sourceIP = IP
+	}
+	UDPSend(node, sourceIP, sourcePort, destinationIP, destinationPort, appData)
+	return nil
+}
+
+// sourceAddressFor picks the address of the interface a packet to destinationIP leaves from in vrf,
+// or the loopback address for IPv4 destinations of the node itself.
+func sourceAddressFor(node *data.Node, vrf *data.Vrf, destinationIP data.IPAddress) (data.IPAddress, error) {
+	if IsVrfLocalDelivery(node, vrf, destinationIP) {
+		return destinationIP, nil
+	}
+	route := node.VrfRoutingTable(vrf).LookupRoutingTableLPM(destinationIP)
+	if route == nil {
+		return data.IPAddress{}, errors.New("no route to host")
+	}
+	var oif *data.Interface
+	if route.IsDirect {
+		oif = node.GetVrfMatchingSubnetInterface(destinationIP, vrf)
+	} else if _, nextHopIntf, ok := resolveNextHop(node, vrf, route, 0); ok {
+		oif = nextHopIntf
+	}
+	if !destinationIP.IsIPv4() {
+		if oif == nil || !oif.Properties.IsIpv6Enabled || oif.Properties.IPv6.IsUnspecified() {
+			return data.IPAddress{}, errors.New("no IPv6 source address to reach host")
+		}
+		return oif.Properties.IPv6, nil
+	}
+	if oif != nil && oif.Properties.IsIpConfigured {
+		return oif.Properties.IP, nil
+	}
+	if vrf == nil && node.Properties.IsLbConfigured {
+		return node.Properties.LB, nil
+	}
+	return data.IPAddress{}, errors.New("no source address to reach host")
+}
@@ -0,0 +1,112 @@
+package layers
+
+import (
+	"errors"
+	"fmt"
+	"tcpip/constants"
+	"tcpip/data"
+)
+
+// getVRFTable returns the VRFs of the node, the first VRF creates the table.
+func getVRFTable(node *data.Node) *data.VrfTable {
+	if node.Properties.Vrfs == nil {
+		table := &data.VrfTable{
+			Vrfs: data.Dll{},
+		}
+		(&table.Vrfs).Init()
+		node.Properties.Vrfs = table
+	}
+	return node.Properties.Vrfs
+}
+
+// AddVrf creates the VRF name on the node, it routes nothing until interfaces are assigned to it.
+func AddVrf(node *data.Node, name string) error {
+	if name == "" || name == "default" || name == "none" {
+		return fmt.Errorf("invalid VRF name %q", name)
+	}
+	table := getVRFTable(node)
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+
+	if table.LookupVrf(name) != nil {
+		return errors.New("VRF already exists")
+	}
+	table.AddVrf(data.NewVrf(name))
+	return nil
+}
+
+// DeleteVrf removes the VRF name with its routes, no interface may be assigned to it.
+func DeleteVrf(node *data.Node, name string) error {
+	vrf := node.LookupVrf(name)
+	if vrf == nil {
+		return data.ErrVrfNotFound
+	}
+	for _, intf := range node.Interfaces {
+		if intf != nil && intf.Properties.Vrf == vrf {
+			return fmt.Errorf("interface %s is assigned to the VRF", intf.Name.String())
+		}
+	}
+	table := node.Properties.Vrfs
+	table.Mutex.Lock()
+	defer table.Mutex.Unlock()
+	(&vrf.VrfGlue).RemoveNode()
+	return nil
+}
+
+// SetIntfVrf assigns the interface to the VRF vrfName, "none" returns it to the default VRF. The
+// IPv4 and IPv6 connected routes of the interface move to the RIB of the new VRF and the neighbors
+// resolved on the interface are forgotten.
+func SetIntfVrf(node *data.Node, intfName string, vrfName string) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	var vrf *data.Vrf
+	if vrfName != "none" {
+		if vrf = node.LookupVrf(vrfName); vrf == nil {
+			return data.ErrVrfNotFound
+		}
+	}
+	oldVrf := intf.Properties.Vrf
+	if oldVrf == vrf {
+		return nil
+	}
+	type connectedRoute struct {
+		IP   data.IPAddress
+		Mask rune
+	}
+	var connectedRoutes []connectedRoute
+	if intf.Properties.IsIpConfigured {
+		connectedRoutes = append(connectedRoutes, connectedRoute{intf.Properties.IP, intf.Properties.Mask})
+	}
+	if intf.Properties.IsIpv6Enabled && !intf.Properties.IPv6.IsUnspecified() {
+		connectedRoutes = append(connectedRoutes, connectedRoute{intf.Properties.IPv6, intf.Properties.IPv6Mask})
+	}
+	for _, route := range connectedRoutes {
+		if other := node.GetVrfMatchingSubnetInterface(route.IP, vrf); other != nil {
+			return fmt.Errorf("interface %s of VRF %s is on the same subnet", other.Name.String(), vrf.String())
+		}
+	}
+
+	intf.Properties.Vrf = vrf
+	for _, route := range connectedRoutes {
+		// the subnet stays connected in the old VRF while another of its interfaces is on it
+		if node.GetVrfMatchingSubnetInterface(route.IP, oldVrf) == nil {
+			node.VrfRib(oldVrf).DeleteRoute(route.IP, route.Mask, constants.RouteSourceConnected)
+		}
+		if err := node.VrfRib(vrf).AddRoute(route.IP, route.Mask, constants.RouteSourceConnected, 0, nil, nil, 0); err != nil && err != data.ErrRouteExists {
+			return err
+		}
+	}
+	node.VrfArpTable(oldVrf).DeleteInterfaceEntries(intf.Name)
+	return nil
+}
+
+// intfVrf returns the VRF of the interface, nil for the default VRF and for packets the node
+// sends to itself.
+func intfVrf(intf *data.Interface) *data.Vrf {
+	if intf == nil {
+		return nil
+	}
+	return intf.Properties.Vrf
+}
@@ -0,0 +1,261 @@
+package layers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"tcpip/cmd/communication/send"
+	"tcpip/constants"
+	"tcpip/data"
+	"time"
+)
+
+// ConfigureVRRP adds a virtual router to the interface or changes its virtual IP, priority and preemption.
+// A virtual IP equal to the interface address makes the node the owner of the virtual router.
+func ConfigureVRRP(node *data.Node, intfName string, VRID uint8, virtualIP data.IPAddress, priority uint8, preempt bool) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	if !intf.Properties.IsIpConfigured {
+		return errors.New("interface has no IP address")
+	}
+	if VRID == 0 {
+		return errors.New("invalid virtual router ID")
+	}
+	if data.ApplyMask(virtualIP, intf.Properties.Mask) != data.ApplyMask(intf.Properties.IP, intf.Properties.Mask) {
+		return errors.New("virtual IP is not in the subnet of the interface")
+	}
+	if virtualIP == intf.Properties.IP {
+		priority = constants.VrrpOwnerPriority
+	} else if priority == 0 || priority == constants.VrrpOwnerPriority {
+		return errors.New("priority must be between 1 and 254")
+	}
+
+	if node.Properties.Vrrp == nil {
+		vrrp := &data.VrrpInstance{
+			Groups: data.Dll{},
+		}
+		(&vrrp.Groups).Init()
+		node.Properties.Vrrp = vrrp
+		go vrrpTimer(node)
+	}
+
+	vrrp := node.Properties.Vrrp
+	vrrp.Mutex.Lock()
+	defer vrrp.Mutex.Unlock()
+
+	group := vrrp.LookupGroup(intf, VRID)
+	if group == nil {
+		group = &data.VrrpGroup{
+			Interface: intf,
+			VRID:      VRID,
+		}
+		vrrp.AddGroup(group)
+	}
+	group.VirtualIP = virtualIP
+	group.Priority = priority
+	group.Preempt = preempt
+	if group.State == data.VrrpStateInitialize || group.IsOwner() {
+		vrrpStartup(node, group, time.Now())
+	}
+	return nil
+}
+
+// DeleteVRRP removes the virtual router from the interface, a master hands over at once by
+// advertising priority zero.
+func DeleteVRRP(node *data.Node, intfName string, VRID uint8) error {
+	intf := node.GetNodeIntfByName(intfName)
+	if intf == nil {
+		return errors.New("interface not found")
+	}
+	vrrp := node.Properties.Vrrp
+	if vrrp == nil {
+		return errors.New("VRRP is not configured on node")
+	}
+
+	vrrp.Mutex.Lock()
+	defer vrrp.Mutex.Unlock()
+
+	group := vrrp.LookupGroup(intf, VRID)
+	if group == nil {
+		return errors.New("virtual router not found")
+	}
+	if group.State == data.VrrpStateMaster {
+		sendVRRPAdvertisement(group, 0)
+	}
+	(&group.GroupGlue).RemoveNode()
+	return nil
+}
+
+func vrrpStartup(node *data.Node, group *data.VrrpGroup, now time.Time) {
+	if group.IsOwner() {
+		vrrpBecomeMaster(node, group, now)
+		return
+	}
+	group.State = data.VrrpStateBackup
+	group.MasterIP = data.IPAddress{}
+	group.MasterDownAt = now.Add(group.MasterDownInterval())
+}
+
+func vrrpBecomeMaster(node *data.Node, group *data.VrrpGroup, now time.Time) {
+	if group.State != data.VrrpStateMaster {
+		fmt.Println("VRRP: node", node.NodeName, "is master of virtual router", group.VRID, "on interface", group.Interface.Name.String())
+	}
+	group.State = data.VrrpStateMaster
+	group.MasterIP = group.Interface.Properties.IP
+	sendVRRPAdvertisement(group, group.Priority)
+	SendGratuitousARP(group.Interface, group.VirtualIP, group.VirtualMAC())
+	group.NextAdvertisement = now.Add(time.Duration(constants.VrrpAdvertisementSeconds) * time.Second)
+}
+
+func vrrpBecomeBackup(node *data.Node, group *data.VrrpGroup, masterIP data.IPAddress, now time.Time) {
+	fmt.Println("VRRP: node", node.NodeName, "is backup of virtual router", group.VRID, "on interface", group.Interface.Name.String())
+	group.State = data.VrrpStateBackup
+	group.MasterIP = masterIP
+	group.MasterDownAt = now.Add(group.MasterDownInterval())
+}
+
+// sendVRRPAdvertisement multicasts an advertisement from the virtual MAC, so the switches of the
+// LAN keep pointing the virtual MAC at the master.
+func sendVRRPAdvertisement(group *data.VrrpGroup, priority uint8) {
+	advertisement := data.VrrpAdvertisement{
+		Version:           constants.VrrpVersion,
+		Type:              constants.VrrpTypeAdvertisement,
+		VRID:              group.VRID,
+		Priority:          priority,
+		MaxAdvertInterval: uint16(constants.VrrpAdvertisementSeconds * 100),
+		VirtualIPs:        []data.IPAddress{group.VirtualIP},
+	}
+	oif := group.Interface
+	appData := advertisement.SerializeVrrpAdvertisement(oif.Properties.IP, constants.VrrpMulticastIP)
+
+	ethernetHeader := linkLocalFrame(oif, constants.VrrpMulticastIP, constants.VrrpProto, appData)
+	ethernetHeader.SourceMAC = group.VirtualMAC()
+	send.PacketSend(ethernetHeader.SerializeEthernetHeader(), oif)
+}
+
+func processVRRPAdvertisement(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, appData []byte) {
+	vrrp := node.Properties.Vrrp
+	if vrrp == nil || iif == nil {
+		return
+	}
+	advertisement, err := data.DeserializeVrrpAdvertisement(appData, ipHeader.SourceIP, ipHeader.DestinationIP)
+	if err != nil {
+		fmt.Println("processVRRPAdvertisement:", err, "on node", node.NodeName)
+		return
+	}
+	if advertisement.Version != constants.VrrpVersion || advertisement.Type != constants.VrrpTypeAdvertisement {
+		return
+	}
+
+	vrrp.Mutex.Lock()
+	defer vrrp.Mutex.Unlock()
+
+	group := vrrp.LookupGroup(iif, advertisement.VRID)
+	if group == nil {
+		return
+	}
+	now := time.Now()
+
+	switch group.State {
+	case data.VrrpStateBackup:
+		switch {
+		case advertisement.Priority == 0:
+			// the master resigned, take over after the skew time only
+			group.MasterDownAt = now.Add(group.SkewTime())
+		case !group.Preempt || advertisement.Priority >= group.Priority:
+			group.MasterIP = ipHeader.SourceIP
+			group.MasterDownAt = now.Add(group.MasterDownInterval())
+		default:
+			// a lower priority master is preempted when the master down timer expires
+			group.MasterIP = ipHeader.SourceIP
+		}
+	case data.VrrpStateMaster:
+		switch {
+		case advertisement.Priority == 0:
+			sendVRRPAdvertisement(group, group.Priority)
+			group.NextAdvertisement = now.Add(time.Duration(constants.VrrpAdvertisementSeconds) * time.Second)
+		case advertisement.Priority > group.Priority ||
+			(advertisement.Priority == group.Priority && bytes.Compare(ipHeader.SourceIP[:], iif.Properties.IP[:]) > 0):
+			vrrpBecomeBackup(node, group, ipHeader.SourceIP, now)
+		}
+	}
+}
+
+// isVRRPMasterIP reports a virtual IP the node is master for, on intf or on any interface when intf is nil.
+func isVRRPMasterIP(node *data.Node, intf *data.Interface, IP data.IPAddress) bool {
+	vrrp := node.Properties.Vrrp
+	if vrrp == nil {
+		return false
+	}
+	vrrp.Mutex.Lock()
+	defer vrrp.Mutex.Unlock()
+
+	return vrrp.LookupMasterByIP(intf, IP) != nil
+}
+
+func isVRRPMasterMAC(intf *data.Interface, MAC data.MacAddress) bool {
+	vrrp := intf.Node.Properties.Vrrp
+	if vrrp == nil {
+		return false
+	}
+	vrrp.Mutex.Lock()
+	defer vrrp.Mutex.Unlock()
+
+	return vrrp.LookupMasterByMAC(intf, MAC) != nil
+}
+
+// vrrpMasterMAC returns the virtual MAC of the virtual IP when the node is its master on intf.
+func vrrpMasterMAC(node *data.Node, intf *data.Interface, IP data.IPAddress) (data.MacAddress, bool) {
+	vrrp := node.Properties.Vrrp
+	if vrrp == nil {
+		return data.MacAddress{}, false
+	}
+	vrrp.Mutex.Lock()
+	defer vrrp.Mutex.Unlock()
+
+	group := vrrp.LookupMasterByIP(intf, IP)
+	if group == nil {
+		return data.MacAddress{}, false
+	}
+	return group.VirtualMAC(), true
+}
+
+func vrrpTimer(node *data.Node) {
+	// the skew time between backups is a fraction of a second
+	ticker := time.NewTicker(time.Second / 10)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		vrrp := node.Properties.Vrrp
+		vrrp.Mutex.Lock()
+
+		now := time.Now()
+		for dllGroup := vrrp.Groups.Next; dllGroup != nil; dllGroup = dllGroup.Next {
+			group := dllGroup.DllToVrrpGroup()
+			if !group.Interface.IsUp() {
+				if group.State != data.VrrpStateInitialize {
+					fmt.Println("VRRP: interface", group.Interface.Name.String(), "of node", node.NodeName, "is down, virtual router", group.VRID, "initializes")
+				}
+				group.State = data.VrrpStateInitialize
+				continue
+			}
+
+			switch group.State {
+			case data.VrrpStateInitialize:
+				vrrpStartup(node, group, now)
+			case data.VrrpStateBackup:
+				if !now.Before(group.MasterDownAt) {
+					vrrpBecomeMaster(node, group, now)
+				}
+			case data.VrrpStateMaster:
+				if !now.Before(group.NextAdvertisement) {
+					sendVRRPAdvertisement(group, group.Priority)
+					group.NextAdvertisement = now.Add(time.Duration(constants.VrrpAdvertisementSeconds) * time.Second)
+				}
+			}
+		}
+		vrrp.Mutex.Unlock()
+	}
+}
@@ -0,0 +1,235 @@
+package layers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"tcpip/constants"
+	"tcpip/data"
+	"unsafe"
+)
+
+// getVXLANInstance returns the VTEP of the node, the first VXLAN configuration creates it and
+// starts listening on the VXLAN port.
+func getVXLANInstance(node *data.Node) *data.VxlanInstance {
+	if node.Properties.Vxlan == nil {
+		vxlan := &data.VxlanInstance{
+			Vnis: data.Dll{},
+		}
+		(&vxlan.Vnis).Init()
+		node.Properties.Vxlan = vxlan
+		node.Properties.UDPPorts.Register(constants.VxlanPort, processVXLANMessage)
+	}
+	return node.Properties.Vxlan
+}
+
+// SetVXLANSource makes the address of the interface, or the loopback address of the node for
+// intfName "loopback", the source of the VXLAN packets of the node. Remote VTEPs list this address
+// in their flood lists.
+func SetVXLANSource(node *data.Node, intfName string) error {
+	var intf *data.Interface
+	if intfName == "loopback" {
+		if !node.Properties.IsLbConfigured {
+			return errors.New("node has no loopback address")
+		}
+	} else {
+		if intf = node.GetNodeIntfByName(intfName); intf == nil {
+			return errors.New("interface not found")
+		}
+		if !intf.Properties.IsIpConfigured {
+			return errors.New("interface has no IP address")
+		}
+	}
+
+	vxlan := getVXLANInstance(node)
+	vxlan.Mutex.Lock()
+	defer vxlan.Mutex.Unlock()
+
+	vxlan.SourceInterface = intf
+	vxlan.IsSourceLoopback = intf == nil
+	return nil
+}
+
+// SetVXLANVni maps the VNI to a VLAN of the switch, a VLAN maps to one VNI only.
+func SetVXLANVni(node *data.Node, vni uint32, vlan uint) error {
+	if vni == 0 || vni > constants.VxlanMaxVni {
+		return errors.New("invalid VNI")
+	}
+	if vlan == 0 || vlan >= 4095 {
+		return errors.New("invalid VLAN ID")
+	}
+
+	vxlan := getVXLANInstance(node)
+	vxlan.Mutex.Lock()
+	defer vxlan.Mutex.Unlock()
+
+	if other := vxlan.LookupVniByVlan(vlan); other != nil && other.Vni != vni {
+		return fmt.Errorf("VLAN is mapped to VNI %d", other.Vni)
+	}
+	if entry := vxlan.LookupVni(vni); entry != nil {
+		entry.Vlan = vlan
+		return nil
+	}
+	vxlan.AddVni(&data.VxlanVni{
+		Vni:  vni,
+		Vlan: vlan,
+	})
+	return nil
+}
+
+// SetVXLANFloodList replaces the remote VTEPs broadcast and unknown unicast frames of the VNI are
+// replicated to.
+func SetVXLANFloodList(node *data.Node, vni uint32, peers []data.IPAddress) error {
+	for _, IP := range peers {
+		if !IP.IsIPv4() {
+			return errors.New("VTEPs have IPv4 addresses only")
+		}
+		if IsRouteLocalDelivery(node, IP) {
+			return fmt.Errorf("%s is an address of the node", IP.String())
+		}
+	}
+	vxlan := node.Properties.Vxlan
+	if vxlan == nil {
+		return errors.New("VNI not found")
+	}
+	vxlan.Mutex.Lock()
+	defer vxlan.Mutex.Unlock()
+
+	entry := vxlan.LookupVni(vni)
+	if entry == nil {
+		return errors.New("VNI not found")
+	}
+	entry.FloodList = append([]data.IPAddress(nil), peers...)
+	return nil
+}
+
+func vxlanSourceAddress(node *data.Node, vxlan *data.VxlanInstance) (data.IPAddress, bool) {
+	if vxlan.IsSourceLoopback {
+		return node.Properties.LB, node.Properties.IsLbConfigured
+	}
+	if vxlan.SourceInterface != nil && vxlan.SourceInterface.Properties.IsIpConfigured {
+		return vxlan.SourceInterface.Properties.IP, true
+	}
+	return data.IPAddress{}, false
+}
+
+// vxlanInnerFrame returns the frame carried over VXLAN, untagged and without the zero padding of
+// its payload which the receiving VTEP restores.
+func vxlanInnerFrame(packet data.Packet) []byte {
+	ethernetHeader := UntagPacketWithVLANId(packet)
+	length := len(ethernetHeader.Payload)
+	for length > 0 && ethernetHeader.Payload[length-1] == 0 {
+		length--
+	}
+	frame := make([]byte, 14+length)
+	copy(frame[0:6], ethernetHeader.DestinationMAC[:])
+	copy(frame[6:12], ethernetHeader.SourceMAC[:])
+	frame[12] = byte(ethernetHeader.Type >> 8)
+	frame[13] = byte(ethernetHeader.Type)
+	copy(frame[14:], ethernetHeader.Payload[:length])
+	return frame
+}
+
+// vxlanSourcePort spreads the flows of the inner frames over the UDP source ports so that ECMP in
+// the core can balance them.
+func vxlanSourcePort(frame []byte) uint16 {
+	hash := fnv.New32a()
+	hash.Write(frame[:14])
+	return constants.UdpEphemeralPortStart + uint16(hash.Sum32()%uint32(0xFFFF-constants.UdpEphemeralPortStart+1))
+}
+
+// vxlanForward sends a frame the switch forwards to remote VTEPs, to vtepIP for a destination
+// learned behind it or to the flood list of the VNI of its VLAN when vtepIP is nil. Frames of VLANs
+// without VNI stay local.
+func vxlanForward(node *data.Node, packet data.Packet, vtepIP *data.IPAddress) {
+	vxlan := node.Properties.Vxlan
+	if vxlan == nil {
+		return
+	}
+	vlanEthernetHeader := IsPacketVLANTagged(packet)
+	if vlanEthernetHeader == nil {
+		return
+	}
+	frame := vxlanInnerFrame(packet)
+	if int(unsafe.Sizeof(data.IPHeader{}))+data.UDPHeaderSize+constants.VxlanHeaderSize+len(frame) > constants.MaxPayloadSize {
+		fmt.Println("VXLAN: frame too big for encapsulation dropped on node", node.NodeName)
+		return
+	}
+
+	vxlan.Mutex.Lock()
+	entry := vxlan.LookupVniByVlan(uint(vlanEthernetHeader.Tag.GetVlanID()))
+	sourceIP, hasSource := vxlanSourceAddress(node, vxlan)
+	if entry == nil || !hasSource {
+		vxlan.Mutex.Unlock()
+		return
+	}
+	vni := entry.Vni
+	targets := entry.FloodList
+	if vtepIP != nil {
+		targets = []data.IPAddress{*vtepIP}
+	}
+	targets = append([]data.IPAddress(nil), targets...)
+	entry.FramesOut += uint64(len(targets))
+	vxlan.Mutex.Unlock()
+
+	header := data.VxlanHeader{
+		Flags: constants.VxlanFlagVni,
+		Vni:   vni,
+	}
+	appData := append(header.SerializeVxlanHeader(), frame...)
+	sourcePort := vxlanSourcePort(frame)
+	for _, IP := range targets {
+		UDPSend(node, sourceIP, sourcePort, IP, constants.VxlanPort, appData)
+	}
+}
+
+// processVXLANMessage switches a frame received from a remote VTEP into the VLAN of its VNI and
+// learns its source MAC behind that VTEP. Frames from VTEPs are never sent back to VTEPs.
+func processVXLANMessage(node *data.Node, iif *data.Interface, ipHeader data.IPHeader, udpHeader data.UDPHeader, appData []byte) {
+	header, err := data.DeserializeVxlanHeader(appData)
+	if err != nil || header.Flags&constants.VxlanFlagVni == 0 || len(appData) < constants.VxlanHeaderSize+14 {
+		fmt.Println("VXLAN: invalid packet from", ipHeader.SourceIP.String(), "dropped on node", node.NodeName)
+		return
+	}
+	vxlan := node.Properties.Vxlan
+	vxlan.Mutex.Lock()
+	entry := vxlan.LookupVni(header.Vni)
+	var vlan uint
+	if entry != nil {
+		entry.FramesIn++
+		vlan = entry.Vlan
+	}
+	vxlan.Mutex.Unlock()
+	if entry == nil {
+		fmt.Println("VXLAN: unknown VNI", header.Vni, "from", ipHeader.SourceIP.String(), "on node", node.NodeName)
+		return
+	}
+
+	frame := appData[constants.VxlanHeaderSize:]
+	ethernetHeader := &data.EthernetHeader{
+		Type: uint16(frame[12])<<8 | uint16(frame[13]),
+	}
+	copy(ethernetHeader.DestinationMAC[:], frame[0:6])
+	copy(ethernetHeader.SourceMAC[:], frame[6:12])
+	copy(ethernetHeader.Payload[:], frame[14:])
+	packet := TagPacketWithVLANId(ethernetHeader.SerializeEthernetHeader(), vlan).SerializeVLANEthernetHeader()
+
+	AddMacTableEntry(node.Properties.MacTable, &data.MacEntry{
+		MAC:      ethernetHeader.SourceMAC,
+		IsRemote: true,
+		VtepIP:   ipHeader.SourceIP,
+	})
+
+	macEntry := MacTableLookup(node.Properties.MacTable, ethernetHeader.DestinationMAC)
+	if bytes.Equal(ethernetHeader.DestinationMAC[:], constants.BroadcastMacAddress[:]) || macEntry == nil {
+		FloodPacket(node, nil, packet)
+		return
+	}
+	if macEntry.IsRemote {
+		return
+	}
+	if oif := node.GetNodeIntfByName(macEntry.InterfaceName.String()); oif != nil {
+		SwitchSendPacketOut(packet, oif)
+	}
+}
@@ -11,7 +11,7 @@ func CyclicTopology() *data.Graph {
 
 	var node1 = topology.CreateNode("node1")
 	var node2 = topology.CreateNode("node2")
-	var node3 = topology.CreateNode("node2")
+	var node3 = topology.CreateNode("node3")
 
 	data.InsertLink(node1, node2, "eth0/0", "eth0/1", 1)
 	data.InsertLink(node2, node3, "eth0/2", "eth0/3", 1)
@@ -37,7 +37,7 @@ func LinearTopology() *data.Graph {
 
 	var node1 = topology.CreateNode("node1")
 	var node2 = topology.CreateNode("node2")
-	var node3 = topology.CreateNode("node2")
+	var node3 = topology.CreateNode("node3")
 
 	data.InsertLink(node1, node2, "eth0/0", "eth0/1", 1)
 	data.InsertLink(node2, node3, "eth0/2", "eth0/3", 1)
@@ -163,3 +163,308 @@ func SquareTopology() *data.Graph {
 
 	return topology
 }
+
+// GatewayRedundancyTopology puts two hosts and two gateway routers on a switched LAN, R1 and R2
+// are both connected to R3 and can share a VRRP virtual IP as default gateway of the hosts.
+func GatewayRedundancyTopology() *data.Graph {
+	topology := data.CreateGraph("Gateway redundancy topology")
+	H1 := topology.CreateNode("H1")
+	H2 := topology.CreateNode("H2")
+	R1 := topology.CreateNode("R1")
+	R2 := topology.CreateNode("R2")
+	R3 := topology.CreateNode("R3")
+	L2SW := topology.CreateNode("L2SW")
+
+	data.InsertLink(H1, L2SW, "eth0/1", "eth0/2", 1)
+	data.InsertLink(H2, L2SW, "eth0/3", "eth0/4", 1)
+	data.InsertLink(R1, L2SW, "eth0/5", "eth0/6", 1)
+	data.InsertLink(R2, L2SW, "eth0/7", "eth0/8", 1)
+	data.InsertLink(R1, R3, "eth0/9", "eth0/10", 1)
+	data.InsertLink(R2, R3, "eth0/11", "eth0/12", 1)
+
+	H1.SetLbAddress(data.StringToIPAddress("122.1.1.11"))
+	H1.SetIntfIPAddress("eth0/1", data.StringToIPAddress("10.1.1.11"), 24)
+	H2.SetLbAddress(data.StringToIPAddress("122.1.1.12"))
+	H2.SetIntfIPAddress("eth0/3", data.StringToIPAddress("10.1.1.12"), 24)
+
+	R1.SetLbAddress(data.StringToIPAddress("122.1.1.1"))
+	R1.SetIntfIPAddress("eth0/5", data.StringToIPAddress("10.1.1.1"), 24)
+	R1.SetIntfIPAddress("eth0/9", data.StringToIPAddress("20.1.1.1"), 24)
+
+	R2.SetLbAddress(data.StringToIPAddress("122.1.1.2"))
+	R2.SetIntfIPAddress("eth0/7", data.StringToIPAddress("10.1.1.2"), 24)
+	R2.SetIntfIPAddress("eth0/11", data.StringToIPAddress("30.1.1.1"), 24)
+
+	R3.SetLbAddress(data.StringToIPAddress("122.1.1.3"))
+	R3.SetIntfIPAddress("eth0/10", data.StringToIPAddress("20.1.1.2"), 24)
+	R3.SetIntfIPAddress("eth0/12", data.StringToIPAddress("30.1.1.2"), 24)
+
+	layers.SetIntfL2Mode(L2SW, "eth0/2", constants.ACCESS)
+	layers.SetIntfL2Mode(L2SW, "eth0/4", constants.ACCESS)
+	layers.SetIntfL2Mode(L2SW, "eth0/6", constants.ACCESS)
+	layers.SetIntfL2Mode(L2SW, "eth0/8", constants.ACCESS)
+
+	return topology
+}
+
+func ProviderBridgeTopology() *data.Graph {
+	topology := data.CreateGraph("Provider bridge topology")
+	CustAH1 := topology.CreateNode("CustAH1")
+	CustAH2 := topology.CreateNode("CustAH2")
+	CustBH1 := topology.CreateNode("CustBH1")
+	CustBH2 := topology.CreateNode("CustBH2")
+
+	PE1 := topology.CreateNode("PE1")
+	PE2 := topology.CreateNode("PE2")
+
+	data.InsertLink(CustAH1, PE1, "eth0/1", "eth0/2", 1)
+	data.InsertLink(CustBH1, PE1, "eth0/3", "eth0/4", 1)
+	data.InsertLink(PE1, PE2, "eth0/5", "eth0/6", 1)
+	data.InsertLink(CustAH2, PE2, "eth0/7", "eth0/8", 1)
+	data.InsertLink(CustBH2, PE2, "eth0/9", "eth0/10", 1)
+
+	// both customers use the same subnet, the S-VLAN keeps them apart across the provider core
+	CustAH1.SetIntfIPAddress("eth0/1", data.StringToIPAddress("10.1.1.1"), 24)
+	CustAH2.SetIntfIPAddress("eth0/7", data.StringToIPAddress("10.1.1.2"), 24)
+	CustBH1.SetIntfIPAddress("eth0/3", data.StringToIPAddress("10.1.1.1"), 24)
+	CustBH2.SetIntfIPAddress("eth0/9", data.StringToIPAddress("10.1.1.2"), 24)
+
+	layers.SetIntfL2Mode(PE1, "eth0/2", constants.PROVIDER_EDGE)
+	layers.SetIntfVLAN(PE1, "eth0/2", 100)
+	layers.SetIntfL2Mode(PE1, "eth0/4", constants.PROVIDER_EDGE)
+	layers.SetIntfVLAN(PE1, "eth0/4", 200)
+	layers.SetIntfL2Mode(PE1, "eth0/5", constants.PROVIDER_CORE)
+	layers.SetIntfVLAN(PE1, "eth0/5", 100)
+	layers.SetIntfVLAN(PE1, "eth0/5", 200)
+
+	layers.SetIntfL2Mode(PE2, "eth0/6", constants.PROVIDER_CORE)
+	layers.SetIntfVLAN(PE2, "eth0/6", 100)
+	layers.SetIntfVLAN(PE2, "eth0/6", 200)
+	layers.SetIntfL2Mode(PE2, "eth0/8", constants.PROVIDER_EDGE)
+	layers.SetIntfVLAN(PE2, "eth0/8", 100)
+	layers.SetIntfL2Mode(PE2, "eth0/10", constants.PROVIDER_EDGE)
+	layers.SetIntfVLAN(PE2, "eth0/10", 200)
+
+	return topology
+}
+
+// SlaacTopology has R1 advertise IPv6 prefixes on a switched LAN and on its link to R2, the hosts
+// and R2 autoconfigure their addresses and use R1 as default router.
+func SlaacTopology() *data.Graph {
+	topology := data.CreateGraph("SLAAC topology")
+	H1 := topology.CreateNode("H1")
+	H2 := topology.CreateNode("H2")
+	R1 := topology.CreateNode("R1")
+	R2 := topology.CreateNode("R2")
+	L2SW := topology.CreateNode("L2SW")
+
+	data.InsertLink(H1, L2SW, "eth0/1", "eth0/2", 1)
+	data.InsertLink(H2, L2SW, "eth0/3", "eth0/4", 1)
+	data.InsertLink(R1, L2SW, "eth0/5", "eth0/6", 1)
+	data.InsertLink(R1, R2, "eth0/7", "eth0/8", 1)
+
+	R1.SetIntfIPv6Address("eth0/5", data.StringToIPAddress("2001:db8:1::1"), 64)
+	R1.SetIntfIPv6Address("eth0/7", data.StringToIPAddress("2001:db8:2::1"), 64)
+	layers.EnableRouterAdvertisement(R1, "eth0/5", constants.RaDefaultRouterLifetimeSeconds, nil)
+	layers.EnableRouterAdvertisement(R1, "eth0/7", constants.RaDefaultRouterLifetimeSeconds, nil)
+
+	layers.EnableSLAAC(H1, "eth0/1")
+	layers.EnableSLAAC(H2, "eth0/3")
+	layers.EnableSLAAC(R2, "eth0/8")
+
+	layers.SetIntfL2Mode(L2SW, "eth0/2", constants.ACCESS)
+	layers.SetIntfL2Mode(L2SW, "eth0/4", constants.ACCESS)
+	layers.SetIntfL2Mode(L2SW, "eth0/6", constants.ACCESS)
+
+	return topology
+}
+
+// BranchNatTopology connects a branch LAN through BR1 and ISP to SRV. The hosts have private
+// addresses, BR1 translates them onto its loopback address, the only branch address ISP routes.
+func BranchNatTopology() *data.Graph {
+	topology := data.CreateGraph("Branch NAT topology")
+	H1 := topology.CreateNode("H1")
+	H2 := topology.CreateNode("H2")
+	BR1 := topology.CreateNode("BR1")
+	ISP := topology.CreateNode("ISP")
+	SRV := topology.CreateNode("SRV")
+	L2SW := topology.CreateNode("L2SW")
+
+	data.InsertLink(H1, L2SW, "eth0/1", "eth0/2", 1)
+	data.InsertLink(H2, L2SW, "eth0/3", "eth0/4", 1)
+	data.InsertLink(BR1, L2SW, "eth0/5", "eth0/6", 1)
+	data.InsertLink(BR1, ISP, "eth0/9", "eth0/10", 1)
+	data.InsertLink(ISP, SRV, "eth0/11", "eth0/12", 1)
+
+	H1.SetIntfIPAddress("eth0/1", data.StringToIPAddress("10.1.1.11"), 24)
+	H2.SetIntfIPAddress("eth0/3", data.StringToIPAddress("10.1.1.12"), 24)
+
+	BR1.SetLbAddress(data.StringToIPAddress("122.1.1.1"))
+	BR1.SetIntfIPAddress("eth0/5", data.StringToIPAddress("10.1.1.1"), 24)
+	BR1.SetIntfIPAddress("eth0/9", data.StringToIPAddress("20.1.1.1"), 24)
+
+	ISP.SetLbAddress(data.StringToIPAddress("122.1.1.2"))
+	ISP.SetIntfIPAddress("eth0/10", data.StringToIPAddress("20.1.1.2"), 24)
+	ISP.SetIntfIPAddress("eth0/11", data.StringToIPAddress("30.1.1.1"), 24)
+
+	SRV.SetLbAddress(data.StringToIPAddress("122.1.1.3"))
+	SRV.SetIntfIPAddress("eth0/12", data.StringToIPAddress("30.1.1.2"), 24)
+
+	defaultRoute := data.StringToIPAddress("0.0.0.0")
+	branchGateway := data.StringToIPAddress("10.1.1.1")
+	H1.Properties.Rib.AddRoute(defaultRoute, 0, constants.RouteSourceStatic, 0, &branchGateway, nil, 1)
+	H2.Properties.Rib.AddRoute(defaultRoute, 0, constants.RouteSourceStatic, 0, &branchGateway, nil, 1)
+	ispGateway := data.StringToIPAddress("20.1.1.2")
+	BR1.Properties.Rib.AddRoute(defaultRoute, 0, constants.RouteSourceStatic, 0, &ispGateway, nil, 1)
+	branchRouter := data.StringToIPAddress("20.1.1.1")
+	ISP.Properties.Rib.AddRoute(data.StringToIPAddress("122.1.1.1"), 32, constants.RouteSourceStatic, 0, &branchRouter, nil, 1)
+	serverGateway := data.StringToIPAddress("30.1.1.1")
+	SRV.Properties.Rib.AddRoute(defaultRoute, 0, constants.RouteSourceStatic, 0, &serverGateway, nil, 1)
+
+	layers.SetNATInterface(BR1, "eth0/5", constants.NatRoleInside)
+	layers.SetNATInterface(BR1, "eth0/9", constants.NatRoleOutside)
+	layers.SetNATOverload(BR1, "loopback")
+
+	layers.SetIntfL2Mode(L2SW, "eth0/2", constants.ACCESS)
+	layers.SetIntfL2Mode(L2SW, "eth0/4", constants.ACCESS)
+	layers.SetIntfL2Mode(L2SW, "eth0/6", constants.ACCESS)
+
+	return topology
+}
+
+// VxlanTopology is SwitchTopology with the trunk between the switches replaced by a routed core,
+// VLANs 10 and 11 are stretched across CORE by the VTEPs on the loopbacks of L2SW1 and L2SW2.
+func VxlanTopology() *data.Graph {
+	topology := data.CreateGraph("VXLAN topology")
+	H1 := topology.CreateNode("H1")
+	H2 := topology.CreateNode("H2")
+	H3 := topology.CreateNode("H3")
+	H4 := topology.CreateNode("H4")
+	H5 := topology.CreateNode("H5")
+	H6 := topology.CreateNode("H6")
+	L2SW1 := topology.CreateNode("L2SW1")
+	L2SW2 := topology.CreateNode("L2SW2")
+	CORE := topology.CreateNode("CORE")
+
+	data.InsertLink(H1, L2SW1, "eth0/1", "eth0/2", 1)
+	data.InsertLink(H2, L2SW1, "eth0/3", "eth0/7", 1)
+	data.InsertLink(H3, L2SW1, "eth0/4", "eth0/6", 1)
+	data.InsertLink(L2SW1, CORE, "eth0/5", "eth0/13", 1)
+	data.InsertLink(CORE, L2SW2, "eth0/14", "eth0/7", 1)
+	data.InsertLink(H5, L2SW2, "eth0/8", "eth0/9", 1)
+	data.InsertLink(H4, L2SW2, "eth0/11", "eth0/12", 1)
+	data.InsertLink(H6, L2SW2, "eth0/11", "eth0/10", 1)
+
+	H1.SetIntfIPAddress("eth0/1", data.StringToIPAddress("10.1.1.1"), 24)
+	H2.SetIntfIPAddress("eth0/3", data.StringToIPAddress("10.1.1.2"), 24)
+	H3.SetIntfIPAddress("eth0/4", data.StringToIPAddress("10.1.1.3"), 24)
+	H4.SetIntfIPAddress("eth0/11", data.StringToIPAddress("10.1.1.4"), 24)
+	H5.SetIntfIPAddress("eth0/8", data.StringToIPAddress("10.1.1.5"), 24)
+	H6.SetIntfIPAddress("eth0/11", data.StringToIPAddress("10.1.1.6"), 24)
+
+	L2SW1.SetLbAddress(data.StringToIPAddress("1.1.1.1"))
+	L2SW1.SetIntfIPAddress("eth0/5", data.StringToIPAddress("172.16.1.1"), 24)
+	L2SW2.SetLbAddress(data.StringToIPAddress("2.2.2.2"))
+	L2SW2.SetIntfIPAddress("eth0/7", data.StringToIPAddress("172.16.2.1"), 24)
+	CORE.SetLbAddress(data.StringToIPAddress("3.3.3.3"))
+	CORE.SetIntfIPAddress("eth0/13", data.StringToIPAddress("172.16.1.2"), 24)
+	CORE.SetIntfIPAddress("eth0/14", data.StringToIPAddress("172.16.2.2"), 24)
+
+	vtep1, vtep2 := data.StringToIPAddress("1.1.1.1"), data.StringToIPAddress("2.2.2.2")
+	coreToSw1, coreToSw2 := data.StringToIPAddress("172.16.1.1"), data.StringToIPAddress("172.16.2.1")
+	sw1ToCore, sw2ToCore := data.StringToIPAddress("172.16.1.2"), data.StringToIPAddress("172.16.2.2")
+	CORE.Properties.Rib.AddRoute(vtep1, 32, constants.RouteSourceStatic, 0, &coreToSw1, nil, 1)
+	CORE.Properties.Rib.AddRoute(vtep2, 32, constants.RouteSourceStatic, 0, &coreToSw2, nil, 1)
+	L2SW1.Properties.Rib.AddRoute(vtep2, 32, constants.RouteSourceStatic, 0, &sw1ToCore, nil, 1)
+	L2SW2.Properties.Rib.AddRoute(vtep1, 32, constants.RouteSourceStatic, 0, &sw2ToCore, nil, 1)
+
+	layers.SetIntfL2Mode(L2SW1, "eth0/2", constants.ACCESS)
+	layers.SetIntfVLAN(L2SW1, "eth0/2", 10)
+	layers.SetIntfL2Mode(L2SW1, "eth0/7", constants.ACCESS)
+	layers.SetIntfVLAN(L2SW1, "eth0/7", 10)
+	layers.SetIntfL2Mode(L2SW1, "eth0/6", constants.ACCESS)
+	layers.SetIntfVLAN(L2SW1, "eth0/6", 11)
+
+	layers.SetIntfL2Mode(L2SW2, "eth0/9", constants.ACCESS)
+	layers.SetIntfVLAN(L2SW2, "eth0/9", 10)
+	layers.SetIntfL2Mode(L2SW2, "eth0/10", constants.ACCESS)
+	layers.SetIntfVLAN(L2SW2, "eth0/10", 10)
+	layers.SetIntfL2Mode(L2SW2, "eth0/12", constants.ACCESS)
+	layers.SetIntfVLAN(L2SW2, "eth0/12", 11)
+
+	layers.SetVXLANSource(L2SW1, "loopback")
+	layers.SetVXLANVni(L2SW1, 10010, 10)
+	layers.SetVXLANVni(L2SW1, 10011, 11)
+	layers.SetVXLANFloodList(L2SW1, 10010, []data.IPAddress{vtep2})
+	layers.SetVXLANFloodList(L2SW1, 10011, []data.IPAddress{vtep2})
+
+	layers.SetVXLANSource(L2SW2, "loopback")
+	layers.SetVXLANVni(L2SW2, 10010, 10)
+	layers.SetVXLANVni(L2SW2, 10011, 11)
+	layers.SetVXLANFloodList(L2SW2, 10010, []data.IPAddress{vtep1})
+	layers.SetVXLANFloodList(L2SW2, 10011, []data.IPAddress{vtep1})
+
+	return topology
+}
+
+// MultiTenantTopology connects the sites of tenants RED and BLUE over the provider edges PE1 and
+// PE2. Both tenants use the subnets 10.1.1.0/24 and 10.2.2.0/24, each tenant is routed in a VRF of
+// its own on both edges.
+func MultiTenantTopology() *data.Graph {
+	topology := data.CreateGraph("Multi-tenant topology")
+	RED1 := topology.CreateNode("RED1")
+	RED2 := topology.CreateNode("RED2")
+	BLUE1 := topology.CreateNode("BLUE1")
+	BLUE2 := topology.CreateNode("BLUE2")
+	PE1 := topology.CreateNode("PE1")
+	PE2 := topology.CreateNode("PE2")
+
+	data.InsertLink(RED1, PE1, "eth0/1", "eth0/2", 1)
+	data.InsertLink(BLUE1, PE1, "eth0/3", "eth0/4", 1)
+	data.InsertLink(PE1, PE2, "eth0/5", "eth0/6", 1)
+	data.InsertLink(PE1, PE2, "eth0/7", "eth0/8", 1)
+	data.InsertLink(PE2, RED2, "eth0/9", "eth0/10", 1)
+	data.InsertLink(PE2, BLUE2, "eth0/11", "eth0/12", 1)
+
+	for _, PE := range []*data.Node{PE1, PE2} {
+		layers.AddVrf(PE, "RED")
+		layers.AddVrf(PE, "BLUE")
+	}
+	layers.SetIntfVrf(PE1, "eth0/2", "RED")
+	layers.SetIntfVrf(PE1, "eth0/5", "RED")
+	layers.SetIntfVrf(PE1, "eth0/4", "BLUE")
+	layers.SetIntfVrf(PE1, "eth0/7", "BLUE")
+	layers.SetIntfVrf(PE2, "eth0/6", "RED")
+	layers.SetIntfVrf(PE2, "eth0/9", "RED")
+	layers.SetIntfVrf(PE2, "eth0/8", "BLUE")
+	layers.SetIntfVrf(PE2, "eth0/11", "BLUE")
+
+	RED1.SetIntfIPAddress("eth0/1", data.StringToIPAddress("10.1.1.2"), 24)
+	BLUE1.SetIntfIPAddress("eth0/3", data.StringToIPAddress("10.1.1.2"), 24)
+	RED2.SetIntfIPAddress("eth0/10", data.StringToIPAddress("10.2.2.2"), 24)
+	BLUE2.SetIntfIPAddress("eth0/12", data.StringToIPAddress("10.2.2.2"), 24)
+
+	PE1.SetIntfIPAddress("eth0/2", data.StringToIPAddress("10.1.1.1"), 24)
+	PE1.SetIntfIPAddress("eth0/4", data.StringToIPAddress("10.1.1.1"), 24)
+	PE1.SetIntfIPAddress("eth0/5", data.StringToIPAddress("172.16.1.1"), 30)
+	PE1.SetIntfIPAddress("eth0/7", data.StringToIPAddress("172.16.1.1"), 30)
+	PE2.SetIntfIPAddress("eth0/6", data.StringToIPAddress("172.16.1.2"), 30)
+	PE2.SetIntfIPAddress("eth0/8", data.StringToIPAddress("172.16.1.2"), 30)
+	PE2.SetIntfIPAddress("eth0/9", data.StringToIPAddress("10.2.2.1"), 24)
+	PE2.SetIntfIPAddress("eth0/11", data.StringToIPAddress("10.2.2.1"), 24)
+
+	defaultRoute := data.StringToIPAddress("0.0.0.0")
+	site1Gateway, site2Gateway := data.StringToIPAddress("10.1.1.1"), data.StringToIPAddress("10.2.2.1")
+	RED1.Properties.Rib.AddRoute(defaultRoute, 0, constants.RouteSourceStatic, 0, &site1Gateway, nil, 1)
+	BLUE1.Properties.Rib.AddRoute(defaultRoute, 0, constants.RouteSourceStatic, 0, &site1Gateway, nil, 1)
+	RED2.Properties.Rib.AddRoute(defaultRoute, 0, constants.RouteSourceStatic, 0, &site2Gateway, nil, 1)
+	BLUE2.Properties.Rib.AddRoute(defaultRoute, 0, constants.RouteSourceStatic, 0, &site2Gateway, nil, 1)
+
+	pe1, pe2 := data.StringToIPAddress("172.16.1.1"), data.StringToIPAddress("172.16.1.2")
+	for _, name := range []string{"RED", "BLUE"} {
+		PE1.VrfRib(PE1.LookupVrf(name)).AddRoute(site2Gateway, 24, constants.RouteSourceStatic, 0, &pe2, nil, 1)
+		PE2.VrfRib(PE2.LookupVrf(name)).AddRoute(site1Gateway, 24, constants.RouteSourceStatic, 0, &pe1, nil, 1)
+	}
+
+	return topology
+}